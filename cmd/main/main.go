@@ -2,45 +2,212 @@ package main
 
 import (
 	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adfeed"
 	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/auditlog"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/cacherepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/dispatcher"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/eventbus"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/mailer"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/notifier"
 	"github.com/TobbyMax/ad-service.git/internal/app"
 	"github.com/TobbyMax/ad-service.git/internal/graceful"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
 	grpcSvc "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
 	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
 	"github.com/jackc/pgx/v5"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"net/http"
+	"net/smtp"
 	"os"
+	"strconv"
+	"time"
 
 	"log"
 	"net"
 )
 
 const (
-	grpcPort = ":8080"
-	httpPort = ":18080"
+	grpcPort    = ":8080"
+	httpPort    = ":18080"
+	gatewayPort = ":8081"
+
+	purgeInterval    = time.Hour
+	deletedRetention = 30 * 24 * time.Hour
 )
 
 func CreateDB(ctx context.Context) (*pgx.Conn, error) {
 	return pgx.Connect(ctx, os.Getenv("DB_CONNECT_STRING"))
 }
 
+// NewMailer returns SMTP mailer configured with SMTP_* environment variables,
+// if SMTP_ADDR is not set, emails are written to the log
+func NewMailer() app.Mailer {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return mailer.LogMailer{}
+	}
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USER"); username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return mailer.NewSMTPMailer(addr, os.Getenv("SMTP_FROM"), auth)
+}
+
+// NewAuditSink returns the audit log stored in the AUDIT_LOG_FILE file,
+// if the variable is not set, the log is kept in memory
+func NewAuditSink() (app.AuditSink, error) {
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if path == "" {
+		return auditlog.NewMemorySink(), nil
+	}
+	return auditlog.NewFileSink(path)
+}
+
+// quotaFromEnv parses the quota in the variable, the default quota is used, when the variable is not set or invalid
+func quotaFromEnv(name string, def string) ratelimit.Quota {
+	str, ok := os.LookupEnv(name)
+	if !ok {
+		str = def
+	}
+	q, err := ratelimit.ParseQuota(str)
+	if err != nil {
+		log.Printf("%s: %v, the default quota %s is used\n", name, err, def)
+		q, _ = ratelimit.ParseQuota(def)
+	}
+	return q
+}
+
+// NewRateLimiter returns the limiter shared by the HTTP and gRPC servers. RATE_LIMIT_IP and RATE_LIMIT_USER
+// are the quotas of every client address and every user on every route, such as "20/s,40",
+// RATE_LIMIT_CREATE_AD is the stricter quota of both for the creation of ads, "off" disables a quota
+func NewRateLimiter() *ratelimit.Limiter {
+	create := quotaFromEnv("RATE_LIMIT_CREATE_AD", "10/m")
+	createRule := ratelimit.Rule{PerIP: create, PerUser: create}
+	return ratelimit.New(ratelimit.Config{
+		Default: ratelimit.Rule{
+			PerIP:   quotaFromEnv("RATE_LIMIT_IP", "20/s,40"),
+			PerUser: quotaFromEnv("RATE_LIMIT_USER", "10/s,20"),
+		},
+		Routes: map[string]ratelimit.Rule{
+			"POST /api/v1/ads":       createRule,
+			"/ad.AdService/CreateAd": createRule,
+		},
+	})
+}
+
+// NewIdempotencyStore returns the store of idempotency keys shared by the HTTP and gRPC servers,
+// IDEMPOTENCY_RETENTION is how long the responses are kept, such as "24h"
+func NewIdempotencyStore() idempotency.Store {
+	retention := idempotency.DefaultRetention
+	if str := os.Getenv("IDEMPOTENCY_RETENTION"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil || d <= 0 {
+			log.Printf("IDEMPOTENCY_RETENTION must be a positive duration, the default retention is used\n")
+		} else {
+			retention = d
+		}
+	}
+	return idempotency.NewMemoryStore(retention, idempotency.DefaultMaxRecords)
+}
+
+// AdminToken returns the token of the admin endpoints from ADMIN_TOKEN, without it the admin endpoints
+// reject every request
+func AdminToken() string {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		log.Printf("ADMIN_TOKEN is not set, the admin endpoints are disabled\n")
+	}
+	return token
+}
+
+// HTTPOptions configures the HTTP server with the limiter, the idempotency store, the admin token
+// and environment variables, FEED_LIMIT is the number of ads in RSS and Atom feeds
+func HTTPOptions(limiter *ratelimit.Limiter, keys idempotency.Store, adminToken string) []httpgin.Option {
+	opts := []httpgin.Option{httpgin.WithRateLimiter(limiter), httpgin.WithIdempotencyStore(keys),
+		httpgin.WithAdminToken(adminToken)}
+	if str := os.Getenv("FEED_LIMIT"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit < 1 {
+			log.Printf("FEED_LIMIT must be a positive number, the default limit is used\n")
+		} else {
+			opts = append(opts, httpgin.WithFeedLimit(limit))
+		}
+	}
+	return opts
+}
+
+// GatewayAddr returns the address of the JSON gateway to the gRPC service, GATEWAY_ADDR overrides the default
+// port, "off" disables the gateway
+func GatewayAddr() string {
+	addr, ok := os.LookupEnv("GATEWAY_ADDR")
+	if !ok || addr == "" {
+		return gatewayPort
+	}
+	if addr == "off" {
+		return ""
+	}
+	return addr
+}
+
 func main() {
-	appSvc := app.NewApp(adrepo.New())
+	auditSink, err := NewAuditSink()
+	if err != nil {
+		log.Fatalf("failed to open audit log: %v", err)
+	}
+	cache := cacherepo.New(adrepo.New())
+	repo := cache
+	mail := NewMailer()
+	hub := notifier.NewHub()
+	feed := adfeed.New()
+	inbox := notifier.NewInbox(repo, hub)
+	alerts := app.NewSearchAlerts(repo, notifier.Multi{inbox, notifier.NewEmail(repo, mail)})
+
+	bus := eventbus.New()
+	// subscribers of domain events
+	bus.Subscribe("log", eventbus.LogHandler)
+	webhookDispatcher := dispatcher.New(repo)
+	bus.Subscribe("webhooks", webhookDispatcher.Handle)
+	bus.Subscribe("searches", alerts.Handle)
+	bus.Subscribe("statuses", app.NewStatusNotifications(inbox).Handle)
+	bus.Subscribe("feed", feed.Handle)
+
+	jobRunner := app.NewJobRunner()
+	appSvc := app.NewApp(repo, app.WithMailer(mail), app.WithAuditSink(auditSink), app.WithNotificationHub(hub), app.WithAdFeed(feed),
+		app.WithJobRunner(jobRunner))
+	relay := app.NewOutboxRelay(repo, bus)
 
 	lis, err := net.Listen("tcp", grpcPort)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	limiter := NewRateLimiter()
+	keys := NewIdempotencyStore()
+	adminToken := AdminToken()
 	svc := grpcSvc.NewService(appSvc)
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	interceptors := []grpc.UnaryServerInterceptor{
 		grpcSvc.UnaryLoggerInterceptor,
+		grpcSvc.UnaryRequestInfoInterceptor,
+		grpcSvc.UnaryAdminInterceptor(adminToken),
+		grpcSvc.UnaryRateLimitInterceptor(limiter),
+		grpcSvc.UnaryIdempotencyInterceptor(keys),
 		grpcSvc.UnaryRecoveryInterceptor(),
-	))
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.StreamInterceptor(grpcSvc.StreamRateLimitInterceptor(limiter)))
 	grpcSvc.RegisterAdServiceServer(grpcServer, svc)
 
-	httpServer := httpgin.NewHTTPServer(httpPort, appSvc)
+	// the gateway calls the service in-process through the same interceptors
+	gateway, err := grpcSvc.NewGateway(svc, interceptors...)
+	if err != nil {
+		log.Fatalf("failed to create gateway: %v", err)
+	}
+
+	httpServer := httpgin.NewHTTPServer(httpPort, appSvc, HTTPOptions(limiter, keys, adminToken)...)
 
 	eg, ctx := errgroup.WithContext(context.Background())
 
@@ -50,9 +217,27 @@ func main() {
 	eg.Go(grpcSvc.RunGRPCServerGracefully(ctx, lis, grpcServer))
 	// run http server
 	eg.Go(httpgin.RunHTTPServerGracefully(ctx, httpServer))
+	// run JSON gateway to the grpc service
+	if addr := GatewayAddr(); addr != "" {
+		eg.Go(httpgin.RunHTTPServerGracefully(ctx, &http.Server{Addr: addr, Handler: gateway}))
+	}
+	// deliver domain events from the outbox to subscribers
+	eg.Go(relay.Run(ctx))
+	eg.Go(bus.Run(ctx))
+	eg.Go(webhookDispatcher.Run(ctx))
+	// end live notification subscriptions and watches of ads on shutdown
+	eg.Go(hub.Run(ctx))
+	eg.Go(feed.Run(ctx))
+	// send digests of saved searches
+	eg.Go(alerts.Run(ctx))
+	// cancel exports and erasures on shutdown and wait for them
+	eg.Go(jobRunner.Run(ctx))
+	// permanently remove soft deleted records after the retention period
+	eg.Go(app.RunPurgeJob(ctx, appSvc, purgeInterval, deletedRetention))
 
 	if err := eg.Wait(); err != nil {
 		log.Printf("gracefully shutting down the servers: %s\n", err.Error())
 	}
+	log.Printf("repository cache stats: %+v\n", cache.Stats())
 	log.Println("servers were successfully shutdown")
 }
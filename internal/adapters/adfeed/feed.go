@@ -0,0 +1,134 @@
+package adfeed
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"log"
+	"sync"
+)
+
+// DefaultBuffer is the number of changes waiting for a watcher
+const DefaultBuffer = 64
+
+var _ app.AdFeed = (*Feed)(nil)
+
+// Feed passes changes of ads from the event bus to the watchers. Publishing never waits for a watcher:
+// a watcher, whose buffer is full, is dropped with app.ErrSlowConsumer, because a feed with gaps
+// would leave a stale list on the client, which has to reload the list and watch again
+type Feed struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	buffer   int
+	closed   bool
+}
+
+type watcher struct {
+	ch    chan events.Event
+	match func(e events.Event) bool
+	err   error
+}
+
+type Option func(*Feed)
+
+// WithBuffer sets the number of changes waiting for a watcher
+func WithBuffer(n int) Option {
+	return func(f *Feed) {
+		f.buffer = n
+	}
+}
+
+func New(opts ...Option) *Feed {
+	f := &Feed{watchers: make(map[*watcher]struct{}), buffer: DefaultBuffer}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Handle is the event bus handler, which passes changes of ads to the watchers
+func (f *Feed) Handle(ctx context.Context, e events.Event) error {
+	switch e.(type) {
+	case events.AdCreated, events.AdPublished, events.AdUnpublished, events.AdUpdated, events.AdDeleted,
+		events.AdRestored:
+		f.Publish(e)
+	}
+	return nil
+}
+
+func (f *Feed) Publish(e events.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for w := range f.watchers {
+		if !w.match(e) {
+			continue
+		}
+		select {
+		case w.ch <- e:
+		default:
+			log.Printf("ad feed: watcher is too slow and is dropped at %s\n", e.EventName())
+			w.err = app.ErrSlowConsumer
+			f.drop(w)
+		}
+	}
+}
+
+func (f *Feed) Subscribe(ctx context.Context, match func(e events.Event) bool) (<-chan events.Event, func() error) {
+	w := &watcher{ch: make(chan events.Event, f.buffer), match: match}
+	errFunc := func() error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return w.err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(w.ch)
+		return w.ch, errFunc
+	}
+	f.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.drop(w)
+	}()
+	return w.ch, errFunc
+}
+
+// Watchers returns the number of connected watchers
+func (f *Feed) Watchers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watchers)
+}
+
+// Close ends all watches, so that the streaming calls return and the servers can stop gracefully
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for w := range f.watchers {
+		f.drop(w)
+	}
+}
+
+// Run closes the feed when the context is done
+func (f *Feed) Run(ctx context.Context) func() error {
+	return func() error {
+		<-ctx.Done()
+		f.Close()
+		return nil
+	}
+}
+
+// drop closes the channel of the watcher once, it must be called under the lock
+func (f *Feed) drop(w *watcher) {
+	if _, ok := f.watchers[w]; !ok {
+		return
+	}
+	delete(f.watchers, w)
+	close(w.ch)
+}
@@ -0,0 +1,140 @@
+package adrepo
+
+import (
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"time"
+)
+
+// adIndex groups the IDs of ads by the key of the index. The indexes keep soft deleted ads as well,
+// so they change only when the ads are added, changed or purged
+type adIndex[K comparable] struct {
+	key  func(ads.Ad) K
+	sets map[K]map[int64]struct{}
+}
+
+func newAdIndex[K comparable](key func(ads.Ad) K) *adIndex[K] {
+	return &adIndex[K]{key: key, sets: make(map[K]map[int64]struct{})}
+}
+
+// lookup returns the IDs of ads with the given key, the set must not be changed
+func (idx *adIndex[K]) lookup(k K) map[int64]struct{} {
+	return idx.sets[k]
+}
+
+func (idx *adIndex[K]) add(s *store, k K, id int64) {
+	set, ok := idx.sets[k]
+	if !ok {
+		set = make(map[int64]struct{})
+		put(s, idx.sets, k, set)
+	}
+	put(s, set, id, struct{}{})
+}
+
+func (idx *adIndex[K]) remove(s *store, k K, id int64) {
+	set := idx.sets[k]
+	remove(s, set, id)
+	if len(set) == 0 {
+		remove(s, idx.sets, k)
+	}
+}
+
+// update moves the ad from the entry of the old version to the entry of the new one,
+// the old version is nil for new ads, the new version is nil for purged ads
+func (idx *adIndex[K]) update(s *store, old *ads.Ad, ad *ads.Ad) {
+	switch {
+	case old == nil:
+		idx.add(s, idx.key(*ad), ad.ID)
+	case ad == nil:
+		idx.remove(s, idx.key(*old), old.ID)
+	default:
+		if k, newK := idx.key(*old), idx.key(*ad); k != newK {
+			idx.remove(s, k, old.ID)
+			idx.add(s, newK, ad.ID)
+		}
+	}
+}
+
+type indexer interface {
+	update(s *store, old *ads.Ad, ad *ads.Ad)
+}
+
+// adIndexes are the secondary indexes of the ad table
+type adIndexes struct {
+	byAuthor *adIndex[int64]
+	byStatus *adIndex[bool]
+	byDay    *adIndex[int]
+	byTitle  *adIndex[string]
+}
+
+func newAdIndexes() adIndexes {
+	return adIndexes{
+		byAuthor: newAdIndex(func(ad ads.Ad) int64 { return ad.AuthorID }),
+		byStatus: newAdIndex(func(ad ads.Ad) bool { return ad.Published }),
+		byDay:    newAdIndex(func(ad ads.Ad) int { return dayKey(ad.DateCreated) }),
+		byTitle:  newAdIndex(func(ad ads.Ad) string { return ad.Title }),
+	}
+}
+
+func (x adIndexes) all() []indexer {
+	return []indexer{x.byAuthor, x.byStatus, x.byDay, x.byTitle}
+}
+
+// dayKey identifies the calendar day of the time in its own location
+func dayKey(t time.Time) int {
+	year, month, day := t.Date()
+	return year*10000 + int(month)*100 + day
+}
+
+// putAd writes the ad to the table and updates the indexes and the time of the last change, it must be called under the lock
+func (r *RepositoryMap) putAd(ad ads.Ad) {
+	old, ok := r.adTable[ad.ID]
+	put(r.store, r.adTable, ad.ID, ad)
+	set(r.store, &r.adsChanged, time.Now().UTC())
+	for _, idx := range r.indexes.all() {
+		if ok {
+			idx.update(r.store, &old, &ad)
+		} else {
+			idx.update(r.store, nil, &ad)
+		}
+	}
+}
+
+// removeAd permanently removes the ad from the table and the indexes, it must be called under the lock.
+// The removal is a change of the ads too
+func (r *RepositoryMap) removeAd(id int64) {
+	old, ok := r.adTable[id]
+	if !ok {
+		return
+	}
+	remove(r.store, r.adTable, id)
+	set(r.store, &r.adsChanged, time.Now().UTC())
+	for _, idx := range r.indexes.all() {
+		idx.update(r.store, &old, nil)
+	}
+}
+
+// plan picks the most selective index for the filters of the list, that is the one with the fewest ads
+// for the requested key. It returns false, when none of the filters is indexed and the table must be scanned
+func (r *RepositoryMap) plan(params app.ListAdsParams) (map[int64]struct{}, bool) {
+	var best map[int64]struct{}
+	found := false
+	consider := func(set map[int64]struct{}) {
+		if !found || len(set) < len(best) {
+			best, found = set, true
+		}
+	}
+	if params.Uid != nil {
+		consider(r.indexes.byAuthor.lookup(*params.Uid))
+	}
+	if params.Title != nil {
+		consider(r.indexes.byTitle.lookup(*params.Title))
+	}
+	if params.Date != nil {
+		consider(r.indexes.byDay.lookup(dayKey(*params.Date)))
+	}
+	if params.Published != nil {
+		consider(r.indexes.byStatus.lookup(*params.Published))
+	}
+	return best, found
+}
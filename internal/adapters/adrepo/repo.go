@@ -4,7 +4,13 @@ import (
 	"context"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
 	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"sort"
 	"sync"
 	"time"
 )
@@ -13,37 +19,117 @@ func New() app.Repository {
 	return NewRepositoryMap()
 }
 
+// RepositoryMap keeps the data in maps guarded by one read-write lock. Reads run in parallel with each other
+// and copy the data out under the read lock, so every result, lists included, is a consistent snapshot.
+// Writes and transactions take the write lock, so readers never see a half-done change.
+// The views of transactions share the store and the lock with the repository, but run under the lock
+// held by InTransaction
 type RepositoryMap struct {
-	sync.Mutex
+	mu   *sync.RWMutex
+	inTx bool
+	*store
+}
+
+type store struct {
+	// adTable is changed only with putAd and removeAd, which keep the indexes up to date
 	adTable   map[int64]ads.Ad
+	indexes   adIndexes
 	userTable map[int64]user.User
-	user2ads  map[int64]map[int64]struct{}
+	// emails maps the emails of the users, who are not deleted, to their IDs, so an email belongs to one user
+	emails     map[string]int64
+	tokens     map[string]user.Token
+	jobs       map[int64]jobs.Job
+	nextAdID   int64
+	nextUserID int64
+	nextJobID  int64
+	// adsChanged is the time of the last change of any ad
+	adsChanged time.Time
+
+	// outbox holds undelivered events in the order they were written
+	outbox        []events.Message
+	deadLetters   []events.Message
+	nextMessageID int64
+
+	hooks         map[int64]webhooks.Subscription
+	deliveries    map[int64][]webhooks.Delivery
+	nextWebhookID int64
+
+	searches           map[int64]searches.SavedSearch
+	nextSearchID       int64
+	notifications      map[int64][]notifications.Notification
+	nextNotificationID int64
+
+	// journal undoes the changes of the running transaction, it is nil outside of transactions
+	journal []func()
 }
 
+// deliveryLogSize is the number of the latest deliveries kept for every webhook
+const deliveryLogSize = 100
+
 func NewRepositoryMap() *RepositoryMap {
-	return &RepositoryMap{
-		adTable:   make(map[int64]ads.Ad),
-		userTable: make(map[int64]user.User),
-		user2ads:  make(map[int64]map[int64]struct{}),
+	return &RepositoryMap{mu: &sync.RWMutex{}, store: &store{
+		adTable:    make(map[int64]ads.Ad),
+		userTable:  make(map[int64]user.User),
+		emails:     make(map[string]int64),
+		indexes:    newAdIndexes(),
+		tokens:     make(map[string]user.Token),
+		jobs:       make(map[int64]jobs.Job),
+		hooks:      make(map[int64]webhooks.Subscription),
+		deliveries: make(map[int64][]webhooks.Delivery),
+
+		searches:      make(map[int64]searches.SavedSearch),
+		notifications: make(map[int64][]notifications.Notification),
+	}}
+}
+
+// liveAd returns the ad if it exists and is not soft deleted
+func (r *RepositoryMap) liveAd(id int64) (ads.Ad, bool) {
+	ad, ok := r.adTable[id]
+	if !ok || ad.DeletedAt != nil {
+		return ads.Ad{}, false
 	}
+	return ad, true
+}
+
+// claimEmail gives the email to the user, it fails with ErrEmailTaken if the email belongs to another user
+func (r *RepositoryMap) claimEmail(email string, uid int64) error {
+	if owner, ok := r.emails[email]; ok && owner != uid {
+		return app.ErrEmailTaken
+	}
+	put(r.store, r.emails, email, uid)
+	return nil
+}
+
+// releaseEmail frees the email of the user, who is deleted or changes the email
+func (r *RepositoryMap) releaseEmail(email string, uid int64) {
+	if owner, ok := r.emails[email]; ok && owner == uid {
+		remove(r.store, r.emails, email)
+	}
+}
+
+// liveUser returns the user if it exists and is not soft deleted
+func (r *RepositoryMap) liveUser(id int64) (user.User, bool) {
+	u, ok := r.userTable[id]
+	if !ok || u.DeletedAt != nil {
+		return user.User{}, false
+	}
+	return u, true
 }
 
 func (r *RepositoryMap) AddAd(ctx context.Context, ad ads.Ad) (int64, error) {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.userTable[ad.AuthorID]; !ok {
+	defer r.lock()()
+	if _, ok := r.liveUser(ad.AuthorID); !ok {
 		return 0, app.ErrUserNotFound
 	}
-	ad.ID = int64(len(r.adTable))
-	r.adTable[ad.ID] = ad
-	r.user2ads[ad.AuthorID][ad.ID] = struct{}{}
+	ad.ID = r.nextAdID
+	r.nextAdID++
+	r.putAd(ad)
 	return ad.ID, nil
 }
 
 func (r *RepositoryMap) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error) {
-	r.Lock()
-	defer r.Unlock()
-	if ad, ok := r.adTable[id]; !ok {
+	defer r.rlock()()
+	if ad, ok := r.liveAd(id); !ok {
 		return nil, app.ErrAdNotFound
 	} else {
 		return &ad, nil
@@ -51,61 +137,77 @@ func (r *RepositoryMap) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error
 }
 
 func (r *RepositoryMap) UpdateAdStatus(ctx context.Context, id int64, published bool, date time.Time) error {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.adTable[id]; !ok {
+	defer r.lock()()
+	ad, ok := r.liveAd(id)
+	if !ok {
 		return app.ErrAdNotFound
 	}
-	ad := r.adTable[id]
 	ad.Published = published
 	ad.DateChanged = date
-	r.adTable[id] = ad
+	r.putAd(ad)
 	return nil
 }
 
 func (r *RepositoryMap) UpdateAdContent(ctx context.Context, id int64, title string, text string, date time.Time) error {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.adTable[id]; !ok {
+	defer r.lock()()
+	ad, ok := r.liveAd(id)
+	if !ok {
 		return app.ErrAdNotFound
 	}
-	ad := r.adTable[id]
 	ad.Title = title
 	ad.Text = text
 	ad.DateChanged = date
-	r.adTable[id] = ad
+	r.putAd(ad)
 	return nil
 }
 
 func (r *RepositoryMap) GetAdList(ctx context.Context, params app.ListAdsParams) (*ads.AdList, error) {
-	r.Lock()
-	defer r.Unlock()
+	defer r.rlock()()
 	al := ads.AdList{Data: make([]ads.Ad, 0)}
-	for _, ad := range r.adTable {
-		if params.Published == nil || *params.Published == ad.Published {
-			if (params.Uid == nil || *params.Uid == ad.AuthorID) && (params.Title == nil || *params.Title == ad.Title) {
-				if year, month, day := ad.DateCreated.Date(); params.Date == nil ||
-					(params.Date.Year() == year && params.Date.Month() == month && params.Date.Day() == day) {
-					al.Data = append(al.Data, ad)
-				}
+	if ids, ok := r.plan(params); ok {
+		for id := range ids {
+			if ad := r.adTable[id]; params.Match(ad) {
+				al.Data = append(al.Data, ad)
 			}
 		}
+		return &al, nil
+	}
+	for _, ad := range r.adTable {
+		if params.Match(ad) {
+			al.Data = append(al.Data, ad)
+		}
 	}
 	return &al, nil
 }
 
+func (r *RepositoryMap) AdsChangedAt(ctx context.Context) (time.Time, error) {
+	defer r.rlock()()
+	return r.adsChanged, nil
+}
+
 func (r *RepositoryMap) AddUser(ctx context.Context, u user.User) (int64, error) {
-	r.Lock()
-	defer r.Unlock()
-	u.ID = int64(len(r.userTable))
-	r.userTable[u.ID] = u
-	r.user2ads[u.ID] = make(map[int64]struct{})
+	defer r.lock()()
+	if _, ok := r.emails[u.Email]; ok {
+		return 0, app.ErrEmailTaken
+	}
+	u.ID = r.nextUserID
+	r.nextUserID++
+	put(r.store, r.userTable, u.ID, u)
+	put(r.store, r.emails, u.Email, u.ID)
 	return u.ID, nil
 }
 
 func (r *RepositoryMap) GetUserByID(ctx context.Context, id int64) (*user.User, error) {
-	r.Lock()
-	defer r.Unlock()
+	defer r.rlock()()
+	if u, ok := r.liveUser(id); !ok {
+		return nil, app.ErrUserNotFound
+	} else {
+		return &u, nil
+	}
+}
+
+func (r *RepositoryMap) GetUserIncludingDeleted(ctx context.Context, id int64) (*user.User, error) {
+	defer r.rlock()()
 	if u, ok := r.userTable[id]; !ok {
 		return nil, app.ErrUserNotFound
 	} else {
@@ -114,38 +216,735 @@ func (r *RepositoryMap) GetUserByID(ctx context.Context, id int64) (*user.User,
 }
 
 func (r *RepositoryMap) UpdateUser(ctx context.Context, id int64, nickname string, email string) error {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.userTable[id]; !ok {
+	defer r.lock()()
+	u, ok := r.liveUser(id)
+	if !ok {
 		return app.ErrUserNotFound
 	}
-	u := r.userTable[id]
+	if err := r.claimEmail(email, id); err != nil {
+		return err
+	}
+	if u.Email != email {
+		r.releaseEmail(u.Email, id)
+	}
 	u.Nickname = nickname
 	u.Email = email
-	r.userTable[id] = u
+	put(r.store, r.userTable, id, u)
 	return nil
 }
 
+// DeleteAdByID marks the ad as deleted, it can be restored until it is purged
 func (r *RepositoryMap) DeleteAdByID(ctx context.Context, id int64) error {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.adTable[id]; !ok {
+	defer r.lock()()
+	ad, ok := r.liveAd(id)
+	if !ok {
 		return app.ErrAdNotFound
 	}
-	delete(r.adTable, id)
+	now := time.Now().UTC()
+	ad.DeletedAt = &now
+	r.putAd(ad)
 	return nil
 }
 
+// DeleteUserByID marks the user and all of the user's ads as deleted
 func (r *RepositoryMap) DeleteUserByID(ctx context.Context, id int64) error {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.userTable[id]; !ok {
+	defer r.lock()()
+	u, ok := r.liveUser(id)
+	if !ok {
+		return app.ErrUserNotFound
+	}
+	now := time.Now().UTC()
+	for adID := range r.indexes.byAuthor.lookup(id) {
+		if ad, ok := r.liveAd(adID); ok {
+			ad.DeletedAt = &now
+			r.putAd(ad)
+		}
+	}
+	u.DeletedAt = &now
+	put(r.store, r.userTable, id, u)
+	r.releaseEmail(u.Email, id)
+	for value, t := range r.tokens {
+		if t.UserID == id {
+			remove(r.store, r.tokens, value)
+		}
+	}
+	return nil
+}
+
+// DeleteUserKeepAds marks the user as deleted after handing all of the user's ads over to newAuthor
+func (r *RepositoryMap) DeleteUserKeepAds(ctx context.Context, id int64, newAuthor int64) error {
+	defer r.lock()()
+	u, ok := r.liveUser(id)
+	if !ok {
+		return app.ErrUserNotFound
+	}
+	if newAuthor == id {
+		return app.ErrInvalidTransfer
+	}
+	if _, ok := r.liveUser(newAuthor); !ok && newAuthor != ads.AnonymousAuthorID {
+		return app.ErrInvalidTransfer
+	}
+	for adID := range r.indexes.byAuthor.lookup(id) {
+		ad := r.adTable[adID]
+		ad.AuthorID = newAuthor
+		r.putAd(ad)
+	}
+
+	now := time.Now().UTC()
+	u.DeletedAt = &now
+	put(r.store, r.userTable, id, u)
+	r.releaseEmail(u.Email, id)
+	for value, t := range r.tokens {
+		if t.UserID == id {
+			remove(r.store, r.tokens, value)
+		}
+	}
+	return nil
+}
+
+// RestoreAdByID undoes DeleteAdByID, the author of the ad must not be deleted
+func (r *RepositoryMap) RestoreAdByID(ctx context.Context, id int64) error {
+	defer r.lock()()
+	ad, ok := r.adTable[id]
+	if !ok || ad.DeletedAt == nil {
+		return app.ErrAdNotFound
+	}
+	if _, ok := r.liveUser(ad.AuthorID); !ok {
 		return app.ErrUserNotFound
 	}
-	for adID := range r.user2ads[id] {
-		delete(r.adTable, adID)
+	ad.DeletedAt = nil
+	r.putAd(ad)
+	return nil
+}
+
+// RestoreUserByID undoes DeleteUserByID together with the ads, which were deleted with the user
+func (r *RepositoryMap) RestoreUserByID(ctx context.Context, id int64) error {
+	defer r.lock()()
+	u, ok := r.userTable[id]
+	if !ok || u.DeletedAt == nil {
+		return app.ErrUserNotFound
+	}
+	// the email may have been taken by another user after the deletion
+	if err := r.claimEmail(u.Email, id); err != nil {
+		return err
+	}
+	for adID := range r.indexes.byAuthor.lookup(id) {
+		if ad := r.adTable[adID]; ad.DeletedAt != nil && ad.DeletedAt.Equal(*u.DeletedAt) {
+			ad.DeletedAt = nil
+			r.putAd(ad)
+		}
+	}
+	u.DeletedAt = nil
+	put(r.store, r.userTable, id, u)
+	return nil
+}
+
+// PurgeDeleted permanently removes ads and users deleted before the given time
+func (r *RepositoryMap) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	defer r.lock()()
+	purged := 0
+	for id, ad := range r.adTable {
+		if ad.DeletedAt != nil && ad.DeletedAt.Before(before) {
+			r.removeAd(id)
+			purged++
+		}
+	}
+	for id, u := range r.userTable {
+		if u.DeletedAt != nil && u.DeletedAt.Before(before) {
+			for adID := range r.indexes.byAuthor.lookup(id) {
+				r.removeAd(adID)
+				purged++
+			}
+			remove(r.store, r.userTable, id)
+			r.removeTokensAndResults(id)
+			r.deleteOwnedBy(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// EraseUser permanently removes the user without waiting for the retention period
+func (r *RepositoryMap) EraseUser(ctx context.Context, id int64) error {
+	defer r.lock()()
+	u, ok := r.userTable[id]
+	if !ok {
+		return app.ErrUserNotFound
+	}
+	erased := make(map[int64]struct{})
+	for adID := range r.indexes.byAuthor.lookup(id) {
+		erased[adID] = struct{}{}
+		r.removeAd(adID)
+	}
+	remove(r.store, r.userTable, id)
+	r.releaseEmail(u.Email, id)
+	r.removeTokensAndResults(id)
+	r.deleteOwnedBy(id)
+	r.scrubMessages(id, erased)
+	r.scrubNotifications(erased)
+	return nil
+}
+
+// removeTokensAndResults removes the tokens of the removed user and the results of their jobs,
+// the jobs themselves stay with their status
+func (r *RepositoryMap) removeTokensAndResults(uid int64) {
+	for value, t := range r.tokens {
+		if t.UserID == uid {
+			remove(r.store, r.tokens, value)
+		}
+	}
+	for jobID, j := range r.jobs {
+		if j.UserID == uid && j.Result != nil {
+			j.Result, j.ContentType = nil, ""
+			put(r.store, r.jobs, jobID, j)
+		}
+	}
+}
+
+// scrubMessages drops the undelivered events of the erased user and ads, except for the deletions, which carry
+// no personal data, and redacts the dead letters, so they are still there for the inspection
+func (r *RepositoryMap) scrubMessages(uid int64, erased map[int64]struct{}) {
+	concerns := func(m events.Message) bool {
+		kind, id := m.Event.Aggregate()
+		if kind == events.AggregateUser {
+			return id == uid
+		}
+		_, ok := erased[id]
+		return kind == events.AggregateAd && ok
+	}
+
+	outbox := make([]events.Message, 0, len(r.outbox))
+	for _, m := range r.outbox {
+		switch m.Event.(type) {
+		case events.AdDeleted, events.UserDeleted:
+			outbox = append(outbox, m)
+			continue
+		}
+		if !concerns(m) {
+			outbox = append(outbox, m)
+		}
+	}
+	if len(outbox) < len(r.outbox) {
+		keep(r.store, &r.outbox)
+		r.outbox = outbox
+	}
+
+	var deadLetters []events.Message
+	for i, m := range r.deadLetters {
+		if !concerns(m) {
+			continue
+		}
+		if deadLetters == nil {
+			deadLetters = modifiable(r.store, &r.deadLetters)
+		}
+		deadLetters[i].Event = events.Redact(m.Event)
+	}
+}
+
+// scrubNotifications removes the titles of the erased ads from the notifications of the other users
+func (r *RepositoryMap) scrubNotifications(erased map[int64]struct{}) {
+	for uid, inbox := range r.notifications {
+		var changed []notifications.Notification
+		for i, n := range inbox {
+			for _, adID := range n.AdIDs {
+				if _, ok := erased[adID]; !ok {
+					continue
+				}
+				if changed == nil {
+					changed = append([]notifications.Notification(nil), inbox...)
+				}
+				changed[i].RedactAd(adID)
+			}
+		}
+		if changed != nil {
+			put(r.store, r.notifications, uid, changed)
+		}
+	}
+}
+
+func (r *RepositoryMap) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	defer r.rlock()()
+	id, ok := r.emails[email]
+	if !ok {
+		return nil, app.ErrUserNotFound
+	}
+	u := r.userTable[id]
+	return &u, nil
+}
+
+func (r *RepositoryMap) UpdateUserPassword(ctx context.Context, id int64, hash []byte) error {
+	defer r.lock()()
+	u, ok := r.liveUser(id)
+	if !ok {
+		return app.ErrUserNotFound
+	}
+	u.PasswordHash = hash
+	put(r.store, r.userTable, id, u)
+	return nil
+}
+
+func (r *RepositoryMap) SetUserVerified(ctx context.Context, id int64, verified bool) error {
+	defer r.lock()()
+	u, ok := r.liveUser(id)
+	if !ok {
+		return app.ErrUserNotFound
+	}
+	u.Verified = verified
+	put(r.store, r.userTable, id, u)
+	return nil
+}
+
+func (r *RepositoryMap) AddToken(ctx context.Context, t user.Token) error {
+	defer r.lock()()
+	if _, ok := r.liveUser(t.UserID); !ok {
+		return app.ErrUserNotFound
+	}
+	put(r.store, r.tokens, t.Value, t)
+	return nil
+}
+
+func (r *RepositoryMap) GetToken(ctx context.Context, value string) (*user.Token, error) {
+	defer r.rlock()()
+	if t, ok := r.tokens[value]; !ok {
+		return nil, app.ErrInvalidToken
+	} else {
+		return &t, nil
+	}
+}
+
+func (r *RepositoryMap) DeleteToken(ctx context.Context, value string) error {
+	defer r.lock()()
+	if _, ok := r.tokens[value]; !ok {
+		return app.ErrInvalidToken
+	}
+	remove(r.store, r.tokens, value)
+	return nil
+}
+
+func (r *RepositoryMap) AddJob(ctx context.Context, j jobs.Job) (int64, error) {
+	defer r.lock()()
+	j.ID = r.nextJobID
+	r.nextJobID++
+	put(r.store, r.jobs, j.ID, j)
+	return j.ID, nil
+}
+
+func (r *RepositoryMap) GetJobByID(ctx context.Context, id int64) (*jobs.Job, error) {
+	defer r.rlock()()
+	if j, ok := r.jobs[id]; !ok {
+		return nil, app.ErrJobNotFound
+	} else {
+		return &j, nil
+	}
+}
+
+// ExpireJobResults removes the results, which expired before now, and returns the number of the removed results
+func (r *RepositoryMap) ExpireJobResults(ctx context.Context, now time.Time) (int, error) {
+	defer r.lock()()
+	expired := 0
+	for id, j := range r.jobs {
+		if j.Result != nil && j.Expired(now) {
+			j.Result, j.ContentType = nil, ""
+			put(r.store, r.jobs, id, j)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *RepositoryMap) UpdateJob(ctx context.Context, j jobs.Job) error {
+	defer r.lock()()
+	if _, ok := r.jobs[j.ID]; !ok {
+		return app.ErrJobNotFound
 	}
-	delete(r.user2ads, id)
-	delete(r.userTable, id)
+	put(r.store, r.jobs, j.ID, j)
+	return nil
+}
+
+// AddEvent writes the event to the outbox, the event is rolled back together with the transaction
+func (r *RepositoryMap) AddEvent(ctx context.Context, e events.Event) error {
+	defer r.lock()()
+	keep(r.store, &r.outbox)
+	r.outbox = append(r.outbox, events.Message{ID: r.nextMessageID, Event: e, Created: time.Now().UTC()})
+	r.nextMessageID++
 	return nil
 }
+
+func (r *RepositoryMap) PendingMessages(ctx context.Context, limit int) ([]events.Message, error) {
+	defer r.rlock()()
+	if limit > len(r.outbox) {
+		limit = len(r.outbox)
+	}
+	return append([]events.Message(nil), r.outbox[:limit]...), nil
+}
+
+func (r *RepositoryMap) DueMessages(ctx context.Context, now time.Time, limit int) ([]events.Message, error) {
+	defer r.rlock()()
+	type aggregate struct {
+		kind string
+		id   int64
+	}
+	waiting := make(map[aggregate]struct{})
+	due := make([]events.Message, 0)
+	for _, m := range r.outbox {
+		if len(due) == limit {
+			break
+		}
+		kind, id := m.Event.Aggregate()
+		key := aggregate{kind: kind, id: id}
+		if _, ok := waiting[key]; ok {
+			continue
+		}
+		if m.NextAttempt.After(now) {
+			waiting[key] = struct{}{}
+			continue
+		}
+		due = append(due, m)
+	}
+	return due, nil
+}
+
+// findMessage returns the index of the message in the outbox
+func (r *RepositoryMap) findMessage(id int64) (int, error) {
+	for i, m := range r.outbox {
+		if m.ID == id {
+			return i, nil
+		}
+	}
+	return 0, app.ErrMessageNotFound
+}
+
+func (r *RepositoryMap) MarkDelivered(ctx context.Context, id int64) error {
+	defer r.lock()()
+	i, err := r.findMessage(id)
+	if err != nil {
+		return err
+	}
+	outbox := modifiable(r.store, &r.outbox)
+	r.outbox = append(outbox[:i], outbox[i+1:]...)
+	return nil
+}
+
+func (r *RepositoryMap) MarkHandled(ctx context.Context, id int64, subscribers []string) error {
+	defer r.lock()()
+	i, err := r.findMessage(id)
+	if err != nil {
+		return err
+	}
+	outbox := modifiable(r.store, &r.outbox)
+	// the copy keeps the messages returned by PendingMessages unchanged
+	outbox[i].Handled = append(append([]string(nil), outbox[i].Handled...), subscribers...)
+	return nil
+}
+
+func (r *RepositoryMap) MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error {
+	defer r.lock()()
+	i, err := r.findMessage(id)
+	if err != nil {
+		return err
+	}
+	outbox := modifiable(r.store, &r.outbox)
+	outbox[i].Attempts++
+	outbox[i].LastError = reason
+	outbox[i].NextAttempt = next
+	return nil
+}
+
+func (r *RepositoryMap) MoveToDeadLetters(ctx context.Context, id int64, reason string) error {
+	defer r.lock()()
+	i, err := r.findMessage(id)
+	if err != nil {
+		return err
+	}
+	m := r.outbox[i]
+	m.Attempts++
+	m.LastError = reason
+	keep(r.store, &r.deadLetters)
+	r.deadLetters = append(r.deadLetters, m)
+	outbox := modifiable(r.store, &r.outbox)
+	r.outbox = append(outbox[:i], outbox[i+1:]...)
+	return nil
+}
+
+func (r *RepositoryMap) DeadLetters(ctx context.Context) ([]events.Message, error) {
+	defer r.rlock()()
+	return append(make([]events.Message, 0, len(r.deadLetters)), r.deadLetters...), nil
+}
+
+// deleteOwnedBy removes webhooks with their delivery logs, saved searches and notifications of the user
+func (r *RepositoryMap) deleteOwnedBy(uid int64) {
+	for id, s := range r.hooks {
+		if s.OwnerID == uid {
+			remove(r.store, r.hooks, id)
+			remove(r.store, r.deliveries, id)
+		}
+	}
+	for id, s := range r.searches {
+		if s.UserID == uid {
+			remove(r.store, r.searches, id)
+		}
+	}
+	remove(r.store, r.notifications, uid)
+}
+
+// copyWebhook keeps the stored subscription safe from changes of the returned one
+func copyWebhook(s webhooks.Subscription) webhooks.Subscription {
+	s.Events = append([]string(nil), s.Events...)
+	if s.DisabledAt != nil {
+		disabled := *s.DisabledAt
+		s.DisabledAt = &disabled
+	}
+	return s
+}
+
+func (r *RepositoryMap) AddWebhook(ctx context.Context, s webhooks.Subscription) (int64, error) {
+	defer r.lock()()
+	if _, ok := r.liveUser(s.OwnerID); !ok {
+		return 0, app.ErrUserNotFound
+	}
+	s.ID = r.nextWebhookID
+	r.nextWebhookID++
+	put(r.store, r.hooks, s.ID, copyWebhook(s))
+	return s.ID, nil
+}
+
+func (r *RepositoryMap) GetWebhookByID(ctx context.Context, id int64) (*webhooks.Subscription, error) {
+	defer r.rlock()()
+	s, ok := r.hooks[id]
+	if !ok {
+		return nil, app.ErrWebhookNotFound
+	}
+	s = copyWebhook(s)
+	return &s, nil
+}
+
+func (r *RepositoryMap) ListWebhooks(ctx context.Context, ownerID int64) ([]webhooks.Subscription, error) {
+	defer r.rlock()()
+	list := make([]webhooks.Subscription, 0)
+	for _, s := range r.hooks {
+		if s.OwnerID == ownerID {
+			list = append(list, copyWebhook(s))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// ActiveWebhooks skips the subscriptions of soft deleted users, they are back if the user is restored
+func (r *RepositoryMap) ActiveWebhooks(ctx context.Context, event string) ([]webhooks.Subscription, error) {
+	defer r.rlock()()
+	list := make([]webhooks.Subscription, 0)
+	for _, s := range r.hooks {
+		if _, ok := r.liveUser(s.OwnerID); ok && s.Active && s.Wants(event) {
+			list = append(list, copyWebhook(s))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (r *RepositoryMap) DeleteWebhook(ctx context.Context, id int64) error {
+	defer r.lock()()
+	if _, ok := r.hooks[id]; !ok {
+		return app.ErrWebhookNotFound
+	}
+	remove(r.store, r.hooks, id)
+	remove(r.store, r.deliveries, id)
+	return nil
+}
+
+func (r *RepositoryMap) SetWebhookActive(ctx context.Context, id int64, active bool) error {
+	defer r.lock()()
+	s, ok := r.hooks[id]
+	if !ok {
+		return app.ErrWebhookNotFound
+	}
+	s.Active = active
+	if active {
+		s.Failures = 0
+		s.DisabledAt = nil
+	} else if s.DisabledAt == nil {
+		now := time.Now().UTC()
+		s.DisabledAt = &now
+	}
+	put(r.store, r.hooks, id, s)
+	return nil
+}
+
+func (r *RepositoryMap) AddWebhookDelivery(ctx context.Context, d webhooks.Delivery) (int, error) {
+	defer r.lock()()
+	s, ok := r.hooks[d.SubscriptionID]
+	if !ok {
+		return 0, app.ErrWebhookNotFound
+	}
+	if d.Success {
+		s.Failures = 0
+	} else {
+		s.Failures++
+	}
+	put(r.store, r.hooks, s.ID, s)
+
+	history := append(r.deliveries[s.ID], d)
+	if len(history) > deliveryLogSize {
+		history = append([]webhooks.Delivery(nil), history[len(history)-deliveryLogSize:]...)
+	}
+	put(r.store, r.deliveries, s.ID, history)
+	return s.Failures, nil
+}
+
+func (r *RepositoryMap) ListWebhookDeliveries(ctx context.Context, id int64) ([]webhooks.Delivery, error) {
+	defer r.rlock()()
+	if _, ok := r.hooks[id]; !ok {
+		return nil, app.ErrWebhookNotFound
+	}
+	history := r.deliveries[id]
+	list := make([]webhooks.Delivery, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		list = append(list, history[i])
+	}
+	return list, nil
+}
+
+// copySearch keeps the stored search safe from changes of the returned one
+func copySearch(s searches.SavedSearch) searches.SavedSearch {
+	s.Pending = append([]int64(nil), s.Pending...)
+	s.Sent = append([]time.Time(nil), s.Sent...)
+	return s
+}
+
+func (r *RepositoryMap) AddSavedSearch(ctx context.Context, s searches.SavedSearch) (int64, error) {
+	defer r.lock()()
+	if _, ok := r.liveUser(s.UserID); !ok {
+		return 0, app.ErrUserNotFound
+	}
+	s.ID = r.nextSearchID
+	r.nextSearchID++
+	put(r.store, r.searches, s.ID, copySearch(s))
+	return s.ID, nil
+}
+
+func (r *RepositoryMap) GetSavedSearchByID(ctx context.Context, id int64) (*searches.SavedSearch, error) {
+	defer r.rlock()()
+	s, ok := r.searches[id]
+	if !ok {
+		return nil, app.ErrSearchNotFound
+	}
+	s = copySearch(s)
+	return &s, nil
+}
+
+func (r *RepositoryMap) ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error) {
+	defer r.rlock()()
+	list := make([]searches.SavedSearch, 0)
+	for _, s := range r.searches {
+		if s.UserID == uid {
+			list = append(list, copySearch(s))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (r *RepositoryMap) ActiveSavedSearches(ctx context.Context) ([]searches.SavedSearch, error) {
+	defer r.rlock()()
+	list := make([]searches.SavedSearch, 0)
+	for _, s := range r.searches {
+		if _, ok := r.liveUser(s.UserID); ok {
+			list = append(list, copySearch(s))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (r *RepositoryMap) UpdateSavedSearch(ctx context.Context, s searches.SavedSearch) error {
+	defer r.lock()()
+	if _, ok := r.searches[s.ID]; !ok {
+		return app.ErrSearchNotFound
+	}
+	put(r.store, r.searches, s.ID, copySearch(s))
+	return nil
+}
+
+func (r *RepositoryMap) DeleteSavedSearch(ctx context.Context, id int64) error {
+	defer r.lock()()
+	if _, ok := r.searches[id]; !ok {
+		return app.ErrSearchNotFound
+	}
+	remove(r.store, r.searches, id)
+	return nil
+}
+
+func (r *RepositoryMap) AddNotification(ctx context.Context, n notifications.Notification) (int64, error) {
+	defer r.lock()()
+	if _, ok := r.liveUser(n.UserID); !ok {
+		return 0, app.ErrUserNotFound
+	}
+	n.ID = r.nextNotificationID
+	r.nextNotificationID++
+	n.AdIDs = append([]int64(nil), n.AdIDs...)
+	put(r.store, r.notifications, n.UserID, append(r.notifications[n.UserID], n))
+	return n.ID, nil
+}
+
+func (r *RepositoryMap) ListNotifications(ctx context.Context, uid int64, params app.ListNotificationsParams) ([]notifications.Notification, error) {
+	defer r.rlock()()
+	inbox := r.notifications[uid]
+	list := make([]notifications.Notification, 0)
+	// notifications are appended, so their IDs grow towards the end of the inbox
+	for i := len(inbox) - 1; i >= 0; i-- {
+		if params.Limit > 0 && len(list) == params.Limit {
+			break
+		}
+		n := inbox[i]
+		if params.Before != nil && n.ID >= *params.Before || params.UnreadOnly && n.Read {
+			continue
+		}
+		n.AdIDs = append([]int64(nil), n.AdIDs...)
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (r *RepositoryMap) CountUnreadNotifications(ctx context.Context, uid int64) (int, error) {
+	defer r.rlock()()
+	count := 0
+	for _, n := range r.notifications[uid] {
+		if !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepositoryMap) MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error) {
+	defer r.lock()()
+	inbox := r.notifications[uid]
+	for i := range inbox {
+		if inbox[i].ID == id {
+			inbox = append([]notifications.Notification(nil), inbox...)
+			inbox[i].Read = true
+			put(r.store, r.notifications, uid, inbox)
+			n := inbox[i]
+			n.AdIDs = append([]int64(nil), n.AdIDs...)
+			return &n, nil
+		}
+	}
+	return nil, app.ErrNotificationNotFound
+}
+
+func (r *RepositoryMap) MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error) {
+	defer r.lock()()
+	inbox := append([]notifications.Notification(nil), r.notifications[uid]...)
+	count := 0
+	for i := range inbox {
+		if !inbox[i].Read {
+			inbox[i].Read = true
+			count++
+		}
+	}
+	if count > 0 {
+		put(r.store, r.notifications, uid, inbox)
+	}
+	return count, nil
+}
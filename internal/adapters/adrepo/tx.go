@@ -0,0 +1,110 @@
+package adrepo
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+)
+
+// InTransaction runs fn under the lock of the repository, so the transactions are serializable.
+// The changes are journaled and undone in the reverse order, when fn fails
+func (r *RepositoryMap) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) (err error) {
+	if r.inTx {
+		return fn(ctx, r)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.journal = make([]func(), 0)
+	defer func() {
+		if p := recover(); p != nil {
+			r.rollback()
+			panic(p)
+		}
+		if err != nil {
+			r.rollback()
+		}
+		r.journal = nil
+	}()
+	return fn(ctx, &RepositoryMap{mu: r.mu, inTx: true, store: r.store})
+}
+
+func (r *RepositoryMap) rollback() {
+	for i := len(r.journal) - 1; i >= 0; i-- {
+		r.journal[i]()
+	}
+	r.journal = nil
+}
+
+// lock takes the write lock of the repository and returns the function releasing it,
+// the views of transactions already run under the lock
+func (r *RepositoryMap) lock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.mu.Lock()
+	return r.mu.Unlock
+}
+
+// rlock takes the read lock for the methods, which do not change the store
+func (r *RepositoryMap) rlock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.mu.RLock()
+	return r.mu.RUnlock
+}
+
+// put and remove change the map and journal the change, when a transaction is running
+
+func put[K comparable, V any](s *store, m map[K]V, k K, v V) {
+	if s.journal != nil {
+		old, ok := m[k]
+		s.journal = append(s.journal, func() {
+			if ok {
+				m[k] = old
+			} else {
+				delete(m, k)
+			}
+		})
+	}
+	m[k] = v
+}
+
+func remove[K comparable, V any](s *store, m map[K]V, k K) {
+	old, ok := m[k]
+	if !ok {
+		return
+	}
+	if s.journal != nil {
+		s.journal = append(s.journal, func() { m[k] = old })
+	}
+	delete(m, k)
+}
+
+// set changes the value and journals the change, when a transaction is running
+func set[T any](s *store, p *T, v T) {
+	if s.journal != nil {
+		old := *p
+		s.journal = append(s.journal, func() { *p = old })
+	}
+	*p = v
+}
+
+// keep journals the slice header, so appending to the slice and cutting it can be undone
+func keep[T any](s *store, p *[]T) {
+	if s.journal != nil {
+		old := *p
+		s.journal = append(s.journal, func() { *p = old })
+	}
+}
+
+// modifiable returns the slice, which elements can be changed in place. Inside of a transaction
+// the slice is copied, so the journaled slice keeps the old elements
+func modifiable[T any](s *store, p *[]T) []T {
+	if s.journal == nil {
+		return *p
+	}
+	keep(s, p)
+	*p = append([]T(nil), *p...)
+	return *p
+}
@@ -0,0 +1,200 @@
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	_ app.AuditSink = (*MemorySink)(nil)
+	_ app.AuditSink = (*FileSink)(nil)
+)
+
+// MemorySink keeps the audit log in memory, it is used in tests and in development
+type MemorySink struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{records: make([]audit.Record, 0)}
+}
+
+func (s *MemorySink) Append(ctx context.Context, r audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *MemorySink) Query(ctx context.Context, filter audit.Filter) ([]audit.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]audit.Record, 0)
+	for _, r := range s.records {
+		if filter.Match(r) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemorySink) Redact(ctx context.Context, filters ...audit.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for i := range s.records {
+		if matchAny(filters, s.records[i]) && redact(&s.records[i]) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func matchAny(filters []audit.Filter, r audit.Record) bool {
+	for _, f := range filters {
+		if f.Match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact removes the snapshots from the record and reports whether it had any
+func redact(r *audit.Record) bool {
+	if r.Before == nil && r.After == nil {
+		return false
+	}
+	r.Before, r.After = nil, nil
+	return true
+}
+
+// FileSink appends the audit log to a file as JSON lines, one record per line
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log: %w", err)
+	}
+	return &FileSink{path: path, file: f}, nil
+}
+
+func (s *FileSink) Append(ctx context.Context, r audit.Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Query scans the whole file, the log is expected to be rotated by external tools
+func (s *FileSink) Query(ctx context.Context, filter audit.Filter) ([]audit.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]audit.Record, 0)
+	err := s.scan(func(r audit.Record) error {
+		if filter.Match(r) {
+			result = append(result, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Redact rewrites the whole file, the new file replaces the old one only when it is completely written
+func (s *FileSink) Redact(ctx context.Context, filters ...audit.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("can't redact audit log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n := 0
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	err = s.scan(func(r audit.Record) error {
+		if matchAny(filters, r) && redact(&r) {
+			n++
+		}
+		return enc.Encode(r)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return 0, fmt.Errorf("can't redact audit log: %w", err)
+	}
+
+	// the records are appended to the new file from now on
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("can't open audit log: %w", err)
+	}
+	_ = s.file.Close()
+	s.file = f
+	return n, nil
+}
+
+// scan passes the records of the file to fn in the order they were appended, it must be called under the lock
+func (s *FileSink) scan(fn func(r audit.Record) error) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("can't open audit log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r audit.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("corrupted audit log: %w", err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,63 @@
+package cacherepo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMiss is returned by backends for missing and expired keys
+var ErrMiss = errors.New("cache miss")
+
+// Backend is a cache shared by the instances of the service, such as Redis or Memcached. The local caches
+// stay in front of it, so the writes made by other instances are seen after the TTL of the local caches
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+// MemoryBackend is the in-memory stand-in for a shared cache, it is used in tests and in development
+type MemoryBackend struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{items: make(map[string]memoryItem)}
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item, ok := b.items[key]
+	if !ok || !time.Now().Before(item.expires) {
+		delete(b.items, key)
+		return nil, ErrMiss
+	}
+	return append([]byte(nil), item.value...), nil
+}
+
+func (b *MemoryBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items[key] = memoryItem{value: append([]byte(nil), value...), expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, keys ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		delete(b.items, key)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package cacherepo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats are the counters of one cache
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Invalidations counts the entries removed by writes
+	Invalidations uint64
+	Size          int
+}
+
+// lru keeps at most size entries, which live for ttl. Every removal bumps the generation,
+// so a value read from the repository before a write is not stored after the write invalidated it
+type lru[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	items   map[K]*list.Element
+	order   *list.List
+	gen     uint64
+	counter Stats
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func newLRU[K comparable, V any](size int, ttl time.Duration) *lru[K, V] {
+	return &lru[K, V]{size: size, ttl: ttl, items: make(map[K]*list.Element), order: list.New()}
+}
+
+func (c *lru[K, V]) get(k K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*entry[K, V])
+		if time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.counter.Hits++
+			return e.value, true
+		}
+		c.order.Remove(el)
+		delete(c.items, k)
+	}
+	c.counter.Misses++
+	var zero V
+	return zero, false
+}
+
+// generation must be taken before reading the value, which is passed to add
+func (c *lru[K, V]) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add stores the value unless an entry was removed after the generation was taken,
+// it reports whether the value is still current, also when the cache is disabled
+func (c *lru[K, V]) add(k K, v V, gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return false
+	}
+	if c.size <= 0 {
+		return true
+	}
+	e := &entry[K, V]{key: k, value: v, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.items[k]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return true
+	}
+	c.items[k] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry[K, V]).key)
+		c.counter.Evictions++
+	}
+	return true
+}
+
+func (c *lru[K, V]) remove(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, k := range keys {
+		if el, ok := c.items[k]; ok {
+			c.order.Remove(el)
+			delete(c.items, k)
+			c.counter.Invalidations++
+		}
+	}
+}
+
+// removeIf removes the entries, for which the function returns true
+func (c *lru[K, V]) removeIf(f func(k K, v V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for k, el := range c.items {
+		if f(k, el.Value.(*entry[K, V]).value) {
+			c.order.Remove(el)
+			delete(c.items, k)
+			c.counter.Invalidations++
+		}
+	}
+}
+
+func (c *lru[K, V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.counter.Invalidations += uint64(len(c.items))
+	c.items = make(map[K]*list.Element)
+	c.order.Init()
+}
+
+func (c *lru[K, V]) stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.counter
+	s.Size = len(c.items)
+	return s
+}
@@ -0,0 +1,452 @@
+package cacherepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultSize is the number of ads and users kept by the caches
+	DefaultSize = 10000
+	DefaultTTL  = time.Minute
+	// DefaultListSize is the number of lists of ads kept by the cache, lists live for DefaultListTTL
+	DefaultListSize = 256
+	DefaultListTTL  = time.Second
+)
+
+var _ app.Repository = (*Repository)(nil)
+
+// Repository caches ads and users by ID and lists of ads in front of any app.Repository.
+// The writes made through it invalidate the entries they change: the ad or the user itself,
+// the ads of a deleted or restored user and the lists, which contained the ad or match its new version.
+// Reads inside of transactions go to the wrapped repository, the changes of a transaction
+// are invalidated after it ends. The users found in the shared backend have no password hash,
+// so the code, which checks passwords, reads the user in a transaction
+type Repository struct {
+	app.Repository
+	c *caches
+	// pending collects the invalidations of the running transaction, it is nil outside of transactions
+	pending *invalidation
+}
+
+type caches struct {
+	ads   *lru[int64, ads.Ad]
+	users *lru[int64, user.User]
+	lists *lru[string, listEntry]
+
+	backend    Backend
+	backendTTL time.Duration
+}
+
+type listEntry struct {
+	params app.ListAdsParams
+	data   []ads.Ad
+}
+
+// invalidation lists the entries changed by writes
+type invalidation struct {
+	ads   []int64
+	users []int64
+	// changed are the new versions of the changed ads, the lists they match are invalidated
+	changed []ads.Ad
+	// lists invalidates all of the lists
+	lists bool
+}
+
+func (inv *invalidation) merge(other invalidation) {
+	inv.ads = append(inv.ads, other.ads...)
+	inv.users = append(inv.users, other.users...)
+	inv.changed = append(inv.changed, other.changed...)
+	inv.lists = inv.lists || other.lists
+}
+
+type Option func(*caches)
+
+// WithAdCache sets the number of ads kept by the cache and their lifetime
+func WithAdCache(size int, ttl time.Duration) Option {
+	return func(c *caches) {
+		c.ads = newLRU[int64, ads.Ad](size, ttl)
+	}
+}
+
+// WithUserCache sets the number of users kept by the cache and their lifetime
+func WithUserCache(size int, ttl time.Duration) Option {
+	return func(c *caches) {
+		c.users = newLRU[int64, user.User](size, ttl)
+	}
+}
+
+// WithListCache sets the number of lists of ads kept by the cache and their lifetime
+func WithListCache(size int, ttl time.Duration) Option {
+	return func(c *caches) {
+		c.lists = newLRU[string, listEntry](size, ttl)
+	}
+}
+
+// WithBackend puts the shared cache behind the local caches of ads and users,
+// lists are short-lived and stay local
+func WithBackend(b Backend, ttl time.Duration) Option {
+	return func(c *caches) {
+		c.backend = b
+		c.backendTTL = ttl
+	}
+}
+
+func New(repo app.Repository, opts ...Option) *Repository {
+	c := &caches{
+		ads:   newLRU[int64, ads.Ad](DefaultSize, DefaultTTL),
+		users: newLRU[int64, user.User](DefaultSize, DefaultTTL),
+		lists: newLRU[string, listEntry](DefaultListSize, DefaultListTTL),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return &Repository{Repository: repo, c: c}
+}
+
+// RepositoryStats are the counters of the caches of ads, users and lists
+type RepositoryStats struct {
+	Ads   Stats
+	Users Stats
+	Lists Stats
+}
+
+func (r *Repository) Stats() RepositoryStats {
+	return RepositoryStats{Ads: r.c.ads.stats(), Users: r.c.users.stats(), Lists: r.c.lists.stats()}
+}
+
+func adKey(id int64) string {
+	return fmt.Sprintf("ad:%d", id)
+}
+
+func userKey(id int64) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+// listKey identifies the filters of the list
+func listKey(p app.ListAdsParams) string {
+	key := fmt.Sprintf("deleted=%t", p.IncludeDeleted)
+	if p.Published != nil {
+		key += fmt.Sprintf("|published=%t", *p.Published)
+	}
+	if p.Uid != nil {
+		key += fmt.Sprintf("|uid=%d", *p.Uid)
+	}
+	if p.Date != nil {
+		key += "|date=" + p.Date.Format("2006-01-02")
+	}
+	if p.Title != nil {
+		key += fmt.Sprintf("|title=%q", *p.Title)
+	}
+	if p.Text != nil {
+		key += fmt.Sprintf("|text=%q", *p.Text)
+	}
+	return key
+}
+
+// read looks the value up in the local cache and then in the backend before loading it from the repository
+func read[V any](ctx context.Context, c *caches, local *lru[int64, V], id int64, key string, load func() (*V, error)) (*V, error) {
+	if v, ok := local.get(id); ok {
+		return &v, nil
+	}
+	gen := local.generation()
+	var v V
+	if c.fromBackend(ctx, key, &v) {
+		local.add(id, v, gen)
+		return &v, nil
+	}
+	res, err := load()
+	if err != nil {
+		return nil, err
+	}
+	// the value loaded before an invalidation is stale and must not get into the shared cache
+	if !local.add(id, *res, gen) {
+		return res, nil
+	}
+	c.toBackend(ctx, key, *res)
+	// the invalidation between the check and the write could miss the written value, so it is removed again
+	if local.generation() != gen {
+		c.deleteFromBackend(ctx, key)
+	}
+	return res, nil
+}
+
+// the failures of the backend are logged and the repository is used as if the key was missing
+
+func (c *caches) fromBackend(ctx context.Context, key string, v any) bool {
+	if c.backend == nil {
+		return false
+	}
+	data, err := c.backend.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrMiss) {
+			log.Printf("cache backend: can't get %s: %v\n", key, err)
+		}
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// toBackend never shares the password hash of the user, so the users read from the backend have none
+func (c *caches) toBackend(ctx context.Context, key string, v any) {
+	if c.backend == nil {
+		return
+	}
+	if u, ok := v.(user.User); ok {
+		u.PasswordHash = nil
+		v = u
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = c.backend.Set(ctx, key, data, c.backendTTL)
+	}
+	if err != nil {
+		log.Printf("cache backend: can't set %s: %v\n", key, err)
+	}
+}
+
+func (c *caches) invalidate(ctx context.Context, inv invalidation) {
+	keys := make([]string, 0, len(inv.ads)+len(inv.users))
+	if len(inv.ads) > 0 {
+		c.ads.remove(inv.ads...)
+		for _, id := range inv.ads {
+			keys = append(keys, adKey(id))
+		}
+	}
+	if len(inv.users) > 0 {
+		c.users.remove(inv.users...)
+		for _, id := range inv.users {
+			keys = append(keys, userKey(id))
+		}
+	}
+	switch {
+	case inv.lists:
+		c.lists.clear()
+	case len(inv.ads) > 0 || len(inv.changed) > 0:
+		c.lists.removeIf(func(_ string, e listEntry) bool {
+			return e.contains(inv.ads) || e.matches(inv.changed)
+		})
+	}
+	if len(keys) > 0 {
+		c.deleteFromBackend(ctx, keys...)
+	}
+}
+
+func (c *caches) deleteFromBackend(ctx context.Context, keys ...string) {
+	if c.backend == nil {
+		return
+	}
+	if err := c.backend.Delete(ctx, keys...); err != nil {
+		log.Printf("cache backend: can't delete %v: %v\n", keys, err)
+	}
+}
+
+func (e listEntry) contains(ids []int64) bool {
+	for _, ad := range e.data {
+		for _, id := range ids {
+			if ad.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e listEntry) matches(changed []ads.Ad) bool {
+	for _, ad := range changed {
+		if e.params.Match(ad) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Repository) invalidate(ctx context.Context, inv invalidation) {
+	if r.pending != nil {
+		r.pending.merge(inv)
+		return
+	}
+	r.c.invalidate(ctx, inv)
+}
+
+// InTransaction passes the view of the transaction, which reads without the caches and collects the invalidations.
+// They are applied after the transaction ends, so the entries read before the commit do not survive it.
+// A rolled back transaction invalidates its entries as well, which costs only a few misses
+func (r *Repository) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	if r.pending != nil {
+		return r.Repository.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+			return fn(ctx, &Repository{Repository: tx, c: r.c, pending: r.pending})
+		})
+	}
+	pending := &invalidation{}
+	err := r.Repository.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		return fn(ctx, &Repository{Repository: tx, c: r.c, pending: pending})
+	})
+	r.c.invalidate(ctx, *pending)
+	return err
+}
+
+func (r *Repository) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error) {
+	if r.pending != nil {
+		return r.Repository.GetAdByID(ctx, id)
+	}
+	return read(ctx, r.c, r.c.ads, id, adKey(id), func() (*ads.Ad, error) {
+		return r.Repository.GetAdByID(ctx, id)
+	})
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, id int64) (*user.User, error) {
+	if r.pending != nil {
+		return r.Repository.GetUserByID(ctx, id)
+	}
+	u, err := read(ctx, r.c, r.c.users, id, userKey(id), func() (*user.User, error) {
+		return r.Repository.GetUserByID(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the cached user shares the hash with the returned one
+	u.PasswordHash = append([]byte(nil), u.PasswordHash...)
+	return u, nil
+}
+
+func (r *Repository) GetAdList(ctx context.Context, params app.ListAdsParams) (*ads.AdList, error) {
+	if r.pending != nil {
+		return r.Repository.GetAdList(ctx, params)
+	}
+	key := listKey(params)
+	if e, ok := r.c.lists.get(key); ok {
+		return &ads.AdList{Data: append([]ads.Ad(nil), e.data...)}, nil
+	}
+	gen := r.c.lists.generation()
+	al, err := r.Repository.GetAdList(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	r.c.lists.add(key, listEntry{params: params, data: append([]ads.Ad(nil), al.Data...)}, gen)
+	return al, nil
+}
+
+func (r *Repository) AddAd(ctx context.Context, ad ads.Ad) (int64, error) {
+	id, err := r.Repository.AddAd(ctx, ad)
+	if err != nil {
+		return 0, err
+	}
+	ad.ID = id
+	r.invalidate(ctx, invalidation{changed: []ads.Ad{ad}})
+	return id, nil
+}
+
+// changeAd invalidates the ad after the change together with the lists, which contained the ad or match it now
+func (r *Repository) changeAd(ctx context.Context, id int64, err error) error {
+	if err != nil {
+		return err
+	}
+	inv := invalidation{ads: []int64{id}}
+	if ad, err := r.Repository.GetAdByID(ctx, id); err == nil {
+		inv.changed = append(inv.changed, *ad)
+	}
+	r.invalidate(ctx, inv)
+	return nil
+}
+
+func (r *Repository) UpdateAdStatus(ctx context.Context, id int64, published bool, date time.Time) error {
+	return r.changeAd(ctx, id, r.Repository.UpdateAdStatus(ctx, id, published, date))
+}
+
+func (r *Repository) UpdateAdContent(ctx context.Context, id int64, title string, text string, date time.Time) error {
+	return r.changeAd(ctx, id, r.Repository.UpdateAdContent(ctx, id, title, text, date))
+}
+
+func (r *Repository) DeleteAdByID(ctx context.Context, id int64) error {
+	return r.changeAd(ctx, id, r.Repository.DeleteAdByID(ctx, id))
+}
+
+func (r *Repository) RestoreAdByID(ctx context.Context, id int64) error {
+	return r.changeAd(ctx, id, r.Repository.RestoreAdByID(ctx, id))
+}
+
+// changeUser makes the change, which affects all of the ads of the user, in one transaction with finding the ads,
+// and invalidates the user with the ads and all of the lists
+func (r *Repository) changeUser(ctx context.Context, id int64, change func(ctx context.Context, tx app.Repository) error) error {
+	inv := invalidation{users: []int64{id}, lists: true}
+	err := r.Repository.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		owned, err := tx.GetAdList(ctx, app.ListAdsParams{Uid: &id, IncludeDeleted: true})
+		if err != nil {
+			return err
+		}
+		for _, ad := range owned.Data {
+			inv.ads = append(inv.ads, ad.ID)
+		}
+		return change(ctx, tx)
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidate(ctx, inv)
+	return nil
+}
+
+func (r *Repository) DeleteUserByID(ctx context.Context, id int64) error {
+	return r.changeUser(ctx, id, func(ctx context.Context, tx app.Repository) error {
+		return tx.DeleteUserByID(ctx, id)
+	})
+}
+
+func (r *Repository) DeleteUserKeepAds(ctx context.Context, id int64, newAuthor int64) error {
+	return r.changeUser(ctx, id, func(ctx context.Context, tx app.Repository) error {
+		return tx.DeleteUserKeepAds(ctx, id, newAuthor)
+	})
+}
+
+func (r *Repository) RestoreUserByID(ctx context.Context, id int64) error {
+	return r.changeUser(ctx, id, func(ctx context.Context, tx app.Repository) error {
+		return tx.RestoreUserByID(ctx, id)
+	})
+}
+
+func (r *Repository) EraseUser(ctx context.Context, id int64) error {
+	return r.changeUser(ctx, id, func(ctx context.Context, tx app.Repository) error {
+		return tx.EraseUser(ctx, id)
+	})
+}
+
+// changeUserOnly invalidates the user after the change, which does not affect the ads
+func (r *Repository) changeUserOnly(ctx context.Context, id int64, err error) error {
+	if err != nil {
+		return err
+	}
+	r.invalidate(ctx, invalidation{users: []int64{id}})
+	return nil
+}
+
+func (r *Repository) UpdateUser(ctx context.Context, id int64, nickname string, email string) error {
+	return r.changeUserOnly(ctx, id, r.Repository.UpdateUser(ctx, id, nickname, email))
+}
+
+func (r *Repository) UpdateUserPassword(ctx context.Context, id int64, hash []byte) error {
+	return r.changeUserOnly(ctx, id, r.Repository.UpdateUserPassword(ctx, id, hash))
+}
+
+func (r *Repository) SetUserVerified(ctx context.Context, id int64, verified bool) error {
+	return r.changeUserOnly(ctx, id, r.Repository.SetUserVerified(ctx, id, verified))
+}
+
+// PurgeDeleted removes only soft deleted records, which are not cached by ID, but may be in the lists
+func (r *Repository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	n, err := r.Repository.PurgeDeleted(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		r.invalidate(ctx, invalidation{lists: true})
+	}
+	return n, nil
+}
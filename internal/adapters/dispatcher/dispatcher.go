@@ -0,0 +1,337 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	DefaultMaxFailures = 10
+	DefaultTimeout     = 10 * time.Second
+)
+
+// ErrStopped is returned by Handle after the shutdown of the dispatcher, so the event is delivered again later
+var ErrStopped = errors.New("webhook dispatcher is stopped")
+
+// Dispatcher delivers ad lifecycle events to webhook subscriptions. Every subscription has its own queue,
+// so a slow partner delays neither the other partners nor the event bus, and the events of a subscription
+// are sent in order. Every delivery is retried with exponential backoff, a delivery fails when all attempts
+// fail, and after maxFailures failed deliveries in a row the subscription is disabled until its owner
+// enables it again. Deliveries run under the context of the dispatcher, which is cancelled on shutdown
+type Dispatcher struct {
+	repository  app.WebhookRepository
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxFailures int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	queues map[int64][]job
+	closed bool
+}
+
+// job is a queued delivery of the event to the subscription
+type job struct {
+	delivery     string
+	subscription webhooks.Subscription
+	event        string
+	body         []byte
+}
+
+type Option func(*Dispatcher)
+
+func WithClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetry sets the number of attempts of a delivery and the delay before the first retry,
+// the delay doubles with every failed attempt
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+// WithMaxFailures sets the number of deliveries failed in a row, which disables a subscription
+func WithMaxFailures(n int) Option {
+	return func(d *Dispatcher) {
+		d.maxFailures = n
+	}
+}
+
+func New(repo app.WebhookRepository, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		repository:  repo,
+		client:      &http.Client{Timeout: DefaultTimeout},
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultBackoff,
+		maxFailures: DefaultMaxFailures,
+		queues:      make(map[int64][]job),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Run stops the dispatcher, when the context is done: the running deliveries are cancelled
+// and the queued ones are dropped
+func (d *Dispatcher) Run(ctx context.Context) func() error {
+	return func() error {
+		<-ctx.Done()
+		d.Stop()
+		return nil
+	}
+}
+
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Wait blocks until the queues are empty, it must not be called together with Handle
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+type adPayload struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Text        string `json:"text,omitempty"`
+	AuthorID    int64  `json:"author_id"`
+	Published   bool   `json:"published"`
+	DateCreated string `json:"date_created,omitempty"`
+	DateChanged string `json:"date_changed,omitempty"`
+}
+
+type payload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Ad         adPayload `json:"ad"`
+}
+
+func newAdPayload(ad ads.Ad) adPayload {
+	return adPayload{
+		ID:          ad.ID,
+		Title:       ad.Title,
+		Text:        ad.Text,
+		AuthorID:    ad.AuthorID,
+		Published:   ad.Published,
+		DateCreated: ad.DateCreated.Format(time.RFC3339),
+		DateChanged: ad.DateChanged.Format(time.RFC3339),
+	}
+}
+
+// newPayload returns false for the events, which are not sent to webhooks. Changes of unpublished
+// ads are not sent either: partners see the same ads as GET /api/v1/ads shows
+func newPayload(e events.Event) (payload, bool) {
+	p := payload{Event: e.EventName(), OccurredAt: e.OccurredAt()}
+	switch t := e.(type) {
+	case events.AdPublished:
+		p.Ad = newAdPayload(t.Ad)
+	case events.AdUnpublished:
+		p.Ad = newAdPayload(t.Ad)
+	case events.AdUpdated:
+		if !t.Ad.Published {
+			return payload{}, false
+		}
+		p.Ad = newAdPayload(t.Ad)
+	case events.AdDeleted:
+		p.Ad = adPayload{ID: t.AdID, AuthorID: t.AuthorID}
+	case events.AdRestored:
+		if !t.Ad.Published {
+			return payload{}, false
+		}
+		p.Ad = newAdPayload(t.Ad)
+	default:
+		return payload{}, false
+	}
+	return p, true
+}
+
+// Handle is the event bus handler of the dispatcher, it never waits for the partners:
+// the event is handled, when it is queued for every subscription
+func (d *Dispatcher) Handle(ctx context.Context, e events.Event) error {
+	p, ok := newPayload(e)
+	if !ok {
+		return nil
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := d.repository.ActiveWebhooks(ctx, e.EventName())
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrStopped
+	}
+	message, fromOutbox := events.MessageID(ctx)
+	for _, s := range subscriptions {
+		j := job{delivery: audit.NewRequestID(), subscription: s, event: e.EventName(), body: body}
+		if fromOutbox {
+			j.delivery = deliveryID(message, s.ID)
+		}
+		d.push(j)
+	}
+	return nil
+}
+
+// deliveryID identifies the delivery of the outbox message to the subscription, it stays the same,
+// when the message is delivered again, so partners can drop the duplicates
+func deliveryID(message, subscription int64) string {
+	return fmt.Sprintf("%d-%d", message, subscription)
+}
+
+// push queues the job, it starts the worker of the subscription, unless it is running already.
+// It must be called under the lock
+func (d *Dispatcher) push(j job) {
+	id := j.subscription.ID
+	queue, running := d.queues[id]
+	d.queues[id] = append(queue, j)
+	if running {
+		return
+	}
+	d.wg.Add(1)
+	go d.work(id)
+}
+
+// work delivers the jobs of the subscription one by one, it returns when the queue is empty
+func (d *Dispatcher) work(id int64) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		queue := d.queues[id]
+		if len(queue) == 0 {
+			delete(d.queues, id)
+			d.mu.Unlock()
+			return
+		}
+		j := queue[0]
+		d.queues[id] = queue[1:]
+		d.mu.Unlock()
+
+		if d.ctx.Err() != nil {
+			log.Printf("webhooks: delivery of %s to subscription %d is dropped on shutdown\n", j.event, id)
+			continue
+		}
+		if disabled := d.deliver(d.ctx, j); disabled {
+			d.drop(id)
+		}
+	}
+}
+
+// drop removes the queued jobs of the disabled subscription
+func (d *Dispatcher) drop(id int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n := len(d.queues[id]); n > 0 {
+		log.Printf("webhooks: %d deliveries to the disabled subscription %d are dropped\n", n, id)
+	}
+	d.queues[id] = nil
+}
+
+// deliver makes the attempts of the delivery and logs it, it returns true, when the subscription is disabled
+// after the failure. Deliveries interrupted by the shutdown are not logged and do not count as failures
+func (d *Dispatcher) deliver(ctx context.Context, j job) bool {
+	s := j.subscription
+	delivery := webhooks.Delivery{
+		ID:             j.delivery,
+		SubscriptionID: s.ID,
+		Event:          j.event,
+		Time:           time.Now().UTC(),
+	}
+	backoff := d.backoff
+	for {
+		delivery.Attempts++
+		delivery.StatusCode, delivery.Error = d.send(ctx, s, delivery.ID, j.event, j.body)
+		delivery.Success = delivery.Error == ""
+		if delivery.Success || delivery.Attempts >= d.maxAttempts || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+		}
+	}
+	delivery.Duration = time.Since(delivery.Time)
+	if !delivery.Success && ctx.Err() != nil {
+		log.Printf("webhooks: delivery %s of subscription %d is interrupted by the shutdown\n", delivery.ID, s.ID)
+		return false
+	}
+
+	failures, err := d.repository.AddWebhookDelivery(ctx, delivery)
+	if err != nil {
+		// usually the subscription was deleted during the delivery
+		log.Printf("webhooks: can't log delivery %s of subscription %d: %s\n", delivery.ID, s.ID, err.Error())
+		return false
+	}
+	if delivery.Success || failures < d.maxFailures {
+		return false
+	}
+	log.Printf("webhooks: subscription %d is disabled after %d failed deliveries: %s\n",
+		s.ID, failures, delivery.Error)
+	if err := d.repository.SetWebhookActive(ctx, s.ID, false); err != nil {
+		log.Printf("webhooks: can't disable subscription %d: %s\n", s.ID, err.Error())
+	}
+	return true
+}
+
+// send makes one attempt and returns the response status and the reason of the failure, if any
+func (d *Dispatcher) send(ctx context.Context, s webhooks.Subscription, id string, event string, body []byte) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.EventHeader, event)
+	req.Header.Set(webhooks.DeliveryHeader, id)
+	req.Header.Set(webhooks.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(s.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	// the body is drained to reuse the connection
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status code: %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
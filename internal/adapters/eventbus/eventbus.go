@@ -0,0 +1,258 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 100 * time.Millisecond
+)
+
+var _ app.OutboxPublisher = (*Bus)(nil)
+
+var (
+	// ErrNotHandled is returned by Deliver, when some of the subscribers failed to handle the event
+	ErrNotHandled = errors.New("event is not handled")
+	// ErrStopped is returned by Deliver after the shutdown of the bus
+	ErrStopped = errors.New("event bus is stopped")
+)
+
+// Handler processes an event, a returned error or a panic makes the bus deliver the event again
+type Handler func(ctx context.Context, e events.Event) error
+
+// Bus delivers events to subscribers in the background, every subscriber has its own queue,
+// so a slow or failing subscriber does not delay the other subscribers. An event is retried with
+// exponential backoff until the handler succeeds or MaxAttempts is reached. Published events are
+// dropped after that, while Deliver reports the failure to the outbox relay, which retries the event
+// or moves it to the dead letters, so the events of the outbox are delivered at-least-once
+// and handlers must be idempotent
+type Bus struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+	maxAttempts int
+	backoff     time.Duration
+}
+
+type Option func(*Bus)
+
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(b *Bus) {
+		b.maxAttempts = maxAttempts
+		b.backoff = backoff
+	}
+}
+
+func New(opts ...Option) *Bus {
+	b := &Bus{maxAttempts: DefaultMaxAttempts, backoff: DefaultBackoff}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscribe registers the handler, it must be called before Run
+func (b *Bus) Subscribe(name string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &subscriber{name: name, handler: h}
+	s.cond = sync.NewCond(&s.mu)
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Publish never blocks: the event is queued for every subscriber
+func (b *Bus) Publish(ctx context.Context, e events.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subscribers {
+		_ = s.push(delivery{ctx: context.Background(), event: e})
+	}
+}
+
+// Deliver lets the bus be the publisher of the outbox relay: the event is queued for every subscriber,
+// which has not handled the message yet, and Deliver waits, until all of them handle it. It returns the
+// subscribers, which handled the event, and the error names the ones, which ran out of attempts, so the relay
+// delivers the event again only to them. The bus must be running, otherwise Deliver waits for the context
+func (b *Bus) Deliver(ctx context.Context, m events.Message) ([]string, error) {
+	type result struct {
+		subscriber string
+		err        error
+	}
+
+	b.mu.Lock()
+	// the channel has room for every outcome, so the subscribers never wait for Deliver
+	results := make(chan result, len(b.subscribers))
+	handlerCtx := events.WithMessageID(context.Background(), m.ID)
+	n := 0
+	for _, s := range b.subscribers {
+		if m.HandledBy(s.name) {
+			continue
+		}
+		name := s.name
+		pushed := s.push(delivery{ctx: handlerCtx, event: m.Event, done: func(err error) {
+			results <- result{subscriber: name, err: err}
+		}})
+		if !pushed {
+			b.mu.Unlock()
+			return nil, ErrStopped
+		}
+		n++
+	}
+	b.mu.Unlock()
+
+	var handled, failed []string
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", r.subscriber, r.err.Error()))
+			} else {
+				handled = append(handled, r.subscriber)
+			}
+		case <-ctx.Done():
+			return handled, ctx.Err()
+		}
+	}
+	if len(failed) > 0 {
+		return handled, fmt.Errorf("%w by %s", ErrNotHandled, strings.Join(failed, "; "))
+	}
+	return handled, nil
+}
+
+// Run delivers events until the context is done, then the queued events are delivered
+// once more without retries and the function returns
+func (b *Bus) Run(ctx context.Context) func() error {
+	return func() error {
+		b.mu.Lock()
+		subscribers := append([]*subscriber(nil), b.subscribers...)
+		b.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, s := range subscribers {
+			wg.Add(1)
+			go func(s *subscriber) {
+				defer wg.Done()
+				b.work(ctx, s)
+			}(s)
+		}
+
+		<-ctx.Done()
+		for _, s := range subscribers {
+			s.close()
+		}
+		wg.Wait()
+		return nil
+	}
+}
+
+func (b *Bus) work(ctx context.Context, s *subscriber) {
+	for {
+		d, ok := s.pop()
+		if !ok {
+			return
+		}
+		err := b.deliver(ctx, s, d)
+		if d.done != nil {
+			d.done(err)
+		} else if err != nil {
+			log.Printf("event bus: subscriber %s dropped %s: %s\n", s.name, d.event.EventName(), err.Error())
+		}
+	}
+}
+
+// deliver returns the error of the last attempt, when the handler runs out of attempts
+func (b *Bus) deliver(ctx context.Context, s *subscriber, d delivery) error {
+	backoff := b.backoff
+	for attempt := 1; ; attempt++ {
+		err := s.call(d.ctx, d.event)
+		if err == nil {
+			return nil
+		}
+		if attempt >= b.maxAttempts || ctx.Err() != nil {
+			return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+		}
+	}
+}
+
+type subscriber struct {
+	name    string
+	handler Handler
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []delivery
+	closed bool
+}
+
+// delivery is the queued event, done gets the outcome of the event delivered by Deliver.
+// The handler gets ctx, it is never cancelled, because handlers outlive the request, which caused
+// the event, and the shutdown of the bus
+type delivery struct {
+	ctx   context.Context
+	event events.Event
+	done  func(err error)
+}
+
+// push queues the event, it returns false after the subscriber is closed, because nobody may handle the event then
+func (s *subscriber) push(d delivery) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.queue = append(s.queue, d)
+	s.cond.Signal()
+	return true
+}
+
+// pop waits for the next event, it returns false when the subscriber is closed and the queue is empty
+func (s *subscriber) pop() (delivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return delivery{}, false
+	}
+	d := s.queue[0]
+	s.queue[0] = delivery{}
+	s.queue = s.queue[1:]
+	return d, true
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// call isolates the bus from panics of the handler
+func (s *subscriber) call(ctx context.Context, e events.Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return s.handler(ctx, e)
+}
+
+// LogHandler writes every event to the log
+func LogHandler(ctx context.Context, e events.Event) error {
+	log.Printf("event: %s at %s: %+v\n", e.EventName(), e.OccurredAt().Format(time.RFC3339), e)
+	return nil
+}
@@ -0,0 +1,103 @@
+package notifier
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"log"
+	"sync"
+)
+
+// DefaultSubscriptionBuffer is the number of notifications waiting for a slow subscriber
+const DefaultSubscriptionBuffer = 16
+
+var _ app.NotificationHub = (*Hub)(nil)
+
+// Hub passes notifications to the live subscriptions of users. Publish never blocks: notifications
+// are dropped for a subscriber, whose buffer is full, the subscriber can still find them in the inbox
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[int64]map[chan notifications.Notification]struct{}
+	buffer int
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs:   make(map[int64]map[chan notifications.Notification]struct{}),
+		buffer: DefaultSubscriptionBuffer,
+	}
+}
+
+func (h *Hub) Publish(n notifications.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+			log.Printf("notifier: subscriber of user %d is too slow, notification %d is dropped\n", n.UserID, n.ID)
+		}
+	}
+}
+
+func (h *Hub) Subscribe(ctx context.Context, uid int64) <-chan notifications.Notification {
+	ch := make(chan notifications.Notification, h.buffer)
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(ch)
+		return ch
+	}
+	if h.subs[uid] == nil {
+		h.subs[uid] = make(map[chan notifications.Notification]struct{})
+	}
+	h.subs[uid][ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		// the channel is already closed if the hub is closed
+		if _, ok := h.subs[uid][ch]; !ok {
+			return
+		}
+		delete(h.subs[uid], ch)
+		if len(h.subs[uid]) == 0 {
+			delete(h.subs, uid)
+		}
+		// closed under the lock, so Publish never sends to a closed channel
+		close(ch)
+	}()
+	return ch
+}
+
+// Subscribers returns the number of live subscriptions of the user
+func (h *Hub) Subscribers(uid int64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[uid])
+}
+
+// Close ends all subscriptions, so that the streaming calls return and the servers can stop gracefully
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for uid, subs := range h.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(h.subs, uid)
+	}
+}
+
+// Run closes the hub when the context is done
+func (h *Hub) Run(ctx context.Context) func() error {
+	return func() error {
+		<-ctx.Done()
+		h.Close()
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"log"
+)
+
+var (
+	_ app.Notifier = (*Inbox)(nil)
+	_ app.Notifier = (*Email)(nil)
+	_ app.Notifier = Multi(nil)
+)
+
+// Inbox stores notifications in the in-app inbox of the user and passes them to the live subscriptions
+type Inbox struct {
+	repository app.NotificationRepository
+	hub        app.NotificationHub
+}
+
+// NewInbox returns the inbox notifier, hub can be nil if there are no live subscriptions
+func NewInbox(repo app.NotificationRepository, hub app.NotificationHub) *Inbox {
+	return &Inbox{repository: repo, hub: hub}
+}
+
+func (i *Inbox) Notify(ctx context.Context, n notifications.Notification) error {
+	id, err := i.repository.AddNotification(ctx, n)
+	if err != nil {
+		return err
+	}
+	if i.hub != nil {
+		n.ID = id
+		i.hub.Publish(n)
+	}
+	return nil
+}
+
+// Email sends notifications to the email of the user, users with unverified emails are skipped
+type Email struct {
+	users  app.UserRepository
+	mailer app.Mailer
+}
+
+func NewEmail(users app.UserRepository, mailer app.Mailer) *Email {
+	return &Email{users: users, mailer: mailer}
+}
+
+func (e *Email) Notify(ctx context.Context, n notifications.Notification) error {
+	u, err := e.users.GetUserByID(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if !u.Verified {
+		return nil
+	}
+	return e.mailer.Send(ctx, u.Email, n.Subject, n.Text)
+}
+
+// Multi delivers notifications with every notifier. It fails only if all of them fail,
+// otherwise a retry would repeat the notification in the channels, which got it
+type Multi []app.Notifier
+
+func (m Multi) Notify(ctx context.Context, n notifications.Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, n); err != nil {
+			log.Printf("notifier: %s for user %d failed: %s\n", n.Kind, n.UserID, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(m) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package adminauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// ErrUnauthorized is returned to the clients of the admin endpoints without the valid admin token
+var ErrUnauthorized = errors.New("admin token is missing or invalid")
+
+const (
+	// Header carries the admin token as "Bearer <token>", it is the authorization metadata in gRPC
+	Header = "Authorization"
+	Scheme = "Bearer"
+)
+
+// Authorized reports whether the value of the Authorization header carries the token.
+// The empty token authorizes nobody, so the admin endpoints are closed until the token is configured
+func Authorized(token string, header string) bool {
+	if token == "" {
+		return false
+	}
+	scheme, value, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, Scheme) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(value)), []byte(token)) == 1
+}
@@ -1,6 +1,12 @@
 package ads
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// AnonymousAuthorID is set as the author of ads, which were kept after their author deleted the account
+const AnonymousAuthorID int64 = -1
 
 type Ad struct {
 	ID          int64
@@ -10,8 +16,15 @@ type Ad struct {
 	Published   bool
 	DateCreated time.Time
 	DateChanged time.Time
+	DeletedAt   *time.Time
 }
 
 type AdList struct {
 	Data []Ad
 }
+
+// Contains tells if the title or the text of the ad contains the string ignoring case
+func Contains(ad Ad, s string) bool {
+	s = strings.ToLower(s)
+	return strings.Contains(strings.ToLower(ad.Title), s) || strings.Contains(strings.ToLower(ad.Text), s)
+}
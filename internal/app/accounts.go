@@ -0,0 +1,223 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/validator"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+const (
+	VerificationTokenTTL  = 24 * time.Hour
+	PasswordResetTokenTTL = time.Hour
+
+	tokenLength = 32
+)
+
+type credentials struct {
+	Password string `validate:"min:8; max:72"`
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if err := validator.Validate(credentials{Password: password}); err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func (a Application) issueToken(ctx context.Context, uid int64, purpose user.TokenPurpose, ttl time.Duration) (string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	t := user.Token{
+		Value:   hex.EncodeToString(b),
+		UserID:  uid,
+		Purpose: purpose,
+		Expires: time.Now().UTC().Add(ttl),
+	}
+	if err := a.repository.AddToken(ctx, t); err != nil {
+		return "", err
+	}
+	return t.Value, nil
+}
+
+// consumeToken checks that the token exists, has the expected purpose and is not expired,
+// the token is deleted, so that it can not be used twice
+func (a Application) consumeToken(ctx context.Context, value string, purpose user.TokenPurpose) (*user.Token, error) {
+	t, err := a.repository.GetToken(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	if t.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	if err := a.repository.DeleteToken(ctx, value); err != nil {
+		return nil, err
+	}
+	if time.Now().UTC().After(t.Expires) {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
+
+func (a Application) sendVerification(ctx context.Context, u *user.User) error {
+	if a.mailer == nil || u.Verified {
+		return nil
+	}
+	token, err := a.issueToken(ctx, u.ID, user.TokenVerifyEmail, VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hello, %s!\n\nUse this token to verify your email: %s\nThe token expires in %s.\n",
+		u.Nickname, token, VerificationTokenTTL)
+	return a.mailer.Send(ctx, u.Email, "Email verification", body)
+}
+
+func (a Application) Register(ctx context.Context, nickname string, email string, password string) (*user.User, error) {
+	u := user.User{Nickname: nickname, Email: email, Verified: a.mailer == nil}
+	if err := validator.Validate(u); err != nil {
+		return nil, err
+	}
+
+	var err error
+	u.PasswordHash, err = hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		_, err := tx.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil:
+			return ErrEmailTaken
+		case !errors.Is(err, ErrUserNotFound):
+			return err
+		}
+		u.ID, err = tx.AddUser(ctx, u)
+		if err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, userCreated(u))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.sendVerification(ctx, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (a Application) VerifyEmail(ctx context.Context, token string) (*user.User, error) {
+	t, err := a.consumeToken(ctx, token, user.TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.repository.SetUserVerified(ctx, t.UserID, true); err != nil {
+		return nil, err
+	}
+
+	return a.repository.GetUserByID(ctx, t.UserID)
+}
+
+func (a Application) Login(ctx context.Context, email string, password string) (*user.User, error) {
+	u, err := a.repository.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if len(u.PasswordHash) == 0 || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return u, nil
+}
+
+// RequestPasswordReset sends a reset token to the user with the given email,
+// unknown emails are silently ignored, so that the method can not be used to find out registered users
+func (a Application) RequestPasswordReset(ctx context.Context, email string) error {
+	u, err := a.repository.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if a.mailer == nil {
+		return nil
+	}
+
+	token, err := a.issueToken(ctx, u.ID, user.TokenResetPassword, PasswordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hello, %s!\n\nUse this token to reset your password: %s\nThe token expires in %s.\n",
+		u.Nickname, token, PasswordResetTokenTTL)
+	return a.mailer.Send(ctx, u.Email, "Password reset", body)
+}
+
+func (a Application) ResetPassword(ctx context.Context, token string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	t, err := a.consumeToken(ctx, token, user.TokenResetPassword)
+	if err != nil {
+		return err
+	}
+
+	return a.repository.UpdateUserPassword(ctx, t.UserID, hash)
+}
+
+// storedUser reads the user in a transaction, so the password hash comes from the repository
+// and not from a cache, which may leave it out
+func (a Application) storedUser(ctx context.Context, id int64) (*user.User, error) {
+	var u *user.User
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		u, err = tx.GetUserByID(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+// ChangePassword checks the old password between the transactions, because bcrypt is slow and a transaction
+// may lock the repository. The second transaction only makes sure, that the checked password is still the current one
+func (a Application) ChangePassword(ctx context.Context, id int64, oldPassword string, newPassword string) error {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	u, err := a.storedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(u.PasswordHash) == 0 || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(oldPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	return a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		current, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		// the password was changed or reset after the check, so the old password is not valid anymore
+		if !bytes.Equal(current.PasswordHash, u.PasswordHash) {
+			return ErrInvalidCredentials
+		}
+		return tx.UpdateUserPassword(ctx, id, hash)
+	})
+}
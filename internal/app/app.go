@@ -2,39 +2,170 @@ package app
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
 	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
 	"github.com/TobbyMax/validator"
+	"io"
 	"time"
 )
 
 var (
-	ErrForbidden    = fmt.Errorf("forbidden")
-	ErrAdNotFound   = fmt.Errorf("ad with such id does not exist")
-	ErrUserNotFound = fmt.Errorf("user with such id does not exist")
+	ErrForbidden    = NewError("FORBIDDEN", "forbidden")
+	ErrAdNotFound   = NewError("AD_NOT_FOUND", "ad with such id does not exist")
+	ErrUserNotFound = NewError("USER_NOT_FOUND", "user with such id does not exist")
+
+	ErrInvalidCredentials = NewError("INVALID_CREDENTIALS", "invalid email or password")
+	ErrInvalidToken       = NewError("INVALID_TOKEN", "token is invalid or expired")
+	ErrUserNotVerified    = NewError("USER_NOT_VERIFIED", "user email is not verified")
+	ErrEmailTaken         = NewError("EMAIL_TAKEN", "user with such email already exists")
+
+	ErrInvalidTransfer = NewError("INVALID_TRANSFER", "ads must be transferred to another existing user")
+
+	ErrJobNotFound    = NewError("JOB_NOT_FOUND", "job with such id does not exist")
+	ErrJobNotFinished = NewError("JOB_NOT_FINISHED", "job is not finished yet")
+	ErrJobNoResult    = NewError("JOB_NO_RESULT", "job has no result to download")
+	ErrJobsStopped    = NewError("JOBS_STOPPED", "jobs can not be started, because the service is shutting down")
+
+	ErrMessageNotFound = NewError("MESSAGE_NOT_FOUND", "outbox message with such id does not exist")
+
+	ErrWebhookNotFound = NewError("WEBHOOK_NOT_FOUND", "webhook with such id does not exist")
+	ErrInvalidWebhook  = NewError("INVALID_WEBHOOK", "webhook url must be an absolute http or https url")
+	ErrUnknownEvent    = NewError("UNKNOWN_EVENT", "webhooks can not be subscribed to such event")
+
+	ErrSearchNotFound = NewError("SEARCH_NOT_FOUND", "saved search with such id does not exist")
+	ErrInvalidSearch  = NewError("INVALID_SEARCH", "saved search must have a query or a filter")
+
+	ErrNotificationNotFound = NewError("NOTIFICATION_NOT_FOUND", "notification with such id does not exist")
+	ErrInvalidPage          = NewError("INVALID_PAGE", "page limit must be between 1 and 100")
+	ErrStreamUnavailable    = NewError("STREAM_UNAVAILABLE", "live notifications are not available")
+
+	ErrFeedUnavailable = NewError("FEED_UNAVAILABLE", "live feed of ads is not available")
+	ErrSlowConsumer    = NewError("SLOW_CONSUMER", "watcher can not keep up with the changes of ads")
+
+	ErrInvalidBatch       = NewError("INVALID_BATCH", "batch must contain from 1 to 100 operations")
+	ErrUnknownBatchAction = NewError("UNKNOWN_BATCH_ACTION", "unknown batch operation, expected create, publish, unpublish or delete")
+	ErrBatchAborted       = NewError("BATCH_ABORTED", "operation is not applied, because another operation of the batch failed")
+
+	ErrInvalidImport = NewError("INVALID_IMPORT", "import can not be read")
+	ErrInvalidRow    = NewError("INVALID_ROW", "row can not be parsed")
+
+	ErrValidation       = NewError(CodeValidationFailed, "request is not valid")
+	ErrInvalidRequest   = NewError("INVALID_REQUEST", "request can not be read")
+	ErrInvalidParameter = NewError("INVALID_PARAMETER", "parameter is invalid")
+	ErrMissingArgument  = NewError("MISSING_ARGUMENT", "required argument is missing")
+	ErrBodyTooLarge     = NewError("BODY_TOO_LARGE", "request body is too large")
 )
 
 type AdApp interface {
 	CreateAd(ctx context.Context, title string, text string, uid int64) (*ads.Ad, error)
 	ChangeAdStatus(ctx context.Context, id int64, uid int64, published bool) (*ads.Ad, error)
 	UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error)
+	// PatchAd changes only the fields set in the patch, only they are validated
+	PatchAd(ctx context.Context, id int64, uid int64, patch AdPatch) (*ads.Ad, error)
 	GetAd(ctx context.Context, id int64) (*ads.Ad, error)
 	DeleteAd(ctx context.Context, id int64, uid int64) error
 
 	ListAds(ctx context.Context, params ListAdsParams) (*ads.AdList, error)
+	// AdsChangedAt returns the time of the last change of any ad, the lists of ads are not newer than that
+	AdsChangedAt(ctx context.Context) (time.Time, error)
+	// WatchAds passes the changes of ads matching the filters to the channel. The channel is closed, when
+	// the context is done or the feed is closed, then the returned function reports ErrSlowConsumer
+	// if the watcher was dropped for falling behind
+	WatchAds(ctx context.Context, params ListAdsParams) (<-chan events.Event, func() error, error)
+	// BatchAds applies the operations of the user one by one and returns the result of every operation.
+	// In the atomic mode either all of the operations are applied or none of them, then the operations,
+	// which did not fail by themselves, fail with ErrBatchAborted
+	BatchAds(ctx context.Context, uid int64, ops []BatchOp, atomic bool) ([]BatchResult, error)
 }
 
 type UserApp interface {
 	CreateUser(ctx context.Context, nickname string, email string) (*user.User, error)
 	GetUser(ctx context.Context, id int64) (*user.User, error)
 	UpdateUser(ctx context.Context, id int64, nickname string, email string) (*user.User, error)
-	DeleteUser(ctx context.Context, id int64) error
+	// PatchUser changes only the fields set in the patch, only they are validated
+	PatchUser(ctx context.Context, id int64, patch UserPatch) (*user.User, error)
+	DeleteUser(ctx context.Context, id int64, params DeleteUserParams) error
+
+	Register(ctx context.Context, nickname string, email string, password string) (*user.User, error)
+	VerifyEmail(ctx context.Context, token string) (*user.User, error)
+	Login(ctx context.Context, email string, password string) (*user.User, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	ChangePassword(ctx context.Context, id int64, oldPassword string, newPassword string) error
+}
+
+// AdminApp contains support operations, which are not available to regular users
+type AdminApp interface {
+	RestoreAd(ctx context.Context, id int64) (*ads.Ad, error)
+	RestoreUser(ctx context.Context, id int64) (*user.User, error)
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int, error)
+	QueryAudit(ctx context.Context, filter audit.Filter) ([]audit.Record, error)
+}
+
+// PrivacyApp exports and erases personal data of users, both operations run as asynchronous jobs
+type PrivacyApp interface {
+	RequestExport(ctx context.Context, uid int64, format ExportFormat) (*jobs.Job, error)
+	RequestErasure(ctx context.Context, uid int64) (*jobs.Job, error)
+	GetJob(ctx context.Context, uid int64, id int64) (*jobs.Job, error)
+}
+
+// WebhookApp manages webhook subscriptions of partners, a user can see and change only own subscriptions
+type WebhookApp interface {
+	CreateWebhook(ctx context.Context, uid int64, url string, eventNames []string) (*webhooks.Subscription, error)
+	GetWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error)
+	ListWebhooks(ctx context.Context, uid int64) ([]webhooks.Subscription, error)
+	DeleteWebhook(ctx context.Context, uid int64, id int64) error
+	// EnableWebhook turns on a subscription, which was disabled after repeated delivery failures
+	EnableWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error)
+	ListWebhookDeliveries(ctx context.Context, uid int64, id int64) ([]webhooks.Delivery, error)
+}
+
+// SearchApp manages saved searches, new ads matching them are notified by SearchAlerts
+type SearchApp interface {
+	CreateSavedSearch(ctx context.Context, uid int64, params SavedSearchParams) (*searches.SavedSearch, error)
+	GetSavedSearch(ctx context.Context, uid int64, id int64) (*searches.SavedSearch, error)
+	ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error)
+	DeleteSavedSearch(ctx context.Context, uid int64, id int64) error
+}
+
+// InboxApp is the in-app inbox of a user, notifications are added by the notifiers
+type InboxApp interface {
+	ListNotifications(ctx context.Context, uid int64, params ListNotificationsParams) (*notifications.Page, error)
+	CountUnreadNotifications(ctx context.Context, uid int64) (int, error)
+	MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error)
+	// MarkAllNotificationsRead returns the number of notifications, which became read
+	MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error)
+	// SubscribeNotifications passes new notifications of the user to the channel,
+	// the channel is closed when the context is done
+	SubscribeNotifications(ctx context.Context, uid int64) (<-chan notifications.Notification, error)
+}
+
+// BulkApp moves the catalogues of sellers in and out of the service as CSV or JSON Lines
+type BulkApp interface {
+	// ImportAds creates an ad of the user for every valid row, other rows are reported with their errors.
+	// The dry run only validates the rows, the report tells which of them would be imported. If the input
+	// can't be read to the end, the rows before the failure stay imported
+	ImportAds(ctx context.Context, uid int64, r io.Reader, format BulkFormat, dryRun bool) (*ImportReport, error)
+	// ExportAds writes the ads of the user, or all published ads if uid is nil, to w one row at a time
+	ExportAds(ctx context.Context, uid *int64, format BulkFormat, w io.Writer) error
 }
 
 type App interface {
 	AdApp
+	BulkApp
 	UserApp
+	AdminApp
+	PrivacyApp
+	WebhookApp
+	SearchApp
+	InboxApp
 }
 
 type AdRepository interface {
@@ -43,32 +174,242 @@ type AdRepository interface {
 	UpdateAdStatus(ctx context.Context, id int64, published bool, date time.Time) error
 	UpdateAdContent(ctx context.Context, id int64, title string, text string, date time.Time) error
 	DeleteAdByID(ctx context.Context, id int64) error
+	RestoreAdByID(ctx context.Context, id int64) error
 
 	GetAdList(ctx context.Context, params ListAdsParams) (*ads.AdList, error)
+	// AdsChangedAt returns the time of the last change of any ad including deletions, zero if there was none
+	AdsChangedAt(ctx context.Context) (time.Time, error)
 }
 
 type UserRepository interface {
 	AddUser(ctx context.Context, u user.User) (int64, error)
 	GetUserByID(ctx context.Context, id int64) (*user.User, error)
+	// GetUserIncludingDeleted returns the user, even a soft deleted one
+	GetUserIncludingDeleted(ctx context.Context, id int64) (*user.User, error)
 	UpdateUser(ctx context.Context, id int64, nickname string, email string) error
 	DeleteUserByID(ctx context.Context, id int64) error
+	// DeleteUserKeepAds reassigns all ads of the user to newAuthor and deletes the user in one step,
+	// newAuthor is either an existing user or ads.AnonymousAuthorID
+	DeleteUserKeepAds(ctx context.Context, id int64, newAuthor int64) error
+	RestoreUserByID(ctx context.Context, id int64) error
+
+	GetUserByEmail(ctx context.Context, email string) (*user.User, error)
+	UpdateUserPassword(ctx context.Context, id int64, hash []byte) error
+	SetUserVerified(ctx context.Context, id int64, verified bool) error
+	// EraseUser permanently removes the user (even a soft deleted one) with all of the user's ads, tokens
+	// and export results. The undelivered events of the user and the ads are dropped, the dead letters
+	// and the notifications of other users keep no personal data of them
+	EraseUser(ctx context.Context, id int64) error
+}
+
+type TokenRepository interface {
+	AddToken(ctx context.Context, t user.Token) error
+	GetToken(ctx context.Context, value string) (*user.Token, error)
+	DeleteToken(ctx context.Context, value string) error
+}
+
+type JobRepository interface {
+	AddJob(ctx context.Context, j jobs.Job) (int64, error)
+	GetJobByID(ctx context.Context, id int64) (*jobs.Job, error)
+	UpdateJob(ctx context.Context, j jobs.Job) error
+	// ExpireJobResults removes the results, which expired before now, and returns the number of the removed results
+	ExpireJobResults(ctx context.Context, now time.Time) (int, error)
+}
+
+// OutboxRepository stores domain events written together with the changes of ads and users
+type OutboxRepository interface {
+	// AddEvent writes the event to the outbox, it is called in the transaction of the change it describes,
+	// so the event is written if and only if the change is committed
+	AddEvent(ctx context.Context, e events.Event) error
+	// PendingMessages returns up to limit undelivered messages in the order they were written
+	PendingMessages(ctx context.Context, limit int) ([]events.Message, error)
+	// DueMessages returns up to limit undelivered messages, which may be delivered at now, in the order they
+	// were written. A message waiting for a retry holds back the later messages of its aggregate, so they
+	// never take the place of the messages of other aggregates in the batch
+	DueMessages(ctx context.Context, now time.Time, limit int) ([]events.Message, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	// MarkHandled records the subscribers, which handled the message, so retries of the message skip them
+	MarkHandled(ctx context.Context, id int64, subscribers []string) error
+	// MarkFailed counts the failed attempt and postpones the next one
+	MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error
+	// MoveToDeadLetters removes the message from the outbox and keeps it for manual inspection
+	MoveToDeadLetters(ctx context.Context, id int64, reason string) error
+	DeadLetters(ctx context.Context) ([]events.Message, error)
+}
+
+type WebhookRepository interface {
+	AddWebhook(ctx context.Context, s webhooks.Subscription) (int64, error)
+	GetWebhookByID(ctx context.Context, id int64) (*webhooks.Subscription, error)
+	ListWebhooks(ctx context.Context, ownerID int64) ([]webhooks.Subscription, error)
+	// ActiveWebhooks returns enabled subscriptions, which want the event
+	ActiveWebhooks(ctx context.Context, event string) ([]webhooks.Subscription, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	// SetWebhookActive enables the subscription and resets its failures or disables it
+	SetWebhookActive(ctx context.Context, id int64, active bool) error
+	// AddWebhookDelivery appends the delivery to the log of the subscription and returns
+	// the number of deliveries failed in a row, a successful delivery resets the number
+	AddWebhookDelivery(ctx context.Context, d webhooks.Delivery) (int, error)
+	// ListWebhookDeliveries returns the latest deliveries of the subscription, the newest one first
+	ListWebhookDeliveries(ctx context.Context, id int64) ([]webhooks.Delivery, error)
+}
+
+type SearchRepository interface {
+	AddSavedSearch(ctx context.Context, s searches.SavedSearch) (int64, error)
+	GetSavedSearchByID(ctx context.Context, id int64) (*searches.SavedSearch, error)
+	ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error)
+	// ActiveSavedSearches returns the searches of all users, who are not deleted
+	ActiveSavedSearches(ctx context.Context) ([]searches.SavedSearch, error)
+	UpdateSavedSearch(ctx context.Context, s searches.SavedSearch) error
+	DeleteSavedSearch(ctx context.Context, id int64) error
+}
+
+// NotificationRepository is the in-app inbox of users
+type NotificationRepository interface {
+	AddNotification(ctx context.Context, n notifications.Notification) (int64, error)
+	// ListNotifications returns up to params.Limit notifications of the user, the newest one first,
+	// zero limit returns all of them
+	ListNotifications(ctx context.Context, uid int64, params ListNotificationsParams) ([]notifications.Notification, error)
+	CountUnreadNotifications(ctx context.Context, uid int64) (int, error)
+	// MarkNotificationRead fails with ErrNotificationNotFound if the notification belongs to another user
+	MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error)
+	MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error)
+}
+
+// Transactor runs a unit of work. The changes made through tx are applied all together when fn
+// returns nil and are rolled back when it returns an error or panics. The reads through tx see
+// the changes made before in the same transaction, calling InTransaction of tx joins the running
+// transaction. Generated IDs are not given back on rollback, just like database sequences
+type Transactor interface {
+	InTransaction(ctx context.Context, fn func(ctx context.Context, tx Repository) error) error
 }
 
 type Repository interface {
+	Transactor
 	AdRepository
 	UserRepository
+	TokenRepository
+	JobRepository
+	OutboxRepository
+	WebhookRepository
+	SearchRepository
+	NotificationRepository
+
+	// PurgeDeleted permanently removes records, which were soft deleted before the given time,
+	// and returns the number of removed records
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+}
+
+// Mailer delivers emails such as verification and password reset links
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// Notifier delivers notifications to users, an error makes the caller try again later
+type Notifier interface {
+	Notify(ctx context.Context, n notifications.Notification) error
+}
+
+// NotificationHub passes stored notifications to the subscribers connected at the moment
+type NotificationHub interface {
+	Publish(n notifications.Notification)
+	// Subscribe returns the channel of the notifications of the user, it is closed when the context is done
+	Subscribe(ctx context.Context, uid int64) <-chan notifications.Notification
+}
+
+// AdFeed passes changes of ads to the watchers connected at the moment
+type AdFeed interface {
+	// Subscribe returns the channel of the events accepted by match, see AdApp.WatchAds
+	Subscribe(ctx context.Context, match func(e events.Event) bool) (<-chan events.Event, func() error)
+}
+
+// AuditSink stores the audit log, records can only be appended
+type AuditSink interface {
+	Append(ctx context.Context, r audit.Record) error
+	Query(ctx context.Context, filter audit.Filter) ([]audit.Record, error)
+	// Redact removes the snapshots from the records matching any of the filters and returns the number
+	// of the changed records, the records themselves stay in the log
+	Redact(ctx context.Context, filters ...audit.Filter) (int, error)
 }
 
 type Application struct {
 	repository Repository
+	mailer     Mailer
+	audit      AuditSink
+	hub        NotificationHub
+	feed       AdFeed
+	jobs       *JobRunner
+	exportTTL  time.Duration
+}
+
+// in returns the application, which works with the repository of the running transaction
+func (a Application) in(tx Repository) Application {
+	a.repository = tx
+	return a
 }
 
-func NewApp(repo Repository) App {
-	return NewAdApp(repo)
+type Option func(*Application)
+
+// WithMailer enables email verification: new users stay unverified
+// until they confirm the token sent to them with the mailer
+func WithMailer(m Mailer) Option {
+	return func(a *Application) {
+		a.mailer = m
+	}
+}
+
+// WithAuditSink makes the application record every mutation to the sink
+func WithAuditSink(s AuditSink) Option {
+	return func(a *Application) {
+		a.audit = s
+	}
+}
+
+// WithNotificationHub enables live subscriptions to the notifications of users
+func WithNotificationHub(h NotificationHub) Option {
+	return func(a *Application) {
+		a.hub = h
+	}
 }
 
-func NewAdApp(repo Repository) *Application {
-	return &Application{repository: repo}
+// WithAdFeed enables watching changes of ads
+func WithAdFeed(f AdFeed) Option {
+	return func(a *Application) {
+		a.feed = f
+	}
+}
+
+// WithJobRunner runs the exports and the erasures with the runner, which is stopped on shutdown,
+// otherwise the application has its own runner
+func WithJobRunner(r *JobRunner) Option {
+	return func(a *Application) {
+		a.jobs = r
+	}
+}
+
+// WithExportTTL sets how long the results of the exports are kept, DefaultExportTTL by default
+func WithExportTTL(ttl time.Duration) Option {
+	return func(a *Application) {
+		a.exportTTL = ttl
+	}
+}
+
+func NewApp(repo Repository, opts ...Option) App {
+	a := NewAdApp(repo, opts...)
+	if a.audit != nil {
+		return auditedApp{Application: *a}
+	}
+	return a
+}
+
+func NewAdApp(repo Repository, opts ...Option) *Application {
+	a := &Application{repository: repo, exportTTL: DefaultExportTTL}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.jobs == nil {
+		a.jobs = NewJobRunner()
+	}
+	return a
 }
 
 func (a Application) CreateAd(ctx context.Context, title string, text string, uid int64) (*ads.Ad, error) {
@@ -78,11 +419,17 @@ func (a Application) CreateAd(ctx context.Context, title string, text string, ui
 		return nil, err
 	}
 
-	id, err := a.repository.AddAd(ctx, ad)
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		ad.ID, err = tx.AddAd(ctx, ad)
+		if err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, events.AdCreated{Ad: ad, Time: ad.DateCreated})
+	})
 	if err != nil {
 		return nil, err
 	}
-	ad.ID = id
 
 	return &ad, nil
 }
@@ -97,53 +444,111 @@ func (a Application) GetAd(ctx context.Context, id int64) (*ads.Ad, error) {
 }
 
 func (a Application) ChangeAdStatus(ctx context.Context, id int64, uid int64, published bool) (*ads.Ad, error) {
-	ad, err := a.repository.GetAdByID(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	if ad.AuthorID != uid {
-		return nil, ErrForbidden
-	}
-
-	ad.Published = published
-	ad.DateChanged = time.Now().UTC()
+	_, ad, err := a.changeAdStatus(ctx, id, uid, published)
+	return ad, err
+}
 
-	err = a.repository.UpdateAdStatus(ctx, id, published, ad.DateChanged)
+// changeAdStatus returns the ad before the change too, it is read in the transaction of the change
+func (a Application) changeAdStatus(ctx context.Context, id int64, uid int64, published bool) (*ads.Ad, *ads.Ad, error) {
+	var before, ad *ads.Ad
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		ad, err = tx.GetAdByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ad.AuthorID != uid {
+			return ErrForbidden
+		}
+		before = copyAd(*ad)
+		if published {
+			u, err := tx.GetUserByID(ctx, uid)
+			if err != nil {
+				return err
+			}
+			if !u.Verified {
+				return ErrUserNotVerified
+			}
+		}
+
+		ad.Published = published
+		ad.DateChanged = time.Now().UTC()
+
+		if err := tx.UpdateAdStatus(ctx, id, published, ad.DateChanged); err != nil {
+			return err
+		}
+		if published {
+			return tx.AddEvent(ctx, events.AdPublished{Ad: *ad, Time: ad.DateChanged})
+		}
+		return tx.AddEvent(ctx, events.AdUnpublished{Ad: *ad, Time: ad.DateChanged})
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return ad, nil
+	return before, ad, nil
 }
 
-func (a Application) UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error) {
-	ad, err := a.repository.GetAdByID(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	if ad.AuthorID != uid {
-		return nil, ErrForbidden
-	}
+func copyAd(ad ads.Ad) *ads.Ad {
+	return &ad
+}
 
-	ad.Title = title
-	ad.Text = text
-	ad.DateChanged = time.Now().UTC()
+func (a Application) UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error) {
+	return a.PatchAd(ctx, id, uid, AdPatch{Title: &title, Text: &text})
+}
 
-	if err := validator.Validate(*ad); err != nil {
-		return nil, err
-	}
+func (a Application) PatchAd(ctx context.Context, id int64, uid int64, patch AdPatch) (*ads.Ad, error) {
+	_, ad, err := a.patchAd(ctx, id, uid, patch)
+	return ad, err
+}
 
-	err = a.repository.UpdateAdContent(ctx, id, title, text, ad.DateChanged)
+// patchAd returns the ad before the change too, it is read in the transaction of the change
+func (a Application) patchAd(ctx context.Context, id int64, uid int64, patch AdPatch) (*ads.Ad, *ads.Ad, error) {
+	var before, ad *ads.Ad
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		ad, err = tx.GetAdByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ad.AuthorID != uid {
+			return ErrForbidden
+		}
+		before = copyAd(*ad)
+		if patch.Empty() {
+			return nil
+		}
+
+		var fields []string
+		if patch.Title != nil {
+			ad.Title = *patch.Title
+			fields = append(fields, "Title")
+		}
+		if patch.Text != nil {
+			ad.Text = *patch.Text
+			fields = append(fields, "Text")
+		}
+		ad.DateChanged = time.Now().UTC()
+
+		if err := validateFields(*ad, fields...); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateAdContent(ctx, id, ad.Title, ad.Text, ad.DateChanged); err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, events.AdUpdated{Ad: *ad, Time: ad.DateChanged})
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return ad, nil
+	return before, ad, nil
 }
 
 func (a Application) ListAds(ctx context.Context, params ListAdsParams) (*ads.AdList, error) {
 	p := true
-	if params.Published == nil && params.Uid == nil && params.Date == nil && params.Title == nil {
+	if params.Published == nil && params.Uid == nil && params.Date == nil && params.Title == nil && params.Text == nil {
 		params.Published = &p
 	}
 	al, err := a.repository.GetAdList(ctx, params)
@@ -155,18 +560,32 @@ func (a Application) ListAds(ctx context.Context, params ListAdsParams) (*ads.Ad
 	return al, nil
 }
 
+func (a Application) AdsChangedAt(ctx context.Context) (time.Time, error) {
+	return a.repository.AdsChangedAt(ctx)
+}
+
 func (a Application) CreateUser(ctx context.Context, nickname string, email string) (*user.User, error) {
-	u := user.User{Nickname: nickname, Email: email}
+	u := user.User{Nickname: nickname, Email: email, Verified: a.mailer == nil}
 
 	if err := validator.Validate(u); err != nil {
 		return nil, err
 	}
 
-	id, err := a.repository.AddUser(ctx, u)
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		u.ID, err = tx.AddUser(ctx, u)
+		if err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, userCreated(u))
+	})
 	if err != nil {
 		return nil, err
 	}
-	u.ID = id
+
+	if err := a.sendVerification(ctx, &u); err != nil {
+		return nil, err
+	}
 
 	return &u, nil
 }
@@ -181,45 +600,225 @@ func (a Application) GetUser(ctx context.Context, id int64) (*user.User, error)
 }
 
 func (a Application) UpdateUser(ctx context.Context, id int64, nickname string, email string) (*user.User, error) {
-	u, err := a.repository.GetUserByID(ctx, id)
+	return a.PatchUser(ctx, id, UserPatch{Nickname: &nickname, Email: &email})
+}
+
+func (a Application) PatchUser(ctx context.Context, id int64, patch UserPatch) (*user.User, error) {
+	_, u, err := a.patchUser(ctx, id, patch)
+	return u, err
+}
+
+// patchUser returns the user before the change too, it is read in the transaction of the change
+func (a Application) patchUser(ctx context.Context, id int64, patch UserPatch) (*user.User, *user.User, error) {
+	var before, u *user.User
+	reverify := false
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		u, err = tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		before = copyUser(*u)
+		if patch.Empty() {
+			return nil
+		}
+
+		var fields []string
+		if patch.Nickname != nil {
+			u.Nickname = *patch.Nickname
+			fields = append(fields, "Nickname")
+		}
+		if patch.Email != nil {
+			reverify = u.Email != *patch.Email && a.mailer != nil
+			u.Email = *patch.Email
+			fields = append(fields, "Email")
+		}
+
+		if err := validateFields(*u, fields...); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateUser(ctx, id, u.Nickname, u.Email); err != nil {
+			return err
+		}
+		if reverify {
+			u.Verified = false
+			return tx.SetUserVerified(ctx, id, false)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	u.Nickname = nickname
-	u.Email = email
+	// the mail is sent after the commit, so it never confirms a rolled back change
+	if reverify {
+		if err := a.sendVerification(ctx, u); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return before, u, nil
+}
+
+func copyUser(u user.User) *user.User {
+	return &u
+}
+
+func (a Application) DeleteAd(ctx context.Context, id int64, uid int64) error {
+	_, err := a.deleteAd(ctx, id, uid)
+	return err
+}
 
-	if err := validator.Validate(*u); err != nil {
+// deleteAd returns the deleted ad, it is read in the transaction of the deletion
+func (a Application) deleteAd(ctx context.Context, id int64, uid int64) (*ads.Ad, error) {
+	var ad *ads.Ad
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		ad, err = tx.GetAdByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ad.AuthorID != uid {
+			return ErrForbidden
+		}
+		if err := tx.DeleteAdByID(ctx, id); err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, events.AdDeleted{AdID: id, AuthorID: uid, Time: time.Now().UTC()})
+	})
+	if err != nil {
 		return nil, err
 	}
+	return ad, nil
+}
+
+// DeleteUser writes an event for every ad of the user besides the deletion of the user: the ads are deleted
+// in the cascade mode and handed over to the new author in the other modes
+func (a Application) DeleteUser(ctx context.Context, id int64, params DeleteUserParams) error {
+	_, err := a.deleteUser(ctx, id, params)
+	return err
+}
 
-	err = a.repository.UpdateUser(ctx, id, nickname, email)
+// deleteUser returns the deleted user, it is read in the transaction of the deletion
+func (a Application) deleteUser(ctx context.Context, id int64, params DeleteUserParams) (*user.User, error) {
+	newAuthor := ads.AnonymousAuthorID
+	switch params.Mode {
+	case DeleteCascade, DeleteAnonymize:
+	case DeleteTransfer:
+		if params.TransferTo == nil || *params.TransferTo == id {
+			return nil, ErrInvalidTransfer
+		}
+		newAuthor = *params.TransferTo
+	default:
+		return nil, ErrInvalidParameter.Withf("unknown delete mode: %d", params.Mode)
+	}
+	var u *user.User
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		u, err = tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		al, err := tx.GetAdList(ctx, ListAdsParams{Uid: &id})
+		if err != nil {
+			return err
+		}
+		if params.Mode == DeleteCascade {
+			err = tx.DeleteUserByID(ctx, id)
+		} else {
+			err = tx.DeleteUserKeepAds(ctx, id, newAuthor)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, ad := range al.Data {
+			var e events.Event = events.AdDeleted{AdID: ad.ID, AuthorID: ad.AuthorID, Time: now}
+			if params.Mode != DeleteCascade {
+				ad.AuthorID = newAuthor
+				e = events.AdUpdated{Ad: ad, PreviousAuthorID: &id, Time: now}
+			}
+			if err := tx.AddEvent(ctx, e); err != nil {
+				return err
+			}
+		}
+		return tx.AddEvent(ctx, events.UserDeleted{UserID: id, Time: now})
+	})
 	if err != nil {
 		return nil, err
 	}
-
 	return u, nil
 }
 
-func (a Application) DeleteAd(ctx context.Context, id int64, uid int64) error {
-	ad, err := a.repository.GetAdByID(ctx, id)
+func (a Application) RestoreAd(ctx context.Context, id int64) (*ads.Ad, error) {
+	var ad *ads.Ad
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		if err := tx.RestoreAdByID(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		ad, err = tx.GetAdByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return tx.AddEvent(ctx, events.AdRestored{Ad: *ad, Time: time.Now().UTC()})
+	})
 	if err != nil {
-		return err
-	}
-	if ad.AuthorID != uid {
-		return ErrForbidden
+		return nil, err
 	}
-	err = a.repository.DeleteAdByID(ctx, id)
+	return ad, nil
+}
+
+// RestoreUser writes an event for every ad, which was deleted together with the user and is restored with them
+func (a Application) RestoreUser(ctx context.Context, id int64) (*user.User, error) {
+	var u *user.User
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		al, err := tx.GetAdList(ctx, ListAdsParams{Uid: &id, IncludeDeleted: true})
+		if err != nil {
+			return err
+		}
+		if err := tx.RestoreUserByID(ctx, id); err != nil {
+			return err
+		}
+		u, err = tx.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, deleted := range al.Data {
+			if deleted.DeletedAt == nil {
+				continue
+			}
+			ad, err := tx.GetAdByID(ctx, deleted.ID)
+			switch {
+			case errors.Is(err, ErrAdNotFound):
+				// the ad was deleted on its own and stays deleted
+				continue
+			case err != nil:
+				return err
+			}
+			if err := tx.AddEvent(ctx, events.AdRestored{Ad: *ad, Time: now}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return u, nil
 }
 
-func (a Application) DeleteUser(ctx context.Context, id int64) error {
-	err := a.repository.DeleteUserByID(ctx, id)
+// PurgeDeleted removes the expired results of the exports too, they are counted with the removed records
+func (a Application) PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	now := time.Now().UTC()
+	purged, err := a.repository.PurgeDeleted(ctx, now.Add(-retention))
 	if err != nil {
-		return err
+		return purged, err
 	}
-	return nil
+	expired, err := a.repository.ExpireJobResults(ctx, now)
+	return purged + expired, err
 }
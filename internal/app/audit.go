@@ -0,0 +1,254 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"io"
+	"log"
+	"time"
+)
+
+// QueryAudit returns the records matching the filter, the result is empty if the audit is disabled
+func (a Application) QueryAudit(ctx context.Context, filter audit.Filter) ([]audit.Record, error) {
+	if a.audit == nil {
+		return make([]audit.Record, 0), nil
+	}
+	return a.audit.Query(ctx, filter)
+}
+
+// auditedApp records successful mutations of ads and users to the audit sink,
+// the use cases return the state of the target before the change, which they read in their transactions
+type auditedApp struct {
+	Application
+}
+
+func (a auditedApp) record(ctx context.Context, actor *int64, action string, targetType string, targetID int64,
+	before any, after any) {
+	r := audit.Record{
+		Time:       time.Now().UTC(),
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     snapshot(before),
+		After:      snapshot(after),
+	}
+	if info, ok := audit.RequestInfoFrom(ctx); ok {
+		r.RequestID = info.ID
+		r.Protocol = info.Protocol
+	}
+	// the mutation is already done, so a failure of the sink must not be reported to the caller
+	if err := a.audit.Append(ctx, r); err != nil {
+		log.Printf("audit: can't append %s of %s %d: %s\n", action, targetType, targetID, err.Error())
+	}
+}
+
+// snapshot serializes ads and users in the same form as the personal data export
+func snapshot(v any) json.RawMessage {
+	switch t := v.(type) {
+	case *ads.Ad:
+		if t == nil {
+			return nil
+		}
+		v = exportAd(*t)
+	case *user.User:
+		if t == nil {
+			return nil
+		}
+		v = exportUser(t)
+	case nil:
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (a auditedApp) CreateAd(ctx context.Context, title string, text string, uid int64) (*ads.Ad, error) {
+	ad, err := a.Application.CreateAd(ctx, title, text, uid)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &uid, "ad.create", audit.TargetAd, ad.ID, nil, ad)
+	return ad, nil
+}
+
+func (a auditedApp) ChangeAdStatus(ctx context.Context, id int64, uid int64, published bool) (*ads.Ad, error) {
+	before, ad, err := a.Application.changeAdStatus(ctx, id, uid, published)
+	if err != nil {
+		return nil, err
+	}
+	action := "ad.unpublish"
+	if published {
+		action = "ad.publish"
+	}
+	a.record(ctx, &uid, action, audit.TargetAd, id, before, ad)
+	return ad, nil
+}
+
+func (a auditedApp) UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error) {
+	before, ad, err := a.Application.patchAd(ctx, id, uid, AdPatch{Title: &title, Text: &text})
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &uid, "ad.update", audit.TargetAd, id, before, ad)
+	return ad, nil
+}
+
+func (a auditedApp) PatchAd(ctx context.Context, id int64, uid int64, patch AdPatch) (*ads.Ad, error) {
+	before, ad, err := a.Application.patchAd(ctx, id, uid, patch)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &uid, "ad.update", audit.TargetAd, id, before, ad)
+	return ad, nil
+}
+
+func (a auditedApp) DeleteAd(ctx context.Context, id int64, uid int64) error {
+	before, err := a.Application.deleteAd(ctx, id, uid)
+	if err != nil {
+		return err
+	}
+	a.record(ctx, &uid, "ad.delete", audit.TargetAd, id, before, nil)
+	return nil
+}
+
+// BatchAds records every applied operation in the same way as the single use case
+func (a auditedApp) BatchAds(ctx context.Context, uid int64, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	results, err := a.Application.BatchAds(ctx, uid, ops, atomic)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		if res.Err == nil {
+			a.record(ctx, &uid, "ad."+string(ops[i].Action), audit.TargetAd, res.AdID, res.before, res.Ad)
+		}
+	}
+	return results, nil
+}
+
+// ImportAds records every imported ad as a created one and the published rows as published after that
+func (a auditedApp) ImportAds(ctx context.Context, uid int64, r io.Reader, format BulkFormat, dryRun bool) (*ImportReport, error) {
+	report, err := a.Application.ImportAds(ctx, uid, r, format, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range report.Rows {
+		if row.created == nil {
+			continue
+		}
+		a.record(ctx, &uid, "ad.create", audit.TargetAd, row.created.ID, nil, row.created)
+		if row.published != nil {
+			a.record(ctx, &uid, "ad.publish", audit.TargetAd, row.published.ID, row.created, row.published)
+		}
+	}
+	return report, nil
+}
+
+func (a auditedApp) CreateUser(ctx context.Context, nickname string, email string) (*user.User, error) {
+	u, err := a.Application.CreateUser(ctx, nickname, email)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, nil, "user.create", audit.TargetUser, u.ID, nil, u)
+	return u, nil
+}
+
+func (a auditedApp) UpdateUser(ctx context.Context, id int64, nickname string, email string) (*user.User, error) {
+	before, u, err := a.Application.patchUser(ctx, id, UserPatch{Nickname: &nickname, Email: &email})
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &id, "user.update", audit.TargetUser, id, before, u)
+	return u, nil
+}
+
+func (a auditedApp) PatchUser(ctx context.Context, id int64, patch UserPatch) (*user.User, error) {
+	before, u, err := a.Application.patchUser(ctx, id, patch)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &id, "user.update", audit.TargetUser, id, before, u)
+	return u, nil
+}
+
+func (a auditedApp) DeleteUser(ctx context.Context, id int64, params DeleteUserParams) error {
+	before, err := a.Application.deleteUser(ctx, id, params)
+	if err != nil {
+		return err
+	}
+	a.record(ctx, &id, "user.delete", audit.TargetUser, id, before, nil)
+	return nil
+}
+
+func (a auditedApp) Register(ctx context.Context, nickname string, email string, password string) (*user.User, error) {
+	u, err := a.Application.Register(ctx, nickname, email, password)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, nil, "user.register", audit.TargetUser, u.ID, nil, u)
+	return u, nil
+}
+
+func (a auditedApp) VerifyEmail(ctx context.Context, token string) (*user.User, error) {
+	u, err := a.Application.VerifyEmail(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &u.ID, "user.verify_email", audit.TargetUser, u.ID, nil, u)
+	return u, nil
+}
+
+func (a auditedApp) ResetPassword(ctx context.Context, token string, password string) error {
+	// the token is consumed by the call, so its owner is looked up beforehand
+	t, tokenErr := a.repository.GetToken(ctx, token)
+	if err := a.Application.ResetPassword(ctx, token, password); err != nil {
+		return err
+	}
+	if tokenErr == nil {
+		a.record(ctx, &t.UserID, "user.reset_password", audit.TargetUser, t.UserID, nil, nil)
+	}
+	return nil
+}
+
+func (a auditedApp) ChangePassword(ctx context.Context, id int64, oldPassword string, newPassword string) error {
+	if err := a.Application.ChangePassword(ctx, id, oldPassword, newPassword); err != nil {
+		return err
+	}
+	a.record(ctx, &id, "user.change_password", audit.TargetUser, id, nil, nil)
+	return nil
+}
+
+func (a auditedApp) RestoreAd(ctx context.Context, id int64) (*ads.Ad, error) {
+	ad, err := a.Application.RestoreAd(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, nil, "ad.restore", audit.TargetAd, id, nil, ad)
+	return ad, nil
+}
+
+func (a auditedApp) RestoreUser(ctx context.Context, id int64) (*user.User, error) {
+	u, err := a.Application.RestoreUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, nil, "user.restore", audit.TargetUser, id, nil, u)
+	return u, nil
+}
+
+// RequestErasure does not put the profile into the record, because the erased data must not survive in the log
+func (a auditedApp) RequestErasure(ctx context.Context, uid int64) (*jobs.Job, error) {
+	j, err := a.Application.RequestErasure(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	a.record(ctx, &uid, "user.erase", audit.TargetUser, uid, nil, nil)
+	return j, nil
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+)
+
+// BatchResult is the outcome of one operation of BatchAds
+type BatchResult struct {
+	// AdID is the ID of the created ad or the ad of the operation
+	AdID int64
+	// Ad is the ad after the operation, it is nil for deleted ads and failed operations
+	Ad  *ads.Ad
+	Err error
+	// before is the ad before the operation, it is read in the transaction of the operation for the audit
+	before *ads.Ad
+}
+
+func (a Application) BatchAds(ctx context.Context, uid int64, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	if len(ops) == 0 || len(ops) > MaxBatchSize {
+		return nil, ErrInvalidBatch
+	}
+	if atomic {
+		return a.batchAtomic(ctx, uid, ops)
+	}
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = a.applyOp(ctx, uid, op)
+	}
+	return results, nil
+}
+
+// applyOp runs the operation as the single use case, so it is checked in the same way
+func (a Application) applyOp(ctx context.Context, uid int64, op BatchOp) BatchResult {
+	res := BatchResult{AdID: op.AdID}
+	switch op.Action {
+	case BatchCreate:
+		res.Ad, res.Err = a.CreateAd(ctx, op.Title, op.Text, uid)
+		if res.Err == nil {
+			res.AdID = res.Ad.ID
+		}
+	case BatchPublish, BatchUnpublish:
+		res.before, res.Ad, res.Err = a.changeAdStatus(ctx, op.AdID, uid, op.Action == BatchPublish)
+	case BatchDelete:
+		res.before, res.Err = a.deleteAd(ctx, op.AdID, uid)
+	default:
+		res.Err = ErrUnknownBatchAction
+	}
+	return res
+}
+
+// errRollback rolls back the transaction of the batch, the errors of the operations are kept in the results
+var errRollback = errors.New("batch failed")
+
+// batchAtomic runs the operations in one transaction, so every operation sees the changes of the previous ones,
+// and rolls all of them back when any of them fails
+func (a Application) batchAtomic(ctx context.Context, uid int64, ops []BatchOp) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		failed := false
+		for i, op := range ops {
+			results[i] = a.in(tx).applyOp(ctx, uid, op)
+			failed = failed || results[i].Err != nil
+		}
+		if failed {
+			return errRollback
+		}
+		return nil
+	})
+	if errors.Is(err, errRollback) {
+		return abortBatch(results), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// abortBatch fails the operations, which did not fail by themselves
+func abortBatch(results []BatchResult) []BatchResult {
+	for i := range results {
+		results[i].Ad = nil
+		if results[i].Err == nil {
+			results[i].Err = ErrBatchAborted
+		}
+	}
+	return results
+}
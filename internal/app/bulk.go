@@ -0,0 +1,257 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/validator"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ContentTypeCSV   = "text/csv"
+	ContentTypeJSONL = "application/x-ndjson"
+)
+
+// maxImportLine is the longest line of a JSON Lines import
+const maxImportLine = 1 << 20
+
+// csvColumns is the header of the CSV export, the import reads title, text and published columns of it
+var csvColumns = []string{"id", "title", "text", "author_id", "published", "date_created", "date_changed"}
+
+// ImportRow is the outcome of one row of the import
+type ImportRow struct {
+	// Line is the line of the file, where the row starts, counting from 1
+	Line int
+	// AdID is the ID of the created ad, it is nil for failed rows and in the dry run
+	AdID *int64
+	Err  error
+	// created and published are the states of the ad after the steps of the import, they are kept for the audit
+	created   *ads.Ad
+	published *ads.Ad
+}
+
+type ImportReport struct {
+	DryRun bool
+	// Imported is the number of imported rows, in the dry run it is the number of valid rows
+	Imported int
+	Failed   int
+	Rows     []ImportRow
+}
+
+// importedAd is a parsed row of the import
+type importedAd struct {
+	Title     string `json:"title"`
+	Text      string `json:"text"`
+	Published bool   `json:"published"`
+}
+
+func (a Application) ImportAds(ctx context.Context, uid int64, r io.Reader, format BulkFormat, dryRun bool) (*ImportReport, error) {
+	u, err := a.repository.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImportReport{DryRun: dryRun, Rows: make([]ImportRow, 0)}
+	err = readImport(r, format, func(line int, row importedAd, err error) error {
+		res := ImportRow{Line: line, Err: err}
+		if res.Err == nil {
+			res.Err = a.importRow(ctx, u, row, dryRun, &res)
+		}
+		if res.Err != nil {
+			report.Failed++
+		} else {
+			report.Imported++
+		}
+		report.Rows = append(report.Rows, res)
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importRow makes the same checks as CreateAd and ChangeAdStatus, a published row needs a verified user
+func (a Application) importRow(ctx context.Context, u *user.User, row importedAd, dryRun bool, res *ImportRow) error {
+	ad := ads.Ad{Title: row.Title, Text: row.Text, AuthorID: u.ID}
+	if err := validator.Validate(ad); err != nil {
+		return err
+	}
+	if row.Published && !u.Verified {
+		return ErrUserNotVerified
+	}
+	if dryRun {
+		return nil
+	}
+
+	// the ad is created and published in one transaction, a row that fails leaves no ad behind
+	var created, published *ads.Ad
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		var err error
+		if created, err = a.in(tx).CreateAd(ctx, row.Title, row.Text, u.ID); err != nil {
+			return err
+		}
+		if row.Published {
+			_, published, err = a.in(tx).changeAdStatus(ctx, created.ID, u.ID, true)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	res.AdID, res.created, res.published = &created.ID, created, published
+	return nil
+}
+
+// readImport passes every row of the input to fn together with the error of parsing it,
+// an error returned by fn stops the reading
+func readImport(r io.Reader, format BulkFormat, fn func(line int, row importedAd, err error) error) error {
+	if format == BulkJSONL {
+		return readJSONL(r, fn)
+	}
+	return readCSV(r, fn)
+}
+
+func readCSV(r io.Reader, fn func(line int, row importedAd, err error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	var parseErr *csv.ParseError
+	if errors.Is(err, io.EOF) || errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: csv must start with a header", ErrInvalidImport)
+	}
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasTitle := columns["title"]
+	_, hasText := columns["text"]
+	if !hasTitle || !hasText {
+		return fmt.Errorf("%w: csv header must contain title and text columns", ErrInvalidImport)
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.As(err, &parseErr) {
+			if err := fn(parseErr.StartLine, importedAd{}, fmt.Errorf("%w: %s", ErrInvalidRow, parseErr.Err.Error())); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		row, err := csvRow(record, columns)
+		if err := fn(line, row, err); err != nil {
+			return err
+		}
+	}
+}
+
+func csvRow(record []string, columns map[string]int) (importedAd, error) {
+	value := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	if columns["title"] >= len(record) || columns["text"] >= len(record) {
+		return importedAd{}, fmt.Errorf("%w: row has %d columns", ErrInvalidRow, len(record))
+	}
+	row := importedAd{Title: value("title"), Text: value("text")}
+	if published := strings.TrimSpace(value("published")); published != "" {
+		var err error
+		if row.Published, err = strconv.ParseBool(published); err != nil {
+			return importedAd{}, fmt.Errorf("%w: published must be true or false", ErrInvalidRow)
+		}
+	}
+	return row, nil
+}
+
+func readJSONL(r io.Reader, fn func(line int, row importedAd, err error) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var row importedAd
+		err := json.Unmarshal(sc.Bytes(), &row)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", ErrInvalidRow, err.Error())
+		}
+		if err := fn(line, row, err); err != nil {
+			return err
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("%w: line %d is longer than %d bytes", ErrInvalidImport, line+1, maxImportLine)
+	}
+	return sc.Err()
+}
+
+// ExportAds lists the ads as ListAds does, so the ads of the user include unpublished ones
+func (a Application) ExportAds(ctx context.Context, uid *int64, format BulkFormat, w io.Writer) error {
+	if uid != nil {
+		if _, err := a.repository.GetUserByID(ctx, *uid); err != nil {
+			return err
+		}
+	}
+	al, err := a.ListAds(ctx, ListAdsParams{Uid: uid})
+	if err != nil {
+		return err
+	}
+	sort.Slice(al.Data, func(i, j int) bool { return al.Data[i].ID < al.Data[j].ID })
+
+	if format == BulkJSONL {
+		enc := json.NewEncoder(w)
+		for _, ad := range al.Data {
+			if err := enc.Encode(exportAd(ad)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the writer is buffered, so the rows reach w in blocks
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvColumns); err != nil {
+		return err
+	}
+	for _, ad := range al.Data {
+		err := cw.Write([]string{
+			strconv.FormatInt(ad.ID, 10),
+			ad.Title,
+			ad.Text,
+			strconv.FormatInt(ad.AuthorID, 10),
+			strconv.FormatBool(ad.Published),
+			ad.DateCreated.Format(time.RFC3339),
+			ad.DateChanged.Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
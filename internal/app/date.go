@@ -13,7 +13,7 @@ func ParseDate(s *string) (*time.Time, error) {
 	}
 	date, err := time.Parse(DateLayout, *s)
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidParameter.Withf("%v", err)
 	}
 	return &date, nil
 }
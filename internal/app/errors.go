@@ -0,0 +1,220 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/adminauth"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
+	"github.com/TobbyMax/validator"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// CodeValidationFailed is the code of the errors of the validator
+	CodeValidationFailed = "VALIDATION_FAILED"
+	// CodeInternal is the code of the unknown errors
+	CodeInternal = "INTERNAL"
+)
+
+// Error is the error of the application with the stable machine-readable code. The clients should rely
+// on the code, while the message is for humans and may change
+type Error struct {
+	Code    string
+	Message string
+	// Err is the cause of the error, if any
+	Err error
+}
+
+func NewError(code string, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes the errors with the same code equal, so that the error with the detailed message
+// still matches its sentinel
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Withf returns the error with the same code and the detailed message
+func (e *Error) Withf(format string, args ...any) *Error {
+	return NewError(e.Code, fmt.Sprintf(format, args...))
+}
+
+// Wrap returns the error with the same code and the message of the cause, the cause is still
+// available to errors.As, e.g. for the violated constraints of the fields
+func (e *Error) Wrap(err error) *Error {
+	return &Error{Code: e.Code, Message: err.Error(), Err: err}
+}
+
+// Translation is the outcome of the error in every transport, so that the clients of HTTP and gRPC
+// get the same code and the equivalent statuses for the same error
+type Translation struct {
+	Code       string
+	HTTPStatus int
+	GRPCCode   codes.Code
+}
+
+// translations is the only mapping of the errors to the statuses of the transports, the code is taken
+// from the error itself, the errors of the packages, which do not depend on the application, have it here
+var translations = []struct {
+	err    error
+	code   string
+	status int
+	grpc   codes.Code
+}{
+	{err: ErrValidation, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrInvalidRequest, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrInvalidParameter, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrMissingArgument, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrBodyTooLarge, status: http.StatusRequestEntityTooLarge, grpc: codes.ResourceExhausted},
+
+	{err: ErrForbidden, status: http.StatusForbidden, grpc: codes.PermissionDenied},
+	{err: ErrUserNotVerified, status: http.StatusForbidden, grpc: codes.FailedPrecondition},
+	{err: ErrInvalidCredentials, status: http.StatusUnauthorized, grpc: codes.Unauthenticated},
+	{err: ErrInvalidToken, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrEmailTaken, status: http.StatusConflict, grpc: codes.AlreadyExists},
+
+	{err: ErrAdNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrUserNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrInvalidTransfer, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+
+	{err: ErrJobNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrJobNotFinished, status: http.StatusConflict, grpc: codes.FailedPrecondition},
+	{err: ErrJobNoResult, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrJobsStopped, status: http.StatusServiceUnavailable, grpc: codes.Unavailable},
+	{err: ErrMessageNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+
+	{err: ErrWebhookNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrInvalidWebhook, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrUnknownEvent, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+
+	{err: ErrSearchNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrInvalidSearch, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+
+	{err: ErrNotificationNotFound, status: http.StatusNotFound, grpc: codes.NotFound},
+	{err: ErrInvalidPage, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrStreamUnavailable, status: http.StatusServiceUnavailable, grpc: codes.Unavailable},
+	{err: ErrFeedUnavailable, status: http.StatusServiceUnavailable, grpc: codes.Unavailable},
+	{err: ErrSlowConsumer, status: http.StatusTooManyRequests, grpc: codes.ResourceExhausted},
+
+	{err: ErrInvalidBatch, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrUnknownBatchAction, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrBatchAborted, status: http.StatusConflict, grpc: codes.Aborted},
+	{err: ErrInvalidImport, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: ErrInvalidRow, status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+
+	{err: ratelimit.ErrLimited, code: "RATE_LIMITED", status: http.StatusTooManyRequests, grpc: codes.ResourceExhausted},
+	{err: idempotency.ErrInvalidKey, code: "INVALID_IDEMPOTENCY_KEY", status: http.StatusBadRequest, grpc: codes.InvalidArgument},
+	{err: idempotency.ErrKeyReused, code: "IDEMPOTENCY_KEY_REUSED", status: http.StatusUnprocessableEntity, grpc: codes.InvalidArgument},
+	{err: idempotency.ErrInProgress, code: "IDEMPOTENCY_KEY_IN_PROGRESS", status: http.StatusConflict, grpc: codes.Aborted},
+	{err: adminauth.ErrUnauthorized, code: "ADMIN_UNAUTHORIZED", status: http.StatusUnauthorized, grpc: codes.Unauthenticated},
+}
+
+// Translate returns the outcome of the error, the unknown errors are internal. The outermost
+// application error decides, so the wrapped cause does not change the outcome
+func Translate(err error) Translation {
+	code := ErrorCode(err)
+	if code == "" && errors.As(err, &validator.ValidationErrors{}) {
+		code = CodeValidationFailed
+	}
+	for _, t := range translations {
+		if code != "" && code == ErrorCode(t.err) || code == "" && errors.Is(err, t.err) {
+			if code == "" {
+				code = t.code
+			}
+			return Translation{Code: code, HTTPStatus: t.status, GRPCCode: t.grpc}
+		}
+	}
+	return internalTranslation
+}
+
+// TranslateCode returns the outcome of the error with the code, such as the reason in the details
+// of the gRPC status, the unknown codes are internal
+func TranslateCode(code string) Translation {
+	for _, t := range translations {
+		if t.code == code || t.code == "" && ErrorCode(t.err) == code {
+			return Translation{Code: code, HTTPStatus: t.status, GRPCCode: t.grpc}
+		}
+	}
+	return internalTranslation
+}
+
+var internalTranslation = Translation{Code: CodeInternal, HTTPStatus: http.StatusInternalServerError, GRPCCode: codes.Internal}
+
+// TranslatedErrors returns the errors of the translation table, so that the transports can be checked against it
+func TranslatedErrors() []error {
+	errs := make([]error, 0, len(translations))
+	for _, t := range translations {
+		errs = append(errs, t.err)
+	}
+	return errs
+}
+
+// ErrorCode returns the code of the application error or an empty string for the other errors
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// FieldViolation is the constraint of the field, which the value does not satisfy
+type FieldViolation struct {
+	// Field is the name of the field in snake case
+	Field string
+	// Constraint is the name of the constraint, such as "min" or "max"
+	Constraint  string
+	Description string
+}
+
+var fieldNotValid = regexp.MustCompile(`^field '(\w+)' of type \w+ is not valid: has constraint \('(\w+)': `)
+
+// FieldViolations extracts the violated constraints from the errors of the validator
+func FieldViolations(err error) []FieldViolation {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	violations := make([]FieldViolation, 0, len(errs))
+	for _, e := range errs {
+		v := FieldViolation{Description: e.Err.Error()}
+		if m := fieldNotValid.FindStringSubmatch(v.Description); m != nil {
+			v.Field = SnakeCase(m[1])
+			v.Constraint = m[2]
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// SnakeCase converts the name of the Go field into snake case keeping the initialisms together,
+// so UserID becomes user_id
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
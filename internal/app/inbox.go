@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+)
+
+// ListNotifications returns a page of the inbox, the next page starts before the last listed notification
+func (a Application) ListNotifications(ctx context.Context, uid int64, params ListNotificationsParams) (*notifications.Page, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	if params.Limit == 0 {
+		params.Limit = DefaultNotificationsLimit
+	}
+	if params.Limit < 0 || params.Limit > MaxNotificationsLimit {
+		return nil, ErrInvalidPage
+	}
+	limit := params.Limit
+	// one more notification tells if there is the next page
+	params.Limit++
+	list, err := a.repository.ListNotifications(ctx, uid, params)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := a.repository.CountUnreadNotifications(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &notifications.Page{Notifications: list, Unread: unread}
+	if len(list) > limit {
+		page.Notifications = list[:limit]
+		next := list[limit-1].ID
+		page.Next = &next
+	}
+	return page, nil
+}
+
+func (a Application) CountUnreadNotifications(ctx context.Context, uid int64) (int, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return 0, err
+	}
+	return a.repository.CountUnreadNotifications(ctx, uid)
+}
+
+func (a Application) MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.repository.MarkNotificationRead(ctx, uid, id)
+}
+
+func (a Application) MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return 0, err
+	}
+	return a.repository.MarkAllNotificationsRead(ctx, uid)
+}
+
+// SubscribeNotifications fails with ErrStreamUnavailable if the application has no notification hub
+func (a Application) SubscribeNotifications(ctx context.Context, uid int64) (<-chan notifications.Notification, error) {
+	if a.hub == nil {
+		return nil, ErrStreamUnavailable
+	}
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.hub.Subscribe(ctx, uid), nil
+}
+
+// StatusNotifications tells authors about the status changes of their ads
+type StatusNotifications struct {
+	notifier Notifier
+}
+
+func NewStatusNotifications(notifier Notifier) *StatusNotifications {
+	return &StatusNotifications{notifier: notifier}
+}
+
+// Handle is the event bus handler, which turns status changes made by ChangeAdStatus into notifications
+func (s *StatusNotifications) Handle(ctx context.Context, e events.Event) error {
+	switch t := e.(type) {
+	case events.AdPublished:
+		return s.notify(ctx, t.Ad, notifications.KindAdPublished, "published", e)
+	case events.AdUnpublished:
+		return s.notify(ctx, t.Ad, notifications.KindAdUnpublished, "unpublished", e)
+	}
+	return nil
+}
+
+func (s *StatusNotifications) notify(ctx context.Context, ad ads.Ad, kind string, status string, e events.Event) error {
+	if ad.AuthorID == ads.AnonymousAuthorID {
+		return nil
+	}
+	err := s.notifier.Notify(ctx, notifications.Notification{
+		UserID:  ad.AuthorID,
+		Kind:    kind,
+		Subject: fmt.Sprintf("Your ad %q is %s", ad.Title, status),
+		Text:    fmt.Sprintf("The ad %d %q is %s.\n", ad.ID, ad.Title, status),
+		AdIDs:   []int64{ad.ID},
+		Created: e.OccurredAt(),
+	})
+	// the author could be deleted before the event is delivered
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// JobRunner runs the background jobs of the users, such as exports and erasures. The jobs outlive
+// the requests, which started them, so they run under the context of the runner, which is cancelled
+// on shutdown, and the shutdown waits for them to finish
+type JobRunner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewJobRunner() *JobRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobRunner{ctx: ctx, cancel: cancel}
+}
+
+// Go runs the job in the background, it returns false and does not run the job, when the runner is stopped
+func (r *JobRunner) Go(job func(ctx context.Context)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		job(r.ctx)
+	}()
+	return true
+}
+
+// Run stops the runner, when the context is done: new jobs are not started, the running ones
+// are cancelled and awaited
+func (r *JobRunner) Run(ctx context.Context) func() error {
+	return func() error {
+		<-ctx.Done()
+		r.Stop()
+		return nil
+	}
+}
+
+// Stop cancels the running jobs and waits for them to finish
+func (r *JobRunner) Stop() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.cancel()
+	r.wg.Wait()
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"log"
+	"time"
+)
+
+const (
+	DefaultRelayInterval    = time.Second
+	DefaultRelayBatch       = 100
+	DefaultRelayMaxAttempts = 10
+	DefaultRelayBackoff     = time.Second
+)
+
+// OutboxPublisher delivers events from the outbox to the subscribers, which have not handled the message yet.
+// It returns the subscribers, which handled the message now, and an error makes the relay retry the delivery
+// to the rest of them
+type OutboxPublisher interface {
+	Deliver(ctx context.Context, m events.Message) (handled []string, err error)
+}
+
+// OutboxRelay moves events from the outbox to the publisher. Events of the same aggregate
+// are delivered in the order they were written: while a message waits for a retry, the later
+// messages of its aggregate wait too. After maxAttempts failures the message goes to the dead letters
+type OutboxRelay struct {
+	repository  OutboxRepository
+	publisher   OutboxPublisher
+	interval    time.Duration
+	batch       int
+	maxAttempts int
+	backoff     time.Duration
+}
+
+type RelayOption func(*OutboxRelay)
+
+func WithRelayInterval(interval time.Duration) RelayOption {
+	return func(r *OutboxRelay) {
+		r.interval = interval
+	}
+}
+
+// WithRelayBatch sets the number of messages delivered by one pass over the outbox
+func WithRelayBatch(batch int) RelayOption {
+	return func(r *OutboxRelay) {
+		r.batch = batch
+	}
+}
+
+// WithRelayRetry sets the number of attempts and the delay before the first retry,
+// the delay doubles with every failed attempt
+func WithRelayRetry(maxAttempts int, backoff time.Duration) RelayOption {
+	return func(r *OutboxRelay) {
+		r.maxAttempts = maxAttempts
+		r.backoff = backoff
+	}
+}
+
+func NewOutboxRelay(repo OutboxRepository, publisher OutboxPublisher, opts ...RelayOption) *OutboxRelay {
+	r := &OutboxRelay{
+		repository:  repo,
+		publisher:   publisher,
+		interval:    DefaultRelayInterval,
+		batch:       DefaultRelayBatch,
+		maxAttempts: DefaultRelayMaxAttempts,
+		backoff:     DefaultRelayBackoff,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run flushes the outbox every interval until the context is done
+func (r *OutboxRelay) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if _, err := r.Flush(ctx); err != nil {
+					log.Printf("outbox relay failed: %s\n", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// Flush makes one pass over the pending messages and returns the number of delivered ones
+func (r *OutboxRelay) Flush(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	messages, err := r.repository.DueMessages(ctx, now, r.batch)
+	if err != nil {
+		return 0, err
+	}
+
+	type aggregate struct {
+		kind string
+		id   int64
+	}
+	// blocked are the aggregates, which failed during this pass, the repository knows about the earlier failures
+	blocked := make(map[aggregate]struct{})
+	delivered := 0
+
+	for _, m := range messages {
+		kind, id := m.Event.Aggregate()
+		key := aggregate{kind: kind, id: id}
+		if _, ok := blocked[key]; ok {
+			continue
+		}
+
+		handled, deliveryErr := r.publisher.Deliver(ctx, m)
+		if deliveryErr == nil {
+			if err := r.repository.MarkDelivered(ctx, m.ID); err != nil {
+				return delivered, err
+			}
+			delivered++
+			continue
+		}
+		if len(handled) > 0 {
+			if err := r.repository.MarkHandled(ctx, m.ID, handled); err != nil {
+				return delivered, err
+			}
+		}
+
+		if m.Attempts+1 >= r.maxAttempts {
+			log.Printf("outbox relay: %s of %s %d moved to dead letters: %s\n",
+				m.Event.EventName(), kind, id, deliveryErr.Error())
+			if err := r.repository.MoveToDeadLetters(ctx, m.ID, deliveryErr.Error()); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		next := now.Add(r.backoff << m.Attempts)
+		if err := r.repository.MarkFailed(ctx, m.ID, deliveryErr.Error(), next); err != nil {
+			return delivered, err
+		}
+		blocked[key] = struct{}{}
+	}
+	return delivered, nil
+}
+
+// userCreated is the event of the new user, the password hash never leaves the service
+func userCreated(u user.User) events.UserCreated {
+	u.PasswordHash = nil
+	return events.UserCreated{User: u, Time: time.Now().UTC()}
+}
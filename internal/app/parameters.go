@@ -1,10 +1,187 @@
 package app
 
-import "time"
+import (
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
+	"time"
+)
 
 type ListAdsParams struct {
 	Published *bool
 	Uid       *int64
 	Date      *time.Time
 	Title     *string
+	// Text filters ads, whose title or text contains the string ignoring case
+	Text *string
+
+	// IncludeDeleted makes the list contain soft deleted ads as well
+	IncludeDeleted bool
+}
+
+// Match checks the ad against all of the filters of the list
+func (p ListAdsParams) Match(ad ads.Ad) bool {
+	switch {
+	case ad.DeletedAt != nil && !p.IncludeDeleted:
+		return false
+	case p.Published != nil && *p.Published != ad.Published:
+		return false
+	case p.Uid != nil && *p.Uid != ad.AuthorID:
+		return false
+	case p.Title != nil && *p.Title != ad.Title:
+		return false
+	case p.Text != nil && !ads.Contains(ad, *p.Text):
+		return false
+	}
+	if p.Date != nil {
+		year, month, day := p.Date.Date()
+		if y, m, d := ad.DateCreated.Date(); y != year || m != month || d != day {
+			return false
+		}
+	}
+	return true
+}
+
+type DeleteMode int
+
+const (
+	// DeleteCascade deletes the user together with all of the user's ads
+	DeleteCascade DeleteMode = iota
+	// DeleteTransfer hands the user's ads over to another user
+	DeleteTransfer
+	// DeleteAnonymize keeps the user's ads, but removes the author from them
+	DeleteAnonymize
+)
+
+var deleteModeNames = map[string]DeleteMode{
+	"cascade":   DeleteCascade,
+	"transfer":  DeleteTransfer,
+	"anonymize": DeleteAnonymize,
+}
+
+// ParseDeleteMode parses the name of a deletion mode, empty string means DeleteCascade
+func ParseDeleteMode(s string) (DeleteMode, error) {
+	if s == "" {
+		return DeleteCascade, nil
+	}
+	mode, ok := deleteModeNames[s]
+	if !ok {
+		return 0, ErrInvalidParameter.Withf("unknown delete mode: %q", s)
+	}
+	return mode, nil
+}
+
+type DeleteUserParams struct {
+	Mode DeleteMode
+	// TransferTo is the ID of the user, who receives the ads in DeleteTransfer mode
+	TransferTo *int64
+}
+
+type ExportFormat int
+
+const (
+	ExportJSON ExportFormat = iota
+	ExportZIP
+)
+
+var exportFormatNames = map[string]ExportFormat{
+	"json": ExportJSON,
+	"zip":  ExportZIP,
+}
+
+// ParseExportFormat parses the name of an export format, empty string means ExportJSON
+func ParseExportFormat(s string) (ExportFormat, error) {
+	if s == "" {
+		return ExportJSON, nil
+	}
+	format, ok := exportFormatNames[s]
+	if !ok {
+		return 0, ErrInvalidParameter.Withf("unknown export format: %q", s)
+	}
+	return format, nil
+}
+
+type BulkFormat int
+
+const (
+	BulkCSV BulkFormat = iota
+	BulkJSONL
+)
+
+var bulkFormatNames = map[string]BulkFormat{
+	"csv":   BulkCSV,
+	"jsonl": BulkJSONL,
+}
+
+// ParseBulkFormat parses the name of an import or export format, empty string means BulkCSV
+func ParseBulkFormat(s string) (BulkFormat, error) {
+	if s == "" {
+		return BulkCSV, nil
+	}
+	format, ok := bulkFormatNames[s]
+	if !ok {
+		return 0, ErrInvalidParameter.Withf("unknown import format: %q", s)
+	}
+	return format, nil
+}
+
+type SavedSearchParams struct {
+	Name  string
+	Query string
+	// Uid, Date and Title filter ads as in ListAdsParams, only published ads match a saved search
+	Uid       *int64
+	Date      *time.Time
+	Title     *string
+	Frequency searches.Frequency
+}
+
+var frequencyNames = map[string]searches.Frequency{
+	"instant": searches.FrequencyInstant,
+	"daily":   searches.FrequencyDaily,
+	"weekly":  searches.FrequencyWeekly,
+}
+
+// ParseFrequency parses the name of a notification frequency, empty string means searches.FrequencyInstant
+func ParseFrequency(s string) (searches.Frequency, error) {
+	if s == "" {
+		return searches.FrequencyInstant, nil
+	}
+	frequency, ok := frequencyNames[s]
+	if !ok {
+		return 0, ErrInvalidParameter.Withf("unknown notification frequency: %q", s)
+	}
+	return frequency, nil
+}
+
+const (
+	DefaultNotificationsLimit = 20
+	MaxNotificationsLimit     = 100
+)
+
+type ListNotificationsParams struct {
+	// Before is the cursor: only notifications with smaller IDs are listed
+	Before *int64
+	// Limit is the size of the page, zero means DefaultNotificationsLimit in the application
+	Limit      int
+	UnreadOnly bool
+}
+
+// MaxBatchSize is the largest number of operations in one call of BatchAds
+const MaxBatchSize = 100
+
+type BatchAction string
+
+const (
+	BatchCreate    BatchAction = "create"
+	BatchPublish   BatchAction = "publish"
+	BatchUnpublish BatchAction = "unpublish"
+	BatchDelete    BatchAction = "delete"
+)
+
+type BatchOp struct {
+	Action BatchAction
+	// AdID is the ad to publish, unpublish or delete
+	AdID int64
+	// Title and Text are the content of the created ad
+	Title string
+	Text  string
 }
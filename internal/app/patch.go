@@ -0,0 +1,44 @@
+package app
+
+import (
+	"github.com/TobbyMax/validator"
+	"reflect"
+)
+
+// AdPatch is the partial update of the ad, the nil fields are not changed
+type AdPatch struct {
+	Title *string
+	Text  *string
+}
+
+// Empty tells if the patch changes nothing
+func (p AdPatch) Empty() bool {
+	return p.Title == nil && p.Text == nil
+}
+
+// UserPatch is the partial update of the user, the nil fields are not changed
+type UserPatch struct {
+	Nickname *string
+	Email    *string
+}
+
+// Empty tells if the patch changes nothing
+func (p UserPatch) Empty() bool {
+	return p.Nickname == nil && p.Email == nil
+}
+
+// validateFields checks only the named fields of the struct, so that a patch is not rejected
+// because of the fields it does not change
+func validateFields(v any, fields ...string) error {
+	val := reflect.ValueOf(v)
+	vld := validator.Validator{}
+	for _, name := range fields {
+		if sf, ok := val.Type().FieldByName(name); ok {
+			vld.ValidateField(sf, val.FieldByIndex(sf.Index))
+		}
+	}
+	if len(vld.Errors) != 0 {
+		return vld.Errors
+	}
+	return nil
+}
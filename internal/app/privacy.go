@@ -0,0 +1,410 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeZIP  = "application/zip"
+)
+
+// DefaultExportTTL is how long the result of an export is kept after the export finishes
+const DefaultExportTTL = 24 * time.Hour
+
+type exportedUser struct {
+	ID          int64  `json:"id"`
+	Nickname    string `json:"nickname"`
+	Email       string `json:"email"`
+	Verified    bool   `json:"verified"`
+	HasPassword bool   `json:"has_password"`
+}
+
+type exportedAd struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Text        string     `json:"text"`
+	AuthorID    int64      `json:"author_id"`
+	Published   bool       `json:"published"`
+	DateCreated time.Time  `json:"date_created"`
+	DateChanged time.Time  `json:"date_changed"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+type exportedSearch struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Query        string     `json:"query"`
+	AuthorID     *int64     `json:"author_id,omitempty"`
+	Title        *string    `json:"title,omitempty"`
+	Date         *time.Time `json:"date,omitempty"`
+	Frequency    string     `json:"frequency"`
+	Created      time.Time  `json:"created"`
+	LastNotified *time.Time `json:"last_notified,omitempty"`
+}
+
+type exportedNotification struct {
+	ID       int64     `json:"id"`
+	Kind     string    `json:"kind"`
+	Subject  string    `json:"subject"`
+	Text     string    `json:"text"`
+	AdIDs    []int64   `json:"ad_ids"`
+	SearchID *int64    `json:"search_id,omitempty"`
+	Created  time.Time `json:"created"`
+	Read     bool      `json:"read"`
+}
+
+// exportedWebhook leaves out the signing secret, it is a credential and not the data of the user
+type exportedWebhook struct {
+	ID         int64              `json:"id"`
+	URL        string             `json:"url"`
+	Events     []string           `json:"events"`
+	Active     bool               `json:"active"`
+	Created    time.Time          `json:"created"`
+	DisabledAt *time.Time         `json:"disabled_at,omitempty"`
+	Deliveries []exportedDelivery `json:"deliveries"`
+}
+
+type exportedDelivery struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Time       time.Time `json:"time"`
+}
+
+type exportedData struct {
+	ExportedAt    time.Time              `json:"exported_at"`
+	User          exportedUser           `json:"user"`
+	Ads           []exportedAd           `json:"ads"`
+	Searches      []exportedSearch       `json:"saved_searches"`
+	Notifications []exportedNotification `json:"notifications"`
+	Webhooks      []exportedWebhook      `json:"webhooks"`
+}
+
+// RequestExport starts a job, which collects the profile, all ads of the user (deleted ones included),
+// the saved searches, the inbox and the webhooks into a JSON document or a ZIP archive.
+// The result is removed after exportTTL
+func (a Application) RequestExport(ctx context.Context, uid int64, format ExportFormat) (*jobs.Job, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.startJob(ctx, jobs.KindExport, uid, func(ctx context.Context, j *jobs.Job) error {
+		data, err := a.collectUserData(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if format == ExportZIP {
+			j.Result, err = zipUserData(data)
+			j.ContentType = ContentTypeZIP
+		} else {
+			j.Result, err = json.Marshal(data)
+			j.ContentType = ContentTypeJSON
+		}
+		expires := time.Now().UTC().Add(a.exportTTL)
+		j.Expires = &expires
+		return err
+	})
+}
+
+// RequestErasure starts a job, which permanently removes the user with all of the user's data.
+// A soft deleted user may be erased too, the deletions are written to the outbox only for the user
+// and the ads, which were not deleted before
+func (a Application) RequestErasure(ctx context.Context, uid int64) (*jobs.Job, error) {
+	if _, err := a.repository.GetUserIncludingDeleted(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.startJob(ctx, jobs.KindErasure, uid, func(ctx context.Context, j *jobs.Job) error {
+		var erased []ads.Ad
+		err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+			u, err := tx.GetUserIncludingDeleted(ctx, uid)
+			if err != nil {
+				return err
+			}
+			// the ads are erased together with the user, so they are listed beforehand
+			al, err := tx.GetAdList(ctx, ListAdsParams{Uid: &uid, IncludeDeleted: true})
+			if err != nil {
+				return err
+			}
+			if err := tx.EraseUser(ctx, uid); err != nil {
+				return err
+			}
+			erased = al.Data
+
+			now := time.Now().UTC()
+			for _, ad := range al.Data {
+				if ad.DeletedAt != nil {
+					continue
+				}
+				if err := tx.AddEvent(ctx, events.AdDeleted{AdID: ad.ID, AuthorID: uid, Time: now}); err != nil {
+					return err
+				}
+			}
+			if u.DeletedAt != nil {
+				return nil
+			}
+			return tx.AddEvent(ctx, events.UserDeleted{UserID: uid, Time: now})
+		})
+		if err != nil {
+			return err
+		}
+		return a.redactAudit(ctx, uid, erased)
+	})
+}
+
+// redactAudit removes the snapshots of the profile and the ads of the erased user from the audit log,
+// the records stay, so the log still tells who changed what and when
+func (a Application) redactAudit(ctx context.Context, uid int64, erased []ads.Ad) error {
+	if a.audit == nil {
+		return nil
+	}
+	targetUser, targetAd := audit.TargetUser, audit.TargetAd
+	filters := []audit.Filter{{Actor: &uid}, {TargetType: &targetUser, TargetID: &uid}}
+	for i := range erased {
+		filters = append(filters, audit.Filter{TargetType: &targetAd, TargetID: &erased[i].ID})
+	}
+	_, err := a.audit.Redact(ctx, filters...)
+	return err
+}
+
+// GetJob returns the job only to the user it was started for, the expired result is never returned,
+// even before the purge job removes it
+func (a Application) GetJob(ctx context.Context, uid int64, id int64) (*jobs.Job, error) {
+	j, err := a.repository.GetJobByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if j.UserID != uid {
+		return nil, ErrJobNotFound
+	}
+	if j.Expired(time.Now().UTC()) {
+		j.Result, j.ContentType = nil, ""
+	}
+	return j, nil
+}
+
+func (a Application) startJob(ctx context.Context, kind jobs.Kind, uid int64,
+	run func(ctx context.Context, j *jobs.Job) error) (*jobs.Job, error) {
+	j := jobs.Job{Kind: kind, UserID: uid, Status: jobs.StatusPending, Created: time.Now().UTC()}
+	id, err := a.repository.AddJob(ctx, j)
+	if err != nil {
+		return nil, err
+	}
+	j.ID = id
+
+	started := a.jobs.Go(func(ctx context.Context) {
+		a.runJob(ctx, j, run)
+	})
+	if !started {
+		a.finishJob(j, ErrJobsStopped)
+		return nil, ErrJobsStopped
+	}
+
+	return &j, nil
+}
+
+// runJob does not use the context of the request, because the job outlives it, the job runs under the context
+// of the runner, which is cancelled on shutdown
+func (a Application) runJob(ctx context.Context, j jobs.Job, run func(ctx context.Context, j *jobs.Job) error) {
+	j.Status = jobs.StatusRunning
+	if err := a.repository.UpdateJob(ctx, j); err != nil {
+		log.Printf("%s job %d: %s\n", j.Kind, j.ID, err.Error())
+		return
+	}
+
+	a.finishJob(j, run(ctx, &j))
+}
+
+// finishJob saves the outcome of the job, even if the job was cancelled
+func (a Application) finishJob(j jobs.Job, err error) {
+	ctx := context.Background()
+
+	finished := time.Now().UTC()
+	j.Finished = &finished
+	if err != nil {
+		j.Status = jobs.StatusFailed
+		j.Error = err.Error()
+		j.Result, j.ContentType, j.Expires = nil, "", nil
+	} else {
+		j.Status = jobs.StatusDone
+	}
+	if err := a.repository.UpdateJob(ctx, j); err != nil {
+		log.Printf("%s job %d: %s\n", j.Kind, j.ID, err.Error())
+	}
+}
+
+func (a Application) collectUserData(ctx context.Context, uid int64) (*exportedData, error) {
+	u, err := a.storedUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	al, err := a.repository.GetAdList(ctx, ListAdsParams{Uid: &uid, IncludeDeleted: true})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(al.Data, func(i, j int) bool { return al.Data[i].ID < al.Data[j].ID })
+
+	data := exportedData{
+		ExportedAt: time.Now().UTC(),
+		User:       exportUser(u),
+		Ads:        make([]exportedAd, 0, len(al.Data)),
+	}
+	for _, ad := range al.Data {
+		data.Ads = append(data.Ads, exportAd(ad))
+	}
+
+	saved, err := a.repository.ListSavedSearches(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	data.Searches = make([]exportedSearch, 0, len(saved))
+	for _, s := range saved {
+		data.Searches = append(data.Searches, exportSearch(s))
+	}
+
+	// the zero limit lists the whole inbox
+	inbox, err := a.repository.ListNotifications(ctx, uid, ListNotificationsParams{})
+	if err != nil {
+		return nil, err
+	}
+	data.Notifications = make([]exportedNotification, 0, len(inbox))
+	for _, n := range inbox {
+		data.Notifications = append(data.Notifications, exportNotification(n))
+	}
+
+	hooks, err := a.repository.ListWebhooks(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	data.Webhooks = make([]exportedWebhook, 0, len(hooks))
+	for _, s := range hooks {
+		deliveries, err := a.repository.ListWebhookDeliveries(ctx, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		data.Webhooks = append(data.Webhooks, exportWebhook(s, deliveries))
+	}
+	return &data, nil
+}
+
+func exportUser(u *user.User) exportedUser {
+	return exportedUser{
+		ID:          u.ID,
+		Nickname:    u.Nickname,
+		Email:       u.Email,
+		Verified:    u.Verified,
+		HasPassword: len(u.PasswordHash) > 0,
+	}
+}
+
+func exportAd(ad ads.Ad) exportedAd {
+	return exportedAd{
+		ID:          ad.ID,
+		Title:       ad.Title,
+		Text:        ad.Text,
+		AuthorID:    ad.AuthorID,
+		Published:   ad.Published,
+		DateCreated: ad.DateCreated,
+		DateChanged: ad.DateChanged,
+		DeletedAt:   ad.DeletedAt,
+	}
+}
+
+func exportSearch(s searches.SavedSearch) exportedSearch {
+	e := exportedSearch{
+		ID:        s.ID,
+		Name:      s.Name,
+		Query:     s.Query,
+		AuthorID:  s.AuthorID,
+		Title:     s.Title,
+		Date:      s.Date,
+		Frequency: s.Frequency.String(),
+		Created:   s.Created,
+	}
+	if !s.LastNotified.IsZero() {
+		e.LastNotified = &s.LastNotified
+	}
+	return e
+}
+
+func exportNotification(n notifications.Notification) exportedNotification {
+	return exportedNotification{
+		ID:       n.ID,
+		Kind:     n.Kind,
+		Subject:  n.Subject,
+		Text:     n.Text,
+		AdIDs:    n.AdIDs,
+		SearchID: n.SearchID,
+		Created:  n.Created,
+		Read:     n.Read,
+	}
+}
+
+func exportWebhook(s webhooks.Subscription, deliveries []webhooks.Delivery) exportedWebhook {
+	e := exportedWebhook{
+		ID:         s.ID,
+		URL:        s.URL,
+		Events:     s.Events,
+		Active:     s.Active,
+		Created:    s.Created,
+		DisabledAt: s.DisabledAt,
+		Deliveries: make([]exportedDelivery, 0, len(deliveries)),
+	}
+	for _, d := range deliveries {
+		e.Deliveries = append(e.Deliveries, exportedDelivery{
+			ID:         d.ID,
+			Event:      d.Event,
+			Attempts:   d.Attempts,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Success:    d.Success,
+			Time:       d.Time,
+		})
+	}
+	return e
+}
+
+// zipUserData puts the profile, the ads and the other data of the user into separate files of the archive
+func zipUserData(data *exportedData) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"user.json", data.User},
+		{"ads.json", data.Ads},
+		{"saved_searches.json", data.Searches},
+		{"notifications.json", data.Notifications},
+		{"webhooks.json", data.Webhooks},
+	}
+	for _, f := range files {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: data.ExportedAt})
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(fw).Encode(f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPurgeJob permanently removes records soft deleted more than retention ago,
+// the check is repeated every interval until the context is done
+func RunPurgeJob(ctx context.Context, a AdminApp, interval time.Duration, retention time.Duration) func() error {
+	return func() error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				n, err := a.PurgeDeleted(ctx, retention)
+				if err != nil {
+					log.Printf("purge job failed: %s\n", err.Error())
+					continue
+				}
+				if n > 0 {
+					log.Printf("purge job removed %d deleted records\n", n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNotificationCap = 10
+	DefaultAlertsInterval  = time.Minute
+
+	// maxAdsPerNotification limits the list of ads in the text of a notification
+	maxAdsPerNotification = 20
+	capWindow             = 24 * time.Hour
+)
+
+// CreateSavedSearch saves the query of the user, a search without a query and filters would match every ad
+func (a Application) CreateSavedSearch(ctx context.Context, uid int64, params SavedSearchParams) (*searches.SavedSearch, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	query := strings.TrimSpace(params.Query)
+	if query == "" && params.Uid == nil && params.Date == nil && params.Title == nil {
+		return nil, ErrInvalidSearch
+	}
+	if params.Frequency < searches.FrequencyInstant || params.Frequency > searches.FrequencyWeekly {
+		return nil, ErrInvalidParameter.Withf("unknown notification frequency: %d", params.Frequency)
+	}
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		name = query
+	}
+
+	s := searches.SavedSearch{
+		UserID:    uid,
+		Name:      name,
+		Query:     query,
+		AuthorID:  params.Uid,
+		Title:     params.Title,
+		Date:      params.Date,
+		Frequency: params.Frequency,
+		Created:   time.Now().UTC(),
+	}
+	id, err := a.repository.AddSavedSearch(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	s.ID = id
+
+	return &s, nil
+}
+
+// GetSavedSearch returns the search only to its owner
+func (a Application) GetSavedSearch(ctx context.Context, uid int64, id int64) (*searches.SavedSearch, error) {
+	s, err := a.repository.GetSavedSearchByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID != uid {
+		return nil, ErrSearchNotFound
+	}
+	return s, nil
+}
+
+func (a Application) ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.repository.ListSavedSearches(ctx, uid)
+}
+
+func (a Application) DeleteSavedSearch(ctx context.Context, uid int64, id int64) error {
+	return a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		if _, err := a.in(tx).GetSavedSearch(ctx, uid, id); err != nil {
+			return err
+		}
+		return tx.DeleteSavedSearch(ctx, id)
+	})
+}
+
+// SearchAlerts evaluates newly published ads against the saved searches and notifies the owners.
+// Matches are collected in the search and sent as one notification, when the frequency of the search
+// allows it. A user gets at most cap notifications a day, the matches above the cap wait for the next day
+type SearchAlerts struct {
+	mu         sync.Mutex
+	repository Repository
+	notifier   Notifier
+	cap        int
+	interval   time.Duration
+}
+
+type AlertsOption func(*SearchAlerts)
+
+// WithNotificationCap sets the number of notifications a user can get a day, zero disables the cap
+func WithNotificationCap(n int) AlertsOption {
+	return func(a *SearchAlerts) {
+		a.cap = n
+	}
+}
+
+// WithAlertsInterval sets how often the digests are checked by Run
+func WithAlertsInterval(interval time.Duration) AlertsOption {
+	return func(a *SearchAlerts) {
+		a.interval = interval
+	}
+}
+
+func NewSearchAlerts(repo Repository, notifier Notifier, opts ...AlertsOption) *SearchAlerts {
+	a := &SearchAlerts{
+		repository: repo,
+		notifier:   notifier,
+		cap:        DefaultNotificationCap,
+		interval:   DefaultAlertsInterval,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Handle is the event bus handler, which matches published ads. Instant searches are notified at once
+func (a *SearchAlerts) Handle(ctx context.Context, e events.Event) error {
+	var ad ads.Ad
+	switch t := e.(type) {
+	case events.AdPublished:
+		ad = t.Ad
+	case events.AdCreated:
+		ad = t.Ad
+	default:
+		return nil
+	}
+	if !ad.Published {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list, err := a.repository.ActiveSavedSearches(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, s := range list {
+		// users are not notified about their own ads
+		if s.UserID == ad.AuthorID || !s.Matches(ad) || containsID(s.Pending, ad.ID) {
+			continue
+		}
+		s.Pending = append(s.Pending, ad.ID)
+		if s.Frequency == searches.FrequencyInstant {
+			_, err := a.notify(ctx, s, now)
+			if err == nil {
+				continue
+			}
+			// the match stays pending and Run tries again
+			log.Printf("search alerts: can't notify user %d about search %d: %s\n", s.UserID, s.ID, err.Error())
+		}
+		if err := a.store(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sends due digests every interval until the context is done
+func (a *SearchAlerts) Run(ctx context.Context) func() error {
+	return func() error {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if _, err := a.Flush(ctx, time.Now().UTC()); err != nil {
+					log.Printf("search alerts failed: %s\n", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// Flush notifies all searches, which have pending matches and whose period is over at the given time,
+// it returns the number of sent notifications
+func (a *SearchAlerts) Flush(ctx context.Context, now time.Time) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list, err := a.repository.ActiveSavedSearches(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, s := range list {
+		if len(s.Pending) == 0 || now.Before(s.NextNotification()) {
+			continue
+		}
+		ok, err := a.notify(ctx, s, now)
+		if err != nil {
+			log.Printf("search alerts: can't notify user %d about search %d: %s\n", s.UserID, s.ID, err.Error())
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// notify sends the pending matches of the search and stores the search, it returns false if nothing
+// was sent because of the cap or because the matched ads are gone. The search is not stored on errors
+func (a *SearchAlerts) notify(ctx context.Context, s searches.SavedSearch, now time.Time) (bool, error) {
+	if a.cap > 0 {
+		count, err := a.sentToday(ctx, s, now)
+		if err != nil {
+			return false, err
+		}
+		if count >= a.cap {
+			return false, a.store(ctx, s)
+		}
+	}
+
+	// ads could be unpublished, changed or deleted since they matched
+	matched := make([]ads.Ad, 0, len(s.Pending))
+	for _, id := range s.Pending {
+		if ad, err := a.repository.GetAdByID(ctx, id); err == nil && s.Matches(*ad) {
+			matched = append(matched, *ad)
+		}
+	}
+	s.Pending = nil
+	if len(matched) == 0 {
+		return false, a.store(ctx, s)
+	}
+
+	if err := a.notifier.Notify(ctx, searchNotification(s, matched, now)); err != nil {
+		return false, err
+	}
+	s.LastNotified = now
+	recent := make([]time.Time, 0, len(s.Sent)+1)
+	for _, t := range s.Sent {
+		if t.After(now.Add(-capWindow)) {
+			recent = append(recent, t)
+		}
+	}
+	s.Sent = append(recent, now)
+	return true, a.store(ctx, s)
+}
+
+// sentToday counts notifications of all searches of the owner of s during the last day
+func (a *SearchAlerts) sentToday(ctx context.Context, s searches.SavedSearch, now time.Time) (int, error) {
+	list, err := a.repository.ListSavedSearches(ctx, s.UserID)
+	if err != nil {
+		return 0, err
+	}
+	since := now.Add(-capWindow)
+	count := s.SentSince(since)
+	for _, other := range list {
+		if other.ID != s.ID {
+			count += other.SentSince(since)
+		}
+	}
+	return count, nil
+}
+
+// store ignores searches deleted while they were processed
+func (a *SearchAlerts) store(ctx context.Context, s searches.SavedSearch) error {
+	if err := a.repository.UpdateSavedSearch(ctx, s); err != nil && !errors.Is(err, ErrSearchNotFound) {
+		return err
+	}
+	return nil
+}
+
+func searchNotification(s searches.SavedSearch, matched []ads.Ad, now time.Time) notifications.Notification {
+	n := notifications.Notification{
+		UserID:   s.UserID,
+		Kind:     notifications.KindSearchMatch,
+		Subject:  fmt.Sprintf("New ads for your search %q", s.Name),
+		AdIDs:    make([]int64, 0, len(matched)),
+		SearchID: &s.ID,
+		Created:  now,
+	}
+	var text strings.Builder
+	for i, ad := range matched {
+		n.AdIDs = append(n.AdIDs, ad.ID)
+		if i < maxAdsPerNotification {
+			text.WriteString(notifications.AdLine(ad.Title, ad.ID))
+		}
+	}
+	if len(matched) > maxAdsPerNotification {
+		fmt.Fprintf(&text, "and %d more\n", len(matched)-maxAdsPerNotification)
+	}
+	n.Text = text.String()
+	return n
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+)
+
+// WatchAds applies the filters as ListAds, including the default list of published ads. Unpublishing
+// and deletion ignore the published filter, so that watchers learn to remove the ad from their list,
+// deletion is filtered by the author only, and the ads handed over to another author are sent to the
+// watchers of the previous author as well
+func (a Application) WatchAds(ctx context.Context, params ListAdsParams) (<-chan events.Event, func() error, error) {
+	if a.feed == nil {
+		return nil, nil, ErrFeedUnavailable
+	}
+	p := true
+	if params.Published == nil && params.Uid == nil && params.Date == nil && params.Title == nil && params.Text == nil {
+		params.Published = &p
+	}
+	ch, errFunc := a.feed.Subscribe(ctx, func(e events.Event) bool {
+		return watchMatches(params, e)
+	})
+	return ch, errFunc, nil
+}
+
+func watchMatches(params ListAdsParams, e events.Event) bool {
+	switch t := e.(type) {
+	case events.AdCreated:
+		return params.Match(t.Ad)
+	case events.AdPublished:
+		return params.Match(t.Ad)
+	case events.AdUpdated:
+		if t.PreviousAuthorID != nil && params.Uid != nil && *params.Uid == *t.PreviousAuthorID {
+			return true
+		}
+		return params.Match(t.Ad)
+	case events.AdRestored:
+		return params.Match(t.Ad)
+	case events.AdUnpublished:
+		params.Published = nil
+		return params.Match(t.Ad)
+	case events.AdDeleted:
+		return params.Uid == nil || *params.Uid == t.AuthorID
+	}
+	return false
+}
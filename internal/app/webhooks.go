@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"net/url"
+	"time"
+)
+
+// CreateWebhook subscribes the endpoint of the user to the given events, no events means all of them.
+// The returned subscription contains the secret, which signs the deliveries
+func (a Application) CreateWebhook(ctx context.Context, uid int64, rawURL string, eventNames []string) (*webhooks.Subscription, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidWebhook
+	}
+	names := make([]string, 0, len(eventNames))
+	seen := make(map[string]struct{}, len(eventNames))
+	for _, name := range eventNames {
+		if !webhooks.IsSupported(name) {
+			return nil, ErrUnknownEvent
+		}
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	s := webhooks.Subscription{
+		OwnerID: uid,
+		URL:     rawURL,
+		Secret:  secret,
+		Events:  names,
+		Active:  true,
+		Created: time.Now().UTC(),
+	}
+	id, err := a.repository.AddWebhook(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	s.ID = id
+
+	return &s, nil
+}
+
+// GetWebhook returns the subscription only to its owner
+func (a Application) GetWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error) {
+	s, err := a.repository.GetWebhookByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.OwnerID != uid {
+		return nil, ErrWebhookNotFound
+	}
+	return s, nil
+}
+
+func (a Application) ListWebhooks(ctx context.Context, uid int64) ([]webhooks.Subscription, error) {
+	if _, err := a.repository.GetUserByID(ctx, uid); err != nil {
+		return nil, err
+	}
+	return a.repository.ListWebhooks(ctx, uid)
+}
+
+func (a Application) DeleteWebhook(ctx context.Context, uid int64, id int64) error {
+	return a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		if _, err := a.in(tx).GetWebhook(ctx, uid, id); err != nil {
+			return err
+		}
+		return tx.DeleteWebhook(ctx, id)
+	})
+}
+
+func (a Application) EnableWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error) {
+	var s *webhooks.Subscription
+	err := a.repository.InTransaction(ctx, func(ctx context.Context, tx Repository) error {
+		if _, err := a.in(tx).GetWebhook(ctx, uid, id); err != nil {
+			return err
+		}
+		if err := tx.SetWebhookActive(ctx, id, true); err != nil {
+			return err
+		}
+		var err error
+		s, err = tx.GetWebhookByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (a Application) ListWebhookDeliveries(ctx context.Context, uid int64, id int64) ([]webhooks.Delivery, error) {
+	if _, err := a.GetWebhook(ctx, uid, id); err != nil {
+		return nil, err
+	}
+	return a.repository.ListWebhookDeliveries(ctx, id)
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	ProtocolHTTP = "HTTP"
+	ProtocolGRPC = "GRPC"
+)
+
+const (
+	TargetAd   = "ad"
+	TargetUser = "user"
+)
+
+// Record describes a single mutation, records are only appended and never changed, except for the snapshots,
+// which are redacted, when the user is erased
+type Record struct {
+	Time time.Time `json:"time"`
+	// Actor is the ID of the user who made the change, nil for anonymous and admin calls
+	Actor      *int64          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id"`
+	Protocol   string          `json:"protocol"`
+}
+
+// Filter selects records, nil fields match any value, the time range is [From, To)
+type Filter struct {
+	Actor      *int64
+	TargetType *string
+	TargetID   *int64
+	From       *time.Time
+	To         *time.Time
+}
+
+func (f Filter) Match(r Record) bool {
+	if f.Actor != nil && (r.Actor == nil || *r.Actor != *f.Actor) {
+		return false
+	}
+	if f.TargetType != nil && *f.TargetType != r.TargetType {
+		return false
+	}
+	if f.TargetID != nil && *f.TargetID != r.TargetID {
+		return false
+	}
+	if f.From != nil && r.Time.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !r.Time.Before(*f.To) {
+		return false
+	}
+	return true
+}
+
+// RequestInfo identifies the request, which caused the mutation
+type RequestInfo struct {
+	ID       string
+	Protocol string
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func RequestInfoFrom(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// NewRequestID generates an ID for requests, which came without one
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,170 @@
+package events
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"time"
+)
+
+const (
+	NameAdCreated     = "ad.created"
+	NameAdPublished   = "ad.published"
+	NameAdUnpublished = "ad.unpublished"
+	NameAdUpdated     = "ad.updated"
+	NameAdDeleted     = "ad.deleted"
+	NameAdRestored    = "ad.restored"
+	NameUserCreated   = "user.created"
+	NameUserDeleted   = "user.deleted"
+)
+
+const (
+	AggregateAd   = "ad"
+	AggregateUser = "user"
+)
+
+// Event is a fact about a change of the domain state, events are published after the change is stored
+type Event interface {
+	EventName() string
+	OccurredAt() time.Time
+	// Aggregate identifies the entity, events of the same aggregate are delivered in order
+	Aggregate() (string, int64)
+}
+
+// Message is an event stored in the outbox until it is delivered
+type Message struct {
+	ID          int64
+	Event       Event
+	Created     time.Time
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	// Handled names the subscribers, which handled the event already, a retry skips them
+	Handled []string
+}
+
+// HandledBy reports whether the subscriber handled the message already
+func (m Message) HandledBy(subscriber string) bool {
+	for _, name := range m.Handled {
+		if name == subscriber {
+			return true
+		}
+	}
+	return false
+}
+
+type messageIDKey struct{}
+
+// WithMessageID passes the ID of the outbox message to the handlers of its event,
+// the ID stays the same, when the event is delivered again
+func WithMessageID(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, messageIDKey{}, id)
+}
+
+// MessageID returns the ID of the outbox message of the handled event, published events have none
+func MessageID(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(messageIDKey{}).(int64)
+	return id, ok
+}
+
+type AdCreated struct {
+	Ad   ads.Ad
+	Time time.Time
+}
+
+type AdPublished struct {
+	Ad   ads.Ad
+	Time time.Time
+}
+
+type AdUnpublished struct {
+	Ad   ads.Ad
+	Time time.Time
+}
+
+type AdUpdated struct {
+	Ad ads.Ad
+	// PreviousAuthorID is set, when the ad is handed over to another author after its author is deleted
+	PreviousAuthorID *int64
+	Time             time.Time
+}
+
+type AdDeleted struct {
+	AdID     int64
+	AuthorID int64
+	Time     time.Time
+}
+
+// AdRestored is written for the ads restored by the admin, alone or together with their author
+type AdRestored struct {
+	Ad   ads.Ad
+	Time time.Time
+}
+
+// UserCreated never carries the password hash of the user
+type UserCreated struct {
+	User user.User
+	Time time.Time
+}
+
+type UserDeleted struct {
+	UserID int64
+	Time   time.Time
+}
+
+func (e AdCreated) EventName() string     { return NameAdCreated }
+func (e AdPublished) EventName() string   { return NameAdPublished }
+func (e AdUnpublished) EventName() string { return NameAdUnpublished }
+func (e AdUpdated) EventName() string     { return NameAdUpdated }
+func (e AdDeleted) EventName() string     { return NameAdDeleted }
+func (e AdRestored) EventName() string    { return NameAdRestored }
+func (e UserCreated) EventName() string   { return NameUserCreated }
+func (e UserDeleted) EventName() string   { return NameUserDeleted }
+
+func (e AdCreated) OccurredAt() time.Time     { return e.Time }
+func (e AdPublished) OccurredAt() time.Time   { return e.Time }
+func (e AdUnpublished) OccurredAt() time.Time { return e.Time }
+func (e AdUpdated) OccurredAt() time.Time     { return e.Time }
+func (e AdDeleted) OccurredAt() time.Time     { return e.Time }
+func (e AdRestored) OccurredAt() time.Time    { return e.Time }
+func (e UserCreated) OccurredAt() time.Time   { return e.Time }
+func (e UserDeleted) OccurredAt() time.Time   { return e.Time }
+
+func (e AdCreated) Aggregate() (string, int64)     { return AggregateAd, e.Ad.ID }
+func (e AdPublished) Aggregate() (string, int64)   { return AggregateAd, e.Ad.ID }
+func (e AdUnpublished) Aggregate() (string, int64) { return AggregateAd, e.Ad.ID }
+func (e AdUpdated) Aggregate() (string, int64)     { return AggregateAd, e.Ad.ID }
+func (e AdDeleted) Aggregate() (string, int64)     { return AggregateAd, e.AdID }
+func (e AdRestored) Aggregate() (string, int64)    { return AggregateAd, e.Ad.ID }
+func (e UserCreated) Aggregate() (string, int64)   { return AggregateUser, e.User.ID }
+func (e UserDeleted) Aggregate() (string, int64)   { return AggregateUser, e.UserID }
+
+// Redact removes the personal data from the event of an erased user, the name and the aggregate of the event stay
+func Redact(e Event) Event {
+	switch e := e.(type) {
+	case AdCreated:
+		e.Ad = redactAd(e.Ad)
+		return e
+	case AdPublished:
+		e.Ad = redactAd(e.Ad)
+		return e
+	case AdUnpublished:
+		e.Ad = redactAd(e.Ad)
+		return e
+	case AdUpdated:
+		e.Ad = redactAd(e.Ad)
+		return e
+	case AdRestored:
+		e.Ad = redactAd(e.Ad)
+		return e
+	case UserCreated:
+		e.User = user.User{ID: e.User.ID, DeletedAt: e.User.DeletedAt}
+		return e
+	}
+	return e
+}
+
+func redactAd(ad ads.Ad) ads.Ad {
+	ad.Title, ad.Text = "", ""
+	return ad
+}
@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// Header holds the key chosen by the client, gRPC calls send it in the idempotency-key metadata
+	Header = "Idempotency-Key"
+	// ReplayedHeader marks the responses, which were stored for the first request with the key
+	ReplayedHeader = "Idempotent-Replayed"
+	MaxKeyLength   = 255
+)
+
+var (
+	ErrInvalidKey = errors.New("idempotency key must have from 1 to 255 characters")
+	ErrKeyReused  = errors.New("idempotency key was already used for a different request")
+	ErrInProgress = errors.New("request with the same idempotency key is in progress")
+)
+
+// Record is the response stored for the key. It is reserved, when the first request starts,
+// and completed with the response, when the request ends
+type Record struct {
+	Key string
+	// Fingerprint is the hash of the request, the retries must have the same one
+	Fingerprint string
+	Done        bool
+	// Status is the HTTP status or the gRPC code of the response
+	Status int
+	// ContentType is the HTTP content type or the full name of the gRPC response message
+	ContentType string
+	// Body is the HTTP body, the gRPC response message or the message of the gRPC error
+	Body    []byte
+	Created time.Time
+}
+
+// Store keeps the records for a bounded time
+type Store interface {
+	// Reserve saves the running record, unless there already is a record with the key, which is returned then
+	Reserve(ctx context.Context, r Record) (*Record, error)
+	// Complete saves the response of the reserved record
+	Complete(ctx context.Context, r Record) error
+	// Release removes the reserved record, so that the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// Fingerprint hashes the parts of the request
+func Fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		_ = binary.Write(h, binary.BigEndian, uint64(len(p)))
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin reserves the key for the request. It returns the stored record to be replayed,
+// or nil, when the request has to be run and then completed or released
+func Begin(ctx context.Context, s Store, key string, fingerprint string) (*Record, error) {
+	existing, err := s.Reserve(ctx, Record{Key: key, Fingerprint: fingerprint, Created: time.Now().UTC()})
+	switch {
+	case err != nil:
+		return nil, err
+	case existing == nil:
+		return nil, nil
+	case existing.Fingerprint != fingerprint:
+		return nil, ErrKeyReused
+	case !existing.Done:
+		return nil, ErrInProgress
+	}
+	return existing, nil
+}
+
+// ValidKey checks the key sent by the client
+func ValidKey(key string) error {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return ErrInvalidKey
+	}
+	return nil
+}
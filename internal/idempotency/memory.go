@@ -0,0 +1,84 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetention is how long the records are kept
+	DefaultRetention  = 24 * time.Hour
+	DefaultMaxRecords = 100000
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the records in memory for the retention period, the oldest records
+// are dropped earlier, when there are more than max of them
+type MemoryStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	max       int
+	records   map[string]*list.Element
+	// order keeps the records from the oldest to the newest
+	order *list.List
+}
+
+func NewMemoryStore(retention time.Duration, max int) *MemoryStore {
+	return &MemoryStore{retention: retention, max: max, records: make(map[string]*list.Element), order: list.New()}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, r Record) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	if el, ok := s.records[r.Key]; ok {
+		existing := *el.Value.(*Record)
+		existing.Body = append([]byte(nil), existing.Body...)
+		return &existing, nil
+	}
+	r.Done = false
+	s.records[r.Key] = s.order.PushBack(&r)
+	for s.order.Len() > s.max {
+		s.drop(s.order.Front())
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.records[r.Key]
+	if !ok {
+		return nil
+	}
+	stored := el.Value.(*Record)
+	stored.Done = true
+	stored.Status = r.Status
+	stored.ContentType = r.ContentType
+	stored.Body = append([]byte(nil), r.Body...)
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.records[key]; ok {
+		s.drop(el)
+	}
+	return nil
+}
+
+// expire drops the records older than the retention period
+func (s *MemoryStore) expire(now time.Time) {
+	for el := s.order.Front(); el != nil && now.Sub(el.Value.(*Record).Created) >= s.retention; el = s.order.Front() {
+		s.drop(el)
+	}
+}
+
+func (s *MemoryStore) drop(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.records, el.Value.(*Record).Key)
+}
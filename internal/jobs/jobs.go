@@ -0,0 +1,67 @@
+package jobs
+
+import "time"
+
+type Kind int
+
+const (
+	KindExport Kind = iota
+	KindErasure
+)
+
+type Status int
+
+const (
+	StatusPending Status = iota
+	StatusRunning
+	StatusDone
+	StatusFailed
+)
+
+// Job is a long-running operation on the data of a single user, its status is polled by the client
+type Job struct {
+	ID     int64
+	Kind   Kind
+	UserID int64
+	Status Status
+	// Error holds the failure reason, when Status is StatusFailed
+	Error string
+	// Result and ContentType hold the archive produced by an export job
+	Result      []byte
+	ContentType string
+	Created     time.Time
+	Finished    *time.Time
+	// Expires is the time, when the result is removed
+	Expires *time.Time
+}
+
+// Expired tells if the result of the job is removed or is due to be removed
+func (j Job) Expired(now time.Time) bool {
+	return j.Expires != nil && !now.Before(*j.Expires)
+}
+
+func (k Kind) String() string {
+	switch k {
+	case KindExport:
+		return "export"
+	case KindErasure:
+		return "erasure"
+	default:
+		return "unknown"
+	}
+}
+
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusRunning:
+		return "running"
+	case StatusDone:
+		return "done"
+	case StatusFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,60 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// KindSearchMatch tells about new ads matching a saved search
+	KindSearchMatch = "search.match"
+	// KindAdPublished tells the author, that the ad is published
+	KindAdPublished = "ad.published"
+	// KindAdUnpublished tells the author, that the ad is taken off the list
+	KindAdUnpublished = "ad.unpublished"
+)
+
+// ErasedAdTitle replaces the titles of the erased ads in the texts of notifications
+const ErasedAdTitle = "[erased]"
+
+// Notification is a message for a user, it is delivered by a notifier: to the in-app inbox, by email etc.
+type Notification struct {
+	ID      int64
+	UserID  int64
+	Kind    string
+	Subject string
+	Text    string
+	// AdIDs lists the ads the notification is about
+	AdIDs []int64
+	// SearchID is the saved search, which produced the notification
+	SearchID *int64
+	Created  time.Time
+	Read     bool
+}
+
+// AdLine is the line of the text, which names the ad
+func AdLine(title string, id int64) string {
+	return fmt.Sprintf("- %s (ad %d)\n", title, id)
+}
+
+// RedactAd replaces the title of the erased ad in the lines of the text, which name it
+func (n *Notification) RedactAd(id int64) {
+	suffix := fmt.Sprintf(" (ad %d)\n", id)
+	lines := strings.SplitAfter(n.Text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "- ") && strings.HasSuffix(line, suffix) {
+			lines[i] = AdLine(ErasedAdTitle, id)
+		}
+	}
+	n.Text = strings.Join(lines, "")
+}
+
+// Page is a part of the inbox, the notifications go from the newest one
+type Page struct {
+	Notifications []Notification
+	// Unread is the number of unread notifications in the whole inbox
+	Unread int
+	// Next is the cursor of the following page, nil on the last page
+	Next *int64
+}
@@ -0,0 +1,364 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxGatewayBody is the size of the largest JSON body of the gateway
+const maxGatewayBody = 1 << 20
+
+// Gateway serves the unary calls of AdService as JSON over HTTP with the paths of the google.api.http
+// annotations in service.proto. The calls go to the service in-process through the same interceptors
+// as the gRPC calls, so the limits, the idempotency keys and the errors are the same in both transports
+type Gateway struct {
+	server      AdServiceServer
+	interceptor grpc.UnaryServerInterceptor
+	routes      []gatewayRoute
+}
+
+type gatewayRoute struct {
+	verb string
+	// segments of the path, the variables are in braces, such as {ad_id}
+	segments   []string
+	body       string
+	fullMethod string
+	handler    methodHandler
+	input      protoreflect.MessageType
+}
+
+// NewGateway returns the gateway calling the service, the interceptors are called in the same order
+// as by grpc.ChainUnaryInterceptor
+func NewGateway(server AdServiceServer, interceptors ...grpc.UnaryServerInterceptor) (*Gateway, error) {
+	g := &Gateway{server: server, interceptor: chainInterceptors(interceptors)}
+	handlers := map[string]methodHandler{}
+	for _, m := range AdService_ServiceDesc.Methods {
+		handlers[m.MethodName] = methodHandler(m.Handler)
+	}
+
+	methods := File_service_proto.Services().ByName("AdService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.Get(i)
+		rule, ok := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		handler, ok := handlers[string(m.Name())]
+		if !ok {
+			return nil, fmt.Errorf("%s: only unary calls can be served by the gateway", m.Name())
+		}
+		input, err := protoregistry.GlobalTypes.FindMessageByName(m.Input().FullName())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Name(), err)
+		}
+		verb, path := httpPattern(rule)
+		if path == "" {
+			return nil, fmt.Errorf("%s: unsupported http rule", m.Name())
+		}
+		g.routes = append(g.routes, gatewayRoute{
+			verb:       verb,
+			segments:   strings.Split(strings.Trim(path, "/"), "/"),
+			body:       rule.GetBody(),
+			fullMethod: fmt.Sprintf("/%s/%s", AdService_ServiceDesc.ServiceName, m.Name()),
+			handler:    handler,
+			input:      input,
+		})
+	}
+	// the literal segments win over the variables, e.g. /v1/ads/batch is not an ad
+	sort.SliceStable(g.routes, func(i, j int) bool {
+		return g.routes[i].variables() < g.routes[j].variables()
+	})
+	return g, nil
+}
+
+// methodHandler is the handler of the unary call in grpc.MethodDesc
+type methodHandler func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+func httpPattern(rule *annotations.HttpRule) (string, string) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	}
+	return "", ""
+}
+
+func (r gatewayRoute) variables() int {
+	n := 0
+	for _, s := range r.segments {
+		if strings.HasPrefix(s, "{") {
+			n++
+		}
+	}
+	return n
+}
+
+// match returns the values of the variables of the path or false, if the path is not of the route
+func (r gatewayRoute) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, s := range r.segments {
+		if name, ok := strings.CutPrefix(s, "{"); ok {
+			vars[strings.TrimSuffix(name, "}")] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// chainInterceptors makes one interceptor of the interceptors, the first one is the outermost
+func chainInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	pathFound := false
+	for _, route := range g.routes {
+		vars, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		pathFound = true
+		if route.verb == r.Method {
+			g.serve(w, r, route, vars)
+			return
+		}
+	}
+	if pathFound {
+		writeStatus(w, nil, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "method not allowed"))
+		return
+	}
+	writeStatus(w, nil, http.StatusNotFound, status.New(codes.NotFound, "no route for "+r.URL.Path))
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request, route gatewayRoute, vars map[string]string) {
+	stream := &gatewayStream{method: route.fullMethod, header: metadata.MD{}}
+	ctx := grpc.NewContextWithServerTransportStream(r.Context(), stream)
+	ctx = metadata.NewIncomingContext(ctx, requestMetadata(r))
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+	}
+
+	req, err := decodeRequest(r, route, vars)
+	if err != nil {
+		writeError(w, stream.header, StatusError(err))
+		return
+	}
+	dec := func(v interface{}) error {
+		proto.Merge(v.(proto.Message), req)
+		return nil
+	}
+	resp, err := route.handler(g.server, ctx, dec, g.interceptor)
+	if err != nil {
+		writeError(w, stream.header, err)
+		return
+	}
+	body, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(resp.(proto.Message))
+	if err != nil {
+		writeError(w, stream.header, StatusError(err))
+		return
+	}
+	writeHeader(w, stream.header)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// requestMetadata passes the headers of the request as the metadata, such as x-user-id or idempotency-key
+func requestMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		md.Append(strings.ToLower(k), v...)
+	}
+	return md
+}
+
+// decodeRequest reads the request of the call from the body, the variables of the path and the query
+func decodeRequest(r *http.Request, route gatewayRoute, vars map[string]string) (proto.Message, error) {
+	req := route.input.New()
+	if route.body == "*" {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxGatewayBody))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return nil, app.ErrBodyTooLarge.Wrap(err)
+		case err != nil:
+			return nil, app.ErrInvalidRequest.Wrap(err)
+		}
+		if len(body) != 0 {
+			if err := protojson.Unmarshal(body, req.Interface()); err != nil {
+				return nil, app.ErrInvalidRequest.Wrap(err)
+			}
+		}
+	} else {
+		for name, values := range r.URL.Query() {
+			for _, v := range values {
+				if err := setField(req, name, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// the path wins over the body
+	for name, v := range vars {
+		if err := setField(req, name, v); err != nil {
+			return nil, err
+		}
+	}
+	return req.Interface(), nil
+}
+
+// setField sets the scalar field with the proto or JSON name, the value is appended to the repeated fields
+func setField(msg protoreflect.Message, name string, value string) error {
+	fields := msg.Descriptor().Fields()
+	fd := fields.ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = fields.ByJSONName(name)
+	}
+	if fd == nil || fd.Message() != nil || fd.IsMap() {
+		return app.ErrInvalidParameter.Withf("unknown parameter: %s", name)
+	}
+	v, err := parseValue(fd, value)
+	if err != nil {
+		return app.ErrInvalidParameter.Withf("invalid %s: %q", name, value)
+	}
+	if fd.IsList() {
+		msg.Mutable(fd).List().Append(v)
+	} else {
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+func parseValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.EnumKind:
+		if v := fd.Enum().Values().ByName(protoreflect.Name(s)); v != nil {
+			return protoreflect.ValueOfEnum(v.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err == nil && fd.Enum().Values().ByNumber(protoreflect.EnumNumber(n)) == nil {
+			err = fmt.Errorf("unknown value of %s", fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %s", fd.Kind())
+}
+
+// writeError writes the status as JSON with the HTTP status of the code in ErrorInfo from the translation
+// table of the application, so the gateway answers with the same statuses as the HTTP API
+func writeError(w http.ResponseWriter, header metadata.MD, err error) {
+	st := status.Convert(err)
+	code := app.CodeInternal
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			code = info.Reason
+		}
+	}
+	writeStatus(w, header, app.TranslateCode(code).HTTPStatus, st)
+}
+
+func writeStatus(w http.ResponseWriter, header metadata.MD, httpStatus int, st *status.Status) {
+	body, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		body = []byte(`{"code":13,"message":"failed to marshal the status"}`)
+		httpStatus = http.StatusInternalServerError
+	}
+	writeHeader(w, header)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(body)
+}
+
+// writeHeader sends the header metadata of the call as the headers of the response
+func writeHeader(w http.ResponseWriter, header metadata.MD) {
+	for k, values := range header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+// gatewayStream keeps the metadata, which the interceptors and the handlers send with grpc.SetHeader
+type gatewayStream struct {
+	method string
+	mu     sync.Mutex
+	header metadata.MD
+}
+
+func (s *gatewayStream) Method() string {
+	return s.method
+}
+
+func (s *gatewayStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *gatewayStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *gatewayStream) SetTrailer(md metadata.MD) error {
+	return s.SetHeader(md)
+}
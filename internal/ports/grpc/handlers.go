@@ -1,9 +1,12 @@
 package grpc
 
 import (
+	"bufio"
 	"context"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
-	"google.golang.org/grpc/codes"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
+	"github.com/TobbyMax/ad-service.git/internal/user"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"net/mail"
@@ -11,12 +14,12 @@ import (
 
 func (s *AdService) CreateAd(ctx context.Context, request *CreateAdRequest) (*AdResponse, error) {
 	if request.UserId == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
 	ad, err := s.app.CreateAd(ctx, request.GetTitle(), request.GetText(), request.GetUserId())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 
 	return AdSuccessResponse(ad), nil
@@ -24,36 +27,46 @@ func (s *AdService) CreateAd(ctx context.Context, request *CreateAdRequest) (*Ad
 
 func (s *AdService) ChangeAdStatus(ctx context.Context, request *ChangeAdStatusRequest) (*AdResponse, error) {
 	if request.AdId == nil || request.UserId == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
 	ad, err := s.app.ChangeAdStatus(ctx, request.GetAdId(), request.GetUserId(), request.GetPublished())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return AdSuccessResponse(ad), nil
 }
 
 func (s *AdService) UpdateAd(ctx context.Context, request *UpdateAdRequest) (*AdResponse, error) {
 	if request.AdId == nil || request.UserId == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
+	}
+	patch, err := AdPatch(request)
+	if err != nil {
+		return nil, StatusError(err)
+	}
+
+	var ad *ads.Ad
+	if len(request.GetUpdateMask().GetPaths()) == 0 {
+		ad, err = s.app.UpdateAd(ctx, request.GetAdId(), request.GetUserId(), request.GetTitle(), request.GetText())
+	} else {
+		ad, err = s.app.PatchAd(ctx, request.GetAdId(), request.GetUserId(), patch)
 	}
-	ad, err := s.app.UpdateAd(ctx, request.GetAdId(), request.GetUserId(), request.GetTitle(), request.GetText())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return AdSuccessResponse(ad), nil
 }
 
 func (s *AdService) GetAd(ctx context.Context, request *GetAdRequest) (*AdResponse, error) {
 	if request.AdId == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
 	ad, err := s.app.GetAd(ctx, request.GetAdId())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return AdSuccessResponse(ad), nil
 }
@@ -61,7 +74,7 @@ func (s *AdService) GetAd(ctx context.Context, request *GetAdRequest) (*AdRespon
 func (s *AdService) ListAds(ctx context.Context, request *ListAdRequest) (*ListAdResponse, error) {
 	date, err := app.ParseDate(request.Date)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, StatusError(err)
 	}
 	al, err := s.app.ListAds(ctx, app.ListAdsParams{
 		Published: request.Published,
@@ -71,72 +84,530 @@ func (s *AdService) ListAds(ctx context.Context, request *ListAdRequest) (*ListA
 	})
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return AdListSuccessResponse(al), nil
 }
 
+// WatchAds streams changes of ads until the client leaves or the server stops,
+// a client, which falls behind, gets ResourceExhausted and should list the ads again
+func (s *AdService) WatchAds(request *ListAdRequest, stream AdService_WatchAdsServer) error {
+	date, err := app.ParseDate(request.Date)
+	if err != nil {
+		return StatusError(err)
+	}
+	ch, errFunc, err := s.app.WatchAds(stream.Context(), app.ListAdsParams{
+		Published: request.Published,
+		Uid:       request.UserId,
+		Date:      date,
+		Title:     request.Title,
+	})
+	if err != nil {
+		return StatusError(err)
+	}
+
+	for e := range ch {
+		if err := stream.Send(AdEventSuccessResponse(e)); err != nil {
+			return err
+		}
+	}
+	if err := errFunc(); err != nil {
+		return StatusError(err)
+	}
+	return nil
+}
+
+// BatchAds fails as a whole only if the batch is invalid, the errors of the operations are in their results
+func (s *AdService) BatchAds(ctx context.Context, request *BatchAdsRequest) (*BatchAdsResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	ops := make([]app.BatchOp, 0, len(request.GetOperations()))
+	for _, op := range request.GetOperations() {
+		ops = append(ops, app.BatchOp{
+			Action: app.BatchAction(op.GetOp()),
+			AdID:   op.GetAdId(),
+			Title:  op.GetTitle(),
+			Text:   op.GetText(),
+		})
+	}
+	results, err := s.app.BatchAds(ctx, request.GetUserId(), ops, request.GetAtomic())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return BatchAdsSuccessResponse(ops, results), nil
+}
+
+// chunkReader reads the file sent in the chunks of the import stream
+type chunkReader struct {
+	stream AdService_ImportAdsServer
+	chunk  []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		request, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = request.GetChunk()
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// ImportAds reads the file from the stream and answers with the report, when the client closes the stream
+func (s *AdService) ImportAds(stream AdService_ImportAdsServer) error {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if request.UserId == nil {
+		return StatusError(ErrMissingArgument)
+	}
+	format, err := app.ParseBulkFormat(request.GetFormat())
+	if err != nil {
+		return StatusError(err)
+	}
+
+	r := &chunkReader{stream: stream, chunk: request.GetChunk()}
+	report, err := s.app.ImportAds(stream.Context(), request.GetUserId(), r, format, request.GetDryRun())
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return StatusError(err)
+	}
+	return stream.SendAndClose(ImportSuccessResponse(report))
+}
+
+// exportChunkSize is the size of the chunks of the export stream
+const exportChunkSize = 32 << 10
+
+// chunkWriter sends every write as a chunk of the export stream
+type chunkWriter struct {
+	stream AdService_ExportAdsServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&ExportAdsChunk{Chunk: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *AdService) ExportAds(request *ExportAdsRequest, stream AdService_ExportAdsServer) error {
+	format, err := app.ParseBulkFormat(request.GetFormat())
+	if err != nil {
+		return StatusError(err)
+	}
+
+	w := bufio.NewWriterSize(chunkWriter{stream: stream}, exportChunkSize)
+	if err := s.app.ExportAds(stream.Context(), request.UserId, format, w); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return StatusError(err)
+	}
+	return w.Flush()
+}
+
 func (s *AdService) CreateUser(ctx context.Context, request *CreateUserRequest) (*UserResponse, error) {
 	_, err := mail.ParseAddress(request.GetEmail())
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, StatusError(app.ErrValidation.Wrap(err))
 	}
 
 	u, err := s.app.CreateUser(ctx, request.GetName(), request.GetEmail())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return UserSuccessResponse(u), nil
 }
 
 func (s *AdService) UpdateUser(ctx context.Context, request *UpdateUserRequest) (*UserResponse, error) {
 	if request.Id == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
-	_, err := mail.ParseAddress(request.GetEmail())
+	patch, err := UserPatch(request)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, StatusError(err)
+	}
+	if patch.Email != nil {
+		if _, err := mail.ParseAddress(*patch.Email); err != nil {
+			return nil, StatusError(app.ErrValidation.Wrap(err))
+		}
 	}
 
-	u, err := s.app.UpdateUser(ctx, request.GetId(), request.GetName(), request.GetEmail())
+	var u *user.User
+	if len(request.GetUpdateMask().GetPaths()) == 0 {
+		u, err = s.app.UpdateUser(ctx, request.GetId(), request.GetName(), request.GetEmail())
+	} else {
+		u, err = s.app.PatchUser(ctx, request.GetId(), patch)
+	}
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return UserSuccessResponse(u), nil
 }
 
 func (s *AdService) GetUser(ctx context.Context, request *GetUserRequest) (*UserResponse, error) {
 	if request.Id == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
 	u, err := s.app.GetUser(ctx, request.GetId())
 
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return UserSuccessResponse(u), nil
 }
 
 func (s *AdService) DeleteUser(ctx context.Context, request *DeleteUserRequest) (*emptypb.Empty, error) {
 	if request.Id == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
-	err := s.app.DeleteUser(ctx, request.GetId())
+	if _, ok := DeleteMode_name[int32(request.GetMode())]; !ok {
+		return nil, StatusError(app.ErrInvalidParameter.Withf("unknown delete mode"))
+	}
+	params := app.DeleteUserParams{Mode: app.DeleteMode(request.GetMode()), TransferTo: request.TransferTo}
+	err := s.app.DeleteUser(ctx, request.GetId(), params)
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
 
 func (s *AdService) DeleteAd(ctx context.Context, request *DeleteAdRequest) (*emptypb.Empty, error) {
 	if request.AdId == nil || request.AuthorId == nil {
-		return nil, status.Error(codes.InvalidArgument, ErrMissingArgument.Error())
+		return nil, StatusError(ErrMissingArgument)
 	}
 	err := s.app.DeleteAd(ctx, request.GetAdId(), request.GetAuthorId())
 	if err != nil {
-		return nil, status.Error(GetErrorCode(err), err.Error())
+		return nil, StatusError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
+
+func (s *AdService) Register(ctx context.Context, request *RegisterRequest) (*UserResponse, error) {
+	_, err := mail.ParseAddress(request.GetEmail())
+	if err != nil {
+		return nil, StatusError(app.ErrValidation.Wrap(err))
+	}
+
+	u, err := s.app.Register(ctx, request.GetName(), request.GetEmail(), request.GetPassword())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return UserSuccessResponse(u), nil
+}
+
+func (s *AdService) VerifyEmail(ctx context.Context, request *VerifyEmailRequest) (*UserResponse, error) {
+	u, err := s.app.VerifyEmail(ctx, request.GetToken())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return UserSuccessResponse(u), nil
+}
+
+func (s *AdService) Login(ctx context.Context, request *LoginRequest) (*UserResponse, error) {
+	u, err := s.app.Login(ctx, request.GetEmail(), request.GetPassword())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return UserSuccessResponse(u), nil
+}
+
+func (s *AdService) RequestPasswordReset(ctx context.Context, request *RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	_, err := mail.ParseAddress(request.GetEmail())
+	if err != nil {
+		return nil, StatusError(app.ErrValidation.Wrap(err))
+	}
+
+	err = s.app.RequestPasswordReset(ctx, request.GetEmail())
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AdService) ResetPassword(ctx context.Context, request *ResetPasswordRequest) (*emptypb.Empty, error) {
+	err := s.app.ResetPassword(ctx, request.GetToken(), request.GetPassword())
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AdService) ChangePassword(ctx context.Context, request *ChangePasswordRequest) (*emptypb.Empty, error) {
+	if request.Id == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	err := s.app.ChangePassword(ctx, request.GetId(), request.GetOldPassword(), request.GetNewPassword())
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AdService) RestoreAd(ctx context.Context, request *RestoreAdRequest) (*AdResponse, error) {
+	if request.AdId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	ad, err := s.app.RestoreAd(ctx, request.GetAdId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return AdSuccessResponse(ad), nil
+}
+
+func (s *AdService) RestoreUser(ctx context.Context, request *RestoreUserRequest) (*UserResponse, error) {
+	if request.Id == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	u, err := s.app.RestoreUser(ctx, request.GetId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return UserSuccessResponse(u), nil
+}
+
+func (s *AdService) RequestExport(ctx context.Context, request *RequestExportRequest) (*JobResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	if _, ok := ExportFormat_name[int32(request.GetFormat())]; !ok {
+		return nil, StatusError(app.ErrInvalidParameter.Withf("unknown export format"))
+	}
+	j, err := s.app.RequestExport(ctx, request.GetUserId(), app.ExportFormat(request.GetFormat()))
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return JobSuccessResponse(j), nil
+}
+
+func (s *AdService) RequestErasure(ctx context.Context, request *RequestErasureRequest) (*JobResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	j, err := s.app.RequestErasure(ctx, request.GetUserId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return JobSuccessResponse(j), nil
+}
+
+func (s *AdService) GetJob(ctx context.Context, request *GetJobRequest) (*JobResponse, error) {
+	if request.UserId == nil || request.JobId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	j, err := s.app.GetJob(ctx, request.GetUserId(), request.GetJobId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return JobSuccessResponse(j), nil
+}
+
+func (s *AdService) CreateWebhook(ctx context.Context, request *CreateWebhookRequest) (*WebhookResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	w, err := s.app.CreateWebhook(ctx, request.GetUserId(), request.GetUrl(), request.GetEvents())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return CreatedWebhookSuccessResponse(w), nil
+}
+
+func (s *AdService) ListWebhooks(ctx context.Context, request *ListWebhooksRequest) (*ListWebhooksResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	list, err := s.app.ListWebhooks(ctx, request.GetUserId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return WebhookListSuccessResponse(list), nil
+}
+
+func (s *AdService) GetWebhook(ctx context.Context, request *WebhookRequest) (*WebhookResponse, error) {
+	if request.UserId == nil || request.WebhookId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	w, err := s.app.GetWebhook(ctx, request.GetUserId(), request.GetWebhookId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return WebhookSuccessResponse(w), nil
+}
+
+func (s *AdService) DeleteWebhook(ctx context.Context, request *WebhookRequest) (*emptypb.Empty, error) {
+	if request.UserId == nil || request.WebhookId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	err := s.app.DeleteWebhook(ctx, request.GetUserId(), request.GetWebhookId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AdService) EnableWebhook(ctx context.Context, request *WebhookRequest) (*WebhookResponse, error) {
+	if request.UserId == nil || request.WebhookId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	w, err := s.app.EnableWebhook(ctx, request.GetUserId(), request.GetWebhookId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return WebhookSuccessResponse(w), nil
+}
+
+func (s *AdService) ListWebhookDeliveries(ctx context.Context, request *WebhookRequest) (*ListWebhookDeliveriesResponse, error) {
+	if request.UserId == nil || request.WebhookId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	list, err := s.app.ListWebhookDeliveries(ctx, request.GetUserId(), request.GetWebhookId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return WebhookDeliveriesSuccessResponse(list), nil
+}
+
+func (s *AdService) CreateSavedSearch(ctx context.Context, request *CreateSavedSearchRequest) (*SavedSearchResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	if _, ok := SearchFrequency_name[int32(request.GetFrequency())]; !ok {
+		return nil, StatusError(app.ErrInvalidParameter.Withf("unknown notification frequency"))
+	}
+	date, err := app.ParseDate(request.Date)
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	search, err := s.app.CreateSavedSearch(ctx, request.GetUserId(), app.SavedSearchParams{
+		Name:      request.GetName(),
+		Query:     request.GetQuery(),
+		Uid:       request.AuthorId,
+		Date:      date,
+		Title:     request.Title,
+		Frequency: searches.Frequency(request.GetFrequency()),
+	})
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return SavedSearchSuccessResponse(search), nil
+}
+
+func (s *AdService) ListSavedSearches(ctx context.Context, request *ListSavedSearchesRequest) (*ListSavedSearchesResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	list, err := s.app.ListSavedSearches(ctx, request.GetUserId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return SavedSearchListSuccessResponse(list), nil
+}
+
+func (s *AdService) GetSavedSearch(ctx context.Context, request *SavedSearchRequest) (*SavedSearchResponse, error) {
+	if request.UserId == nil || request.SearchId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	search, err := s.app.GetSavedSearch(ctx, request.GetUserId(), request.GetSearchId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return SavedSearchSuccessResponse(search), nil
+}
+
+func (s *AdService) DeleteSavedSearch(ctx context.Context, request *SavedSearchRequest) (*emptypb.Empty, error) {
+	if request.UserId == nil || request.SearchId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	err := s.app.DeleteSavedSearch(ctx, request.GetUserId(), request.GetSearchId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AdService) ListNotifications(ctx context.Context, request *ListNotificationsRequest) (*ListNotificationsResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	page, err := s.app.ListNotifications(ctx, request.GetUserId(), app.ListNotificationsParams{
+		Before:     request.Before,
+		Limit:      int(request.GetLimit()),
+		UnreadOnly: request.GetUnreadOnly(),
+	})
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return NotificationPageSuccessResponse(page), nil
+}
+
+func (s *AdService) MarkNotificationRead(ctx context.Context, request *NotificationRequest) (*NotificationResponse, error) {
+	if request.UserId == nil || request.NotificationId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	n, err := s.app.MarkNotificationRead(ctx, request.GetUserId(), request.GetNotificationId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return NotificationSuccessResponse(n), nil
+}
+
+func (s *AdService) MarkAllNotificationsRead(ctx context.Context, request *UserNotificationsRequest) (*MarkAllNotificationsReadResponse, error) {
+	if request.UserId == nil {
+		return nil, StatusError(ErrMissingArgument)
+	}
+	count, err := s.app.MarkAllNotificationsRead(ctx, request.GetUserId())
+
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return &MarkAllNotificationsReadResponse{Marked: int64(count)}, nil
+}
+
+// SubscribeNotifications streams new notifications of the user until the client leaves
+func (s *AdService) SubscribeNotifications(request *UserNotificationsRequest, stream AdService_SubscribeNotificationsServer) error {
+	if request.UserId == nil {
+		return StatusError(ErrMissingArgument)
+	}
+	ch, err := s.app.SubscribeNotifications(stream.Context(), request.GetUserId())
+	if err != nil {
+		return StatusError(err)
+	}
+
+	for n := range ch {
+		if err := stream.Send(NotificationSuccessResponse(&n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
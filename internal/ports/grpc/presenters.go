@@ -1,15 +1,66 @@
 package grpc
 
 import (
-	"errors"
+	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
 	"github.com/TobbyMax/ad-service.git/internal/user"
-	"github.com/TobbyMax/validator"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"time"
 )
 
-var ErrMissingArgument = errors.New("required argument is missing")
+var (
+	ErrMissingArgument = app.ErrMissingArgument
+	ErrInvalidMask     = app.ErrInvalidRequest.Withf("update mask contains a field, which can not be changed")
+)
+
+// AdPatch returns the patch of the fields in the update mask, all of the fields are changed without the mask
+func AdPatch(request *UpdateAdRequest) (app.AdPatch, error) {
+	paths := request.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return app.AdPatch{Title: &request.Title, Text: &request.Text}, nil
+	}
+	var patch app.AdPatch
+	for _, path := range paths {
+		switch path {
+		case "title":
+			patch.Title = &request.Title
+		case "text":
+			patch.Text = &request.Text
+		default:
+			return app.AdPatch{}, fmt.Errorf("%w: %s", ErrInvalidMask, path)
+		}
+	}
+	return patch, nil
+}
+
+// UserPatch returns the patch of the fields in the update mask, all of the fields are changed without the mask
+func UserPatch(request *UpdateUserRequest) (app.UserPatch, error) {
+	paths := request.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return app.UserPatch{Nickname: &request.Name, Email: &request.Email}, nil
+	}
+	var patch app.UserPatch
+	for _, path := range paths {
+		switch path {
+		case "name":
+			patch.Nickname = &request.Name
+		case "email":
+			patch.Email = &request.Email
+		default:
+			return app.UserPatch{}, fmt.Errorf("%w: %s", ErrInvalidMask, path)
+		}
+	}
+	return patch, nil
+}
 
 func AdSuccessResponse(ad *ads.Ad) *AdResponse {
 	return &AdResponse{
@@ -23,6 +74,67 @@ func AdSuccessResponse(ad *ads.Ad) *AdResponse {
 	}
 }
 
+func BatchAdsSuccessResponse(ops []app.BatchOp, results []app.BatchResult) *BatchAdsResponse {
+	response := BatchAdsResponse{Results: make([]*BatchAdResult, 0, len(results))}
+	for i, res := range results {
+		item := BatchAdResult{Op: string(ops[i].Action), AdId: res.AdID, Code: uint32(codes.OK)}
+		if res.Ad != nil {
+			item.Ad = AdSuccessResponse(res.Ad)
+		}
+		if res.Err != nil {
+			item.Code = uint32(GetErrorCode(res.Err))
+			item.Error = res.Err.Error()
+			response.Failed++
+		}
+		response.Results = append(response.Results, &item)
+	}
+	return &response
+}
+
+func ImportSuccessResponse(report *app.ImportReport) *ImportAdsResponse {
+	response := ImportAdsResponse{
+		DryRun:   report.DryRun,
+		Imported: int64(report.Imported),
+		Failed:   int64(report.Failed),
+		Rows:     make([]*ImportRowResult, 0, len(report.Rows)),
+	}
+	for _, row := range report.Rows {
+		item := ImportRowResult{Line: int64(row.Line), AdId: row.AdID, Code: uint32(codes.OK)}
+		if row.Err != nil {
+			item.Code = uint32(GetErrorCode(row.Err))
+			item.Error = row.Err.Error()
+		}
+		response.Rows = append(response.Rows, &item)
+	}
+	return &response
+}
+
+func AdEventSuccessResponse(e events.Event) *AdEventResponse {
+	response := AdEventResponse{
+		Event:      e.EventName(),
+		OccurredAt: e.OccurredAt().Format(time.RFC3339Nano),
+	}
+	_, response.AdId = e.Aggregate()
+	switch t := e.(type) {
+	case events.AdCreated:
+		response.Ad = AdSuccessResponse(&t.Ad)
+	case events.AdPublished:
+		response.Ad = AdSuccessResponse(&t.Ad)
+	case events.AdUnpublished:
+		response.Ad = AdSuccessResponse(&t.Ad)
+	case events.AdUpdated:
+		response.Ad = AdSuccessResponse(&t.Ad)
+	case events.AdRestored:
+		response.Ad = AdSuccessResponse(&t.Ad)
+	case events.AdDeleted:
+		response.AuthorId = t.AuthorID
+	}
+	if response.Ad != nil {
+		response.AuthorId = response.Ad.AuthorId
+	}
+	return &response
+}
+
 func AdListSuccessResponse(al *ads.AdList) *ListAdResponse {
 	response := ListAdResponse{List: make([]*AdResponse, 0)}
 
@@ -34,22 +146,166 @@ func AdListSuccessResponse(al *ads.AdList) *ListAdResponse {
 
 func UserSuccessResponse(u *user.User) *UserResponse {
 	return &UserResponse{
-		Id:    u.ID,
-		Name:  u.Nickname,
-		Email: u.Email,
+		Id:       u.ID,
+		Name:     u.Nickname,
+		Email:    u.Email,
+		Verified: u.Verified,
+	}
+}
+
+func JobSuccessResponse(j *jobs.Job) *JobResponse {
+	response := JobResponse{
+		Id:          j.ID,
+		Kind:        JobKind(j.Kind),
+		UserId:      j.UserID,
+		Status:      JobStatus(j.Status),
+		Error:       j.Error,
+		Created:     j.Created.Format(time.RFC3339),
+		Result:      j.Result,
+		ContentType: j.ContentType,
+	}
+	if j.Finished != nil {
+		response.Finished = j.Finished.Format(time.RFC3339)
+	}
+	return &response
+}
+
+func WebhookSuccessResponse(s *webhooks.Subscription) *WebhookResponse {
+	response := WebhookResponse{
+		Id:       s.ID,
+		UserId:   s.OwnerID,
+		Url:      s.URL,
+		Events:   s.Events,
+		Active:   s.Active,
+		Failures: int64(s.Failures),
+		Created:  s.Created.Format(time.RFC3339),
+	}
+	if len(response.Events) == 0 {
+		response.Events = webhooks.Events
+	}
+	if s.DisabledAt != nil {
+		response.DisabledAt = s.DisabledAt.Format(time.RFC3339)
+	}
+	return &response
+}
+
+// CreatedWebhookSuccessResponse is the only response with the secret, it is never shown after the creation
+func CreatedWebhookSuccessResponse(s *webhooks.Subscription) *WebhookResponse {
+	response := WebhookSuccessResponse(s)
+	response.Secret = s.Secret
+	return response
+}
+
+func WebhookListSuccessResponse(list []webhooks.Subscription) *ListWebhooksResponse {
+	response := ListWebhooksResponse{List: make([]*WebhookResponse, 0, len(list))}
+
+	for _, s := range list {
+		response.List = append(response.List, WebhookSuccessResponse(&s))
+	}
+	return &response
+}
+
+func WebhookDeliveriesSuccessResponse(list []webhooks.Delivery) *ListWebhookDeliveriesResponse {
+	response := ListWebhookDeliveriesResponse{List: make([]*WebhookDeliveryResponse, 0, len(list))}
+
+	for _, d := range list {
+		response.List = append(response.List, &WebhookDeliveryResponse{
+			Id:         d.ID,
+			Event:      d.Event,
+			Attempts:   int64(d.Attempts),
+			StatusCode: int64(d.StatusCode),
+			Error:      d.Error,
+			Success:    d.Success,
+			Time:       d.Time.Format(time.RFC3339Nano),
+			DurationMs: d.Duration.Milliseconds(),
+		})
+	}
+	return &response
+}
+
+func SavedSearchSuccessResponse(s *searches.SavedSearch) *SavedSearchResponse {
+	response := SavedSearchResponse{
+		Id:        s.ID,
+		UserId:    s.UserID,
+		Name:      s.Name,
+		Query:     s.Query,
+		AuthorId:  s.AuthorID,
+		Title:     s.Title,
+		Frequency: SearchFrequency(s.Frequency),
+		Created:   s.Created.Format(time.RFC3339),
+		Pending:   int64(len(s.Pending)),
+	}
+	if s.Date != nil {
+		date := s.Date.Format(app.DateLayout)
+		response.Date = &date
+	}
+	if !s.LastNotified.IsZero() {
+		response.LastNotified = s.LastNotified.Format(time.RFC3339)
+	}
+	return &response
+}
+
+func SavedSearchListSuccessResponse(list []searches.SavedSearch) *ListSavedSearchesResponse {
+	response := ListSavedSearchesResponse{List: make([]*SavedSearchResponse, 0, len(list))}
+
+	for _, s := range list {
+		response.List = append(response.List, SavedSearchSuccessResponse(&s))
+	}
+	return &response
+}
+
+func NotificationSuccessResponse(n *notifications.Notification) *NotificationResponse {
+	return &NotificationResponse{
+		Id:       n.ID,
+		UserId:   n.UserID,
+		Kind:     n.Kind,
+		Subject:  n.Subject,
+		Text:     n.Text,
+		AdIds:    n.AdIDs,
+		SearchId: n.SearchID,
+		Created:  n.Created.Format(time.RFC3339),
+		Read:     n.Read,
+	}
+}
+
+func NotificationPageSuccessResponse(page *notifications.Page) *ListNotificationsResponse {
+	response := ListNotificationsResponse{
+		List:   make([]*NotificationResponse, 0, len(page.Notifications)),
+		Unread: int64(page.Unread),
+		Next:   page.Next,
+	}
+
+	for _, n := range page.Notifications {
+		response.List = append(response.List, NotificationSuccessResponse(&n))
 	}
+	return &response
 }
 
+// GetErrorCode returns the code of the error from the translation table of the application
 func GetErrorCode(err error) codes.Code {
-	switch {
-	case errors.As(err, &validator.ValidationErrors{}):
-		return codes.InvalidArgument
-	case errors.Is(err, app.ErrForbidden):
-		return codes.PermissionDenied
-	case errors.Is(err, app.ErrAdNotFound):
-		fallthrough
-	case errors.Is(err, app.ErrUserNotFound):
-		return codes.NotFound
-	}
-	return codes.Internal
+	return app.Translate(err).GRPCCode
+}
+
+// ErrorDomain is the domain of the ErrorInfo in the details of the statuses
+const ErrorDomain = "ad-service"
+
+// StatusError converts the error into the status with the same message and the code from the translation
+// table of the application. The details of the status carry the stable code of the error in ErrorInfo
+// and the violated constraints of the fields in BadRequest
+func StatusError(err error) error {
+	t := app.Translate(err)
+	details := []protoiface.MessageV1{&errdetails.ErrorInfo{Reason: t.Code, Domain: ErrorDomain}}
+	if violations := app.FieldViolations(err); len(violations) != 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Description})
+		}
+		details = append(details, badRequest)
+	}
+	st := status.New(t.GRPCCode, err.Error())
+	if detailed, err := st.WithDetails(details...); err == nil {
+		st = detailed
+	}
+	return st.Err()
 }
@@ -3,17 +3,31 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/adminauth"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
 	grpcRecovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"log"
 	"net"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const RequestIDMetadata = "x-request-id"
+
 type AdService struct {
 	app app.App
 }
@@ -40,6 +54,237 @@ func UnaryLoggerInterceptor(ctx context.Context,
 	return h, err
 }
 
+// UnaryRequestInfoInterceptor puts the request ID into the context of the call,
+// the ID is taken from the x-request-id metadata or generated
+func UnaryRequestInfoInterceptor(ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDMetadata); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = audit.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, id))
+
+	ctx = audit.WithRequestInfo(ctx, audit.RequestInfo{ID: id, Protocol: audit.ProtocolGRPC})
+	return handler(ctx, req)
+}
+
+// UnaryRateLimitInterceptor limits the calls of every client address and every user on every method.
+// The user is taken from the x-user-id metadata or the user_id field of the request
+func UnaryRateLimitInterceptor(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		uid := metadataUser(ctx)
+		if uid == "" {
+			uid = requestUser(req)
+		}
+		if err := allow(ctx, l, info.FullMethod, uid, grpc.SetHeader); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AdminMethods are the methods, which require the admin token
+var AdminMethods = map[string]bool{
+	"/ad.AdService/RestoreAd":   true,
+	"/ad.AdService/RestoreUser": true,
+}
+
+// UnaryAdminInterceptor lets through the calls of AdminMethods only with the admin token in the authorization
+// metadata, without the token every such call is rejected
+func UnaryAdminInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		if AdminMethods[info.FullMethod] && !adminauth.Authorized(token, metadataValue(ctx, strings.ToLower(adminauth.Header))) {
+			return nil, StatusError(adminauth.ErrUnauthorized)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimitInterceptor limits the streaming calls, the user is taken only from the metadata
+func StreamRateLimitInterceptor(l *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		setHeader := func(_ context.Context, md metadata.MD) error {
+			return ss.SetHeader(md)
+		}
+		if err := allow(ss.Context(), l, info.FullMethod, metadataUser(ss.Context()), setHeader); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// allow checks the call and sends the limits in the header metadata
+func allow(ctx context.Context, l *ratelimit.Limiter, method string, uid string,
+	setHeader func(context.Context, metadata.MD) error) error {
+
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	d := l.Allow(method, ip, uid)
+	if headers := d.Headers(); len(headers) > 0 {
+		md := metadata.MD{}
+		for k, v := range headers {
+			md.Set(strings.ToLower(k), v)
+		}
+		_ = setHeader(ctx, md)
+	}
+	if !d.Allowed {
+		return StatusError(ratelimit.ErrLimited)
+	}
+	return nil
+}
+
+func metadataUser(ctx context.Context) string {
+	return metadataValue(ctx, strings.ToLower(ratelimit.UserHeader))
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// requestUser returns the user_id field of the request, if the request has one and it is set
+func requestUser(req interface{}) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	r := m.ProtoReflect()
+	f := r.Descriptor().Fields().ByName("user_id")
+	if f == nil || f.Kind() != protoreflect.Int64Kind || f.HasPresence() && !r.Has(f) {
+		return ""
+	}
+	return strconv.FormatInt(r.Get(f).Int(), 10)
+}
+
+// IdempotencyMetadata is the metadata key of the idempotency key, the equivalent of the Idempotency-Key header
+const IdempotencyMetadata = "idempotency-key"
+
+// IdempotentMethods are the methods creating resources, which can be retried with the idempotency key
+var IdempotentMethods = map[string]bool{
+	"/ad.AdService/CreateAd":          true,
+	"/ad.AdService/BatchAds":          true,
+	"/ad.AdService/CreateUser":        true,
+	"/ad.AdService/Register":          true,
+	"/ad.AdService/CreateWebhook":     true,
+	"/ad.AdService/CreateSavedSearch": true,
+}
+
+// UnaryIdempotencyInterceptor stores the result of the call of IdempotentMethods with the idempotency-key metadata
+// and replays it for the retries with the same key and the same request. Transient errors are not stored
+func UnaryIdempotencyInterceptor(s idempotency.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		key := metadataValue(ctx, IdempotencyMetadata)
+		msg, ok := req.(proto.Message)
+		if key == "" || !ok || !IdempotentMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if err := idempotency.ValidKey(key); err != nil {
+			return nil, StatusError(err)
+		}
+		body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, StatusError(app.ErrInvalidRequest.Wrap(err))
+		}
+
+		key = info.FullMethod + " " + key
+		fingerprint := idempotency.Fingerprint([]byte(info.FullMethod), body)
+		stored, err := idempotency.Begin(ctx, s, key, fingerprint)
+		switch {
+		case err != nil:
+			return nil, StatusError(err)
+		case stored != nil:
+			_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(idempotency.ReplayedHeader), "true"))
+			return replay(stored)
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				_ = s.Release(ctx, key)
+			}
+		}()
+
+		res, err := handler(ctx, req)
+		r := idempotency.Record{Key: key, Fingerprint: fingerprint}
+		if err != nil {
+			st := status.Convert(err)
+			if transientCodes[st.Code()] {
+				return res, err
+			}
+			r.Status = int(st.Code())
+			r.Body, _ = proto.Marshal(st.Proto())
+		} else if m, ok := res.(proto.Message); ok {
+			r.ContentType = string(proto.MessageName(m))
+			if r.Body, err = proto.Marshal(m); err != nil {
+				return res, nil
+			}
+		}
+		completed = s.Complete(ctx, r) == nil
+		return res, err
+	}
+}
+
+// transientCodes are the errors, which may not happen on the retry
+var transientCodes = map[codes.Code]bool{
+	codes.Canceled:          true,
+	codes.Unknown:           true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.Internal:          true,
+	codes.Unavailable:       true,
+}
+
+// replay restores the stored result of the call
+func replay(r *idempotency.Record) (interface{}, error) {
+	if codes.Code(r.Status) != codes.OK {
+		st := &spb.Status{}
+		if err := proto.Unmarshal(r.Body, st); err != nil {
+			return nil, StatusError(err)
+		}
+		return nil, status.ErrorProto(st)
+	}
+	t, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(r.ContentType))
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	m := t.New().Interface()
+	if err := proto.Unmarshal(r.Body, m); err != nil {
+		return nil, StatusError(err)
+	}
+	return m, nil
+}
+
 func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
 	stackTraceLogger := grpcRecovery.WithRecoveryHandlerContext(
 		func(ctx context.Context, p interface{}) error {
@@ -51,6 +296,27 @@ func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
 	return grpcRecovery.UnaryServerInterceptor(stackTraceLogger)
 }
 
+// shutdownTimeout limits the wait for running calls, streams, which are still open after it, are cancelled
+const shutdownTimeout = 30 * time.Second
+
+// stopGracefully waits for the running calls to finish, streaming calls must end themselves
+// (feeds and subscriptions are closed on shutdown), otherwise they are cancelled after the timeout
+func stopGracefully(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("grpc server: calls are still running after %s, cancelling them\n", timeout)
+		server.Stop()
+		<-stopped
+	}
+}
+
 func RunGRPCServerGracefully(ctx context.Context, lis net.Listener, server *grpc.Server) func() error {
 	return func() error {
 		log.Printf("starting grpc server, listening on %s\n", lis.Addr())
@@ -59,7 +325,7 @@ func RunGRPCServerGracefully(ctx context.Context, lis net.Listener, server *grpc
 		errCh := make(chan error)
 
 		defer func() {
-			server.GracefulStop()
+			stopGracefully(server, shutdownTimeout)
 			_ = lis.Close()
 
 			close(errCh)
@@ -1,16 +1,74 @@
 package httpgin
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/app"
-	"github.com/TobbyMax/validator"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"io"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
-var ErrParameterNotFound = errors.New("necessary parameters not provided")
+var (
+	ErrParameterNotFound = app.ErrMissingArgument.Withf("necessary parameters not provided")
+	ErrJobNoResult       = app.ErrJobNoResult
+	ErrInvalidPatch      = app.ErrInvalidRequest.Withf("merge patch must be a JSON object of the fields, which can be changed")
+)
+
+// bindMergePatch reads the JSON Merge Patch (RFC 7386) into the request with pointer fields, the fields missing
+// in the patch stay nil. The fields can not be removed, so null members are rejected as well as unknown ones
+func bindMergePatch(c *gin.Context, req any) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil || members == nil {
+		return ErrInvalidPatch
+	}
+	for name, value := range members {
+		if string(value) == "null" {
+			return fmt.Errorf("%w: %s can not be removed", ErrInvalidPatch, name)
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+	}
+	return binding.Validator.ValidateStruct(req)
+}
+
+// problem writes the error as the problem details with the status from the translation table of the application.
+// The content type is set before the body, because gin keeps the content type, which is already set
+func problem(c *gin.Context, err error) {
+	p := ProblemResponse(err, c.Request.URL.Path)
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(p.Status, p)
+}
+
+func abortWithProblem(c *gin.Context, err error) {
+	problem(c, err)
+	c.Abort()
+}
+
+// sseHeartbeat is the interval of comments sent to idle event streams
+const sseHeartbeat = 15 * time.Second
+
+// maxImportSize is the largest file accepted by the import of ads
+const maxImportSize = 32 << 20
 
 type Handler = func(*gin.Context) error
 
@@ -18,23 +76,16 @@ type Handler = func(*gin.Context) error
 func createAd(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody createAdRequest
-		err := c.Bind(&reqBody)
+		err := c.ShouldBind(&reqBody)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		ad, err := a.CreateAd(c, reqBody.Title, reqBody.Text, reqBody.UserID)
 
 		if err != nil {
-			switch {
-			case errors.As(err, &validator.ValidationErrors{}):
-				c.JSON(http.StatusBadRequest, AdErrorResponse(err))
-			case errors.Is(err, app.ErrUserNotFound):
-				c.JSON(http.StatusFailedDependency, AdErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, AdSuccessResponse(ad))
@@ -45,29 +96,22 @@ func createAd(a app.App) gin.HandlerFunc {
 func changeAdStatus(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody changeAdStatusRequest
-		if err := c.Bind(&reqBody); err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
 			return
 		}
 
 		adIDStr := c.Param("ad_id")
 		adID, err := strconv.Atoi(adIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		ad, err := a.ChangeAdStatus(c, int64(adID), reqBody.UserID, reqBody.Published)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, app.ErrForbidden):
-				c.JSON(http.StatusForbidden, AdErrorResponse(err))
-			case errors.Is(err, app.ErrAdNotFound):
-				c.JSON(http.StatusNotFound, AdErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, AdSuccessResponse(ad))
@@ -78,31 +122,47 @@ func changeAdStatus(a app.App) gin.HandlerFunc {
 func updateAd(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody updateAdRequest
-		if err := c.Bind(&reqBody); err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
 			return
 		}
 
 		adIDStr := c.Param("ad_id")
 		adID, err := strconv.Atoi(adIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		ad, err := a.UpdateAd(c, int64(adID), reqBody.UserID, reqBody.Title, reqBody.Text)
 
 		if err != nil {
-			switch {
-			case errors.As(err, &validator.ValidationErrors{}):
-				c.JSON(http.StatusBadRequest, AdErrorResponse(err))
-			case errors.Is(err, app.ErrForbidden):
-				c.JSON(http.StatusForbidden, AdErrorResponse(err))
-			case errors.Is(err, app.ErrAdNotFound):
-				c.JSON(http.StatusNotFound, AdErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
-			}
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, AdSuccessResponse(ad))
+	}
+}
+
+// Метод для частичного обновления объявления (JSON Merge Patch), меняются только переданные поля
+func patchAd(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody patchAdRequest
+		if err := bindMergePatch(c, &reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		adID, err := strconv.Atoi(c.Param("ad_id"))
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		ad, err := a.PatchAd(c, int64(adID), reqBody.UserID, app.AdPatch{Title: reqBody.Title, Text: reqBody.Text})
+
+		if err != nil {
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, AdSuccessResponse(ad))
@@ -115,19 +175,14 @@ func getAd(a app.App) gin.HandlerFunc {
 		adIDStr := c.Param("ad_id")
 		adID, err := strconv.Atoi(adIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		ad, err := a.GetAd(c, int64(adID))
 
 		if err != nil {
-			switch {
-			case errors.Is(err, app.ErrAdNotFound):
-				c.JSON(http.StatusNotFound, AdErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, AdSuccessResponse(ad))
@@ -140,48 +195,190 @@ func deleteAd(a app.App) gin.HandlerFunc {
 		adIDStr := c.Param("ad_id")
 		adID, err := strconv.Atoi(adIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 		userIDStr, ok := c.GetQuery("user_id")
 		if !ok {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(ErrParameterNotFound))
+			problem(c, ErrParameterNotFound)
 			return
 		}
 		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		err = a.DeleteAd(c, int64(adID), int64(userID))
 
 		if err != nil {
-			switch {
-			case errors.Is(err, app.ErrAdNotFound):
-				c.JSON(http.StatusNotFound, AdErrorResponse(err))
-			case errors.Is(err, app.ErrForbidden):
-				c.JSON(http.StatusForbidden, AdErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, DeletionSuccessResponse())
 	}
 }
 
+// Метод для создания, публикации, снятия с публикации и удаления нескольких объявлений одним запросом
+func batchAds(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody batchAdsRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		ops := make([]app.BatchOp, 0, len(reqBody.Operations))
+		for _, op := range reqBody.Operations {
+			ops = append(ops, app.BatchOp{Action: app.BatchAction(op.Op), AdID: op.AdID, Title: op.Title, Text: op.Text})
+		}
+
+		results, err := a.BatchAds(c, reqBody.UserID, ops, reqBody.Atomic)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, BatchSuccessResponse(ops, results))
+	}
+}
+
+// importFile returns the uploaded file, it is either the file field of a multipart form or the whole body
+func importFile(c *gin.Context) (io.Reader, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrParameterNotFound
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// Метод для загрузки объявлений пользователя из CSV или JSON Lines (format=csv|jsonl, dry_run - только проверка)
+func importAds(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		format, err := app.ParseBulkFormat(c.Query("format"))
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		dryRun := false
+		if dryRunStr, ok := c.GetQuery("dry_run"); ok {
+			if dryRun, err = strconv.ParseBool(dryRunStr); err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+		file, err := importFile(c)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		report, err := a.ImportAds(c, int64(userID), file, format, dryRun)
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) && app.ErrorCode(err) == "" {
+			err = app.ErrBodyTooLarge.Wrap(err)
+		}
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, ImportSuccessResponse(report))
+	}
+}
+
+// exportWriter sends the headers of the download together with the first row,
+// so an error returned before it is still answered with JSON
+type exportWriter struct {
+	c           *gin.Context
+	contentType string
+	filename    string
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	w.start()
+	return w.c.Writer.Write(p)
+}
+
+func (w *exportWriter) start() {
+	if w.c.Writer.Written() {
+		return
+	}
+	w.c.Header("Content-Type", w.contentType)
+	w.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+	w.c.Status(http.StatusOK)
+	w.c.Writer.WriteHeaderNow()
+}
+
+// Метод для выгрузки объявлений пользователя (user_id) или всех опубликованных объявлений в CSV или JSON Lines
+func exportAds(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := app.ParseBulkFormat(c.Query("format"))
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		var uid *int64
+		if userIDStr, ok := c.GetQuery("user_id"); ok {
+			userID, err := strconv.Atoi(userIDStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			id := int64(userID)
+			uid = &id
+		}
+		w := &exportWriter{c: c, contentType: app.ContentTypeCSV, filename: "ads.csv"}
+		if format == app.BulkJSONL {
+			w.contentType, w.filename = app.ContentTypeJSONL, "ads.jsonl"
+		}
+
+		err = a.ExportAds(c, uid, format, w)
+
+		if err != nil {
+			if c.Writer.Written() {
+				// the status is sent, the client sees the download cut short
+				log.Printf("export of ads is interrupted: %s\n", err.Error())
+				return
+			}
+			problem(c, err)
+			return
+		}
+		w.start()
+	}
+}
+
 // Метод для получения списка объявлений с фильтрами
 func listAds(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody listAdsRequest
 		if err := c.ShouldBindJSON(&reqBody); err != nil && err != io.EOF {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 		date, err := app.ParseDate(reqBody.Date)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, AdErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
@@ -193,7 +390,7 @@ func listAds(a app.App) gin.HandlerFunc {
 		})
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, AdErrorResponse(err))
+			problem(c, err)
 			return
 		}
 		//if len(al.Data) == 0 {
@@ -204,25 +401,212 @@ func listAds(a app.App) gin.HandlerFunc {
 	}
 }
 
+// Метод для получения изменений объявлений (создание, публикация, снятие, обновление, удаление)
+// в виде Server-Sent Events. Фильтры передаются в query: published, user_id, date, title.
+// Клиент, который не успевает читать события, получает событие error и должен заново загрузить список
+func watchAds(a app.App, closing <-chan struct{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params app.ListAdsParams
+		if publishedStr, ok := c.GetQuery("published"); ok {
+			published, err := strconv.ParseBool(publishedStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			params.Published = &published
+		}
+		if userIDStr, ok := c.GetQuery("user_id"); ok {
+			userID, err := strconv.ParseInt(userIDStr, 10, 64)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			params.Uid = &userID
+		}
+		if title, ok := c.GetQuery("title"); ok {
+			params.Title = &title
+		}
+		var dateStr *string
+		if str, ok := c.GetQuery("date"); ok {
+			dateStr = &str
+		}
+		date, err := app.ParseDate(dateStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		params.Date = date
+
+		ch, errFunc, err := a.WatchAds(c, params)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		// the client learns that the watch is started before the first change
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					if err := errFunc(); err != nil {
+						c.SSEvent("error", ProblemResponse(err, c.Request.URL.Path))
+					}
+					return false
+				}
+				c.SSEvent(e.EventName(), AdEventResponse(e))
+				return true
+			case <-heartbeat.C:
+				// the comment keeps proxies from closing an idle connection
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err == nil
+			case <-closing:
+				return false
+			}
+		})
+	}
+}
+
+type feedFormat int
+
+const (
+	feedRSS feedFormat = iota
+	feedAtom
+)
+
+// Метод для получения ленты опубликованных объявлений (новые первыми) с фильтрами user_id и text,
+// limit - число объявлений, не больше настроенного. Поддерживает условный GET (If-None-Match, If-Modified-Since)
+func adsFeed(a app.App, maxLimit int, format feedFormat) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		published := true
+		params := app.ListAdsParams{Published: &published}
+		if userIDStr, ok := c.GetQuery("user_id"); ok {
+			userID, err := strconv.ParseInt(userIDStr, 10, 64)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			params.Uid = &userID
+		}
+		if text := c.Query("text"); text != "" {
+			params.Text = &text
+		}
+		limit := maxLimit
+		if limitStr, ok := c.GetQuery("limit"); ok {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			if limit < 1 || limit > maxLimit {
+				problem(c, app.ErrInvalidParameter.Withf("feed limit must be between 1 and %d", maxLimit))
+				return
+			}
+		}
+
+		al, err := a.ListAds(c, params)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		list := al.Data
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].DateCreated.Equal(list[j].DateCreated) {
+				return list[i].DateCreated.After(list[j].DateCreated)
+			}
+			return list[i].ID > list[j].ID
+		})
+		if len(list) > limit {
+			list = list[:limit]
+		}
+		// the feed is dated by the last change of any ad, which is read after the list, so the date is never
+		// older than the list. The dates of the items would go back, when the last changed ad leaves the feed
+		lastModified, err := a.AdsChangedAt(c)
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		// the date of the feed without changes is the epoch, so that it does not change between requests
+		if lastModified.IsZero() {
+			lastModified = time.Unix(0, 0).UTC()
+		}
+
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		base := scheme + "://" + c.Request.Host
+		self := base + c.Request.URL.RequestURI()
+		var feed any
+		contentType := "application/rss+xml; charset=utf-8"
+		if format == feedAtom {
+			feed = AtomFeedResponse(list, base, self, lastModified)
+			contentType = "application/atom+xml; charset=utf-8"
+		} else {
+			feed = RSSFeedResponse(list, base, self, lastModified)
+		}
+		body, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		body = append([]byte(xml.Header), body...)
+
+		sum := sha256.Sum256(body)
+		etag := fmt.Sprintf(`"%x"`, sum[:16])
+		c.Header("ETag", etag)
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		if notModified(c.Request, etag, lastModified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, contentType, body)
+	}
+}
+
+// notModified checks the conditional headers of the request, If-None-Match takes precedence over If-Modified-Since
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// the header has one second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
 // Метод для создания пользователя
 func createUser(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody createUserRequest
-		err := c.Bind(&reqBody)
+		err := c.ShouldBind(&reqBody)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, UserErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		u, err := a.CreateUser(c, reqBody.Nickname, reqBody.Email)
 
 		if err != nil {
-			switch {
-			case errors.As(err, &validator.ValidationErrors{}):
-				c.JSON(http.StatusBadRequest, UserErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, UserErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, UserSuccessResponse(u))
@@ -233,29 +617,47 @@ func createUser(a app.App) gin.HandlerFunc {
 func updateUser(a app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqBody updateUserRequest
-		if err := c.Bind(&reqBody); err != nil {
-			c.JSON(http.StatusBadRequest, UserErrorResponse(err))
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
 			return
 		}
 
 		userIDStr := c.Param("user_id")
 		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, UserErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		u, err := a.UpdateUser(c, int64(userID), reqBody.Nickname, reqBody.Email)
 
 		if err != nil {
-			switch {
-			case errors.As(err, &validator.ValidationErrors{}):
-				c.JSON(http.StatusBadRequest, UserErrorResponse(err))
-			case errors.Is(err, app.ErrUserNotFound):
-				c.JSON(http.StatusNotFound, UserErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, UserErrorResponse(err))
-			}
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, UserSuccessResponse(u))
+	}
+}
+
+// Метод для частичного обновления пользователя (JSON Merge Patch), меняются только переданные поля
+func patchUser(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody patchUserRequest
+		if err := bindMergePatch(c, &reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		userID, err := strconv.Atoi(c.Param("user_id"))
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		u, err := a.PatchUser(c, int64(userID), app.UserPatch{Nickname: reqBody.Nickname, Email: reqBody.Email})
+
+		if err != nil {
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, UserSuccessResponse(u))
@@ -268,19 +670,14 @@ func getUser(a app.App) gin.HandlerFunc {
 		userIDStr := c.Param("user_id")
 		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, UserErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
 		u, err := a.GetUser(c, int64(userID))
 
 		if err != nil {
-			switch {
-			case errors.Is(err, app.ErrUserNotFound):
-				c.JSON(http.StatusNotFound, UserErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, UserErrorResponse(err))
-			}
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, UserSuccessResponse(u))
@@ -293,21 +690,698 @@ func deleteUser(a app.App) gin.HandlerFunc {
 		userIDStr := c.Param("user_id")
 		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, UserErrorResponse(err))
+			problem(c, badRequest(err))
 			return
 		}
 
-		err = a.DeleteUser(c, int64(userID))
-
+		var params app.DeleteUserParams
+		params.Mode, err = app.ParseDeleteMode(c.Query("mode"))
 		if err != nil {
-			switch {
-			case errors.Is(err, app.ErrUserNotFound):
-				c.JSON(http.StatusNotFound, UserErrorResponse(err))
-			default:
-				c.JSON(http.StatusInternalServerError, UserErrorResponse(err))
+			problem(c, badRequest(err))
+			return
+		}
+		if transferToStr, ok := c.GetQuery("transfer_to"); ok {
+			transferTo, err := strconv.Atoi(transferToStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
 			}
+			params.TransferTo = new(int64)
+			*params.TransferTo = int64(transferTo)
+		}
+
+		err = a.DeleteUser(c, int64(userID), params)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DeletionSuccessResponse())
+	}
+}
+
+// Метод для регистрации пользователя с паролем
+func register(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody registerRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		u, err := a.Register(c, reqBody.Nickname, reqBody.Email, reqBody.Password)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, UserSuccessResponse(u))
+	}
+}
+
+// Метод для подтверждения почты по токену из письма
+func verifyEmail(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody verifyEmailRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		u, err := a.VerifyEmail(c, reqBody.Token)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, UserSuccessResponse(u))
+	}
+}
+
+// Метод для входа по почте и паролю
+func login(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody loginRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		u, err := a.Login(c, reqBody.Email, reqBody.Password)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, UserSuccessResponse(u))
+	}
+}
+
+// Метод для отправки письма со сбросом пароля
+func requestPasswordReset(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody passwordResetRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		err := a.RequestPasswordReset(c, reqBody.Email)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DeletionSuccessResponse())
+	}
+}
+
+// Метод для установки нового пароля по токену из письма
+func resetPassword(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody resetPasswordRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		err := a.ResetPassword(c, reqBody.Token, reqBody.Password)
+
+		if err != nil {
+			problem(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, DeletionSuccessResponse())
 	}
 }
+
+// Метод для смены пароля пользователя
+func changePassword(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqBody changePasswordRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		err = a.ChangePassword(c, int64(userID), reqBody.OldPassword, reqBody.NewPassword)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DeletionSuccessResponse())
+	}
+}
+
+// Метод для восстановления удаленного объявления
+func restoreAd(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adIDStr := c.Param("ad_id")
+		adID, err := strconv.Atoi(adIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		ad, err := a.RestoreAd(c, int64(adID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, AdSuccessResponse(ad))
+	}
+}
+
+// Метод для восстановления удаленного пользователя вместе с его объявлениями
+func restoreUser(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		u, err := a.RestoreUser(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, UserSuccessResponse(u))
+	}
+}
+
+// Метод для запуска выгрузки всех данных пользователя (format=json|zip)
+func requestExport(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		format, err := app.ParseExportFormat(c.Query("format"))
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		j, err := a.RequestExport(c, int64(userID), format)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, JobSuccessResponse(j))
+	}
+}
+
+// Метод для запуска полного удаления данных пользователя
+func requestErasure(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		j, err := a.RequestErasure(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, JobSuccessResponse(j))
+	}
+}
+
+// Метод для получения статуса задачи
+func getJob(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		jobIDStr := c.Param("job_id")
+		jobID, err := strconv.Atoi(jobIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		j, err := a.GetJob(c, int64(userID), int64(jobID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, JobSuccessResponse(j))
+	}
+}
+
+// Метод для скачивания архива, подготовленного задачей выгрузки
+func downloadJobResult(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		jobIDStr := c.Param("job_id")
+		jobID, err := strconv.Atoi(jobIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		j, err := a.GetJob(c, int64(userID), int64(jobID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		switch {
+		case j.Status == jobs.StatusPending || j.Status == jobs.StatusRunning:
+			problem(c, app.ErrJobNotFinished)
+			return
+		case j.Result == nil:
+			problem(c, ErrJobNoResult)
+			return
+		}
+
+		ext := "json"
+		if j.ContentType == app.ContentTypeZIP {
+			ext = "zip"
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%d-export-%d.%s\"", j.UserID, j.ID, ext))
+		c.Data(http.StatusOK, j.ContentType, j.Result)
+	}
+}
+
+// Метод для поиска по журналу аудита (по actor, target_type, target_id и промежутку времени from - to)
+func queryAudit(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var filter audit.Filter
+		for param, dst := range map[string]**int64{"actor": &filter.Actor, "target_id": &filter.TargetID} {
+			if str, ok := c.GetQuery(param); ok {
+				v, err := strconv.ParseInt(str, 10, 64)
+				if err != nil {
+					problem(c, badRequest(err))
+					return
+				}
+				*dst = &v
+			}
+		}
+		for param, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+			if str, ok := c.GetQuery(param); ok {
+				t, err := time.Parse(time.RFC3339, str)
+				if err != nil {
+					problem(c, badRequest(err))
+					return
+				}
+				*dst = &t
+			}
+		}
+		if targetType, ok := c.GetQuery("target_type"); ok {
+			filter.TargetType = &targetType
+		}
+
+		records, err := a.QueryAudit(c, filter)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, AuditSuccessResponse(records))
+	}
+}
+
+// Метод для подписки на события объявлений (webhook), events - список событий, пустой список - все события
+func createWebhook(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		var reqBody createWebhookRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		s, err := a.CreateWebhook(c, int64(userID), reqBody.URL, reqBody.Events)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, CreatedWebhookSuccessResponse(s))
+	}
+}
+
+// Метод для получения списка подписок пользователя
+func listWebhooks(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		list, err := a.ListWebhooks(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, WebhookListSuccessResponse(list))
+	}
+}
+
+// webhookParams parses user_id and webhook_id, on failure the response is already written
+func webhookParams(c *gin.Context) (int64, int64, bool) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		problem(c, badRequest(err))
+		return 0, 0, false
+	}
+	webhookID, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		problem(c, badRequest(err))
+		return 0, 0, false
+	}
+	return int64(userID), int64(webhookID), true
+}
+
+// Метод для получения подписки по ID
+func getWebhook(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, webhookID, ok := webhookParams(c)
+		if !ok {
+			return
+		}
+
+		s, err := a.GetWebhook(c, userID, webhookID)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, WebhookSuccessResponse(s))
+	}
+}
+
+// Метод для удаления подписки
+func deleteWebhook(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, webhookID, ok := webhookParams(c)
+		if !ok {
+			return
+		}
+
+		err := a.DeleteWebhook(c, userID, webhookID)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DeletionSuccessResponse())
+	}
+}
+
+// Метод для включения подписки, отключенной после неудачных доставок
+func enableWebhook(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, webhookID, ok := webhookParams(c)
+		if !ok {
+			return
+		}
+
+		s, err := a.EnableWebhook(c, userID, webhookID)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, WebhookSuccessResponse(s))
+	}
+}
+
+// Метод для получения журнала доставок подписки (последние доставки первыми)
+func listWebhookDeliveries(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, webhookID, ok := webhookParams(c)
+		if !ok {
+			return
+		}
+
+		list, err := a.ListWebhookDeliveries(c, userID, webhookID)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, WebhookDeliveriesSuccessResponse(list))
+	}
+}
+
+// Метод для сохранения поиска, frequency=instant|daily|weekly - как часто присылать уведомления о новых объявлениях
+func createSavedSearch(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		var reqBody createSavedSearchRequest
+		if err := c.ShouldBind(&reqBody); err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		date, err := app.ParseDate(reqBody.Date)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		frequency, err := app.ParseFrequency(reqBody.Frequency)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		s, err := a.CreateSavedSearch(c, int64(userID), app.SavedSearchParams{
+			Name:      reqBody.Name,
+			Query:     reqBody.Query,
+			Uid:       reqBody.AuthorID,
+			Date:      date,
+			Title:     reqBody.Title,
+			Frequency: frequency,
+		})
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, SavedSearchSuccessResponse(s))
+	}
+}
+
+// Метод для получения списка сохраненных поисков пользователя
+func listSavedSearches(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		list, err := a.ListSavedSearches(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, SavedSearchListSuccessResponse(list))
+	}
+}
+
+// Метод для получения сохраненного поиска по ID
+func getSavedSearch(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		searchIDStr := c.Param("search_id")
+		searchID, err := strconv.Atoi(searchIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		s, err := a.GetSavedSearch(c, int64(userID), int64(searchID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, SavedSearchSuccessResponse(s))
+	}
+}
+
+// Метод для удаления сохраненного поиска
+func deleteSavedSearch(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		searchIDStr := c.Param("search_id")
+		searchID, err := strconv.Atoi(searchIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		err = a.DeleteSavedSearch(c, int64(userID), int64(searchID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DeletionSuccessResponse())
+	}
+}
+
+// Метод для получения уведомлений пользователя (новые первыми), постранично:
+// limit - размер страницы, before - курсор следующей страницы, unread=true - только непрочитанные
+func listNotifications(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		var params app.ListNotificationsParams
+		if limitStr, ok := c.GetQuery("limit"); ok {
+			params.Limit, err = strconv.Atoi(limitStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+		}
+		if beforeStr, ok := c.GetQuery("before"); ok {
+			before, err := strconv.ParseInt(beforeStr, 10, 64)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+			params.Before = &before
+		}
+		if unreadStr, ok := c.GetQuery("unread"); ok {
+			params.UnreadOnly, err = strconv.ParseBool(unreadStr)
+			if err != nil {
+				problem(c, badRequest(err))
+				return
+			}
+		}
+
+		page, err := a.ListNotifications(c, int64(userID), params)
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, NotificationPageSuccessResponse(page))
+	}
+}
+
+// Метод для получения количества непрочитанных уведомлений
+func countUnreadNotifications(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		count, err := a.CountUnreadNotifications(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, NotificationCountSuccessResponse("unread", count))
+	}
+}
+
+// Метод для отметки уведомления прочитанным
+func markNotificationRead(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+		notificationIDStr := c.Param("notification_id")
+		notificationID, err := strconv.Atoi(notificationIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		n, err := a.MarkNotificationRead(c, int64(userID), int64(notificationID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, NotificationSuccessResponse(n))
+	}
+}
+
+// Метод для отметки всех уведомлений пользователя прочитанными
+func markAllNotificationsRead(a app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr := c.Param("user_id")
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			problem(c, badRequest(err))
+			return
+		}
+
+		count, err := a.MarkAllNotificationsRead(c, int64(userID))
+
+		if err != nil {
+			problem(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, NotificationCountSuccessResponse("marked", count))
+	}
+}
@@ -0,0 +1,526 @@
+package httpgin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// APIPrefix is the path of the group, which AppRouter is mounted on
+const APIPrefix = "/api/v1"
+
+//go:embed docs.html
+var docsPage []byte
+
+// apiParam is the query parameter of the route
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// apiRoute documents the route of AppRouter, the paths are written in the same way as in the router.
+// Body and Data are values of the types, which the handler binds and the presenter returns,
+// so the schemas follow the code
+type apiRoute struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Query   []apiParam
+	// Body is the JSON request body, BodyTypes are the content types of a raw body
+	Body      any
+	BodyTypes []string
+	// Data is the data field of the success response, nil for responses without data
+	Data any
+	// Status is the success status, 200 by default
+	Status int
+	// Content is the content type of the success response, which is not the JSON envelope
+	Content []string
+	// Errors are the statuses of the client errors
+	Errors []int
+	// Idempotent routes accept the Idempotency-Key header
+	Idempotent bool
+	// Admin routes require the admin token
+	Admin bool
+}
+
+var (
+	userIDQuery = apiParam{Name: "user_id", Type: "integer", Description: "ID of the user"}
+	limitQuery  = apiParam{Name: "limit", Type: "integer", Description: "number of the items"}
+)
+
+// apiRoutes are all of the routes of AppRouter, TestOpenAPIRoutes fails, when they diverge
+var apiRoutes = []apiRoute{
+	{Method: http.MethodPost, Path: "/ads", Tag: "ads", Summary: "Create an ad", Body: createAdRequest{},
+		Data: adResponse{}, Errors: []int{400, 404}, Idempotent: true},
+	{Method: http.MethodPut, Path: "/ads/:ad_id/status", Tag: "ads", Summary: "Publish or unpublish the ad",
+		Body: changeAdStatusRequest{}, Data: adResponse{}, Errors: []int{400, 403, 404}},
+	{Method: http.MethodPut, Path: "/ads/:ad_id", Tag: "ads", Summary: "Replace the title and the text of the ad",
+		Body: updateAdRequest{}, Data: adResponse{}, Errors: []int{400, 403, 404}},
+	{Method: http.MethodPatch, Path: "/ads/:ad_id", Tag: "ads", Summary: "Change the fields of the ad in the JSON Merge Patch",
+		Body: patchAdRequest{}, BodyTypes: []string{"application/merge-patch+json"}, Data: adResponse{}, Errors: []int{400, 403, 404}},
+	{Method: http.MethodGet, Path: "/ads/:ad_id", Tag: "ads", Summary: "Get the ad", Data: adResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodDelete, Path: "/ads/:ad_id", Tag: "ads", Summary: "Delete the ad of the user",
+		Query: []apiParam{{Name: "user_id", Type: "integer", Description: "ID of the author", Required: true}}, Errors: []int{400, 403, 404}},
+	{Method: http.MethodPost, Path: "/ads/batch", Tag: "ads", Summary: "Create, publish, unpublish and delete several ads",
+		Body: batchAdsRequest{}, Data: batchResponse{}, Errors: []int{400}, Idempotent: true},
+	{Method: http.MethodGet, Path: "/ads", Tag: "ads", Summary: "List the ads, only published ones without filters",
+		Body: listAdsRequest{}, Data: adListResponse{}, Errors: []int{400}},
+	{Method: http.MethodGet, Path: "/ads/watch", Tag: "ads", Summary: "Stream the changes of the ads as Server-Sent Events",
+		Query: []apiParam{{Name: "published", Type: "boolean"}, userIDQuery, {Name: "date", Type: "string", Description: app.DateLayout},
+			{Name: "title", Type: "string"}},
+		Data: adEventResponse{}, Content: []string{"text/event-stream"}, Errors: []int{400, 503}},
+	{Method: http.MethodGet, Path: "/ads/export", Tag: "ads", Summary: "Export the ads",
+		Query:   []apiParam{userIDQuery, {Name: "format", Type: "string", Description: "csv or jsonl, csv by default"}},
+		Content: []string{app.ContentTypeCSV, app.ContentTypeJSONL}, Errors: []int{400, 404}},
+	{Method: http.MethodPost, Path: "/users/:user_id/ads/import", Tag: "ads", Summary: "Import the ads of the user",
+		Query: []apiParam{{Name: "format", Type: "string", Description: "csv or jsonl, csv by default"},
+			{Name: "dry_run", Type: "boolean", Description: "only check the rows"}},
+		BodyTypes: []string{app.ContentTypeCSV, app.ContentTypeJSONL, "multipart/form-data"},
+		Data:      importReportResponse{}, Errors: []int{400, 404, 413}},
+	{Method: http.MethodGet, Path: "/feeds/ads.rss", Tag: "feeds", Summary: "RSS feed of the published ads, the newest first",
+		Query:   []apiParam{userIDQuery, {Name: "text", Type: "string"}, limitQuery},
+		Content: []string{"application/rss+xml"}, Errors: []int{400}},
+	{Method: http.MethodGet, Path: "/feeds/ads.atom", Tag: "feeds", Summary: "Atom feed of the published ads, the newest first",
+		Query:   []apiParam{userIDQuery, {Name: "text", Type: "string"}, limitQuery},
+		Content: []string{"application/atom+xml"}, Errors: []int{400}},
+
+	{Method: http.MethodPost, Path: "/users", Tag: "users", Summary: "Create a user", Body: createUserRequest{},
+		Data: userResponse{}, Errors: []int{400}, Idempotent: true},
+	{Method: http.MethodGet, Path: "/users/:user_id", Tag: "users", Summary: "Get the user", Data: userResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodPut, Path: "/users/:user_id", Tag: "users", Summary: "Replace the nickname and the email of the user",
+		Body: updateUserRequest{}, Data: userResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodPatch, Path: "/users/:user_id", Tag: "users", Summary: "Change the fields of the user in the JSON Merge Patch",
+		Body: patchUserRequest{}, BodyTypes: []string{"application/merge-patch+json"}, Data: userResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodDelete, Path: "/users/:user_id", Tag: "users", Summary: "Delete the user",
+		Query: []apiParam{{Name: "mode", Type: "string", Description: "cascade, transfer or anonymize, cascade by default"},
+			{Name: "transfer_to", Type: "integer", Description: "ID of the user getting the ads in the transfer mode"}},
+		Errors: []int{400, 404}},
+
+	{Method: http.MethodPost, Path: "/auth/register", Tag: "auth", Summary: "Register a user with the password",
+		Body: registerRequest{}, Data: userResponse{}, Errors: []int{400, 409}, Idempotent: true},
+	{Method: http.MethodPost, Path: "/auth/verify", Tag: "auth", Summary: "Verify the email with the token",
+		Body: verifyEmailRequest{}, Data: userResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodPost, Path: "/auth/login", Tag: "auth", Summary: "Log in with the email and the password",
+		Body: loginRequest{}, Data: userResponse{}, Errors: []int{400, 401}},
+	{Method: http.MethodPost, Path: "/auth/password/forgot", Tag: "auth", Summary: "Send the mail with the password reset",
+		Body: passwordResetRequest{}, Errors: []int{400}},
+	{Method: http.MethodPost, Path: "/auth/password/reset", Tag: "auth", Summary: "Set the new password with the token",
+		Body: resetPasswordRequest{}, Errors: []int{400, 404}},
+	{Method: http.MethodPut, Path: "/users/:user_id/password", Tag: "auth", Summary: "Change the password",
+		Body: changePasswordRequest{}, Errors: []int{400, 401, 404}},
+
+	{Method: http.MethodPost, Path: "/users/:user_id/export", Tag: "privacy", Summary: "Start the export of the user's data",
+		Query:  []apiParam{{Name: "format", Type: "string", Description: "json or zip, json by default"}},
+		Status: http.StatusAccepted, Data: jobResponse{}, Errors: []int{400, 404, 503}},
+	{Method: http.MethodPost, Path: "/users/:user_id/erasure", Tag: "privacy", Summary: "Start the erasure of the user's data",
+		Status: http.StatusAccepted, Data: jobResponse{}, Errors: []int{400, 404, 503}},
+	{Method: http.MethodGet, Path: "/users/:user_id/jobs/:job_id", Tag: "privacy", Summary: "Get the job",
+		Data: jobResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodGet, Path: "/users/:user_id/jobs/:job_id/result", Tag: "privacy", Summary: "Download the result of the export",
+		Content: []string{"application/json", "application/zip"}, Errors: []int{400, 404, 409}},
+
+	{Method: http.MethodPost, Path: "/users/:user_id/webhooks", Tag: "webhooks", Summary: "Subscribe to the events of the ads",
+		Body: createWebhookRequest{}, Data: createdWebhookResponse{}, Errors: []int{400, 404}, Idempotent: true},
+	{Method: http.MethodGet, Path: "/users/:user_id/webhooks", Tag: "webhooks", Summary: "List the subscriptions",
+		Data: []webhookResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodGet, Path: "/users/:user_id/webhooks/:webhook_id", Tag: "webhooks", Summary: "Get the subscription",
+		Data: webhookResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodDelete, Path: "/users/:user_id/webhooks/:webhook_id", Tag: "webhooks", Summary: "Delete the subscription",
+		Errors: []int{400, 404}},
+	{Method: http.MethodPost, Path: "/users/:user_id/webhooks/:webhook_id/enable", Tag: "webhooks", Summary: "Enable the disabled subscription",
+		Data: webhookResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodGet, Path: "/users/:user_id/webhooks/:webhook_id/deliveries", Tag: "webhooks", Summary: "List the deliveries",
+		Data: []webhookDeliveryResponse{}, Errors: []int{400, 404}},
+
+	{Method: http.MethodPost, Path: "/users/:user_id/searches", Tag: "searches", Summary: "Save the search with alerts about new ads",
+		Body: createSavedSearchRequest{}, Data: savedSearchResponse{}, Errors: []int{400, 404}, Idempotent: true},
+	{Method: http.MethodGet, Path: "/users/:user_id/searches", Tag: "searches", Summary: "List the saved searches",
+		Data: []savedSearchResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodGet, Path: "/users/:user_id/searches/:search_id", Tag: "searches", Summary: "Get the saved search",
+		Data: savedSearchResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodDelete, Path: "/users/:user_id/searches/:search_id", Tag: "searches", Summary: "Delete the saved search",
+		Errors: []int{400, 404}},
+
+	{Method: http.MethodGet, Path: "/users/:user_id/notifications", Tag: "notifications", Summary: "List the notifications, the newest first",
+		Query: []apiParam{limitQuery, {Name: "before", Type: "integer", Description: "ID of the notification to continue before"},
+			{Name: "unread", Type: "boolean", Description: "only unread notifications"}},
+		Data: notificationPageResponse{}, Errors: []int{400}},
+	{Method: http.MethodGet, Path: "/users/:user_id/notifications/unread", Tag: "notifications", Summary: "Count the unread notifications",
+		Data: map[string]int{"unread": 0}, Errors: []int{400}},
+	{Method: http.MethodPost, Path: "/users/:user_id/notifications/read", Tag: "notifications", Summary: "Mark all of the notifications read",
+		Data: map[string]int{"marked": 0}, Errors: []int{400}},
+	{Method: http.MethodPost, Path: "/users/:user_id/notifications/:notification_id/read", Tag: "notifications",
+		Summary: "Mark the notification read", Data: notificationResponse{}, Errors: []int{400, 404}},
+
+	{Method: http.MethodPost, Path: "/admin/ads/:ad_id/restore", Tag: "admin", Summary: "Restore the deleted ad",
+		Data: adResponse{}, Errors: []int{400, 401, 404}, Admin: true},
+	{Method: http.MethodPost, Path: "/admin/users/:user_id/restore", Tag: "admin", Summary: "Restore the deleted user",
+		Data: userResponse{}, Errors: []int{400, 401, 404, 409}, Admin: true},
+	{Method: http.MethodGet, Path: "/admin/audit", Tag: "admin", Summary: "Search the audit log",
+		Query: []apiParam{{Name: "actor", Type: "integer"}, {Name: "target_type", Type: "string"}, {Name: "target_id", Type: "integer"},
+			{Name: "from", Type: "string", Description: "RFC 3339 time"}, {Name: "to", Type: "string", Description: "RFC 3339 time"}},
+		Data: []auditRecordResponse{}, Errors: []int{400, 401}, Admin: true},
+
+	{Method: http.MethodGet, Path: "/openapi.json", Tag: "docs", Summary: "This document", Content: []string{"application/json"}},
+	{Method: http.MethodGet, Path: "/docs", Tag: "docs", Summary: "Interactive documentation of the API", Content: []string{"text/html"}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// OpenAPI returns the OpenAPI 3 document of the routes of AppRouter
+func OpenAPI() []byte {
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIJSON, err = json.MarshalIndent(newOpenAPI(apiRoutes), "", "  "); err != nil {
+			panic(err)
+		}
+	})
+	return openAPIJSON
+}
+
+// Метод для получения описания API в формате OpenAPI 3
+func openAPISpec() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", OpenAPI())
+	}
+}
+
+// Метод для получения интерактивной документации API
+func apiDocs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	}
+}
+
+// OpenAPIPath converts the path of the router into the path of the document, ":ad_id" becomes "{ad_id}"
+func OpenAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// adminSecurity is the name of the security scheme of the admin routes
+const adminSecurity = "adminToken"
+
+// statusDescriptions describe the client errors of the routes
+var statusDescriptions = map[int]string{
+	http.StatusBadRequest:            "The request is invalid",
+	http.StatusUnauthorized:          "The credentials are invalid",
+	http.StatusForbidden:             "The user is not allowed to change the resource",
+	http.StatusNotFound:              "The resource does not exist",
+	http.StatusConflict:              "The resource is in a conflicting state",
+	http.StatusRequestEntityTooLarge: "The body is too large",
+	http.StatusServiceUnavailable:    "The service can not handle the request now",
+}
+
+func newOpenAPI(routes []apiRoute) map[string]any {
+	g := schemaGenerator{components: map[string]any{}}
+	// the problem is referenced by the responses of every operation, not through the fields of the types
+	g.schema(reflect.TypeOf(Problem{}))
+	g.components["Problem"].(map[string]any)["required"] = []string{"type", "title", "status", "detail", "code"}
+
+	paths := map[string]map[string]any{}
+	tags := map[string]bool{}
+	for _, r := range routes {
+		path := OpenAPIPath(r.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(r.Method)] = g.operation(r)
+		tags[r.Tag] = true
+	}
+
+	tagList := make([]map[string]string, 0, len(tags))
+	for tag := range tags {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"] < tagList[j]["name"] })
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Ad service",
+			"version":     "1.0.0",
+			"description": "HTTP API of the ad service. Successful responses have the data field, errors are problem details (RFC 7807) with the stable code.",
+		},
+		"servers": []map[string]string{{"url": APIPrefix}},
+		"tags":    tagList,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "The request failed",
+					"content":     problemContent(ref("Problem")),
+				},
+				"TooManyRequests": map[string]any{
+					"description": "The rate limit is exceeded",
+					"headers": map[string]any{
+						ratelimit.RetryAfter: map[string]any{"schema": map[string]string{"type": "integer"}},
+					},
+					"content": problemContent(ref("Problem")),
+				},
+			},
+			"securitySchemes": map[string]any{
+				adminSecurity: map[string]any{"type": "http", "scheme": "bearer",
+					"description": "the admin token of the service"},
+			},
+		},
+	}
+}
+
+func (g schemaGenerator) operation(r apiRoute) map[string]any {
+	op := map[string]any{
+		"tags":        []string{r.Tag},
+		"summary":     r.Summary,
+		"operationId": operationID(r),
+	}
+
+	var params []map[string]any
+	for _, p := range strings.Split(r.Path, "/") {
+		if strings.HasPrefix(p, ":") {
+			params = append(params, map[string]any{"name": p[1:], "in": "path", "required": true,
+				"schema": map[string]string{"type": "integer", "format": "int64"}})
+		}
+	}
+	for _, p := range r.Query {
+		param := map[string]any{"name": p.Name, "in": "query", "schema": map[string]string{"type": p.Type}}
+		if p.Required {
+			param["required"] = true
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if r.Idempotent {
+		params = append(params, map[string]any{"name": idempotency.Header, "in": "header",
+			"description": "the retries with the same key and body get the stored response",
+			"schema":      map[string]any{"type": "string", "maxLength": idempotency.MaxKeyLength}})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	content := map[string]any{}
+	if r.Body != nil {
+		types := r.BodyTypes
+		if len(types) == 0 {
+			types = []string{"application/json"}
+		}
+		for _, t := range types {
+			content[t] = map[string]any{"schema": g.schema(reflect.TypeOf(r.Body))}
+		}
+	} else {
+		for _, t := range r.BodyTypes {
+			content[t] = map[string]any{"schema": map[string]string{"type": "string", "format": "binary"}}
+		}
+	}
+	if len(content) > 0 {
+		op["requestBody"] = map[string]any{"required": r.Method != http.MethodGet, "content": content}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case len(r.Content) > 0:
+		content := map[string]any{}
+		for _, t := range r.Content {
+			schema := map[string]any{"type": "string"}
+			if r.Data != nil {
+				schema = g.schema(reflect.TypeOf(r.Data))
+			}
+			content[t] = map[string]any{"schema": schema}
+		}
+		success["content"] = content
+	default:
+		data := map[string]any{"nullable": true, "description": "always null"}
+		if r.Data != nil {
+			data = g.schema(reflect.TypeOf(r.Data))
+		}
+		success["content"] = jsonContent(map[string]any{
+			"type":     "object",
+			"required": []string{"data", "error"},
+			"properties": map[string]any{
+				"data":  data,
+				"error": map[string]any{"nullable": true, "description": "always null"},
+			},
+		})
+	}
+
+	responses := map[string]any{strconv.Itoa(status): success}
+	errs := r.Errors
+	if r.Idempotent {
+		errs = append(append([]int(nil), errs...), http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	for _, code := range errs {
+		description := statusDescriptions[code]
+		if r.Idempotent && code == http.StatusUnprocessableEntity {
+			description = "The idempotency key was used for a different request"
+		}
+		if description == "" {
+			description = http.StatusText(code)
+		}
+		responses[strconv.Itoa(code)] = map[string]any{"description": description, "content": problemContent(ref("Problem"))}
+	}
+	responses[strconv.Itoa(http.StatusTooManyRequests)] = ref("TooManyRequests", "responses")
+	responses[strconv.Itoa(http.StatusInternalServerError)] = ref("Error", "responses")
+	op["responses"] = responses
+	if r.Admin {
+		op["security"] = []map[string][]string{{adminSecurity: {}}}
+	}
+	return op
+}
+
+// operationID is the method and the path in camel case, such as "getUsersUserIdWebhooks"
+func operationID(r apiRoute) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Method))
+	upper := true
+	for _, c := range r.Path {
+		switch {
+		case c == '/' || c == ':' || c == '_' || c == '.':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func ref(name string, kind ...string) map[string]any {
+	section := "schemas"
+	if len(kind) > 0 {
+		section = kind[0]
+	}
+	return map[string]any{"$ref": "#/components/" + section + "/" + name}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func problemContent(schema map[string]any) map[string]any {
+	return map[string]any{ProblemContentType: map[string]any{"schema": schema}}
+}
+
+// schemaGenerator converts the types of the requests and the responses into JSON schemas,
+// the structs become components named after the types
+type schemaGenerator struct {
+	components map[string]any
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func (g schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t == rawMessageType {
+		return map[string]any{"description": "any JSON value", "nullable": true}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// the placeholder stops the recursion of self-referencing types
+			g.components[name] = nil
+			g.components[name] = g.object(t)
+		}
+		return ref(name)
+	}
+	return map[string]any{}
+}
+
+func (g schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			// the fields of embedded structs are encoded as the fields of the outer one
+			embedded := g.object(f.Type)
+			for name, s := range embedded["properties"].(map[string]any) {
+				properties[name] = s
+			}
+			if r, ok := embedded["required"].([]string); ok {
+				required = append(required, r...)
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		rules := strings.Split(f.Tag.Get("binding"), ",")
+		for _, rule := range rules {
+			switch rule {
+			case "required":
+				required = append(required, name)
+			case "email":
+				s["format"] = "email"
+			}
+		}
+		properties[name] = s
+	}
+	object := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// componentName is the name of the type starting with the capital letter
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
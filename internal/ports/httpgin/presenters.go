@@ -1,10 +1,24 @@
 package httpgin
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
 	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 type createUserRequest struct {
@@ -17,10 +31,142 @@ type updateUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 }
 
+// patchUserRequest is the JSON Merge Patch of the user, the missing fields are not changed
+type patchUserRequest struct {
+	Nickname *string `json:"nickname"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+}
+
 type userResponse struct {
 	ID       int64  `json:"id"`
 	Nickname string `json:"nickname"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+}
+
+type registerRequest struct {
+	Nickname string `json:"nickname"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type jobResponse struct {
+	ID       int64   `json:"id"`
+	Kind     string  `json:"kind"`
+	UserID   int64   `json:"user_id"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Created  string  `json:"created"`
+	Finished *string `json:"finished"`
+}
+
+type auditRecordResponse struct {
+	Time       string          `json:"time"`
+	Actor      *int64          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"request_id"`
+	Protocol   string          `json:"protocol"`
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+}
+
+type webhookResponse struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"user_id"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	Active     bool     `json:"active"`
+	Failures   int      `json:"failures"`
+	Created    string   `json:"created"`
+	DisabledAt *string  `json:"disabled_at"`
+}
+
+// createdWebhookResponse is the only response with the secret, it is never shown after the creation
+type createdWebhookResponse struct {
+	webhookResponse
+	Secret string `json:"secret"`
+}
+
+type webhookDeliveryResponse struct {
+	ID         string `json:"id"`
+	Event      string `json:"event"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+	Success    bool   `json:"success"`
+	Time       string `json:"time"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type createSavedSearchRequest struct {
+	Name      string  `json:"name"`
+	Query     string  `json:"query"`
+	AuthorID  *int64  `json:"author_id"`
+	Date      *string `json:"date"`
+	Title     *string `json:"title"`
+	Frequency string  `json:"frequency"`
+}
+
+type savedSearchResponse struct {
+	ID           int64   `json:"id"`
+	UserID       int64   `json:"user_id"`
+	Name         string  `json:"name"`
+	Query        string  `json:"query"`
+	AuthorID     *int64  `json:"author_id"`
+	Date         *string `json:"date"`
+	Title        *string `json:"title"`
+	Frequency    string  `json:"frequency"`
+	Created      string  `json:"created"`
+	LastNotified *string `json:"last_notified"`
+	Pending      int     `json:"pending"`
+}
+
+type notificationResponse struct {
+	ID       int64   `json:"id"`
+	UserID   int64   `json:"user_id"`
+	Kind     string  `json:"kind"`
+	Subject  string  `json:"subject"`
+	Text     string  `json:"text"`
+	AdIDs    []int64 `json:"ad_ids"`
+	SearchID *int64  `json:"search_id"`
+	Created  string  `json:"created"`
+	Read     bool    `json:"read"`
+}
+
+type notificationPageResponse struct {
+	Notifications []notificationResponse `json:"notifications"`
+	Unread        int                    `json:"unread"`
+	Next          *int64                 `json:"next"`
 }
 
 type createAdRequest struct {
@@ -39,6 +185,77 @@ type adResponse struct {
 	DateChanged string `json:"date_changed"`
 }
 
+type adEventResponse struct {
+	Event      string      `json:"event"`
+	OccurredAt string      `json:"occurred_at"`
+	AdID       int64       `json:"ad_id"`
+	AuthorID   int64       `json:"author_id"`
+	Ad         *adResponse `json:"ad"`
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomSpace string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
 type changeAdStatusRequest struct {
 	Published bool  `json:"published"`
 	UserID    int64 `json:"user_id"`
@@ -50,6 +267,13 @@ type updateAdRequest struct {
 	UserID int64  `json:"user_id"`
 }
 
+// patchAdRequest is the JSON Merge Patch of the ad, the missing fields are not changed
+type patchAdRequest struct {
+	Title  *string `json:"title"`
+	Text   *string `json:"text"`
+	UserID int64   `json:"user_id"`
+}
+
 type listAdsRequest struct {
 	Published *bool   `json:"published"`
 	UserID    *int64  `json:"user_id"`
@@ -59,34 +283,165 @@ type listAdsRequest struct {
 
 type adListResponse []adResponse
 
+type batchAdsRequest struct {
+	UserID     int64            `json:"user_id"`
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOpRequest `json:"operations"`
+}
+
+type batchOpRequest struct {
+	Op    string `json:"op"`
+	AdID  int64  `json:"ad_id"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+type batchResultResponse struct {
+	Op     string      `json:"op"`
+	AdID   int64       `json:"ad_id"`
+	Status int         `json:"status"`
+	Ad     *adResponse `json:"ad"`
+	Error  *string     `json:"error"`
+	Code   *string     `json:"code"`
+}
+
+type importRowResponse struct {
+	Line   int     `json:"line"`
+	AdID   *int64  `json:"ad_id"`
+	Status int     `json:"status"`
+	Error  *string `json:"error"`
+	Code   *string `json:"code"`
+}
+
+type importReportResponse struct {
+	DryRun   bool                `json:"dry_run"`
+	Imported int                 `json:"imported"`
+	Failed   int                 `json:"failed"`
+	Rows     []importRowResponse `json:"rows"`
+}
+
+type batchResponse struct {
+	Results []batchResultResponse `json:"results"`
+	Failed  int                   `json:"failed"`
+}
+
 func AdSuccessResponse(ad *ads.Ad) *gin.H {
 	return &gin.H{
-		"data": adResponse{
-			ID:          ad.ID,
+		"data":  newAdResponse(*ad),
+		"error": nil,
+	}
+}
+
+func newAdResponse(ad ads.Ad) adResponse {
+	return adResponse{
+		ID:          ad.ID,
+		Title:       ad.Title,
+		Text:        ad.Text,
+		AuthorID:    ad.AuthorID,
+		Published:   ad.Published,
+		DateCreated: app.FormatDate(ad.DateCreated),
+		DateChanged: app.FormatDate(ad.DateChanged),
+	}
+}
+
+// AdEventResponse is the data of a Server-Sent Event, the ad is null for deleted ads
+func AdEventResponse(e events.Event) adEventResponse {
+	data := adEventResponse{
+		Event:      e.EventName(),
+		OccurredAt: e.OccurredAt().Format(time.RFC3339Nano),
+	}
+	_, data.AdID = e.Aggregate()
+	var ad *ads.Ad
+	switch t := e.(type) {
+	case events.AdCreated:
+		ad = &t.Ad
+	case events.AdPublished:
+		ad = &t.Ad
+	case events.AdUnpublished:
+		ad = &t.Ad
+	case events.AdUpdated:
+		ad = &t.Ad
+	case events.AdRestored:
+		ad = &t.Ad
+	case events.AdDeleted:
+		data.AuthorID = t.AuthorID
+	}
+	if ad != nil {
+		response := newAdResponse(*ad)
+		data.Ad = &response
+		data.AuthorID = ad.AuthorID
+	}
+	return data
+}
+
+const (
+	feedTitle  = "Published ads"
+	feedAuthor = "Ad service"
+)
+
+// RSSFeedResponse renders the ads as RSS 2.0, base is the URL of the service and self is the URL of the feed.
+// Item dates are taken from DateCreated, the date of the feed is the last change of the ads
+func RSSFeedResponse(list []ads.Ad, base string, self string, updated time.Time) rssFeed {
+	feed := rssFeed{
+		Version:   "2.0",
+		AtomSpace: "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + "/api/v1/ads",
+			Description:   "Ads published in the service, the newest first",
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			Items:         make([]rssItem, 0, len(list)),
+		},
+	}
+	for _, ad := range list {
+		link := adLink(base, ad.ID)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
 			Title:       ad.Title,
-			Text:        ad.Text,
-			AuthorID:    ad.AuthorID,
-			Published:   ad.Published,
-			DateCreated: app.FormatDate(ad.DateCreated),
-			DateChanged: app.FormatDate(ad.DateChanged),
+			Link:        link,
+			Description: ad.Text,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     ad.DateCreated.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return feed
+}
+
+// AtomFeedResponse renders the ads as Atom, entries are published at DateCreated and updated at DateChanged
+func AtomFeedResponse(list []ads.Ad, base string, self string, updated time.Time) atomFeed {
+	feed := atomFeed{
+		Title:   feedTitle,
+		ID:      self,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/api/v1/ads", Rel: "alternate", Type: "application/json"},
 		},
-		"error": nil,
+		Author:  atomAuthor{Name: feedAuthor},
+		Entries: make([]atomEntry, 0, len(list)),
+	}
+	for _, ad := range list {
+		link := adLink(base, ad.ID)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     ad.Title,
+			ID:        link,
+			Link:      atomLink{Href: link},
+			Published: ad.DateCreated.UTC().Format(time.RFC3339),
+			Updated:   ad.DateChanged.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "text", Value: ad.Text},
+		})
 	}
+	return feed
+}
+
+func adLink(base string, id int64) string {
+	return fmt.Sprintf("%s/api/v1/ads/%d", base, id)
 }
 
 func AdListSuccessResponse(al *ads.AdList) *gin.H {
 	data := make(adListResponse, 0)
 	for _, ad := range al.Data {
-		data = append(data,
-			adResponse{
-				ID:          ad.ID,
-				Title:       ad.Title,
-				Text:        ad.Text,
-				AuthorID:    ad.AuthorID,
-				Published:   ad.Published,
-				DateCreated: app.FormatDate(ad.DateCreated),
-				DateChanged: app.FormatDate(ad.DateChanged),
-			})
+		data = append(data, newAdResponse(ad))
 	}
 	return &gin.H{
 		"data":  data,
@@ -94,10 +449,46 @@ func AdListSuccessResponse(al *ads.AdList) *gin.H {
 	}
 }
 
-func AdErrorResponse(err error) *gin.H {
+// BatchSuccessResponse contains the result of every operation with the status, which the single request would get
+func BatchSuccessResponse(ops []app.BatchOp, results []app.BatchResult) *gin.H {
+	data := batchResponse{Results: make([]batchResultResponse, 0, len(results))}
+	for i, res := range results {
+		item := batchResultResponse{Op: string(ops[i].Action), AdID: res.AdID, Status: resultStatus(res.Err)}
+		if res.Ad != nil {
+			ad := newAdResponse(*res.Ad)
+			item.Ad = &ad
+		}
+		if res.Err != nil {
+			msg, code := res.Err.Error(), app.Translate(res.Err).Code
+			item.Error, item.Code = &msg, &code
+			data.Failed++
+		}
+		data.Results = append(data.Results, item)
+	}
 	return &gin.H{
-		"data":  nil,
-		"error": err.Error(),
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func ImportSuccessResponse(report *app.ImportReport) *gin.H {
+	data := importReportResponse{
+		DryRun:   report.DryRun,
+		Imported: report.Imported,
+		Failed:   report.Failed,
+		Rows:     make([]importRowResponse, 0, len(report.Rows)),
+	}
+	for _, row := range report.Rows {
+		item := importRowResponse{Line: row.Line, AdID: row.AdID, Status: resultStatus(row.Err)}
+		if row.Err != nil {
+			msg, code := row.Err.Error(), app.Translate(row.Err).Code
+			item.Error, item.Code = &msg, &code
+		}
+		data.Rows = append(data.Rows, item)
+	}
+	return &gin.H{
+		"data":  data,
+		"error": nil,
 	}
 }
 
@@ -107,21 +498,279 @@ func UserSuccessResponse(u *user.User) *gin.H {
 			ID:       u.ID,
 			Nickname: u.Nickname,
 			Email:    u.Email,
+			Verified: u.Verified,
 		},
 		"error": nil,
 	}
 }
 
-func UserErrorResponse(err error) *gin.H {
+func DeletionSuccessResponse() *gin.H {
 	return &gin.H{
 		"data":  nil,
-		"error": err.Error(),
+		"error": nil,
 	}
 }
 
-func DeletionSuccessResponse() *gin.H {
+func JobSuccessResponse(j *jobs.Job) *gin.H {
+	data := jobResponse{
+		ID:      j.ID,
+		Kind:    j.Kind.String(),
+		UserID:  j.UserID,
+		Status:  j.Status.String(),
+		Error:   j.Error,
+		Created: j.Created.Format(time.RFC3339),
+	}
+	if j.Finished != nil {
+		finished := j.Finished.Format(time.RFC3339)
+		data.Finished = &finished
+	}
 	return &gin.H{
-		"data":  nil,
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func AuditSuccessResponse(records []audit.Record) *gin.H {
+	data := make([]auditRecordResponse, 0, len(records))
+	for _, r := range records {
+		data = append(data, auditRecordResponse{
+			Time:       r.Time.Format(time.RFC3339Nano),
+			Actor:      r.Actor,
+			Action:     r.Action,
+			TargetType: r.TargetType,
+			TargetID:   r.TargetID,
+			Before:     r.Before,
+			After:      r.After,
+			RequestID:  r.RequestID,
+			Protocol:   r.Protocol,
+		})
+	}
+	return &gin.H{
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func newWebhookResponse(s webhooks.Subscription) webhookResponse {
+	data := webhookResponse{
+		ID:       s.ID,
+		UserID:   s.OwnerID,
+		URL:      s.URL,
+		Events:   s.Events,
+		Active:   s.Active,
+		Failures: s.Failures,
+		Created:  s.Created.Format(time.RFC3339),
+	}
+	if len(data.Events) == 0 {
+		data.Events = webhooks.Events
+	}
+	if s.DisabledAt != nil {
+		disabled := s.DisabledAt.Format(time.RFC3339)
+		data.DisabledAt = &disabled
+	}
+	return data
+}
+
+func WebhookSuccessResponse(s *webhooks.Subscription) *gin.H {
+	return &gin.H{
+		"data":  newWebhookResponse(*s),
+		"error": nil,
+	}
+}
+
+func CreatedWebhookSuccessResponse(s *webhooks.Subscription) *gin.H {
+	return &gin.H{
+		"data":  createdWebhookResponse{webhookResponse: newWebhookResponse(*s), Secret: s.Secret},
+		"error": nil,
+	}
+}
+
+func WebhookListSuccessResponse(list []webhooks.Subscription) *gin.H {
+	data := make([]webhookResponse, 0, len(list))
+	for _, s := range list {
+		data = append(data, newWebhookResponse(s))
+	}
+	return &gin.H{
+		"data":  data,
 		"error": nil,
 	}
 }
+
+func WebhookDeliveriesSuccessResponse(list []webhooks.Delivery) *gin.H {
+	data := make([]webhookDeliveryResponse, 0, len(list))
+	for _, d := range list {
+		data = append(data, webhookDeliveryResponse{
+			ID:         d.ID,
+			Event:      d.Event,
+			Attempts:   d.Attempts,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Success:    d.Success,
+			Time:       d.Time.Format(time.RFC3339Nano),
+			DurationMs: d.Duration.Milliseconds(),
+		})
+	}
+	return &gin.H{
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func newSavedSearchResponse(s searches.SavedSearch) savedSearchResponse {
+	data := savedSearchResponse{
+		ID:        s.ID,
+		UserID:    s.UserID,
+		Name:      s.Name,
+		Query:     s.Query,
+		AuthorID:  s.AuthorID,
+		Title:     s.Title,
+		Frequency: s.Frequency.String(),
+		Created:   s.Created.Format(time.RFC3339),
+		Pending:   len(s.Pending),
+	}
+	if s.Date != nil {
+		date := s.Date.Format(app.DateLayout)
+		data.Date = &date
+	}
+	if !s.LastNotified.IsZero() {
+		notified := s.LastNotified.Format(time.RFC3339)
+		data.LastNotified = &notified
+	}
+	return data
+}
+
+func SavedSearchSuccessResponse(s *searches.SavedSearch) *gin.H {
+	return &gin.H{
+		"data":  newSavedSearchResponse(*s),
+		"error": nil,
+	}
+}
+
+func SavedSearchListSuccessResponse(list []searches.SavedSearch) *gin.H {
+	data := make([]savedSearchResponse, 0, len(list))
+	for _, s := range list {
+		data = append(data, newSavedSearchResponse(s))
+	}
+	return &gin.H{
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func newNotificationResponse(n notifications.Notification) notificationResponse {
+	data := notificationResponse{
+		ID:       n.ID,
+		UserID:   n.UserID,
+		Kind:     n.Kind,
+		Subject:  n.Subject,
+		Text:     n.Text,
+		AdIDs:    n.AdIDs,
+		SearchID: n.SearchID,
+		Created:  n.Created.Format(time.RFC3339),
+		Read:     n.Read,
+	}
+	if data.AdIDs == nil {
+		data.AdIDs = make([]int64, 0)
+	}
+	return data
+}
+
+func NotificationSuccessResponse(n *notifications.Notification) *gin.H {
+	return &gin.H{
+		"data":  newNotificationResponse(*n),
+		"error": nil,
+	}
+}
+
+func NotificationPageSuccessResponse(page *notifications.Page) *gin.H {
+	data := notificationPageResponse{
+		Notifications: make([]notificationResponse, 0, len(page.Notifications)),
+		Unread:        page.Unread,
+		Next:          page.Next,
+	}
+	for _, n := range page.Notifications {
+		data.Notifications = append(data.Notifications, newNotificationResponse(n))
+	}
+	return &gin.H{
+		"data":  data,
+		"error": nil,
+	}
+}
+
+func NotificationCountSuccessResponse(name string, count int) *gin.H {
+	return &gin.H{
+		"data":  gin.H{name: count},
+		"error": nil,
+	}
+}
+
+// ProblemContentType is the media type of the error responses
+const ProblemContentType = "application/problem+json"
+
+// FieldError is the constraint of the request field, which the value does not satisfy
+type FieldError struct {
+	Field       string `json:"field"`
+	Constraint  string `json:"constraint,omitempty"`
+	Description string `json:"description"`
+}
+
+// Problem is the error response in the format of problem details (RFC 7807). The code is stable,
+// so the clients should check it instead of the detail
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func ProblemResponse(err error, instance string) *Problem {
+	t := app.Translate(err)
+	var fields []FieldError
+	for _, v := range app.FieldViolations(err) {
+		fields = append(fields, FieldError{Field: v.Field, Constraint: v.Constraint, Description: v.Description})
+	}
+	var bindErrs validator.ValidationErrors
+	if errors.As(err, &bindErrs) {
+		for _, e := range bindErrs {
+			fields = append(fields, FieldError{Field: app.SnakeCase(e.Field()), Constraint: e.Tag(), Description: e.Error()})
+		}
+	}
+	return &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(t.HTTPStatus),
+		Status:   t.HTTPStatus,
+		Detail:   err.Error(),
+		Instance: instance,
+		Code:     t.Code,
+		Errors:   fields,
+	}
+}
+
+// resultStatus is the status of the item of the batch or the import, the same as of the single request
+func resultStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return app.Translate(err).HTTPStatus
+}
+
+// badRequest gives the code to the errors of reading the request, the errors of the application keep their own
+func badRequest(err error) error {
+	var numErr *strconv.NumError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case app.ErrorCode(err) != "":
+		return err
+	case errors.As(err, &validator.ValidationErrors{}):
+		return app.ErrValidation.Wrap(err)
+	case errors.As(err, &numErr):
+		return app.ErrInvalidParameter.Wrap(err)
+	case errors.As(err, &tooLarge):
+		return app.ErrBodyTooLarge.Wrap(err)
+	default:
+		return app.ErrInvalidRequest.Wrap(err)
+	}
+}
@@ -5,17 +5,68 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func AppRouter(r *gin.RouterGroup, a app.App) {
-	r.POST("/ads", createAd(a))                    // Метод для создания объявления (ad)
+func AppRouter(r *gin.RouterGroup, a app.App, cfg Config) {
+	// the requests creating resources can be retried with the Idempotency-Key header
+	create := idempotent(cfg.Idempotency)
+
+	r.POST("/ads", create, createAd(a))            // Метод для создания объявления (ad)
 	r.PUT("/ads/:ad_id/status", changeAdStatus(a)) // Метод для изменения статуса объявления (опубликовано - Published = true или снято с публикации Published = false)
 	r.PUT("/ads/:ad_id", updateAd(a))              // Метод для обновления текста(Text) или заголовка(Title) объявления
+	r.PATCH("/ads/:ad_id", patchAd(a))             // Метод для частичного обновления объявления (JSON Merge Patch), меняются только переданные поля
 	r.GET("/ads/:ad_id", getAd(a))                 // Метод для получения объявления по ID
 	r.DELETE("/ads/:ad_id", deleteAd(a))
+	r.POST("/ads/batch", create, batchAds(a)) // Метод для создания, публикации, снятия с публикации и удаления нескольких объявлений (atomic - все или ничего)
+
+	r.GET("/ads", listAds(a))                     // Метод для получения списка объявлений с фильтрами (по published, userID, date, title)
+	r.GET("/ads/watch", watchAds(a, cfg.Closing)) // Метод для получения изменений объявлений в виде Server-Sent Events (фильтры как у списка)
+
+	r.GET("/ads/export", exportAds(a))                 // Метод для выгрузки объявлений в CSV или JSON Lines (по user_id, format=csv|jsonl)
+	r.POST("/users/:user_id/ads/import", importAds(a)) // Метод для загрузки объявлений пользователя из CSV или JSON Lines (format=csv|jsonl, dry_run)
+
+	r.GET("/feeds/ads.rss", adsFeed(a, cfg.FeedLimit, feedRSS))   // Метод для получения RSS-ленты опубликованных объявлений (по user_id, text)
+	r.GET("/feeds/ads.atom", adsFeed(a, cfg.FeedLimit, feedAtom)) // Метод для получения Atom-ленты опубликованных объявлений (по user_id, text)
+
+	r.POST("/users", create, createUser(a))    // Метод для создания пользователя (user)
+	r.GET("/users/:user_id", getUser(a))       // Метод для получения пользователя по ID
+	r.PUT("/users/:user_id", updateUser(a))    // Метод для обновления имени(Nickname) или почты(Email) пользователя
+	r.PATCH("/users/:user_id", patchUser(a))   // Метод для частичного обновления пользователя (JSON Merge Patch), меняются только переданные поля
+	r.DELETE("/users/:user_id", deleteUser(a)) // Метод для удаления пользователя, mode=cascade|transfer|anonymize
+
+	r.POST("/auth/register", create, register(a))            // Метод для регистрации пользователя с паролем
+	r.POST("/auth/verify", verifyEmail(a))                   // Метод для подтверждения почты по токену
+	r.POST("/auth/login", login(a))                          // Метод для входа по почте и паролю
+	r.POST("/auth/password/forgot", requestPasswordReset(a)) // Метод для отправки письма со сбросом пароля
+	r.POST("/auth/password/reset", resetPassword(a))         // Метод для установки нового пароля по токену
+	r.PUT("/users/:user_id/password", changePassword(a))     // Метод для смены пароля
+
+	r.POST("/users/:user_id/export", requestExport(a))                 // Метод для запуска выгрузки данных пользователя
+	r.POST("/users/:user_id/erasure", requestErasure(a))               // Метод для запуска полного удаления данных пользователя
+	r.GET("/users/:user_id/jobs/:job_id", getJob(a))                   // Метод для получения статуса задачи
+	r.GET("/users/:user_id/jobs/:job_id/result", downloadJobResult(a)) // Метод для скачивания результата выгрузки
+
+	r.POST("/users/:user_id/webhooks", create, createWebhook(a))                       // Метод для подписки на события объявлений
+	r.GET("/users/:user_id/webhooks", listWebhooks(a))                                 // Метод для получения списка подписок
+	r.GET("/users/:user_id/webhooks/:webhook_id", getWebhook(a))                       // Метод для получения подписки по ID
+	r.DELETE("/users/:user_id/webhooks/:webhook_id", deleteWebhook(a))                 // Метод для удаления подписки
+	r.POST("/users/:user_id/webhooks/:webhook_id/enable", enableWebhook(a))            // Метод для включения отключенной подписки
+	r.GET("/users/:user_id/webhooks/:webhook_id/deliveries", listWebhookDeliveries(a)) // Метод для получения журнала доставок
+
+	r.POST("/users/:user_id/searches", create, createSavedSearch(a))      // Метод для сохранения поиска с уведомлениями о новых объявлениях
+	r.GET("/users/:user_id/searches", listSavedSearches(a))               // Метод для получения списка сохраненных поисков
+	r.GET("/users/:user_id/searches/:search_id", getSavedSearch(a))       // Метод для получения сохраненного поиска по ID
+	r.DELETE("/users/:user_id/searches/:search_id", deleteSavedSearch(a)) // Метод для удаления сохраненного поиска
+
+	r.GET("/users/:user_id/notifications", listNotifications(a))                           // Метод для получения уведомлений пользователя
+	r.GET("/users/:user_id/notifications/unread", countUnreadNotifications(a))             // Метод для получения количества непрочитанных уведомлений
+	r.POST("/users/:user_id/notifications/read", markAllNotificationsRead(a))              // Метод для отметки всех уведомлений прочитанными
+	r.POST("/users/:user_id/notifications/:notification_id/read", markNotificationRead(a)) // Метод для отметки уведомления прочитанным
 
-	r.GET("/ads", listAds(a)) // Метод для получения списка объявлений с фильтрами (по published, userID, date, title)
+	// admin routes require the admin token
+	admin := r.Group("/admin", AdminMiddleware(cfg.AdminToken))
+	admin.POST("/ads/:ad_id/restore", restoreAd(a))       // Метод для восстановления удаленного объявления
+	admin.POST("/users/:user_id/restore", restoreUser(a)) // Метод для восстановления удаленного пользователя
+	admin.GET("/audit", queryAudit(a))                    // Метод для поиска по журналу аудита
 
-	r.POST("/users", createUser(a))         // Метод для создания пользователя (user)
-	r.GET("/users/:user_id", getUser(a))    // Метод для получения пользователя по ID
-	r.PUT("/users/:user_id", updateUser(a)) // Метод для обновления имени(Nickname) или почты(Email) пользователя
-	r.DELETE("/users/:user_id", deleteUser(a))
+	r.GET("/openapi.json", openAPISpec()) // Метод для получения описания API в формате OpenAPI 3
+	r.GET("/docs", apiDocs())             // Метод для получения интерактивной документации API
 }
@@ -1,18 +1,28 @@
 package httpgin
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/TobbyMax/ad-service.git/internal/adminauth"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
 )
 
+const RequestIDHeader = "X-Request-ID"
+
 func LoggerMiddleWare(c *gin.Context) {
 	start := time.Now()
 
@@ -26,22 +36,250 @@ func LoggerMiddleWare(c *gin.Context) {
 	log.Printf("-- handled request -- | protocol: HTTP | status: %d | latency: %+v | method: %s | path: %s\n", status, latency, c.Request.Method, c.Request.URL.Path)
 }
 
-func NewHTTPServer(port string, a app.App) *http.Server {
+// RequestInfoMiddleware puts the request ID into the context of the request,
+// the ID is taken from the X-Request-ID header or generated
+func RequestInfoMiddleware(c *gin.Context) {
+	id := c.GetHeader(RequestIDHeader)
+	if id == "" {
+		id = audit.NewRequestID()
+	}
+	c.Header(RequestIDHeader, id)
+	ctx := audit.WithRequestInfo(c.Request.Context(), audit.RequestInfo{ID: id, Protocol: audit.ProtocolHTTP})
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+}
+
+// RateLimitMiddleware limits the requests of every client address and every user on every route.
+// The user is taken from the X-User-ID header, the user_id parameter of the path or the query,
+// or the user_id field of a JSON body
+func RateLimitMiddleware(l *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.GetHeader(ratelimit.UserHeader)
+		if uid == "" {
+			uid = c.Param("user_id")
+		}
+		if uid == "" {
+			uid = c.Query("user_id")
+		}
+		if uid == "" {
+			uid = bodyUser(c)
+		}
+
+		d := l.Allow(c.Request.Method+" "+c.FullPath(), c.ClientIP(), uid)
+		for k, v := range d.Headers() {
+			c.Header(k, v)
+		}
+		if !d.Allowed {
+			abortWithProblem(c, ratelimit.ErrLimited)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// maxPeekedBody is the size of the largest JSON body, which is looked into for the user
+const maxPeekedBody = 64 << 10
+
+// bodyUser returns the user_id field of the JSON body, the body is left for the handler as it was
+func bodyUser(c *gin.Context) string {
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+	peeked, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekedBody+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), c.Request.Body), c.Request.Body}
+	if err != nil || len(peeked) > maxPeekedBody {
+		return ""
+	}
+
+	var body struct {
+		UserID *int64 `json:"user_id"`
+	}
+	if json.Unmarshal(peeked, &body) != nil || body.UserID == nil {
+		return ""
+	}
+	return strconv.FormatInt(*body.UserID, 10)
+}
+
+// maxIdempotentBody is the size of the largest body of a request with the Idempotency-Key header,
+// the body is read into memory for the fingerprint
+const maxIdempotentBody = 1 << 20
+
+// IdempotencyMiddleware stores the response of the request with the Idempotency-Key header and replays it
+// for the retries with the same key and the same request. Server errors are not stored, so they can be retried
+func IdempotencyMiddleware(s idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotency.Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if err := idempotency.ValidKey(key); err != nil {
+			abortWithProblem(c, err)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBody))
+		if err != nil {
+			abortWithProblem(c, badRequest(err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		key = c.Request.Method + " " + c.FullPath() + " " + key
+		fingerprint := idempotency.Fingerprint([]byte(c.Request.URL.RequestURI()), body)
+		stored, err := idempotency.Begin(c, s, key, fingerprint)
+		switch {
+		case err != nil:
+			abortWithProblem(c, err)
+			return
+		case stored != nil:
+			c.Header(idempotency.ReplayedHeader, "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				_ = s.Release(c, key)
+			}
+		}()
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			return
+		}
+		completed = s.Complete(c, idempotency.Record{Key: key, Fingerprint: fingerprint, Status: status,
+			ContentType: c.Writer.Header().Get("Content-Type"), Body: recorder.body.Bytes()}) == nil
+	}
+}
+
+// bodyRecorder keeps a copy of the written body
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// AdminMiddleware lets through only the requests with the admin token in the Authorization header,
+// without the token every request is rejected
+func AdminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !adminauth.Authorized(token, c.GetHeader(adminauth.Header)) {
+			c.Header("WWW-Authenticate", adminauth.Scheme)
+			abortWithProblem(c, adminauth.ErrUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// idempotent returns the middleware for the routes creating resources, it does nothing without the store
+func idempotent(s idempotency.Store) gin.HandlerFunc {
+	if s == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	return IdempotencyMiddleware(s)
+}
+
+// DefaultFeedLimit is the number of items in RSS and Atom feeds
+const DefaultFeedLimit = 50
+
+// Config tunes the handlers of the router
+type Config struct {
+	// Closing ends streaming responses, when the server shuts down
+	Closing <-chan struct{}
+	// FeedLimit is the default and the maximum number of items in RSS and Atom feeds
+	FeedLimit int
+	// Limiter limits the rate of requests, nil means no limits
+	Limiter *ratelimit.Limiter
+	// Idempotency stores the responses of the requests creating resources, nil disables idempotency keys
+	Idempotency idempotency.Store
+	// AdminToken is required by the admin routes, they reject every request, when it is empty
+	AdminToken string
+}
+
+type Option func(*Config)
+
+// WithFeedLimit sets the number of items in RSS and Atom feeds, a request can ask for fewer items only
+func WithFeedLimit(n int) Option {
+	return func(c *Config) {
+		c.FeedLimit = n
+	}
+}
+
+// WithRateLimiter limits the rate of requests, the limiter may be shared with the gRPC server
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return func(c *Config) {
+		c.Limiter = l
+	}
+}
+
+// WithIdempotencyStore enables the Idempotency-Key header for the requests creating resources
+func WithIdempotencyStore(s idempotency.Store) Option {
+	return func(c *Config) {
+		c.Idempotency = s
+	}
+}
+
+// WithAdminToken opens the admin routes to the requests with the token
+func WithAdminToken(token string) Option {
+	return func(c *Config) {
+		c.AdminToken = token
+	}
+}
+
+func NewHTTPServer(port string, a app.App, opts ...Option) *http.Server {
 	gin.SetMode(gin.ReleaseMode)
 	handler := gin.New()
+	// handlers pass *gin.Context to the application, so its values must come from the request context
+	handler.ContextWithFallback = true
 	s := &http.Server{Addr: port, Handler: handler}
+	// streaming responses end, when the server starts shutting down, otherwise Shutdown would wait for them
+	closing := make(chan struct{})
+	s.RegisterOnShutdown(func() {
+		close(closing)
+	})
+	cfg := Config{Closing: closing, FeedLimit: DefaultFeedLimit}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	// todo: add your own logic
 
-	api := handler.Group("/api/v1")
+	api := handler.Group(APIPrefix)
 
 	// MiddleWare для логирования и паник
 	api.Use(gin.Logger())
 	api.Use(gin.Recovery())
 
 	api.Use(LoggerMiddleWare)
+	api.Use(RequestInfoMiddleware)
+	if cfg.Limiter != nil {
+		api.Use(RateLimitMiddleware(cfg.Limiter))
+	}
 
-	AppRouter(api, a)
+	AppRouter(api, a, cfg)
 	return s
 }
 
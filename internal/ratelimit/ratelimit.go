@@ -0,0 +1,229 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLimited is returned to the clients, which ran out of their quota
+var ErrLimited = errors.New("rate limit exceeded")
+
+const (
+	// headers of the limits, they are sent with every limited response
+	LimitHeader     = "RateLimit-Limit"
+	RemainingHeader = "RateLimit-Remaining"
+	ResetHeader     = "RateLimit-Reset"
+	RetryAfter      = "Retry-After"
+
+	// UserHeader identifies the user of the request for the per user quotas
+	UserHeader = "X-User-ID"
+)
+
+// Quota is a token bucket: it allows Burst requests at once and refills Rate requests a second.
+// The zero Quota does not limit anything
+type Quota struct {
+	Rate  float64
+	Burst int
+}
+
+func (q Quota) unlimited() bool {
+	return q.Rate <= 0 || q.Burst <= 0
+}
+
+var quotaPeriods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseQuota parses quotas such as "10/s" or "100/m", the burst equals the number of requests.
+// A different burst follows a comma: "100/m,20". Empty string and "off" mean no limit
+func ParseQuota(s string) (Quota, error) {
+	if s == "" || s == "off" {
+		return Quota{}, nil
+	}
+	spec, burstStr, hasBurst := strings.Cut(s, ",")
+	countStr, periodStr, ok := strings.Cut(spec, "/")
+	period, known := quotaPeriods[periodStr]
+	count, err := strconv.Atoi(countStr)
+	if !ok || !known || err != nil || count < 1 {
+		return Quota{}, fmt.Errorf("invalid quota %q, expected <requests>/<s|m|h>[,<burst>]", s)
+	}
+	q := Quota{Rate: float64(count) / period.Seconds(), Burst: count}
+	if hasBurst {
+		if q.Burst, err = strconv.Atoi(burstStr); err != nil || q.Burst < 1 {
+			return Quota{}, fmt.Errorf("invalid burst of quota %q", s)
+		}
+	}
+	return q, nil
+}
+
+// Rule holds the quotas of every client address and every user, the buckets are separate for every route
+type Rule struct {
+	PerIP   Quota
+	PerUser Quota
+}
+
+type Config struct {
+	Default Rule
+	// Routes override the default rule, HTTP routes are named as "POST /api/v1/ads",
+	// gRPC methods by their full names such as "/ad.AdService/CreateAd"
+	Routes map[string]Rule
+}
+
+// Decision is the result of the check of the request, it describes the most restrictive of the buckets
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time left until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time left until the request would be allowed, it is zero for allowed requests
+	RetryAfter time.Duration
+}
+
+// Limited tells if the decision comes from a quota, unlimited requests have no headers
+func (d Decision) Limited() bool {
+	return d.Limit > 0
+}
+
+// Headers returns the values of the headers of the decision, the number of seconds is rounded up
+func (d Decision) Headers() map[string]string {
+	if !d.Limited() {
+		return nil
+	}
+	h := map[string]string{
+		LimitHeader:     strconv.Itoa(d.Limit),
+		RemainingHeader: strconv.Itoa(d.Remaining),
+		ResetHeader:     seconds(d.Reset),
+	}
+	if !d.Allowed {
+		h[RetryAfter] = seconds(d.RetryAfter)
+	}
+	return h
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// sweepInterval is how often the buckets, which are full again, are forgotten
+const sweepInterval = time.Minute
+
+// Limiter keeps the token buckets of the clients, it is shared by the HTTP and gRPC servers
+type Limiter struct {
+	mu        sync.Mutex
+	cfg       Config
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route string
+	// client is the address or the user prefixed with the kind of the key, so they never clash
+	client string
+}
+
+type bucket struct {
+	quota   Quota
+	tokens  float64
+	updated time.Time
+}
+
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, buckets: make(map[bucketKey]*bucket), lastSweep: time.Now()}
+}
+
+func (l *Limiter) rule(route string) Rule {
+	if r, ok := l.cfg.Routes[route]; ok {
+		return r
+	}
+	return l.cfg.Default
+}
+
+// Allow checks the request of the client address and the user, the user is empty for anonymous requests.
+// A request takes a token from each of its buckets, and only if all of them have one
+func (l *Limiter) Allow(route string, ip string, user string) Decision {
+	rule := l.rule(route)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	buckets := make([]*bucket, 0, 2)
+	if !rule.PerIP.unlimited() {
+		buckets = append(buckets, l.bucket(bucketKey{route: route, client: "ip:" + ip}, rule.PerIP, now))
+	}
+	if user != "" && !rule.PerUser.unlimited() {
+		buckets = append(buckets, l.bucket(bucketKey{route: route, client: "user:" + user}, rule.PerUser, now))
+	}
+
+	allowed := true
+	for _, b := range buckets {
+		allowed = allowed && b.tokens >= 1
+	}
+	var d Decision
+	for i, b := range buckets {
+		if allowed {
+			b.tokens--
+		}
+		bd := b.decision()
+		if i == 0 || bd.RetryAfter > d.RetryAfter || bd.RetryAfter == d.RetryAfter && bd.Remaining < d.Remaining {
+			d = bd
+		}
+	}
+	d.Allowed = allowed
+	if allowed {
+		d.RetryAfter = 0
+	}
+	return d
+}
+
+// bucket returns the bucket refilled up to now
+func (l *Limiter) bucket(key bucketKey, q Quota, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok || b.quota != q {
+		b = &bucket{quota: q, tokens: float64(q.Burst), updated: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.tokens = math.Min(float64(q.Burst), b.tokens+now.Sub(b.updated).Seconds()*q.Rate)
+	b.updated = now
+	return b
+}
+
+func (b *bucket) decision() Decision {
+	d := Decision{
+		Limit:     b.quota.Burst,
+		Remaining: int(b.tokens),
+		Reset:     refillTime(float64(b.quota.Burst)-b.tokens, b.quota.Rate),
+	}
+	if b.tokens < 1 {
+		d.RetryAfter = refillTime(1-b.tokens, b.quota.Rate)
+	}
+	return d
+}
+
+// refillTime returns the time needed to refill the tokens
+func refillTime(tokens float64, perSecond float64) time.Duration {
+	return time.Duration(tokens / perSecond * float64(time.Second))
+}
+
+// sweep forgets the buckets, which are full again, they are the same as new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.quota.Rate >= float64(b.quota.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package searches
+
+import (
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"strings"
+	"time"
+)
+
+type Frequency int
+
+const (
+	// FrequencyInstant notifies about every match as soon as the ad is published
+	FrequencyInstant Frequency = iota
+	FrequencyDaily
+	FrequencyWeekly
+)
+
+// Period is the minimal time between two notifications of a search
+func (f Frequency) Period() time.Duration {
+	switch f {
+	case FrequencyDaily:
+		return 24 * time.Hour
+	case FrequencyWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+func (f Frequency) String() string {
+	switch f {
+	case FrequencyInstant:
+		return "instant"
+	case FrequencyDaily:
+		return "daily"
+	case FrequencyWeekly:
+		return "weekly"
+	default:
+		return "unknown"
+	}
+}
+
+// SavedSearch is a query of a buyer, which is evaluated against every newly published ad.
+// The filters have the same meaning as in the list of ads, Query matches ads, whose title or text
+// contain all of its words
+type SavedSearch struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	Query     string
+	AuthorID  *int64
+	Title     *string
+	Date      *time.Time
+	Frequency Frequency
+	Created   time.Time
+
+	// Pending holds IDs of the matched ads, which were not notified yet
+	Pending []int64
+	// LastNotified is the time of the last notification, zero if there were none
+	LastNotified time.Time
+	// Sent holds the times of the notifications of the last day, they are counted against the cap
+	Sent []time.Time
+}
+
+// Matches reports whether the published ad satisfies the search
+func (s SavedSearch) Matches(ad ads.Ad) bool {
+	if !ad.Published || ad.DeletedAt != nil {
+		return false
+	}
+	if s.AuthorID != nil && *s.AuthorID != ad.AuthorID {
+		return false
+	}
+	if s.Title != nil && *s.Title != ad.Title {
+		return false
+	}
+	if s.Date != nil {
+		year, month, day := ad.DateCreated.Date()
+		if s.Date.Year() != year || s.Date.Month() != month || s.Date.Day() != day {
+			return false
+		}
+	}
+	content := strings.ToLower(ad.Title + " " + ad.Text)
+	for _, word := range strings.Fields(strings.ToLower(s.Query)) {
+		if !strings.Contains(content, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// NextNotification returns the earliest time, when pending matches can be notified
+func (s SavedSearch) NextNotification() time.Time {
+	last := s.LastNotified
+	if last.IsZero() {
+		last = s.Created
+	}
+	return last.Add(s.Frequency.Period())
+}
+
+// SentSince returns the number of notifications sent after the given time
+func (s SavedSearch) SentSince(t time.Time) int {
+	n := 0
+	for _, sent := range s.Sent {
+		if sent.After(t) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/mailer"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type AccountSuite struct {
+	suite.Suite
+	Client *testClient
+	Outbox *mailer.Outbox
+}
+
+func (suite *AccountSuite) SetupTest() {
+	log.Println("Setting Up Test")
+
+	suite.Outbox = mailer.NewOutbox()
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New(), app.WithMailer(suite.Outbox)))
+	testServer := httptest.NewServer(server.Handler)
+
+	suite.Client = &testClient{
+		client:  testServer.Client(),
+		baseURL: testServer.URL,
+	}
+}
+
+func (suite *AccountSuite) lastToken(email string) string {
+	msg, ok := suite.Outbox.Last(email)
+	suite.Require().True(ok, "no mail sent to %s", email)
+	token := tokenFromMail(msg.Body)
+	suite.Require().NotEmpty(token)
+	return token
+}
+
+func (suite *AccountSuite) TestRegister() {
+	resp, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+	suite.Equal("Mac Miller", resp.Data.Nickname)
+	suite.Equal("swimming@circles.com", resp.Data.Email)
+	suite.False(resp.Data.Verified)
+
+	msg, ok := suite.Outbox.Last("swimming@circles.com")
+	suite.True(ok)
+	suite.Equal("Email verification", msg.Subject)
+}
+
+func (suite *AccountSuite) TestRegister_EmailTaken() {
+	_, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	_, err = suite.Client.register("Larry Fisherman", "swimming@circles.com", "good-news")
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *AccountSuite) TestRegister_ShortPassword() {
+	_, err := suite.Client.register("Mac Miller", "swimming@circles.com", "dang")
+	suite.ErrorIs(err, ErrBadRequest)
+}
+
+func (suite *AccountSuite) TestVerifyEmail() {
+	u, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	resp, err := suite.Client.verifyEmail(suite.lastToken("swimming@circles.com"))
+	suite.NoError(err)
+	suite.Equal(u.Data.ID, resp.Data.ID)
+	suite.True(resp.Data.Verified)
+
+	resp, err = suite.Client.getUser(u.Data.ID)
+	suite.NoError(err)
+	suite.True(resp.Data.Verified)
+}
+
+func (suite *AccountSuite) TestVerifyEmail_TokenUsedTwice() {
+	_, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	token := suite.lastToken("swimming@circles.com")
+	_, err = suite.Client.verifyEmail(token)
+	suite.NoError(err)
+
+	_, err = suite.Client.verifyEmail(token)
+	suite.ErrorIs(err, ErrBadRequest)
+}
+
+func (suite *AccountSuite) TestPublish_NotVerified() {
+	u, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, true)
+	suite.ErrorIs(err, ErrForbidden)
+
+	_, err = suite.Client.verifyEmail(suite.lastToken("swimming@circles.com"))
+	suite.NoError(err)
+
+	resp, err := suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, true)
+	suite.NoError(err)
+	suite.True(resp.Data.Published)
+}
+
+func (suite *AccountSuite) TestCreateUser_NeedsVerification() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	suite.False(u.Data.Verified)
+
+	_, err = suite.Client.verifyEmail(suite.lastToken("swimming@circles.com"))
+	suite.NoError(err)
+}
+
+func (suite *AccountSuite) TestUpdateUser_EmailChangeNeedsVerification() {
+	u, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+	_, err = suite.Client.verifyEmail(suite.lastToken("swimming@circles.com"))
+	suite.NoError(err)
+
+	resp, err := suite.Client.updateUser(u.Data.ID, "Mac Miller", "good_am@circles.com")
+	suite.NoError(err)
+	suite.False(resp.Data.Verified)
+
+	_, err = suite.Client.verifyEmail(suite.lastToken("good_am@circles.com"))
+	suite.NoError(err)
+}
+
+func (suite *AccountSuite) TestLogin() {
+	u, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	resp, err := suite.Client.login("swimming@circles.com", "self-care")
+	suite.NoError(err)
+	suite.Equal(u.Data.ID, resp.Data.ID)
+
+	_, err = suite.Client.login("swimming@circles.com", "self-harm")
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	_, err = suite.Client.login("blue_slide@park.com", "self-care")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *AccountSuite) TestLogin_UserWithoutPassword() {
+	_, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.login("swimming@circles.com", "")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	_, err = suite.Client.login("swimming@circles.com", "anything")
+	suite.ErrorIs(err, ErrUnauthorized)
+}
+
+func (suite *AccountSuite) TestResetPassword() {
+	_, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	err = suite.Client.forgotPassword("swimming@circles.com")
+	suite.NoError(err)
+
+	msg, ok := suite.Outbox.Last("swimming@circles.com")
+	suite.True(ok)
+	suite.Equal("Password reset", msg.Subject)
+
+	err = suite.Client.resetPassword(tokenFromMail(msg.Body), "hand-me-downs")
+	suite.NoError(err)
+
+	_, err = suite.Client.login("swimming@circles.com", "self-care")
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	_, err = suite.Client.login("swimming@circles.com", "hand-me-downs")
+	suite.NoError(err)
+}
+
+func (suite *AccountSuite) TestResetPassword_VerificationTokenRejected() {
+	_, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	err = suite.Client.resetPassword(suite.lastToken("swimming@circles.com"), "hand-me-downs")
+	suite.ErrorIs(err, ErrBadRequest)
+}
+
+func (suite *AccountSuite) TestForgotPassword_UnknownEmail() {
+	err := suite.Client.forgotPassword("nobody@circles.com")
+	suite.NoError(err)
+	suite.Empty(suite.Outbox.Messages())
+}
+
+func (suite *AccountSuite) TestChangePassword() {
+	u, err := suite.Client.register("Mac Miller", "swimming@circles.com", "self-care")
+	suite.NoError(err)
+
+	err = suite.Client.changePassword(u.Data.ID, "wrong-password", "hand-me-downs")
+	suite.ErrorIs(err, ErrUnauthorized)
+
+	err = suite.Client.changePassword(u.Data.ID, "self-care", "short")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	err = suite.Client.changePassword(u.Data.ID, "self-care", "hand-me-downs")
+	suite.NoError(err)
+
+	_, err = suite.Client.login("swimming@circles.com", "hand-me-downs")
+	suite.NoError(err)
+}
+
+func TestAccountSuite(t *testing.T) {
+	suite.Run(t, new(AccountSuite))
+}
+
+func TestChangePassword_DoesNotBlockReads(t *testing.T) {
+	a := app.NewApp(adrepo.New())
+	ctx := context.Background()
+	u, err := a.Register(ctx, "Mac Miller", "swimming@circles.com", "swimming")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+
+	// the reads must take much less than one comparison of a password
+	start := time.Now()
+	_ = bcrypt.CompareHashAndPassword(u.PasswordHash, []byte("swimming"))
+	compare := time.Since(start)
+
+	done := make(chan error)
+	go func() {
+		done <- a.ChangePassword(ctx, u.ID, "swimming", "circles!")
+	}()
+	var slowest time.Duration
+	for changed := false; !changed; {
+		start := time.Now()
+		_, err := a.GetAd(ctx, ad.ID)
+		require.NoError(t, err)
+		if d := time.Since(start); d > slowest {
+			slowest = d
+		}
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			changed = true
+		default:
+		}
+	}
+	assert.Less(t, slowest, compare/2)
+}
+
+// resetDuringCheck resets the password of the user, after ChangePassword reads the user to check the old password
+type resetDuringCheck struct {
+	app.Repository
+	uid   int64
+	hash  []byte
+	reset bool
+}
+
+func (r *resetDuringCheck) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	if err := r.Repository.InTransaction(ctx, fn); err != nil || r.reset {
+		return err
+	}
+	r.reset = true
+	return r.Repository.UpdateUserPassword(ctx, r.uid, r.hash)
+}
+
+func TestChangePassword_ConcurrentReset(t *testing.T) {
+	repo := adrepo.New()
+	ctx := context.Background()
+	u, err := app.NewApp(repo).Register(ctx, "Mac Miller", "swimming@circles.com", "swimming")
+	require.NoError(t, err)
+	reset, err := bcrypt.GenerateFromPassword([]byte("circles!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// the old password was valid at the check, but the reset wins
+	err = app.NewApp(&resetDuringCheck{Repository: repo, uid: u.ID, hash: reset}).ChangePassword(ctx, u.ID, "swimming", "blue world")
+	assert.ErrorIs(t, err, app.ErrInvalidCredentials)
+	got, err := repo.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, reset, got.PasswordHash)
+}
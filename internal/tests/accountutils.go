@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+var tokenRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// tokenFromMail extracts verification or reset token from the body of an email
+func tokenFromMail(body string) string {
+	return tokenRegexp.FindString(body)
+}
+
+func (tc *testClient) sendJSON(method string, path string, body map[string]any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(method, tc.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	return tc.getResponse(req, out)
+}
+
+func (tc *testClient) register(nickname any, email any, password any) (userResponse, error) {
+	var response userResponse
+	err := tc.sendJSON(http.MethodPost, "/api/v1/auth/register", map[string]any{
+		"nickname": nickname,
+		"email":    email,
+		"password": password,
+	}, &response)
+	return response, err
+}
+
+func (tc *testClient) verifyEmail(token any) (userResponse, error) {
+	var response userResponse
+	err := tc.sendJSON(http.MethodPost, "/api/v1/auth/verify", map[string]any{
+		"token": token,
+	}, &response)
+	return response, err
+}
+
+func (tc *testClient) login(email any, password any) (userResponse, error) {
+	var response userResponse
+	err := tc.sendJSON(http.MethodPost, "/api/v1/auth/login", map[string]any{
+		"email":    email,
+		"password": password,
+	}, &response)
+	return response, err
+}
+
+func (tc *testClient) forgotPassword(email any) error {
+	var response userResponse
+	return tc.sendJSON(http.MethodPost, "/api/v1/auth/password/forgot", map[string]any{
+		"email": email,
+	}, &response)
+}
+
+func (tc *testClient) resetPassword(token any, password any) error {
+	var response userResponse
+	return tc.sendJSON(http.MethodPost, "/api/v1/auth/password/reset", map[string]any{
+		"token":    token,
+		"password": password,
+	}, &response)
+}
+
+func (tc *testClient) changePassword(userID any, oldPassword any, newPassword any) error {
+	var response userResponse
+	return tc.sendJSON(http.MethodPut, fmt.Sprintf("/api/v1/users/%v/password", userID), map[string]any{
+		"old_password": oldPassword,
+		"new_password": newPassword,
+	}, &response)
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
 	"github.com/TobbyMax/ad-service.git/internal/tests/mocks"
 	"github.com/TobbyMax/ad-service.git/internal/user"
 	"github.com/TobbyMax/validator"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"testing"
+	"time"
 )
 
 type AppTestSuite struct {
@@ -21,6 +23,14 @@ type AppTestSuite struct {
 func (suite *AppTestSuite) SetupTest() {
 	suite.Repo = mocks.NewRepository(suite.T())
 	suite.Ctx = context.Background()
+	// the use cases run their checks in transactions, which are passed through to the mock
+	suite.Repo.On("InTransaction", mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, fn func(context.Context, app.Repository) error) error {
+			return fn(ctx, suite.Repo)
+		}).
+		Maybe()
+	// the events of the use cases are covered by the tests of the outbox
+	suite.Repo.On("AddEvent", mock.Anything, mock.Anything).Return(nil).Maybe()
 }
 
 func (suite *AppTestSuite) TestApp_CreateAd() {
@@ -140,6 +150,9 @@ func (suite *AppTestSuite) TestApp_ChangeAdStatus() {
 	suite.Repo.On("GetAdByID", suite.Ctx, id).
 		Return(&ads.Ad{AuthorID: 1}, nil).
 		Once()
+	suite.Repo.On("GetUserByID", suite.Ctx, int64(1)).
+		Return(&user.User{ID: 1, Verified: true}, nil).
+		Once()
 	suite.Repo.On("UpdateAdStatus", suite.Ctx, id, true, mock.AnythingOfType("time.Time")).
 		Return(nil).
 		Once()
@@ -173,11 +186,44 @@ func (suite *AppTestSuite) TestApp_ChangeAdStatus_Forbidden() {
 	suite.ErrorIs(err, app.ErrForbidden)
 }
 
+func (suite *AppTestSuite) TestApp_ChangeAdStatus_NotVerified() {
+	id := int64(0)
+	suite.Repo.On("GetAdByID", suite.Ctx, id).
+		Return(&ads.Ad{AuthorID: 1}, nil).
+		Once()
+	suite.Repo.On("GetUserByID", suite.Ctx, int64(1)).
+		Return(&user.User{ID: 1}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.ChangeAdStatus(suite.Ctx, id, int64(1), true)
+	suite.Error(err)
+	suite.ErrorIs(err, app.ErrUserNotVerified)
+}
+
+func (suite *AppTestSuite) TestApp_ChangeAdStatus_Unpublish() {
+	id := int64(0)
+	suite.Repo.On("GetAdByID", suite.Ctx, id).
+		Return(&ads.Ad{AuthorID: 1, Published: true}, nil).
+		Once()
+	suite.Repo.On("UpdateAdStatus", suite.Ctx, id, false, mock.AnythingOfType("time.Time")).
+		Return(nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	ad, err := service.ChangeAdStatus(suite.Ctx, id, int64(1), false)
+	suite.Nil(err)
+	suite.False(ad.Published)
+}
+
 func (suite *AppTestSuite) TestApp_ChangeAdStatus_RepoError() {
 	id := int64(0)
 	suite.Repo.On("GetAdByID", suite.Ctx, id).
 		Return(&ads.Ad{AuthorID: 1}, nil).
 		Once()
+	suite.Repo.On("GetUserByID", suite.Ctx, int64(1)).
+		Return(&user.User{ID: 1, Verified: true}, nil).
+		Once()
 	suite.Repo.On("UpdateAdStatus", suite.Ctx, id, true, mock.AnythingOfType("time.Time")).
 		Return(ErrMock).
 		Once()
@@ -344,27 +390,105 @@ func (suite *AppTestSuite) TestApp_UpdateUser_RepoError() {
 
 func (suite *AppTestSuite) TestApp_DeleteUser() {
 	id := int64(0)
+	suite.Repo.On("GetUserByID", suite.Ctx, id).
+		Return(&user.User{ID: id}, nil).
+		Once()
+	suite.Repo.On("GetAdList", suite.Ctx, app.ListAdsParams{Uid: &id}).
+		Return(&ads.AdList{}, nil).
+		Once()
 	suite.Repo.On("DeleteUserByID", suite.Ctx, id).
 		Return(nil).
 		Once()
 
 	service := app.NewApp(suite.Repo)
-	err := service.DeleteUser(suite.Ctx, id)
+	err := service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{})
 	suite.Nil(err)
 }
 
 func (suite *AppTestSuite) TestApp_DeleteUser_RepoError() {
 	id := int64(0)
+	suite.Repo.On("GetUserByID", suite.Ctx, id).
+		Return(&user.User{ID: id}, nil).
+		Once()
+	suite.Repo.On("GetAdList", suite.Ctx, app.ListAdsParams{Uid: &id}).
+		Return(&ads.AdList{}, nil).
+		Once()
 	suite.Repo.On("DeleteUserByID", suite.Ctx, id).
 		Return(ErrMock).
 		Once()
 
 	service := app.NewApp(suite.Repo)
-	err := service.DeleteUser(suite.Ctx, id)
+	err := service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{})
 	suite.Error(err)
 	suite.ErrorIs(err, ErrMock)
 }
 
+func (suite *AppTestSuite) TestApp_DeleteUser_Transfer() {
+	id, to := int64(0), int64(1)
+	suite.Repo.On("GetUserByID", suite.Ctx, id).
+		Return(&user.User{ID: id}, nil).
+		Once()
+	suite.Repo.On("GetAdList", suite.Ctx, app.ListAdsParams{Uid: &id}).
+		Return(&ads.AdList{}, nil).
+		Once()
+	suite.Repo.On("DeleteUserKeepAds", suite.Ctx, id, to).
+		Return(nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	err := service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{Mode: app.DeleteTransfer, TransferTo: &to})
+	suite.Nil(err)
+}
+
+func (suite *AppTestSuite) TestApp_DeleteUser_TransferToSelf() {
+	id := int64(0)
+
+	service := app.NewApp(suite.Repo)
+	err := service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{Mode: app.DeleteTransfer, TransferTo: &id})
+	suite.ErrorIs(err, app.ErrInvalidTransfer)
+
+	err = service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{Mode: app.DeleteTransfer})
+	suite.ErrorIs(err, app.ErrInvalidTransfer)
+}
+
+func (suite *AppTestSuite) TestApp_DeleteUser_Anonymize() {
+	id := int64(0)
+	suite.Repo.On("GetUserByID", suite.Ctx, id).
+		Return(&user.User{ID: id}, nil).
+		Once()
+	suite.Repo.On("GetAdList", suite.Ctx, app.ListAdsParams{Uid: &id}).
+		Return(&ads.AdList{}, nil).
+		Once()
+	suite.Repo.On("DeleteUserKeepAds", suite.Ctx, id, ads.AnonymousAuthorID).
+		Return(nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	err := service.DeleteUser(suite.Ctx, id, app.DeleteUserParams{Mode: app.DeleteAnonymize})
+	suite.Nil(err)
+}
+
+func (suite *AppTestSuite) TestApp_RequestExport_UserNotFound() {
+	id := int64(0)
+	suite.Repo.On("GetUserByID", suite.Ctx, id).
+		Return(nil, app.ErrUserNotFound).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.RequestExport(suite.Ctx, id, app.ExportJSON)
+	suite.ErrorIs(err, app.ErrUserNotFound)
+}
+
+func (suite *AppTestSuite) TestApp_GetJob_OtherUser() {
+	suite.Repo.On("GetJobByID", suite.Ctx, int64(1)).
+		Return(&jobs.Job{ID: 1, UserID: 2}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.GetJob(suite.Ctx, 3, 1)
+	suite.ErrorIs(err, app.ErrJobNotFound)
+}
+
 func (suite *AppTestSuite) TestApp_DeleteAd() {
 	id := int64(0)
 	suite.Repo.On("GetAdByID", suite.Ctx, id).
@@ -418,6 +542,99 @@ func (suite *AppTestSuite) TestApp_DeleteAd_RepoError() {
 	suite.ErrorIs(err, ErrMock)
 }
 
+func (suite *AppTestSuite) TestApp_Register() {
+	id := int64(13)
+	suite.Repo.On("GetUserByEmail", suite.Ctx, "swimming@circles.com").
+		Return(nil, app.ErrUserNotFound).
+		Once()
+	suite.Repo.On("AddUser", suite.Ctx, mock.MatchedBy(func(u user.User) bool {
+		return len(u.PasswordHash) > 0 && string(u.PasswordHash) != "self-care"
+	})).
+		Return(id, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	u, err := service.Register(suite.Ctx, "Mac Miller", "swimming@circles.com", "self-care")
+	suite.Nil(err)
+	suite.Equal(id, u.ID)
+	suite.True(u.Verified)
+}
+
+func (suite *AppTestSuite) TestApp_Register_EmailTaken() {
+	suite.Repo.On("GetUserByEmail", suite.Ctx, "swimming@circles.com").
+		Return(&user.User{}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.Register(suite.Ctx, "Mac Miller", "swimming@circles.com", "self-care")
+	suite.ErrorIs(err, app.ErrEmailTaken)
+}
+
+func (suite *AppTestSuite) TestApp_Login_NoPassword() {
+	suite.Repo.On("GetUserByEmail", suite.Ctx, "swimming@circles.com").
+		Return(&user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.Login(suite.Ctx, "swimming@circles.com", "")
+	suite.ErrorIs(err, app.ErrInvalidCredentials)
+}
+
+func (suite *AppTestSuite) TestApp_VerifyEmail_WrongPurpose() {
+	suite.Repo.On("GetToken", suite.Ctx, "token").
+		Return(&user.Token{Value: "token", Purpose: user.TokenResetPassword}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.VerifyEmail(suite.Ctx, "token")
+	suite.ErrorIs(err, app.ErrInvalidToken)
+}
+
+func (suite *AppTestSuite) TestApp_RestoreAd() {
+	id := int64(0)
+	suite.Repo.On("RestoreAdByID", suite.Ctx, id).
+		Return(nil).
+		Once()
+	suite.Repo.On("GetAdByID", suite.Ctx, id).
+		Return(&ads.Ad{ID: id}, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	ad, err := service.RestoreAd(suite.Ctx, id)
+	suite.Nil(err)
+	suite.Equal(id, ad.ID)
+}
+
+func (suite *AppTestSuite) TestApp_RestoreUser_NotFound() {
+	id := int64(0)
+	suite.Repo.On("GetAdList", suite.Ctx, app.ListAdsParams{Uid: &id, IncludeDeleted: true}).
+		Return(&ads.AdList{}, nil).
+		Once()
+	suite.Repo.On("RestoreUserByID", suite.Ctx, id).
+		Return(app.ErrUserNotFound).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	_, err := service.RestoreUser(suite.Ctx, id)
+	suite.ErrorIs(err, app.ErrUserNotFound)
+}
+
+func (suite *AppTestSuite) TestApp_PurgeDeleted() {
+	suite.Repo.On("PurgeDeleted", suite.Ctx, mock.MatchedBy(func(before time.Time) bool {
+		return time.Since(before) >= time.Hour
+	})).
+		Return(3, nil).
+		Once()
+	suite.Repo.On("ExpireJobResults", suite.Ctx, mock.AnythingOfType("time.Time")).
+		Return(2, nil).
+		Once()
+
+	service := app.NewApp(suite.Repo)
+	n, err := service.PurgeDeleted(suite.Ctx, time.Hour)
+	suite.Nil(err)
+	suite.Equal(5, n)
+}
+
 func TestAppSuite(t *testing.T) {
 	suite.Run(t, new(AppTestSuite))
 }
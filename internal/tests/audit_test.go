@@ -0,0 +1,397 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/auditlog"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type auditRecordData struct {
+	Actor      *int64          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"request_id"`
+	Protocol   string          `json:"protocol"`
+}
+
+type auditResponse struct {
+	Data []auditRecordData `json:"data"`
+}
+
+func (tc *testClient) queryAudit(query string) (auditResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, tc.baseURL+"/api/v1/admin/audit?"+query, nil)
+	if err != nil {
+		return auditResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response auditResponse
+	err = tc.getResponse(tc.asAdmin(req), &response)
+	if err != nil {
+		return auditResponse{}, err
+	}
+
+	return response, nil
+}
+
+type AuditSuite struct {
+	suite.Suite
+	Client *testClient
+	Sink   *auditlog.MemorySink
+}
+
+func (suite *AuditSuite) SetupTest() {
+	log.Println("Setting Up Test")
+
+	suite.Sink = auditlog.NewMemorySink()
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New(), app.WithAuditSink(suite.Sink)),
+		httpgin.WithAdminToken(testAdminToken))
+	testServer := httptest.NewServer(server.Handler)
+
+	suite.Client = &testClient{
+		client:     testServer.Client(),
+		baseURL:    testServer.URL,
+		adminToken: testAdminToken,
+	}
+}
+
+func (suite *AuditSuite) TestAudit_AdMutations() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, true)
+	suite.NoError(err)
+	_, err = suite.Client.updateAd(u.Data.ID, ad.Data.ID, "Self Care", "Swimming")
+	suite.NoError(err)
+	_, err = suite.Client.changeAdStatus(u.Data.ID+1, ad.Data.ID, false)
+	suite.ErrorIs(err, ErrForbidden)
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.NoError(err)
+
+	res, err := suite.Client.queryAudit(fmt.Sprintf("target_type=ad&target_id=%d", ad.Data.ID))
+	suite.NoError(err)
+	suite.Len(res.Data, 3)
+	suite.Equal("ad.create", res.Data[0].Action)
+	suite.Equal("ad.publish", res.Data[1].Action)
+	suite.Equal("ad.update", res.Data[2].Action)
+
+	update := res.Data[2]
+	suite.Equal(u.Data.ID, *update.Actor)
+	suite.Equal("HTTP", update.Protocol)
+	suite.NotEmpty(update.RequestID)
+	suite.Contains(string(update.Before), `"title":"Good News"`)
+	suite.Contains(string(update.After), `"title":"Self Care"`)
+
+	res, err = suite.Client.queryAudit(fmt.Sprintf("actor=%d", u.Data.ID))
+	suite.NoError(err)
+	suite.Len(res.Data, 3)
+}
+
+func (suite *AuditSuite) TestAudit_RequestID() {
+	req, err := http.NewRequest(http.MethodPost, suite.Client.baseURL+"/api/v1/users",
+		strings.NewReader(`{"nickname": "Mac Miller", "email": "swimming@circles.com"}`))
+	suite.NoError(err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(httpgin.RequestIDHeader, "the-divine-feminine")
+
+	resp, err := suite.Client.client.Do(req)
+	suite.NoError(err)
+	suite.Equal("the-divine-feminine", resp.Header.Get(httpgin.RequestIDHeader))
+	_ = resp.Body.Close()
+
+	res, err := suite.Client.queryAudit("target_type=user")
+	suite.NoError(err)
+	suite.Len(res.Data, 1)
+	suite.Equal("user.create", res.Data[0].Action)
+	suite.Equal("the-divine-feminine", res.Data[0].RequestID)
+	suite.Nil(res.Data[0].Actor)
+}
+
+func (suite *AuditSuite) TestAudit_TimeRange() {
+	_, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	from := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	res, err := suite.Client.queryAudit("from=" + from)
+	suite.NoError(err)
+	suite.Len(res.Data, 0)
+
+	res, err = suite.Client.queryAudit("to=" + from)
+	suite.NoError(err)
+	suite.Len(res.Data, 1)
+
+	_, err = suite.Client.queryAudit("from=yesterday")
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.queryAudit("actor=me")
+	suite.ErrorIs(err, ErrBadRequest)
+}
+
+// the erasure removes the profile and the ads of the user from the snapshots, the records stay
+func (suite *AuditSuite) TestAudit_ErasureRedactsSnapshots() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	_, err = suite.Client.updateUser(u.Data.ID, "Larry Fisherman", "faces@circles.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	other, err := suite.Client.createUser("KDot", "money@trees.com")
+	suite.NoError(err)
+
+	job, err := suite.Client.requestErasure(u.Data.ID)
+	suite.NoError(err)
+	job, err = suite.Client.waitJob(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+	suite.Equal("done", job.Data.Status)
+
+	for _, query := range []string{
+		fmt.Sprintf("target_type=user&target_id=%d", u.Data.ID),
+		fmt.Sprintf("target_type=ad&target_id=%d", ad.Data.ID),
+		fmt.Sprintf("actor=%d", u.Data.ID),
+	} {
+		res, err := suite.Client.queryAudit(query)
+		suite.NoError(err)
+		suite.NotEmpty(res.Data, query)
+		for _, r := range res.Data {
+			for _, snapshot := range []json.RawMessage{r.Before, r.After} {
+				suite.True(len(snapshot) == 0 || string(snapshot) == "null", "%s: %s", r.Action, snapshot)
+			}
+		}
+	}
+
+	// the records of the other users keep their snapshots
+	res, err := suite.Client.queryAudit(fmt.Sprintf("target_type=user&target_id=%d", other.Data.ID))
+	suite.NoError(err)
+	suite.Len(res.Data, 1)
+	suite.Contains(string(res.Data[0].After), "money@trees.com")
+}
+
+func TestAuditSuite(t *testing.T) {
+	suite.Run(t, new(AuditSuite))
+}
+
+func TestGRPCAudit(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	t.Cleanup(func() {
+		lis.Close()
+	})
+
+	sink := auditlog.NewMemorySink()
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(grpcPort.UnaryRequestInfoInterceptor))
+	t.Cleanup(func() {
+		srv.Stop()
+	})
+
+	svc := grpcPort.NewService(app.NewApp(adrepo.New(), app.WithAuditSink(sink)))
+	grpcPort.RegisterAdServiceServer(srv, svc)
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Println("srv.Serve:", err)
+		}
+	}()
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.DialContext: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	client := grpcPort.NewAdServiceClient(conn)
+	user, err := client.CreateUser(ctx, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	mdCtx := metadata.AppendToOutgoingContext(ctx, grpcPort.RequestIDMetadata, "forest-hill-drive")
+	var header metadata.MD
+	_, err = client.UpdateUser(mdCtx, &grpcPort.UpdateUserRequest{Id: &user.Id, Name: "Ivan", Email: "ivanov@yandex.ru"},
+		grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got := header.Get(grpcPort.RequestIDMetadata); len(got) != 1 || got[0] != "forest-hill-drive" {
+		t.Errorf("request id header: %v", got)
+	}
+
+	records, err := sink.Query(ctx, audit.Filter{Actor: &user.Id})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	r := records[0]
+	if r.Action != "user.update" || r.Protocol != audit.ProtocolGRPC || r.RequestID != "forest-hill-drive" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	ctx := context.Background()
+	actor, other := int64(1), int64(2)
+
+	sink, err := auditlog.NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	for _, r := range []audit.Record{
+		{Time: time.Now().UTC(), Actor: &actor, Action: "ad.create", TargetType: audit.TargetAd, TargetID: 5},
+		{Time: time.Now().UTC(), Actor: &other, Action: "ad.create", TargetType: audit.TargetAd, TargetID: 6},
+	} {
+		if err := sink.Append(ctx, r); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// the log survives reopening and new records are appended to the old ones
+	sink, err = auditlog.NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close()
+	err = sink.Append(ctx, audit.Record{Time: time.Now().UTC(), Actor: &actor, Action: "ad.delete",
+		TargetType: audit.TargetAd, TargetID: 5, Before: json.RawMessage(`{"id":5}`)})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	records, err := sink.Query(ctx, audit.Filter{Actor: &actor})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(records) != 2 || records[0].Action != "ad.create" || records[1].Action != "ad.delete" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if string(records[1].Before) != `{"id":5}` {
+		t.Errorf("before is not preserved: %s", records[1].Before)
+	}
+	// the redaction rewrites the file, the records are still appended to it afterwards
+	n, err := sink.Redact(ctx, audit.Filter{Actor: &actor}, audit.Filter{Actor: &other})
+	if err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected number of redacted records: %d", n)
+	}
+	err = sink.Append(ctx, audit.Record{Time: time.Now().UTC(), Actor: &other, Action: "ad.delete",
+		TargetType: audit.TargetAd, TargetID: 6, Before: json.RawMessage(`{"id":6}`)})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	records, err = sink.Query(ctx, audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(records) != 4 || records[2].Action != "ad.delete" || records[2].Before != nil {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if string(records[3].Before) != `{"id":6}` {
+		t.Errorf("before is not preserved: %s", records[3].Before)
+	}
+}
+
+// changeBeforeTransaction changes the ad right before the first transaction starts
+type changeBeforeTransaction struct {
+	app.Repository
+	adID    int64
+	changed bool
+}
+
+func (r *changeBeforeTransaction) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	if !r.changed {
+		r.changed = true
+		if err := r.Repository.UpdateAdContent(ctx, r.adID, "Blue World", "Circles", time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+	return r.Repository.InTransaction(ctx, fn)
+}
+
+// the state before is the one the change was applied to, even if the ad changed right before the transaction
+func TestAudit_BeforeIsReadInTransaction(t *testing.T) {
+	ctx := context.Background()
+	repo := adrepo.New()
+	u, err := app.NewApp(repo).CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := app.NewApp(repo).CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+
+	sink := auditlog.NewMemorySink()
+	a := app.NewApp(&changeBeforeTransaction{Repository: repo, adID: ad.ID}, app.WithAuditSink(sink))
+	_, err = a.UpdateAd(ctx, ad.ID, u.ID, "Self Care", "Swimming")
+	require.NoError(t, err)
+
+	records, err := sink.Query(ctx, audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Contains(t, string(records[0].Before), `"title":"Blue World"`)
+	assert.Contains(t, string(records[0].After), `"title":"Self Care"`)
+}
+
+func TestAudit_ImportPublishes(t *testing.T) {
+	ctx := context.Background()
+	sink := auditlog.NewMemorySink()
+	a := app.NewApp(adrepo.New(), app.WithAuditSink(sink))
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	report, err := a.ImportAds(ctx, u.ID, strings.NewReader("title,text,published\nBike,New,true\nCar,Old,false\n"),
+		app.BulkCSV, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Imported)
+
+	actions := make([]string, 0)
+	records, err := sink.Query(ctx, audit.Filter{Actor: &u.ID})
+	require.NoError(t, err)
+	for _, r := range records {
+		actions = append(actions, fmt.Sprintf("%s %d", r.Action, r.TargetID))
+	}
+	bike, car := *report.Rows[0].AdID, *report.Rows[1].AdID
+	assert.Equal(t, []string{
+		fmt.Sprintf("ad.create %d", bike),
+		fmt.Sprintf("ad.publish %d", bike),
+		fmt.Sprintf("ad.create %d", car),
+	}, actions)
+	assert.Contains(t, string(records[1].Before), `"published":false`)
+	assert.Contains(t, string(records[1].After), `"published":true`)
+}
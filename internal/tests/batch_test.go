@@ -0,0 +1,299 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/auditlog"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/audit"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"testing"
+)
+
+type batchEnv struct {
+	repo  *adrepo.RepositoryMap
+	app   app.App
+	u     int64
+	other int64
+	own   *ads.Ad
+	alien *ads.Ad
+}
+
+// newBatchEnv creates two users with an unpublished ad each
+func newBatchEnv(t *testing.T, opts ...app.Option) *batchEnv {
+	env := &batchEnv{repo: adrepo.NewRepositoryMap()}
+	env.app = app.NewApp(env.repo, opts...)
+	ctx := context.Background()
+	u, err := env.app.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := env.app.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	env.u, env.other = u.ID, other.ID
+	env.own, err = env.app.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	env.alien, err = env.app.CreateAd(ctx, "Blue car", "Fast", other.ID)
+	require.NoError(t, err)
+	return env
+}
+
+func (env *batchEnv) pending(t *testing.T) []string {
+	messages, err := env.repo.PendingMessages(context.Background(), 100)
+	require.NoError(t, err)
+	names := make([]string, 0, len(messages))
+	for _, m := range messages {
+		names = append(names, m.Event.EventName())
+	}
+	return names
+}
+
+func TestBatchAds_BestEffort(t *testing.T) {
+	env := newBatchEnv(t)
+	ctx := context.Background()
+
+	results, err := env.app.BatchAds(ctx, env.u, []app.BatchOp{
+		{Action: app.BatchCreate, Title: "Green bike", Text: "New"},
+		{Action: app.BatchPublish, AdID: env.own.ID},
+		{Action: app.BatchDelete, AdID: env.alien.ID},
+		{Action: app.BatchCreate, Title: "", Text: "No title"},
+		{Action: "archive", AdID: env.own.ID},
+		{Action: app.BatchUnpublish, AdID: 100},
+	}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	assert.NoError(t, results[0].Err)
+	require.NotNil(t, results[0].Ad)
+	assert.Equal(t, results[0].AdID, results[0].Ad.ID)
+	assert.NoError(t, results[1].Err)
+	assert.True(t, results[1].Ad.Published)
+	assert.ErrorIs(t, results[2].Err, app.ErrForbidden)
+	assert.True(t, errors.As(results[3].Err, &validator.ValidationErrors{}))
+	assert.ErrorIs(t, results[4].Err, app.ErrUnknownBatchAction)
+	assert.ErrorIs(t, results[5].Err, app.ErrAdNotFound)
+
+	// the successful operations stay applied
+	created, err := env.app.GetAd(ctx, results[0].AdID)
+	require.NoError(t, err)
+	assert.Equal(t, "Green bike", created.Title)
+	_, err = env.app.GetAd(ctx, env.alien.ID)
+	assert.NoError(t, err)
+}
+
+func TestBatchAds_Atomic(t *testing.T) {
+	env := newBatchEnv(t)
+	ctx := context.Background()
+	before := env.pending(t)
+
+	results, err := env.app.BatchAds(ctx, env.u, []app.BatchOp{
+		{Action: app.BatchCreate, Title: "Green bike", Text: "New"},
+		{Action: app.BatchPublish, AdID: env.own.ID},
+		{Action: app.BatchDelete, AdID: env.alien.ID},
+	}, true)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, app.ErrBatchAborted)
+	assert.ErrorIs(t, results[1].Err, app.ErrBatchAborted)
+	assert.ErrorIs(t, results[2].Err, app.ErrForbidden)
+	for _, res := range results {
+		assert.Nil(t, res.Ad)
+	}
+
+	// nothing is applied, even the events are not written
+	assert.Equal(t, before, env.pending(t))
+	ad, err := env.app.GetAd(ctx, env.own.ID)
+	require.NoError(t, err)
+	assert.False(t, ad.Published)
+	list, err := env.app.ListAds(ctx, app.ListAdsParams{Uid: &env.u})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+
+	// the operations see the changes made by the previous ones
+	results, err = env.app.BatchAds(ctx, env.u, []app.BatchOp{
+		{Action: app.BatchCreate, Title: "Green bike", Text: "New"},
+		{Action: app.BatchPublish, AdID: env.own.ID},
+		{Action: app.BatchUnpublish, AdID: env.own.ID},
+		{Action: app.BatchDelete, AdID: env.own.ID},
+		{Action: app.BatchPublish, AdID: env.own.ID},
+	}, true)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[4].Err, app.ErrAdNotFound)
+	assert.Equal(t, before, env.pending(t))
+
+	results, err = env.app.BatchAds(ctx, env.u, []app.BatchOp{
+		{Action: app.BatchCreate, Title: "Green bike", Text: "New"},
+		{Action: app.BatchPublish, AdID: env.own.ID},
+		{Action: app.BatchUnpublish, AdID: env.own.ID},
+		{Action: app.BatchDelete, AdID: env.own.ID},
+	}, true)
+	require.NoError(t, err)
+	for _, res := range results {
+		assert.NoError(t, res.Err)
+	}
+	assert.True(t, results[1].Ad.Published)
+	assert.False(t, results[2].Ad.Published)
+	assert.Nil(t, results[3].Ad)
+	created, err := env.app.GetAd(ctx, results[0].AdID)
+	require.NoError(t, err)
+	assert.Equal(t, results[0].Ad, created)
+	_, err = env.app.GetAd(ctx, env.own.ID)
+	assert.ErrorIs(t, err, app.ErrAdNotFound)
+	assert.Equal(t, append(before, events.NameAdCreated, events.NameAdPublished, events.NameAdUnpublished,
+		events.NameAdDeleted), env.pending(t))
+}
+
+func TestBatchAds_NotVerified(t *testing.T) {
+	env := newBatchEnv(t)
+	ctx := context.Background()
+	require.NoError(t, env.repo.SetUserVerified(ctx, env.u, false))
+
+	for _, atomic := range []bool{false, true} {
+		results, err := env.app.BatchAds(ctx, env.u, []app.BatchOp{
+			{Action: app.BatchUnpublish, AdID: env.own.ID},
+			{Action: app.BatchPublish, AdID: env.own.ID},
+		}, atomic)
+		require.NoError(t, err)
+		assert.ErrorIs(t, results[1].Err, app.ErrUserNotVerified)
+	}
+}
+
+func TestBatchAds_Invalid(t *testing.T) {
+	env := newBatchEnv(t)
+	ctx := context.Background()
+
+	_, err := env.app.BatchAds(ctx, env.u, nil, false)
+	assert.ErrorIs(t, err, app.ErrInvalidBatch)
+	ops := make([]app.BatchOp, app.MaxBatchSize+1)
+	for i := range ops {
+		ops[i] = app.BatchOp{Action: app.BatchCreate, Title: "Bike", Text: "New"}
+	}
+	_, err = env.app.BatchAds(ctx, env.u, ops, true)
+	assert.ErrorIs(t, err, app.ErrInvalidBatch)
+
+	// the author of created ads must exist
+	results, err := env.app.BatchAds(ctx, 100, []app.BatchOp{{Action: app.BatchCreate, Title: "Bike", Text: "New"}}, true)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, app.ErrUserNotFound)
+}
+
+func TestBatchAds_Audit(t *testing.T) {
+	sink := auditlog.NewMemorySink()
+	env := newBatchEnv(t, app.WithAuditSink(sink))
+	ctx := context.Background()
+
+	results, err := env.app.BatchAds(ctx, env.u, []app.BatchOp{
+		{Action: app.BatchCreate, Title: "Green bike", Text: "New"},
+		{Action: app.BatchDelete, AdID: env.own.ID},
+		{Action: app.BatchDelete, AdID: env.alien.ID},
+	}, false)
+	require.NoError(t, err)
+	require.ErrorIs(t, results[2].Err, app.ErrForbidden)
+
+	records, err := sink.Query(ctx, audit.Filter{Actor: &env.u})
+	require.NoError(t, err)
+	actions := make(map[string]audit.Record)
+	for _, r := range records {
+		actions[r.Action] = r
+	}
+	require.Contains(t, actions, "ad.create")
+	assert.Equal(t, results[0].AdID, actions["ad.create"].TargetID)
+	require.Contains(t, actions, "ad.delete")
+	assert.Equal(t, env.own.ID, actions["ad.delete"].TargetID)
+	assert.NotEmpty(t, actions["ad.delete"].Before)
+	assert.Empty(t, actions["ad.delete"].After)
+	// the failed operation is not recorded
+	for _, r := range records {
+		assert.NotEqual(t, env.alien.ID, r.TargetID)
+	}
+}
+
+func TestHTTPBatchAds(t *testing.T) {
+	env := newBatchEnv(t)
+	client := getTestClientWithApp(env.app)
+
+	resp, err := client.batchAds(env.u, false, []map[string]any{
+		{"op": "create", "title": "Green bike", "text": "New"},
+		{"op": "publish", "ad_id": env.own.ID},
+		{"op": "delete", "ad_id": env.alien.ID},
+		{"op": "create", "title": "", "text": "No title"},
+	})
+	require.NoError(t, err)
+	results := resp.Data.Results
+	require.Len(t, results, 4)
+	assert.Equal(t, 2, resp.Data.Failed)
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Equal(t, "Green bike", results[0].Ad.Title)
+	assert.Nil(t, results[0].Error)
+	assert.Equal(t, "publish", results[1].Op)
+	assert.True(t, results[1].Ad.Published)
+	assert.Equal(t, http.StatusForbidden, results[2].Status)
+	require.NotNil(t, results[2].Error)
+	assert.Nil(t, results[2].Ad)
+	assert.Equal(t, http.StatusBadRequest, results[3].Status)
+
+	resp, err = client.batchAds(env.u, true, []map[string]any{
+		{"op": "unpublish", "ad_id": env.own.ID},
+		{"op": "delete", "ad_id": 100},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Data.Failed)
+	assert.Equal(t, http.StatusConflict, resp.Data.Results[0].Status)
+	assert.Equal(t, http.StatusNotFound, resp.Data.Results[1].Status)
+	ad, err := client.getAd(env.own.ID)
+	require.NoError(t, err)
+	assert.True(t, ad.Data.Published)
+
+	_, err = client.batchAds(env.u, true, []map[string]any{})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.batchAds("abc", true, []map[string]any{{"op": "create"}})
+	assert.ErrorIs(t, err, ErrBadRequest)
+}
+
+func (suite *GRPCSuite) TestGRPCBatchAds() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "hello", Text: "world", UserId: &u.Id})
+	suite.NoError(err)
+
+	resp, err := suite.Client.BatchAds(suite.Context, &grpcPort.BatchAdsRequest{
+		UserId: &u.Id,
+		Atomic: true,
+		Operations: []*grpcPort.BatchAdOperation{
+			{Op: "create", Title: "bye", Text: "world"},
+			{Op: "publish", AdId: ad.Id},
+		},
+	})
+	suite.NoError(err)
+	suite.Equal(int64(0), resp.Failed)
+	suite.Equal("bye", resp.Results[0].Ad.GetTitle())
+	suite.Equal(resp.Results[0].AdId, resp.Results[0].Ad.GetId())
+	suite.True(resp.Results[1].Ad.GetPublished())
+
+	resp, err = suite.Client.BatchAds(suite.Context, &grpcPort.BatchAdsRequest{
+		UserId: &u.Id,
+		Atomic: true,
+		Operations: []*grpcPort.BatchAdOperation{
+			{Op: "delete", AdId: ad.Id},
+			{Op: "archive", AdId: ad.Id},
+		},
+	})
+	suite.NoError(err)
+	suite.Equal(int64(2), resp.Failed)
+	suite.Equal(uint32(codes.Aborted), resp.Results[0].Code)
+	suite.Equal(uint32(codes.InvalidArgument), resp.Results[1].Code)
+	suite.NotEmpty(resp.Results[1].Error)
+	_, err = suite.Client.GetAd(suite.Context, &grpcPort.GetAdRequest{AdId: &ad.Id})
+	suite.NoError(err)
+
+	_, err = suite.Client.BatchAds(suite.Context, &grpcPort.BatchAdsRequest{UserId: &u.Id})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.BatchAds(suite.Context, &grpcPort.BatchAdsRequest{})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type batchResultData struct {
+	Op     string  `json:"op"`
+	AdID   int64   `json:"ad_id"`
+	Status int     `json:"status"`
+	Ad     *adData `json:"ad"`
+	Error  *string `json:"error"`
+}
+
+type batchResponse struct {
+	Data struct {
+		Results []batchResultData `json:"results"`
+		Failed  int               `json:"failed"`
+	} `json:"data"`
+}
+
+func (tc *testClient) batchAds(userID any, atomic bool, operations []map[string]any) (batchResponse, error) {
+	body := map[string]any{
+		"user_id":    userID,
+		"atomic":     atomic,
+		"operations": operations,
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return batchResponse{}, fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, tc.baseURL+"/api/v1/ads/batch", bytes.NewReader(data))
+	if err != nil {
+		return batchResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	var response batchResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return batchResponse{}, err
+	}
+
+	return response, nil
+}
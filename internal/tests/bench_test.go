@@ -2,12 +2,14 @@ package tests
 
 import (
 	"context"
+	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
 	"github.com/TobbyMax/ad-service.git/internal/user"
 	"log"
 	"testing"
+	"time"
 )
 
 var (
@@ -16,11 +18,18 @@ var (
 	Ad        = ads.Ad{Title: "Missed Calls", Text: "Blue Slide Park"}
 )
 
+// benchUser returns the fixture user with the unique email, as the emails of the users must not repeat
+func benchUser(i int) user.User {
+	u := U
+	u.Email = fmt.Sprintf("u%d@circles.com", i)
+	return u
+}
+
 func BenchmarkRepoCreateUser(b *testing.B) {
 	ctx := context.Background()
 	repo := adrepo.New()
 	for i := 0; i < b.N; i++ {
-		id, err := repo.AddUser(ctx, U)
+		id, err := repo.AddUser(ctx, benchUser(i))
 		if err != nil {
 			log.Fatalf("Function returned error: %v", err)
 		}
@@ -119,3 +128,120 @@ func BenchmarkRepoListAds1000(b *testing.B) {
 		BenchSink++
 	}
 }
+
+// fillRepo adds n ads of 1000 authors spread over a year, every tenth ad is published
+func fillRepo(b *testing.B, n int) app.Repository {
+	ctx := context.Background()
+	repo := adrepo.New()
+	for i := 0; i < 1000; i++ {
+		if _, err := repo.AddUser(ctx, benchUser(i)); err != nil {
+			b.Fatalf("Function returned error: %v", err)
+		}
+	}
+	start := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		ad := ads.Ad{
+			Title:       fmt.Sprintf("Ad %d", i%5000),
+			Text:        Ad.Text,
+			AuthorID:    int64(i % 1000),
+			Published:   i%10 == 0,
+			DateCreated: start.AddDate(0, 0, i%365),
+		}
+		if _, err := repo.AddAd(ctx, ad); err != nil {
+			b.Fatalf("Function returned error: %v", err)
+		}
+	}
+	return repo
+}
+
+// BenchmarkRepoListAds100k compares the queries served by the indexes with the full scan of the table,
+// which is still needed for the text search
+func BenchmarkRepoListAds100k(b *testing.B) {
+	ctx := context.Background()
+	repo := fillRepo(b, 100000)
+	published, uid, title, text := true, int64(7), "Ad 42", "park"
+	date := time.Date(2023, time.March, 8, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name   string
+		params app.ListAdsParams
+	}{
+		{"Scan", app.ListAdsParams{Text: &text}},
+		{"Published", app.ListAdsParams{Published: &published}},
+		{"Author", app.ListAdsParams{Uid: &uid}},
+		{"Title", app.ListAdsParams{Title: &title}},
+		{"Date", app.ListAdsParams{Date: &date}},
+		{"PublishedByAuthorAndDate", app.ListAdsParams{Published: &published, Uid: &uid, Date: &date}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				al, err := repo.GetAdList(ctx, c.params)
+				if err != nil {
+					b.Fatalf("Function returned error: %v", err)
+				}
+				BenchSink += int64(len(al.Data))
+			}
+		})
+	}
+}
+
+// The parallel benchmarks show how the reads scale with the cores, run them with -cpu 1,2,4,8
+
+func BenchmarkRepoParallelGetAd(b *testing.B) {
+	ctx := context.Background()
+	n := 10000
+	repo := fillRepo(b, n)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := int64(0)
+		for pb.Next() {
+			ad, err := repo.GetAdByID(ctx, id%int64(n))
+			if err != nil {
+				b.Fatalf("Function returned error: %v", err)
+			}
+			BenchSink += ad.ID
+			id++
+		}
+	})
+}
+
+func BenchmarkRepoParallelListAds(b *testing.B) {
+	ctx := context.Background()
+	repo := fillRepo(b, 10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		uid := int64(0)
+		for pb.Next() {
+			params := app.ListAdsParams{Uid: &uid}
+			al, err := repo.GetAdList(ctx, params)
+			if err != nil {
+				b.Fatalf("Function returned error: %v", err)
+			}
+			BenchSink += int64(len(al.Data))
+			uid = (uid + 1) % 1000
+		}
+	})
+}
+
+// BenchmarkRepoParallelMixed makes every tenth operation a write
+func BenchmarkRepoParallelMixed(b *testing.B) {
+	ctx := context.Background()
+	n := 10000
+	repo := fillRepo(b, n)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			id := int64(i % n)
+			if i%10 == 0 {
+				if err := repo.UpdateAdStatus(ctx, id, i%20 == 0, time.Now().UTC()); err != nil {
+					b.Fatalf("Function returned error: %v", err)
+				}
+			} else if _, err := repo.GetAdByID(ctx, id); err != nil {
+				b.Fatalf("Function returned error: %v", err)
+			}
+			i++
+		}
+	})
+}
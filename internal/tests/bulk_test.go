@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogue has the columns in another order than the export, an unknown column and a byte order mark
+const catalogue = "\ufeffText,note,Title,published\n" +
+	"Almost new,-,Red bike,true\n" +
+	"No title,-,,false\n" +
+	"\"Two lines\nof text\",-,Blue car,\n" +
+	"Cheap,-,Green bike,maybe\n" +
+	"Short row\n" +
+	"Fast,-,Black car,FALSE\n"
+
+func TestImportAds_CSV(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	report, err := a.ImportAds(ctx, u.ID, strings.NewReader(catalogue), app.BulkCSV, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 3, report.Failed)
+	require.Len(t, report.Rows, 6)
+	lines := make([]int, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		lines = append(lines, row.Line)
+		assert.Nil(t, row.AdID)
+	}
+	assert.Equal(t, []int{2, 3, 4, 6, 7, 8}, lines)
+	assert.NoError(t, report.Rows[0].Err)
+	assert.True(t, errors.As(report.Rows[1].Err, &validator.ValidationErrors{}))
+	assert.NoError(t, report.Rows[2].Err)
+	assert.ErrorIs(t, report.Rows[3].Err, app.ErrInvalidRow)
+	assert.ErrorIs(t, report.Rows[4].Err, app.ErrInvalidRow)
+	// the dry run creates nothing
+	list, err := a.ListAds(ctx, app.ListAdsParams{Uid: &u.ID})
+	require.NoError(t, err)
+	assert.Empty(t, list.Data)
+
+	report, err = a.ImportAds(ctx, u.ID, strings.NewReader(catalogue), app.BulkCSV, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Imported)
+	require.NotNil(t, report.Rows[0].AdID)
+	ad, err := a.GetAd(ctx, *report.Rows[0].AdID)
+	require.NoError(t, err)
+	assert.Equal(t, "Red bike", ad.Title)
+	assert.True(t, ad.Published)
+	ad, err = a.GetAd(ctx, *report.Rows[2].AdID)
+	require.NoError(t, err)
+	assert.Equal(t, "Two lines\nof text", ad.Text)
+	assert.False(t, ad.Published)
+	assert.Nil(t, report.Rows[1].AdID)
+}
+
+func TestImportAds_JSONL(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	require.NoError(t, repo.SetUserVerified(ctx, u.ID, false))
+
+	file := `{"title": "Red bike", "text": "Almost new", "id": 100}` + "\n\n" +
+		`{"title": "Blue car", "text": "Fast", "published": true}` + "\n" +
+		`{"title": "Green bike",` + "\n" +
+		`{"title": "Black car", "text": "` + strings.Repeat("a", 500) + `"}`
+	report, err := a.ImportAds(ctx, u.ID, strings.NewReader(file), app.BulkJSONL, false)
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 4)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, 1, report.Rows[0].Line)
+	assert.NoError(t, report.Rows[0].Err)
+	// a published ad needs a verified author, as in ChangeAdStatus
+	assert.Equal(t, 3, report.Rows[1].Line)
+	assert.ErrorIs(t, report.Rows[1].Err, app.ErrUserNotVerified)
+	assert.ErrorIs(t, report.Rows[2].Err, app.ErrInvalidRow)
+	assert.True(t, errors.As(report.Rows[3].Err, &validator.ValidationErrors{}))
+
+	ad, err := a.GetAd(ctx, *report.Rows[0].AdID)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, ad.AuthorID)
+	assert.NotEqual(t, int64(100), ad.ID)
+}
+
+// failingPublish fails to publish ads, the ads created for the rows must be rolled back
+type failingPublish struct {
+	app.Repository
+}
+
+var errTestPublish = errors.New("publishing is down")
+
+func (r failingPublish) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	return r.Repository.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		return fn(ctx, failingPublish{Repository: tx})
+	})
+}
+
+func (r failingPublish) UpdateAdStatus(ctx context.Context, id int64, published bool, date time.Time) error {
+	return errTestPublish
+}
+
+func TestImportAds_PublishInSameTransaction(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(failingPublish{Repository: repo})
+	ctx := context.Background()
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	pending, err := repo.PendingMessages(ctx, 100)
+	require.NoError(t, err)
+
+	file := `{"title": "Red bike", "text": "Almost new", "published": true}` + "\n" +
+		`{"title": "Blue car", "text": "Fast"}`
+	report, err := a.ImportAds(ctx, u.ID, strings.NewReader(file), app.BulkJSONL, false)
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 2)
+	assert.ErrorIs(t, report.Rows[0].Err, errTestPublish)
+	assert.Nil(t, report.Rows[0].AdID)
+	assert.NoError(t, report.Rows[1].Err)
+
+	// only the unpublished row left an ad and its event
+	list, err := a.ListAds(ctx, app.ListAdsParams{Uid: &u.ID})
+	require.NoError(t, err)
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, "Blue car", list.Data[0].Title)
+	after, err := repo.PendingMessages(ctx, 100)
+	require.NoError(t, err)
+	assert.Len(t, after, len(pending)+1)
+}
+
+func TestImportAds_Invalid(t *testing.T) {
+	a := app.NewApp(adrepo.New())
+	ctx := context.Background()
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	_, err = a.ImportAds(ctx, u.ID, strings.NewReader("name,description\nRed bike,Almost new\n"), app.BulkCSV, false)
+	assert.ErrorIs(t, err, app.ErrInvalidImport)
+	_, err = a.ImportAds(ctx, u.ID, strings.NewReader(""), app.BulkCSV, false)
+	assert.ErrorIs(t, err, app.ErrInvalidImport)
+	_, err = a.ImportAds(ctx, u.ID, strings.NewReader(strings.Repeat("a", 2<<20)), app.BulkJSONL, false)
+	assert.ErrorIs(t, err, app.ErrInvalidImport)
+	_, err = a.ImportAds(ctx, 100, strings.NewReader(catalogue), app.BulkCSV, false)
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+
+	_, err = app.ParseBulkFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestExportAds(t *testing.T) {
+	a := app.NewApp(adrepo.New())
+	ctx := context.Background()
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := a.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	_, err = a.ImportAds(ctx, u.ID, strings.NewReader(catalogue), app.BulkCSV, false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, a.ExportAds(ctx, &u.ID, app.BulkCSV, &buf))
+	records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, []string{"id", "title", "text", "author_id", "published", "date_created", "date_changed"}, records[0])
+	assert.Equal(t, "Red bike", records[1][1])
+	assert.Equal(t, "true", records[1][4])
+	assert.Equal(t, "Two lines\nof text", records[2][2])
+
+	// the export is imported back as it is
+	report, err := a.ImportAds(ctx, other.ID, bytes.NewReader(buf.Bytes()), app.BulkCSV, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 0, report.Failed)
+
+	// all ads means only the published ones
+	buf.Reset()
+	require.NoError(t, a.ExportAds(ctx, nil, app.BulkJSONL, &buf))
+	dec := json.NewDecoder(&buf)
+	titles := make([]string, 0)
+	for {
+		var ad adData
+		if err := dec.Decode(&ad); errors.Is(err, io.EOF) {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		assert.True(t, ad.Published)
+		titles = append(titles, ad.Title)
+	}
+	assert.Equal(t, []string{"Red bike", "Red bike"}, titles)
+
+	missing := int64(100)
+	assert.ErrorIs(t, a.ExportAds(ctx, &missing, app.BulkCSV, io.Discard), app.ErrUserNotFound)
+}
+
+func TestHTTPImportExportAds(t *testing.T) {
+	client := getTestClientWithApp(app.NewApp(adrepo.New()))
+	u, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	report, err := client.importAds(u.Data.ID, url.Values{"dry_run": {"true"}}, catalogue)
+	require.NoError(t, err)
+	assert.True(t, report.Data.DryRun)
+	assert.Equal(t, 3, report.Data.Failed)
+	assert.Equal(t, http.StatusOK, report.Data.Rows[0].Status)
+	assert.Equal(t, http.StatusBadRequest, report.Data.Rows[1].Status)
+	assert.NotNil(t, report.Data.Rows[1].Error)
+
+	file := `{"title": "Red bike", "text": "Almost new", "published": true}` + "\n"
+	report, err = client.uploadAds(u.Data.ID, url.Values{"format": {"jsonl"}}, "ads.jsonl", file)
+	require.NoError(t, err)
+	assert.False(t, report.Data.DryRun)
+	assert.Equal(t, 1, report.Data.Imported)
+	require.NotNil(t, report.Data.Rows[0].AdID)
+	ad, err := client.getAd(*report.Data.Rows[0].AdID)
+	require.NoError(t, err)
+	assert.True(t, ad.Data.Published)
+
+	_, err = client.importAds(u.Data.ID, url.Values{"format": {"xml"}}, catalogue)
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.importAds(u.Data.ID, url.Values{"dry_run": {"maybe"}}, catalogue)
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.importAds(u.Data.ID, nil, "name\nRed bike\n")
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.importAds(100, nil, catalogue)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	body, contentType, err := client.exportAds(url.Values{"user_id": {"0"}, "format": {"jsonl"}})
+	require.NoError(t, err)
+	assert.Contains(t, contentType, app.ContentTypeJSONL)
+	assert.Contains(t, body, `"title":"Red bike"`)
+	body, contentType, err = client.exportAds(nil)
+	require.NoError(t, err)
+	assert.Contains(t, contentType, app.ContentTypeCSV)
+	assert.True(t, strings.HasPrefix(body, "id,title,text"))
+
+	_, _, err = client.exportAds(url.Values{"user_id": {"100"}})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = client.exportAds(url.Values{"format": {"xml"}})
+	assert.ErrorIs(t, err, ErrBadRequest)
+}
+
+func (suite *GRPCSuite) TestGRPCImportExportAds() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	stream, err := suite.Client.ImportAds(suite.Context)
+	suite.NoError(err)
+	// the file is split in small chunks, rows are cut in the middle
+	file := []byte("title,text\nRed bike,Almost new\n,No title\nBlue car,Fast\n")
+	suite.NoError(stream.Send(&grpcPort.ImportAdsRequest{UserId: &u.Id, Chunk: file[:5]}))
+	for i := 5; i < len(file); i += 7 {
+		end := i + 7
+		if end > len(file) {
+			end = len(file)
+		}
+		suite.NoError(stream.Send(&grpcPort.ImportAdsRequest{Chunk: file[i:end]}))
+	}
+	report, err := stream.CloseAndRecv()
+	suite.NoError(err)
+	suite.Equal(int64(2), report.Imported)
+	suite.Len(report.Rows, 3)
+	suite.NotNil(report.Rows[0].AdId)
+	suite.Equal(uint32(codes.InvalidArgument), report.Rows[1].Code)
+
+	export, err := suite.Client.ExportAds(suite.Context, &grpcPort.ExportAdsRequest{UserId: &u.Id, Format: "jsonl"})
+	suite.NoError(err)
+	var buf bytes.Buffer
+	for {
+		chunk, err := export.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		suite.NoError(err)
+		buf.Write(chunk.Chunk)
+	}
+	suite.Equal(2, strings.Count(buf.String(), "\n"))
+	suite.Contains(buf.String(), `"title":"Blue car"`)
+
+	stream, err = suite.Client.ImportAds(suite.Context)
+	suite.NoError(err)
+	suite.NoError(stream.Send(&grpcPort.ImportAdsRequest{Chunk: file}))
+	_, err = stream.CloseAndRecv()
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	missing := int64(100)
+	export, err = suite.Client.ExportAds(suite.Context, &grpcPort.ExportAdsRequest{UserId: &missing})
+	suite.NoError(err)
+	_, err = export.Recv()
+	suite.Equal(codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+type importRowData struct {
+	Line   int     `json:"line"`
+	AdID   *int64  `json:"ad_id"`
+	Status int     `json:"status"`
+	Error  *string `json:"error"`
+}
+
+type importReportResponse struct {
+	Data struct {
+		DryRun   bool            `json:"dry_run"`
+		Imported int             `json:"imported"`
+		Failed   int             `json:"failed"`
+		Rows     []importRowData `json:"rows"`
+	} `json:"data"`
+}
+
+func (tc *testClient) importAds(userID any, query url.Values, file string) (importReportResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/ads/import?%s", userID, query.Encode()),
+		bytes.NewReader([]byte(file)))
+	if err != nil {
+		return importReportResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "text/csv")
+
+	var response importReportResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return importReportResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) uploadAds(userID any, query url.Values, name string, file string) (importReportResponse, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return importReportResponse{}, fmt.Errorf("unable to create form: %w", err)
+	}
+	if _, err := fw.Write([]byte(file)); err != nil {
+		return importReportResponse{}, fmt.Errorf("unable to create form: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return importReportResponse{}, fmt.Errorf("unable to create form: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/ads/import?%s", userID, query.Encode()), &body)
+	if err != nil {
+		return importReportResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", w.FormDataContentType())
+
+	var response importReportResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return importReportResponse{}, err
+	}
+
+	return response, nil
+}
+
+// exportAds returns the downloaded file and its content type
+func (tc *testClient) exportAds(query url.Values) (string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, tc.baseURL+"/api/v1/ads/export?"+query.Encode(), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to create request: %w", err)
+	}
+
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("unexpected error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		return "", "", ErrBadRequest
+	case http.StatusNotFound:
+		return "", "", ErrNotFound
+	default:
+		return "", "", fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read response: %w", err)
+	}
+	return string(body), resp.Header.Get("Content-Type"), nil
+}
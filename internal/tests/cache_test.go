@@ -0,0 +1,325 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/cacherepo"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepo counts the reads, which reach the wrapped repository
+type countingRepo struct {
+	app.Repository
+	adReads int64
+}
+
+func (r *countingRepo) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error) {
+	atomic.AddInt64(&r.adReads, 1)
+	return r.Repository.GetAdByID(ctx, id)
+}
+
+type cacheEnv struct {
+	inner *countingRepo
+	repo  *cacherepo.Repository
+	uid   int64
+	ids   []int64
+}
+
+// newCacheEnv creates a user with two unpublished ads
+func newCacheEnv(t *testing.T, opts ...cacherepo.Option) *cacheEnv {
+	env := &cacheEnv{inner: &countingRepo{Repository: adrepo.New()}}
+	env.repo = cacherepo.New(env.inner, opts...)
+	ctx := context.Background()
+	var err error
+	env.uid, err = env.repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	for _, title := range []string{"Bike", "Car"} {
+		id, err := env.repo.AddAd(ctx, ads.Ad{Title: title, Text: "New", AuthorID: env.uid})
+		require.NoError(t, err)
+		env.ids = append(env.ids, id)
+	}
+	return env
+}
+
+func TestCacheRepo_Ads(t *testing.T) {
+	env := newCacheEnv(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		ad, err := env.repo.GetAdByID(ctx, env.ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "Bike", ad.Title)
+	}
+	_, err := env.repo.GetAdByID(ctx, env.ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), env.inner.adReads)
+	stats := env.repo.Stats().Ads
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+
+	// the write invalidates only the changed ad
+	require.NoError(t, env.repo.UpdateAdContent(ctx, env.ids[0], "Green bike", "New", time.Now().UTC()))
+	ad, err := env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Green bike", ad.Title)
+	_, err = env.repo.GetAdByID(ctx, env.ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), env.repo.Stats().Ads.Hits)
+
+	require.NoError(t, env.repo.UpdateAdStatus(ctx, env.ids[0], true, time.Now().UTC()))
+	ad, err = env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.True(t, ad.Published)
+
+	require.NoError(t, env.repo.DeleteAdByID(ctx, env.ids[0]))
+	_, err = env.repo.GetAdByID(ctx, env.ids[0])
+	assert.ErrorIs(t, err, app.ErrAdNotFound)
+
+	// the returned ads are copies
+	ad, err = env.repo.GetAdByID(ctx, env.ids[1])
+	require.NoError(t, err)
+	ad.Title = "Changed"
+	ad, err = env.repo.GetAdByID(ctx, env.ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, "Car", ad.Title)
+}
+
+func TestCacheRepo_Users(t *testing.T) {
+	env := newCacheEnv(t)
+	ctx := context.Background()
+
+	u, err := env.repo.GetUserByID(ctx, env.uid)
+	require.NoError(t, err)
+	assert.False(t, u.Verified)
+	require.NoError(t, env.repo.SetUserVerified(ctx, env.uid, true))
+	u, err = env.repo.GetUserByID(ctx, env.uid)
+	require.NoError(t, err)
+	assert.True(t, u.Verified)
+
+	require.NoError(t, env.repo.UpdateUser(ctx, env.uid, "Larry Fisherman", "swimming@circles.com"))
+	u, err = env.repo.GetUserByID(ctx, env.uid)
+	require.NoError(t, err)
+	assert.Equal(t, "Larry Fisherman", u.Nickname)
+
+	// deleting the user invalidates the user's ads
+	for _, id := range env.ids {
+		_, err := env.repo.GetAdByID(ctx, id)
+		require.NoError(t, err)
+	}
+	require.NoError(t, env.repo.DeleteUserByID(ctx, env.uid))
+	_, err = env.repo.GetUserByID(ctx, env.uid)
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+	for _, id := range env.ids {
+		_, err := env.repo.GetAdByID(ctx, id)
+		assert.ErrorIs(t, err, app.ErrAdNotFound)
+	}
+
+	require.NoError(t, env.repo.RestoreUserByID(ctx, env.uid))
+	_, err = env.repo.GetAdByID(ctx, env.ids[0])
+	assert.NoError(t, err)
+}
+
+func TestCacheRepo_Lists(t *testing.T) {
+	env := newCacheEnv(t, cacherepo.WithListCache(10, 100*time.Millisecond))
+	ctx := context.Background()
+	published, title := true, "Bike"
+
+	list, err := env.repo.GetAdList(ctx, app.ListAdsParams{Published: &published})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 0)
+	list, err = env.repo.GetAdList(ctx, app.ListAdsParams{Title: &title})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+	_, err = env.repo.GetAdList(ctx, app.ListAdsParams{Published: &published})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), env.repo.Stats().Lists.Hits)
+
+	// the list of published ads does not contain the ad, but matches its new version
+	require.NoError(t, env.repo.UpdateAdStatus(ctx, env.ids[1], true, time.Now().UTC()))
+	list, err = env.repo.GetAdList(ctx, app.ListAdsParams{Published: &published})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+	// the list of bikes neither contains the car nor matches it, so it is kept
+	list, err = env.repo.GetAdList(ctx, app.ListAdsParams{Title: &title})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+	assert.Equal(t, uint64(2), env.repo.Stats().Lists.Hits)
+
+	// the list containing the ad is invalidated, when the ad stops matching it
+	require.NoError(t, env.repo.UpdateAdContent(ctx, env.ids[0], "Green bike", "New", time.Now().UTC()))
+	list, err = env.repo.GetAdList(ctx, app.ListAdsParams{Title: &title})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 0)
+
+	// lists expire without writes as well
+	_, err = env.inner.AddAd(ctx, ads.Ad{Title: "Bike", Text: "Old", AuthorID: env.uid})
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+	list, err = env.repo.GetAdList(ctx, app.ListAdsParams{Title: &title})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+}
+
+func TestCacheRepo_Eviction(t *testing.T) {
+	env := newCacheEnv(t, cacherepo.WithAdCache(1, time.Minute))
+	ctx := context.Background()
+
+	for _, id := range []int64{env.ids[0], env.ids[1], env.ids[0]} {
+		_, err := env.repo.GetAdByID(ctx, id)
+		require.NoError(t, err)
+	}
+	stats := env.repo.Stats().Ads
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, 1, stats.Size)
+
+	// the ads expire after the TTL
+	env = newCacheEnv(t, cacherepo.WithAdCache(10, 50*time.Millisecond))
+	_, err := env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), env.inner.adReads)
+}
+
+func TestCacheRepo_Transactions(t *testing.T) {
+	env := newCacheEnv(t)
+	ctx := context.Background()
+	a := app.NewApp(env.repo)
+	require.NoError(t, env.repo.SetUserVerified(ctx, env.uid, true))
+
+	ad, err := env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.False(t, ad.Published)
+
+	// the use case changes the ad in a transaction, the change is invalidated after the commit
+	_, err = a.ChangeAdStatus(ctx, env.ids[0], env.uid, true)
+	require.NoError(t, err)
+	ad, err = env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.True(t, ad.Published)
+
+	// reads inside of the transaction see its own changes and do not fill the cache
+	err = env.repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		require.NoError(t, tx.UpdateAdContent(ctx, env.ids[0], "Green bike", "New", time.Now().UTC()))
+		ad, err := tx.GetAdByID(ctx, env.ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "Green bike", ad.Title)
+		return app.ErrForbidden
+	})
+	assert.ErrorIs(t, err, app.ErrForbidden)
+	ad, err = env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Bike", ad.Title)
+
+	results, err := a.BatchAds(ctx, env.uid, []app.BatchOp{
+		{Action: app.BatchUnpublish, AdID: env.ids[0]},
+		{Action: app.BatchDelete, AdID: env.ids[1]},
+	}, true)
+	require.NoError(t, err)
+	require.NoError(t, results[1].Err)
+	ad, err = env.repo.GetAdByID(ctx, env.ids[0])
+	require.NoError(t, err)
+	assert.False(t, ad.Published)
+	_, err = env.repo.GetAdByID(ctx, env.ids[1])
+	assert.ErrorIs(t, err, app.ErrAdNotFound)
+}
+
+func TestCacheRepo_Backend(t *testing.T) {
+	backend := cacherepo.NewMemoryBackend()
+	inner := &countingRepo{Repository: adrepo.New()}
+	first := cacherepo.New(inner, cacherepo.WithBackend(backend, time.Minute))
+	second := cacherepo.New(inner, cacherepo.WithBackend(backend, time.Minute))
+	ctx := context.Background()
+	uid, err := first.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	id, err := first.AddAd(ctx, ads.Ad{Title: "Bike", Text: "New", AuthorID: uid})
+	require.NoError(t, err)
+
+	// the second instance finds the ad read by the first one in the shared cache
+	_, err = first.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	ad, err := second.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Bike", ad.Title)
+	assert.Equal(t, int64(1), inner.adReads)
+
+	// the write removes the ad from the shared cache, the local cache of the second instance
+	// keeps the old version until it expires
+	require.NoError(t, first.UpdateAdContent(ctx, id, "Green bike", "New", time.Now().UTC()))
+	_, err = backend.Get(ctx, "ad:0")
+	assert.ErrorIs(t, err, cacherepo.ErrMiss)
+	reads := inner.adReads
+	ad, err = first.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Green bike", ad.Title)
+	assert.Equal(t, reads+1, inner.adReads)
+}
+
+// changeDuringRead changes the ad through the cache, after the wrapped repository returned the old version
+type changeDuringRead struct {
+	app.Repository
+	cache   *cacherepo.Repository
+	changed bool
+}
+
+func (r *changeDuringRead) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error) {
+	ad, err := r.Repository.GetAdByID(ctx, id)
+	if err != nil || r.changed {
+		return ad, err
+	}
+	r.changed = true
+	return ad, r.cache.UpdateAdContent(ctx, id, "Green bike", ad.Text, time.Now().UTC())
+}
+
+func TestCacheRepo_BackendSkipsStaleReads(t *testing.T) {
+	backend := cacherepo.NewMemoryBackend()
+	inner := &changeDuringRead{Repository: adrepo.New()}
+	inner.cache = cacherepo.New(inner, cacherepo.WithBackend(backend, time.Minute))
+	ctx := context.Background()
+	uid, err := inner.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	id, err := inner.AddAd(ctx, ads.Ad{Title: "Bike", Text: "New", AuthorID: uid})
+	require.NoError(t, err)
+
+	ad, err := inner.cache.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Bike", ad.Title)
+	// the version read before the change is kept out of both caches
+	_, err = backend.Get(ctx, "ad:0")
+	assert.ErrorIs(t, err, cacherepo.ErrMiss)
+	assert.Equal(t, 0, inner.cache.Stats().Ads.Size)
+}
+
+func TestCacheRepo_BackendWithoutPasswords(t *testing.T) {
+	backend := cacherepo.NewMemoryBackend()
+	inner := adrepo.New()
+	first := cacherepo.New(inner, cacherepo.WithBackend(backend, time.Minute))
+	second := cacherepo.New(inner, cacherepo.WithBackend(backend, time.Minute))
+	ctx := context.Background()
+	u, err := app.NewApp(first).Register(ctx, "Mac Miller", "swimming@circles.com", "swimming")
+	require.NoError(t, err)
+
+	_, err = first.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	data, err := backend.Get(ctx, "user:0")
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "PasswordHash\":\"")
+	shared, err := second.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Mac Miller", shared.Nickname)
+	assert.Empty(t, shared.PasswordHash)
+
+	// the password is checked against the hash in the repository
+	require.NoError(t, app.NewApp(second).ChangePassword(ctx, u.ID, "swimming", "circles!"))
+	_, err = app.NewApp(second).Login(ctx, "swimming@circles.com", "circles!")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/ad-service.git/internal/tests/mocks"
+	"github.com/TobbyMax/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestErrorContract checks, that HTTP and gRPC follow the translation table of the application,
+// so the same error of the application gives the same code and the equivalent statuses
+func TestErrorContract(t *testing.T) {
+	a := mocks.NewApp(t)
+
+	testServer := httptest.NewServer(httpgin.NewHTTPServer(":18080", a).Handler)
+	defer testServer.Close()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpcPort.RegisterAdServiceServer(server, grpcPort.NewService(a))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+	conn, err := grpc.DialContext(context.Background(), "",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := grpcPort.NewAdServiceClient(conn)
+
+	errs := append(app.TranslatedErrors(), validator.ValidationErrors{}, errors.New("mock error"))
+	for _, e := range errs {
+		expected := app.Translate(e)
+		a.On("GetAd", mock.Anything, int64(1)).Return((*ads.Ad)(nil), e).Twice()
+
+		resp, err := http.Get(testServer.URL + "/api/v1/ads/1")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		var p httpgin.Problem
+		require.NoError(t, json.Unmarshal(body, &p), string(body))
+
+		id := int64(1)
+		_, err = client.GetAd(context.Background(), &grpcPort.GetAdRequest{AdId: &id})
+		st := status.Convert(err)
+		info, _ := errorInfo(err)
+		require.NotNil(t, info, e.Error())
+
+		assert.Equal(t, expected.HTTPStatus, resp.StatusCode, e.Error())
+		assert.Equal(t, expected.GRPCCode, st.Code(), e.Error())
+		assert.Equal(t, expected.Code, p.Code, e.Error())
+		assert.Equal(t, p.Code, info.Reason, e.Error())
+		assert.Equal(t, p.Detail, st.Message(), e.Error())
+		// the client errors are not internal errors of the other transport
+		internal := st.Code() == codes.Internal || st.Code() == codes.Unavailable
+		assert.Equal(t, resp.StatusCode >= http.StatusInternalServerError, internal, e.Error())
+	}
+}
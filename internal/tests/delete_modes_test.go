@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+)
+
+func (suite *HTTPSuite) TestDeleteUser_Transfer() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	to, err := suite.Client.createUser("Kendrick", "damn@tde.com")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, fmt.Sprintf("mode=transfer&transfer_to=%d", to.Data.ID))
+	suite.NoError(err)
+
+	_, err = suite.Client.getUser(u.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	res, err := suite.Client.getAd(ad.Data.ID)
+	suite.NoError(err)
+	suite.Equal(to.Data.ID, res.Data.AuthorID)
+}
+
+func (suite *HTTPSuite) TestDeleteUser_Anonymize() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, "mode=anonymize")
+	suite.NoError(err)
+
+	res, err := suite.Client.getAd(ad.Data.ID)
+	suite.NoError(err)
+	suite.Equal(ads.AnonymousAuthorID, res.Data.AuthorID)
+}
+
+func (suite *HTTPSuite) TestDeleteUser_BadMode() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, "mode=shred")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, "mode=transfer")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, fmt.Sprintf("mode=transfer&transfer_to=%d", u.Data.ID+1))
+	suite.ErrorIs(err, ErrBadRequest)
+
+	_, err = suite.Client.getUser(u.Data.ID)
+	suite.NoError(err)
+}
+
+func (suite *GRPCSuite) TestGRPCDeleteUser_Transfer() {
+	user, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+	to, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Ivan", Email: "olegov@yandex.ru"})
+	suite.NoError(err)
+
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "Forest", Text: "Hill Drive", UserId: &user.Id})
+	suite.NoError(err)
+
+	_, err = suite.Client.DeleteUser(suite.Context, &grpcPort.DeleteUserRequest{Id: &user.Id, Mode: grpcPort.DeleteMode_DELETE_MODE_TRANSFER})
+	suite.Equal(ErrInvalidTransfer.Error(), err.Error())
+
+	_, err = suite.Client.DeleteUser(suite.Context, &grpcPort.DeleteUserRequest{
+		Id: &user.Id, Mode: grpcPort.DeleteMode_DELETE_MODE_TRANSFER, TransferTo: &to.Id,
+	})
+	suite.NoError(err)
+
+	res, err := suite.Client.GetAd(suite.Context, &grpcPort.GetAdRequest{AdId: &ad.Id})
+	suite.NoError(err)
+	suite.Equal(to.Id, res.AuthorId)
+}
+
+func (suite *GRPCSuite) TestGRPCDeleteUser_Anonymize() {
+	user, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "Forest", Text: "Hill Drive", UserId: &user.Id})
+	suite.NoError(err)
+
+	_, err = suite.Client.DeleteUser(suite.Context, &grpcPort.DeleteUserRequest{Id: &user.Id, Mode: grpcPort.DeleteMode_DELETE_MODE_ANONYMIZE})
+	suite.NoError(err)
+
+	res, err := suite.Client.GetAd(suite.Context, &grpcPort.GetAdRequest{AdId: &ad.Id})
+	suite.NoError(err)
+	suite.Equal(ads.AnonymousAuthorID, res.AuthorId)
+}
@@ -0,0 +1,415 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/cacherepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/eventbus"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder is a subscriber, which remembers names of the received events
+type eventRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *eventRecorder) Deliver(ctx context.Context, m events.Message) ([]string, error) {
+	return nil, r.Handle(ctx, m.Event)
+}
+
+func (r *eventRecorder) Handle(ctx context.Context, e events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, e.EventName())
+	return nil
+}
+
+func (r *eventRecorder) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func TestApp_WritesEventsToOutbox(t *testing.T) {
+	recorder := &eventRecorder{}
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	relay := app.NewOutboxRelay(repo, recorder)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, u.ID, true)
+	require.NoError(t, err)
+	_, err = a.UpdateAd(ctx, ad.ID, u.ID, "Self Care", "Swimming")
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, u.ID, false)
+	require.NoError(t, err)
+
+	_, err = a.UpdateAd(ctx, ad.ID, u.ID+1, "Self Care", "Swimming")
+	require.ErrorIs(t, err, app.ErrForbidden)
+
+	require.NoError(t, a.DeleteAd(ctx, ad.ID, u.ID))
+	require.NoError(t, a.DeleteUser(ctx, u.ID, app.DeleteUserParams{}))
+
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	assert.Equal(t, []string{
+		events.NameUserCreated,
+		events.NameAdCreated,
+		events.NameAdPublished,
+		events.NameAdUpdated,
+		events.NameAdUnpublished,
+		events.NameAdDeleted,
+		events.NameUserDeleted,
+	}, recorder.Names())
+}
+
+// failingOutbox fails to write events, the changes they describe must be rolled back
+type failingOutbox struct {
+	app.Repository
+}
+
+var errTestOutbox = errors.New("outbox is down")
+
+func (r failingOutbox) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	return r.Repository.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		return fn(ctx, failingOutbox{Repository: tx})
+	})
+}
+
+func (r failingOutbox) AddEvent(ctx context.Context, e events.Event) error {
+	return errTestOutbox
+}
+
+func TestApp_WritesEventsThroughAnyRepository(t *testing.T) {
+	recorder := &eventRecorder{}
+	repo := cacherepo.New(adrepo.New(), cacherepo.WithAdCache(10, time.Minute))
+	a := app.NewApp(repo)
+	relay := app.NewOutboxRelay(repo, recorder)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, a.DeleteAd(ctx, ad.ID, u.ID))
+
+	_, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{events.NameUserCreated, events.NameAdCreated, events.NameAdDeleted}, recorder.Names())
+}
+
+func TestApp_RollsBackChangeWithoutEvent(t *testing.T) {
+	inner := adrepo.New()
+	a := app.NewApp(failingOutbox{Repository: inner})
+	ctx := context.Background()
+
+	_, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.ErrorIs(t, err, errTestOutbox)
+
+	_, err = inner.GetUserByEmail(ctx, "swimming@circles.com")
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+}
+
+func TestEventBus_Delivery(t *testing.T) {
+	bus := eventbus.New(eventbus.WithRetry(3, time.Millisecond))
+
+	good := &eventRecorder{}
+	bus.Subscribe("good", good.Handle)
+
+	var mu sync.Mutex
+	failing, panicking := 0, 0
+	bus.Subscribe("failing", func(ctx context.Context, e events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		failing++
+		return errors.New("search index is down")
+	})
+	bus.Subscribe("panicking", func(ctx context.Context, e events.Event) error {
+		mu.Lock()
+		panicking++
+		first := panicking == 1
+		mu.Unlock()
+		if first {
+			panic("analytics is broken")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bus.Run(ctx)()
+	}()
+
+	bus.Publish(context.Background(), events.UserCreated{Time: time.Now()})
+	bus.Publish(context.Background(), events.UserDeleted{Time: time.Now()})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(good.Names()) == 2 && failing == 6 && panicking == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{events.NameUserCreated, events.NameUserDeleted}, good.Names())
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("bus did not stop")
+	}
+}
+
+func TestEventBus_SlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := eventbus.New()
+
+	release := make(chan struct{})
+	bus.Subscribe("slow", func(ctx context.Context, e events.Event) error {
+		<-release
+		return nil
+	})
+	fast := &eventRecorder{}
+	bus.Subscribe("fast", fast.Handle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bus.Run(ctx)()
+	}()
+
+	for i := 0; i < 100; i++ {
+		bus.Publish(context.Background(), events.AdDeleted{AdID: int64(i), Time: time.Now()})
+	}
+	assert.Eventually(t, func() bool {
+		return len(fast.Names()) == 100
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	close(release)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("bus did not stop")
+	}
+}
+
+// flakyPublisher fails the first deliveries of the events of the given ad
+type flakyPublisher struct {
+	eventRecorder
+	adID     int64
+	failures int
+}
+
+func (p *flakyPublisher) Deliver(ctx context.Context, m events.Message) ([]string, error) {
+	if kind, id := m.Event.Aggregate(); kind == events.AggregateAd && id == p.adID && p.failures > 0 {
+		p.failures--
+		return nil, errors.New("broker is unavailable")
+	}
+	return nil, p.Handle(ctx, m.Event)
+}
+
+func TestOutboxRelay_OrderingPerAggregate(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	first, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+	_, err = a.UpdateAd(ctx, first.ID, u.ID, "Self Care", "Swimming")
+	require.NoError(t, err)
+	_, err = a.CreateAd(ctx, "Blue World", "Circles", u.ID)
+	require.NoError(t, err)
+
+	publisher := &flakyPublisher{adID: first.ID, failures: 1}
+	relay := app.NewOutboxRelay(repo, publisher, app.WithRelayRetry(3, time.Millisecond))
+
+	// the update of the first ad waits for its creation, the second ad is not affected
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{events.NameUserCreated, events.NameAdCreated}, publisher.Names())
+
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Equal(t, "broker is unavailable", pending[0].LastError)
+
+	time.Sleep(2 * time.Millisecond)
+	n, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{
+		events.NameUserCreated,
+		events.NameAdCreated,
+		events.NameAdCreated,
+		events.NameAdUpdated,
+	}, publisher.Names())
+}
+
+// the messages waiting for a retry do not take the whole batch, the other aggregates are still delivered
+func TestOutboxRelay_BlockedMessagesDoNotStarveOthers(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	blocked, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = a.UpdateAd(ctx, blocked.ID, u.ID, "Self Care", fmt.Sprintf("Swimming %d", i))
+		require.NoError(t, err)
+	}
+	_, err = a.CreateAd(ctx, "Blue World", "Circles", u.ID)
+	require.NoError(t, err)
+
+	publisher := &flakyPublisher{adID: blocked.ID, failures: 1}
+	relay := app.NewOutboxRelay(repo, publisher, app.WithRelayBatch(2), app.WithRelayRetry(3, time.Hour))
+
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// the four messages of the blocked ad are more than the batch
+	n, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{events.NameUserCreated, events.NameAdCreated}, publisher.Names())
+
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 4)
+	for _, m := range pending {
+		_, id := m.Event.Aggregate()
+		assert.Equal(t, blocked.ID, id)
+	}
+}
+
+func TestOutboxRelay_DeadLetters(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+
+	publisher := &flakyPublisher{adID: ad.ID, failures: 100}
+	relay := app.NewOutboxRelay(repo, publisher, app.WithRelayRetry(2, 0))
+
+	for i := 0; i < 2; i++ {
+		_, err = relay.Flush(ctx)
+		require.NoError(t, err)
+	}
+
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	dead, err := repo.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, events.NameAdCreated, dead[0].Event.EventName())
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Equal(t, []string{events.NameUserCreated}, publisher.Names())
+}
+
+// the outbox relay gets the failures of the subscribers from the bus, so the events, which a subscriber
+// could not handle, are delivered again and finally moved to the dead letters instead of being dropped
+func TestEventBus_ReportsFailuresToRelay(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+
+	bus := eventbus.New(eventbus.WithRetry(2, time.Millisecond))
+	good := &eventRecorder{}
+	bus.Subscribe("good", good.Handle)
+	// the index is down for the first three attempts of the user, while the ad can never be indexed
+	var mu sync.Mutex
+	userFailures := 3
+	bus.Subscribe("index", func(ctx context.Context, e events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if kind, _ := e.Aggregate(); kind == events.AggregateAd {
+			return errors.New("ad can not be indexed")
+		}
+		if userFailures > 0 {
+			userFailures--
+			return errors.New("index is down")
+		}
+		return nil
+	})
+
+	busCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bus.Run(busCtx)()
+	}()
+
+	relay := app.NewOutboxRelay(repo, bus, app.WithRelayRetry(3, 0))
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Contains(t, pending[0].LastError, "index")
+	assert.Contains(t, pending[0].LastError, eventbus.ErrNotHandled.Error())
+
+	// the second attempt of the relay delivers the user, the ad is retried once more
+	n, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = relay.Flush(ctx)
+	require.NoError(t, err)
+
+	pending, err = repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	dead, err := repo.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, events.NameAdCreated, dead[0].Event.EventName())
+	kind, id := dead[0].Event.Aggregate()
+	assert.Equal(t, events.AggregateAd, kind)
+	assert.Equal(t, ad.ID, id)
+
+	// the retries skip the subscribers, which handled the event
+	assert.Equal(t, []string{events.NameUserCreated, events.NameAdCreated}, good.Names())
+	assert.Equal(t, []string{"good"}, dead[0].Handled)
+
+	cancel()
+	require.NoError(t, <-done)
+	_, err = bus.Deliver(ctx, events.Message{Event: events.UserDeleted{UserID: u.ID, Time: time.Now()}})
+	assert.ErrorIs(t, err, eventbus.ErrStopped)
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type rssData struct {
+	Channel struct {
+		Title         string `xml:"title"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		Items         []struct {
+			Title   string `xml:"title"`
+			Link    string `xml:"link"`
+			GUID    string `xml:"guid"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomData struct {
+	Updated string `xml:"updated"`
+	Entries []struct {
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Content   string `xml:"content"`
+	} `xml:"entry"`
+}
+
+type feedEnv struct {
+	app    app.App
+	server *httptest.Server
+	ads    []*ads.Ad
+}
+
+// newFeedEnv publishes three ads of one user and one ad of another, the feeds are limited to 3 ads
+func newFeedEnv(t *testing.T) *feedEnv {
+	env := &feedEnv{app: app.NewApp(adrepo.New())}
+	env.server = httptest.NewServer(httpgin.NewHTTPServer(":0", env.app, httpgin.WithFeedLimit(3)).Handler)
+	t.Cleanup(env.server.Close)
+
+	ctx := context.Background()
+	u, err := env.app.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := env.app.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	for _, item := range []struct {
+		uid   int64
+		title string
+	}{{u.ID, "Red bike"}, {u.ID, "Blue car"}, {other.ID, "Green bike"}, {u.ID, "Black cat"}} {
+		ad, err := env.app.CreateAd(ctx, item.title, "For sale", item.uid)
+		require.NoError(t, err)
+		_, err = env.app.ChangeAdStatus(ctx, ad.ID, item.uid, true)
+		require.NoError(t, err)
+		env.ads = append(env.ads, ad)
+	}
+	// unpublished ads are not in the feeds
+	_, err = env.app.CreateAd(ctx, "Hidden bike", "Draft", u.ID)
+	require.NoError(t, err)
+	return env
+}
+
+func (env *feedEnv) get(t *testing.T, path string, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, env.server.URL+path, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := env.server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestFeeds_RSS(t *testing.T) {
+	env := newFeedEnv(t)
+
+	resp, body := env.get(t, "/api/v1/feeds/ads.rss", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/rss+xml")
+	var feed rssData
+	require.NoError(t, xml.Unmarshal(body, &feed))
+	// the newest ads first, up to the configured limit
+	require.Len(t, feed.Channel.Items, 3)
+	assert.Equal(t, "Black cat", feed.Channel.Items[0].Title)
+	assert.Equal(t, "Green bike", feed.Channel.Items[1].Title)
+	assert.Equal(t, env.ads[3].DateCreated.UTC().Format(time.RFC1123Z), feed.Channel.Items[0].PubDate)
+	assert.Equal(t, feed.Channel.Items[0].Link, feed.Channel.Items[0].GUID)
+	assert.Contains(t, feed.Channel.Items[0].Link, "/api/v1/ads/")
+
+	_, body = env.get(t, "/api/v1/feeds/ads.rss?text=BIKE&limit=1", nil)
+	var bikes rssData
+	require.NoError(t, xml.Unmarshal(body, &bikes))
+	require.Len(t, bikes.Channel.Items, 1)
+	assert.Equal(t, "Green bike", bikes.Channel.Items[0].Title)
+
+	_, body = env.get(t, "/api/v1/feeds/ads.rss?text=bike&user_id=0", nil)
+	var own rssData
+	require.NoError(t, xml.Unmarshal(body, &own))
+	require.Len(t, own.Channel.Items, 1)
+	assert.Equal(t, "Red bike", own.Channel.Items[0].Title)
+
+	resp, _ = env.get(t, "/api/v1/feeds/ads.rss?limit=4", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = env.get(t, "/api/v1/feeds/ads.rss?user_id=abc", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFeeds_Atom(t *testing.T) {
+	env := newFeedEnv(t)
+	updated, err := env.app.UpdateAd(context.Background(), env.ads[0].ID, env.ads[0].AuthorID, "Red bike", "Sold")
+	require.NoError(t, err)
+
+	resp, body := env.get(t, "/api/v1/feeds/ads.atom?user_id=0&text=red", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/atom+xml")
+	var feed atomData
+	require.NoError(t, xml.Unmarshal(body, &feed))
+	require.Len(t, feed.Entries, 1)
+	entry := feed.Entries[0]
+	assert.Equal(t, "Sold", entry.Content)
+	assert.Equal(t, env.ads[0].DateCreated.UTC().Format(time.RFC3339), entry.Published)
+	assert.Equal(t, updated.DateChanged.UTC().Format(time.RFC3339), entry.Updated)
+	// the feed is dated by the last change of the ads, which is the update
+	feedUpdated, err := time.Parse(time.RFC3339, feed.Updated)
+	require.NoError(t, err)
+	assert.False(t, feedUpdated.Before(updated.DateChanged.Truncate(time.Second)))
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	require.NoError(t, err)
+	assert.True(t, lastModified.Equal(feedUpdated))
+}
+
+func TestFeeds_ConditionalGet(t *testing.T) {
+	env := newFeedEnv(t)
+
+	resp, _ := env.get(t, "/api/v1/feeds/ads.atom", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, lastModified)
+
+	resp, body := env.get(t, "/api/v1/feeds/ads.atom", http.Header{"If-None-Match": {`"other", ` + etag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+	resp, _ = env.get(t, "/api/v1/feeds/ads.atom", http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	// the RSS feed of the same ads is another representation
+	resp, _ = env.get(t, "/api/v1/feeds/ads.rss", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err := env.app.UpdateAd(context.Background(), env.ads[3].ID, env.ads[3].AuthorID, "Black cat", "Sold")
+	require.NoError(t, err)
+	resp, _ = env.get(t, "/api/v1/feeds/ads.atom", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+}
+
+func TestFeeds_LastModifiedAfterRemoval(t *testing.T) {
+	env := newFeedEnv(t)
+	ctx := context.Background()
+	_, err := env.app.UpdateAd(ctx, env.ads[3].ID, env.ads[3].AuthorID, "Black cat", "Sold")
+	require.NoError(t, err)
+	resp, _ := env.get(t, "/api/v1/feeds/ads.atom", nil)
+	lastModified := resp.Header.Get("Last-Modified")
+
+	// Last-Modified has one second precision
+	time.Sleep(time.Second)
+	// the last changed ad leaves the feed, the date of the feed must not go back to the dates of the other ads
+	_, err = env.app.ChangeAdStatus(ctx, env.ads[3].ID, env.ads[3].AuthorID, false)
+	require.NoError(t, err)
+
+	resp, _ = env.get(t, "/api/v1/feeds/ads.atom", http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	before, err := http.ParseTime(lastModified)
+	require.NoError(t, err)
+	after, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	require.NoError(t, err)
+	assert.True(t, after.After(before))
+
+	resp, _ = env.get(t, "/api/v1/feeds/ads.atom", http.Header{"If-Modified-Since": {resp.Header.Get("Last-Modified")}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
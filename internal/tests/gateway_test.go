@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGateway(t *testing.T, interceptors ...grpc.UnaryServerInterceptor) (*httptest.Server, grpcPort.AdServiceServer) {
+	svc := grpcPort.NewService(app.NewApp(adrepo.New()))
+	gateway, err := grpcPort.NewGateway(svc, interceptors...)
+	require.NoError(t, err)
+	return httptest.NewServer(gateway), svc
+}
+
+func gatewayCall(t *testing.T, server *httptest.Server, method string, path string, header http.Header, body any) (*http.Response, []byte) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, data
+}
+
+// gatewayError returns the status in the body of the response and the code in its ErrorInfo
+func gatewayError(t *testing.T, body []byte) (*spb.Status, string) {
+	var st spb.Status
+	require.NoError(t, protojson.Unmarshal(body, &st), string(body))
+	for _, d := range st.Details {
+		var info errdetails.ErrorInfo
+		if d.UnmarshalTo(&info) == nil {
+			return &st, info.Reason
+		}
+	}
+	return &st, ""
+}
+
+func TestGateway(t *testing.T) {
+	server, svc := newGateway(t)
+	defer server.Close()
+
+	resp, body := gatewayCall(t, server, http.MethodPost, "/v1/users", nil, map[string]any{"name": "Mac Miller", "email": "swimming@circles.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var user struct {
+		ID    int64  `json:"id,string"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	require.NoError(t, json.Unmarshal(body, &user))
+	assert.Equal(t, "Mac Miller", user.Name)
+
+	resp, body = gatewayCall(t, server, http.MethodPost, "/v1/ads", nil, map[string]any{"title": "Bike", "text": "New", "user_id": user.ID})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	// the gateway returns the same response as the gRPC call
+	id := int64(0)
+	expected, err := svc.GetAd(context.Background(), &grpcPort.GetAdRequest{AdId: &id})
+	require.NoError(t, err)
+	resp, body = gatewayCall(t, server, http.MethodGet, "/v1/ads/0", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	var ad grpcPort.AdResponse
+	require.NoError(t, protojson.Unmarshal(body, &ad))
+	assert.Equal(t, expected.String(), ad.String())
+	// unset fields are in the response too
+	assert.Contains(t, string(body), `"published":false`)
+
+	// the path variable and the body make one request
+	resp, body = gatewayCall(t, server, http.MethodPut, "/v1/ads/0/status", nil, map[string]any{"user_id": user.ID, "published": true})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	// the query fills the fields of the request without the body
+	resp, body = gatewayCall(t, server, http.MethodGet, "/v1/ads?published=true&user_id=0", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	var list grpcPort.ListAdResponse
+	require.NoError(t, protojson.Unmarshal(body, &list))
+	require.Len(t, list.List, 1)
+	assert.True(t, list.List[0].Published)
+
+	resp, _ = gatewayCall(t, server, http.MethodDelete, "/v1/ads/0?author_id=0", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = gatewayCall(t, server, http.MethodGet, "/v1/ads/0", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGatewayErrors(t *testing.T) {
+	server, _ := newGateway(t)
+	defer server.Close()
+
+	// the statuses are from the translation table, as in the HTTP API
+	resp, body := gatewayCall(t, server, http.MethodGet, "/v1/ads/5", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	st, code := gatewayError(t, body)
+	assert.Equal(t, "AD_NOT_FOUND", code)
+	assert.Equal(t, app.ErrAdNotFound.Error(), st.Message)
+
+	resp, body = gatewayCall(t, server, http.MethodGet, "/v1/ads/abc", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, code = gatewayError(t, body)
+	assert.Equal(t, "INVALID_PARAMETER", code)
+
+	resp, body = gatewayCall(t, server, http.MethodGet, "/v1/ads?color=red", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, code = gatewayError(t, body)
+	assert.Equal(t, "INVALID_PARAMETER", code)
+
+	resp, body = gatewayCall(t, server, http.MethodPost, "/v1/ads", nil, map[string]any{"title": 5})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, code = gatewayError(t, body)
+	assert.Equal(t, "INVALID_REQUEST", code)
+
+	resp, _ = gatewayCall(t, server, http.MethodPost, "/v1/users", nil, map[string]any{"name": "Mac Miller", "email": "swimming@circles.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = gatewayCall(t, server, http.MethodPost, "/v1/ads", nil, map[string]any{"title": strings.Repeat("a", 100), "text": "New", "user_id": 0})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, code = gatewayError(t, body)
+	assert.Equal(t, app.CodeValidationFailed, code)
+
+	resp, _ = gatewayCall(t, server, http.MethodGet, "/v1/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = gatewayCall(t, server, http.MethodPatch, "/v1/ads/0", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// the gateway calls the service through the interceptors, the metadata comes from the headers
+func TestGatewayInterceptors(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{
+		Routes: map[string]ratelimit.Rule{
+			"/ad.AdService/GetUser": {PerUser: ratelimit.Quota{Rate: 0.01, Burst: 1}},
+		},
+	})
+	server, _ := newGateway(t,
+		grpcPort.UnaryRequestInfoInterceptor,
+		grpcPort.UnaryRateLimitInterceptor(l),
+		grpcPort.UnaryIdempotencyInterceptor(idempotency.NewMemoryStore(time.Hour, 100)),
+		grpcPort.UnaryRecoveryInterceptor())
+	defer server.Close()
+
+	withKey := http.Header{idempotency.Header: {"user-1"}}
+	user := map[string]any{"name": "Mac Miller", "email": "swimming@circles.com"}
+	resp, first := gatewayCall(t, server, http.MethodPost, "/v1/users", withKey, user)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(httpgin.RequestIDHeader))
+	resp, replayed := gatewayCall(t, server, http.MethodPost, "/v1/users", withKey, user)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(idempotency.ReplayedHeader))
+	assert.JSONEq(t, string(first), string(replayed))
+
+	asUser := http.Header{ratelimit.UserHeader: {"0"}}
+	resp, _ = gatewayCall(t, server, http.MethodGet, "/v1/users/0", asUser, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(ratelimit.LimitHeader))
+	resp, body := gatewayCall(t, server, http.MethodGet, "/v1/users/0", asUser, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(ratelimit.RetryAfter))
+	_, code := gatewayError(t, body)
+	assert.Equal(t, "RATE_LIMITED", code)
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/mailer"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+type GRPCAccountSuite struct {
+	suite.Suite
+	Outbox  *mailer.Outbox
+	Client  grpcPort.AdServiceClient
+	Conn    *grpc.ClientConn
+	Context context.Context
+	Cancel  context.CancelFunc
+	Server  *grpc.Server
+	Lis     *bufconn.Listener
+}
+
+func (suite *GRPCAccountSuite) SetupTest() {
+	log.Println("Setting Up Test")
+
+	suite.Lis = bufconn.Listen(1024 * 1024)
+	suite.Server = grpc.NewServer()
+	suite.Outbox = mailer.NewOutbox()
+	svc := grpcPort.NewService(app.NewApp(adrepo.New(), app.WithMailer(suite.Outbox)))
+	grpcPort.RegisterAdServiceServer(suite.Server, svc)
+
+	suite.Context, suite.Cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	go func(srv *grpc.Server, lis *bufconn.Listener) {
+		if err := srv.Serve(lis); err != nil {
+			log.Println("srv.Serve:", err)
+		}
+	}(suite.Server, suite.Lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return suite.Lis.Dial()
+	}
+	conn, err := grpc.DialContext(suite.Context, "", grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	suite.NoError(err, "grpc.DialContext")
+	suite.Conn = conn
+
+	suite.Client = grpcPort.NewAdServiceClient(suite.Conn)
+}
+
+func (suite *GRPCAccountSuite) TearDownTest() {
+	log.Println("Tearing Down Test")
+
+	_ = suite.Conn.Close()
+	suite.Cancel()
+	suite.Server.Stop()
+	_ = suite.Lis.Close()
+}
+
+func (suite *GRPCAccountSuite) lastToken(email string) string {
+	msg, ok := suite.Outbox.Last(email)
+	suite.Require().True(ok, "no mail sent to %s", email)
+	return tokenFromMail(msg.Body)
+}
+
+func (suite *GRPCAccountSuite) TestGRPCRegisterAndVerify() {
+	u, err := suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Oleg", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+	suite.False(u.Verified)
+
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "Forest", Text: "Hill Drive", UserId: &u.Id})
+	suite.NoError(err)
+
+	_, err = suite.Client.ChangeAdStatus(suite.Context, &grpcPort.ChangeAdStatusRequest{AdId: &ad.Id, UserId: &u.Id, Published: true})
+	suite.Equal(codes.FailedPrecondition, status.Code(err))
+
+	res, err := suite.Client.VerifyEmail(suite.Context, &grpcPort.VerifyEmailRequest{Token: suite.lastToken("ivanov@yandex.ru")})
+	suite.NoError(err)
+	suite.True(res.Verified)
+
+	published, err := suite.Client.ChangeAdStatus(suite.Context, &grpcPort.ChangeAdStatusRequest{AdId: &ad.Id, UserId: &u.Id, Published: true})
+	suite.NoError(err)
+	suite.True(published.Published)
+}
+
+func (suite *GRPCAccountSuite) TestGRPCRegister_EmailTaken() {
+	_, err := suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Oleg", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+
+	_, err = suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Ivan", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.Equal(codes.AlreadyExists, status.Code(err))
+}
+
+func (suite *GRPCAccountSuite) TestGRPCVerifyEmail_InvalidToken() {
+	_, err := suite.Client.VerifyEmail(suite.Context, &grpcPort.VerifyEmailRequest{Token: "abc"})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (suite *GRPCAccountSuite) TestGRPCLogin() {
+	u, err := suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Oleg", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+
+	res, err := suite.Client.Login(suite.Context, &grpcPort.LoginRequest{Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+	suite.Equal(u.Id, res.Id)
+
+	_, err = suite.Client.Login(suite.Context, &grpcPort.LoginRequest{Email: "ivanov@yandex.ru", Password: "123qwerty"})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+}
+
+func (suite *GRPCAccountSuite) TestGRPCResetPassword() {
+	_, err := suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Oleg", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+
+	_, err = suite.Client.RequestPasswordReset(suite.Context, &grpcPort.RequestPasswordResetRequest{Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	_, err = suite.Client.ResetPassword(suite.Context, &grpcPort.ResetPasswordRequest{Token: suite.lastToken("ivanov@yandex.ru"), Password: "password1"})
+	suite.NoError(err)
+
+	_, err = suite.Client.Login(suite.Context, &grpcPort.LoginRequest{Email: "ivanov@yandex.ru", Password: "password1"})
+	suite.NoError(err)
+}
+
+func (suite *GRPCAccountSuite) TestGRPCChangePassword() {
+	u, err := suite.Client.Register(suite.Context, &grpcPort.RegisterRequest{Name: "Oleg", Email: "ivanov@yandex.ru", Password: "qwerty123"})
+	suite.NoError(err)
+
+	_, err = suite.Client.ChangePassword(suite.Context, &grpcPort.ChangePasswordRequest{OldPassword: "qwerty123", NewPassword: "password1"})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	_, err = suite.Client.ChangePassword(suite.Context, &grpcPort.ChangePasswordRequest{Id: &u.Id, OldPassword: "123qwerty", NewPassword: "password1"})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+
+	_, err = suite.Client.ChangePassword(suite.Context, &grpcPort.ChangePasswordRequest{Id: &u.Id, OldPassword: "qwerty123", NewPassword: "password1"})
+	suite.NoError(err)
+
+	_, err = suite.Client.Login(suite.Context, &grpcPort.LoginRequest{Email: "ivanov@yandex.ru", Password: "password1"})
+	suite.NoError(err)
+}
+
+func TestGRPCAccountSuite(t *testing.T) {
+	suite.Run(t, new(GRPCAccountSuite))
+}
@@ -412,6 +412,7 @@ func (suite *GRPCMockSuite) TestHandler_DeleteUser() {
 				suite.App.On("DeleteUser",
 					mock.AnythingOfType("*context.valueCtx"),
 					tc.args.id,
+					app.DeleteUserParams{},
 				).
 					Return(tc.args.err).
 					Once()
@@ -3,7 +3,9 @@ package tests
 import (
 	"context"
 	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adfeed"
 	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/notifier"
 	"github.com/TobbyMax/ad-service.git/internal/app"
 	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
 	"github.com/stretchr/testify/suite"
@@ -21,6 +23,7 @@ var (
 	ErrGRPCForbidden   = errors.New("rpc error: code = PermissionDenied desc = forbidden")
 	ErrInvalidEmail    = errors.New("rpc error: code = InvalidArgument desc = mail: missing '@' or angle-addr")
 	ErrMissingArgument = errors.New("rpc error: code = InvalidArgument desc = required argument is missing")
+	ErrInvalidTransfer = errors.New("rpc error: code = InvalidArgument desc = ads must be transferred to another existing user")
 	ErrMockInternal    = errors.New("rpc error: code = Internal desc = mock error")
 	ErrValidationMock  = errors.New("rpc error: code = InvalidArgument desc = ")
 	ErrDateMock        = errors.New("rpc error: code = InvalidArgument desc = parsing time \"abc\" as \"2006-01-02\": cannot parse \"abc\" as \"2006\"")
@@ -29,6 +32,8 @@ var (
 type GRPCSuite struct {
 	suite.Suite
 	Repo     *adrepo.RepositoryMap
+	Hub      *notifier.Hub
+	Feed     *adfeed.Feed
 	Client   grpcPort.AdServiceClient
 	Conn     *grpc.ClientConn
 	Context  context.Context
@@ -47,7 +52,9 @@ func (suite *GRPCSuite) SetupSuite() {
 		grpcPort.UnaryRecoveryInterceptor(),
 	))
 	suite.Repo = adrepo.NewRepositoryMap()
-	svc := grpcPort.NewService(app.NewApp(suite.Repo))
+	suite.Hub = notifier.NewHub()
+	suite.Feed = adfeed.New()
+	svc := grpcPort.NewService(app.NewApp(suite.Repo, app.WithNotificationHub(suite.Hub), app.WithAdFeed(suite.Feed)))
 	grpcPort.RegisterAdServiceServer(suite.Server, svc)
 
 	suite.Context, suite.Cancel = context.WithTimeout(context.Background(), 30*time.Second)
@@ -447,6 +447,7 @@ func (suite *HTTPMockSuite) TestHandler_DeleteUser() {
 				suite.App.On("DeleteUser",
 					mock.AnythingOfType("*gin.Context"),
 					tc.args.id,
+					app.DeleteUserParams{},
 				).
 					Return(tc.args.err).
 					Once()
@@ -492,14 +493,14 @@ func (suite *HTTPMockSuite) TestHandler_CreateAd() {
 			wantErr:  false,
 		},
 		{
-			name: "failed dependency",
+			name: "user not found",
 			args: args{
 				err: app.ErrUserNotFound,
 			},
 			needMock: true,
 			wantErr:  true,
 			checkErr: func(err error) bool {
-				suite.ErrorIs(err, ErrFailedDependency)
+				suite.ErrorIs(err, ErrNotFound)
 				return true
 			},
 		},
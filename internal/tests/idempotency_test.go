@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/idempotency"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := idempotency.NewMemoryStore(time.Hour, 2)
+
+	r, err := idempotency.Begin(ctx, s, "a", "fp")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	_, err = idempotency.Begin(ctx, s, "a", "fp")
+	assert.ErrorIs(t, err, idempotency.ErrInProgress)
+	_, err = idempotency.Begin(ctx, s, "a", "other")
+	assert.ErrorIs(t, err, idempotency.ErrKeyReused)
+
+	require.NoError(t, s.Complete(ctx, idempotency.Record{Key: "a", Status: http.StatusOK, Body: []byte("ok")}))
+	r, err = idempotency.Begin(ctx, s, "a", "fp")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, http.StatusOK, r.Status)
+	assert.Equal(t, []byte("ok"), r.Body)
+
+	// the released key can be used again
+	_, err = idempotency.Begin(ctx, s, "b", "fp")
+	require.NoError(t, err)
+	require.NoError(t, s.Release(ctx, "b"))
+	r, err = idempotency.Begin(ctx, s, "b", "other")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	// the oldest record is dropped over the limit
+	_, err = idempotency.Begin(ctx, s, "c", "fp")
+	require.NoError(t, err)
+	r, err = idempotency.Begin(ctx, s, "a", "other")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	// and the records expire after the retention period
+	s = idempotency.NewMemoryStore(10*time.Millisecond, 10)
+	_, err = idempotency.Begin(ctx, s, "a", "fp")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	r, err = idempotency.Begin(ctx, s, "a", "other")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+}
+
+func postWithKey(t *testing.T, client *testClient, path string, key string, body any) (*http.Response, []byte) {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, client.baseURL+path, bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotency.Header, key)
+	}
+	resp, err := client.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, out
+}
+
+func TestHTTPIdempotency(t *testing.T) {
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New()),
+		httpgin.WithIdempotencyStore(idempotency.NewMemoryStore(time.Hour, 100)))
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+	client := &testClient{client: testServer.Client(), baseURL: testServer.URL}
+
+	user := map[string]any{"nickname": "Mac Miller", "email": "swimming@circles.com"}
+	resp, first := postWithKey(t, client, "/api/v1/users", "user-1", user)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, replayed := postWithKey(t, client, "/api/v1/users", "user-1", user)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(idempotency.ReplayedHeader))
+	assert.Equal(t, first, replayed)
+
+	ad := map[string]any{"user_id": 0, "title": "Bike", "text": "New"}
+	resp, first = postWithKey(t, client, "/api/v1/ads", "ad-1", ad)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(idempotency.ReplayedHeader))
+	resp, replayed = postWithKey(t, client, "/api/v1/ads", "ad-1", ad)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, first, replayed)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
+
+	// the key is reused for a different payload
+	ad["title"] = "Car"
+	resp, _ = postWithKey(t, client, "/api/v1/ads", "ad-1", ad)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// the same key on another route and the requests without the key are not affected
+	resp, _ = postWithKey(t, client, "/api/v1/ads/batch", "ad-1", map[string]any{
+		"user_id": 0, "operations": []map[string]any{{"op": "create", "title": "Car", "text": "Old"}}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = postWithKey(t, client, "/api/v1/ads", "", ad)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = postWithKey(t, client, "/api/v1/ads", "ad-2", ad)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// only one ad was created for the retried request
+	created, err := client.createAd(0, "Bike", "New")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), created.Data.ID)
+
+	// client errors are replayed too
+	resp, _ = postWithKey(t, client, "/api/v1/ads", "ad-3", map[string]any{"user_id": 0, "title": "", "text": "New"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = postWithKey(t, client, "/api/v1/ads", "ad-3", map[string]any{"user_id": 0, "title": "", "text": "New"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(idempotency.ReplayedHeader))
+
+	resp, _ = postWithKey(t, client, "/api/v1/ads", strings.Repeat("k", idempotency.MaxKeyLength+1), ad)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the body is read into memory only up to the limit
+	ad["text"] = strings.Repeat("a", 2<<20)
+	resp, body := postWithKey(t, client, "/api/v1/ads", "ad-4", ad)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Contains(t, string(body), "BODY_TOO_LARGE")
+}
+
+func TestGRPCIdempotency(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		grpcPort.UnaryIdempotencyInterceptor(idempotency.NewMemoryStore(time.Hour, 100))))
+	grpcPort.RegisterAdServiceServer(server, grpcPort.NewService(app.NewApp(adrepo.New())))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := grpcPort.NewAdServiceClient(conn)
+
+	u, err := client.CreateUser(ctx, &grpcPort.CreateUserRequest{Name: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+
+	withKey := metadata.NewOutgoingContext(ctx, metadata.Pairs(grpcPort.IdempotencyMetadata, "ad-1"))
+	req := &grpcPort.CreateAdRequest{Title: "Bike", Text: "New", UserId: &u.Id}
+	first, err := client.CreateAd(withKey, req)
+	require.NoError(t, err)
+	var header metadata.MD
+	replayed, err := client.CreateAd(withKey, req, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, replayed.Id)
+	assert.Equal(t, first.DateCreated, replayed.DateCreated)
+	assert.Equal(t, []string{"true"}, header.Get("idempotent-replayed"))
+
+	_, err = client.CreateAd(withKey, &grpcPort.CreateAdRequest{Title: "Car", Text: "New", UserId: &u.Id})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the errors of the use cases are replayed
+	other := int64(7)
+	withKey = metadata.NewOutgoingContext(ctx, metadata.Pairs(grpcPort.IdempotencyMetadata, "ad-2"))
+	_, err = client.CreateAd(withKey, &grpcPort.CreateAdRequest{Title: "Car", Text: "New", UserId: &other})
+	code := status.Code(err)
+	assert.NotEqual(t, codes.OK, code)
+	_, err = client.CreateAd(withKey, &grpcPort.CreateAdRequest{Title: "Car", Text: "New", UserId: &other})
+	assert.Equal(t, code, status.Code(err))
+
+	// other methods ignore the key
+	_, err = client.GetAd(withKey, &grpcPort.GetAdRequest{AdId: &first.Id})
+	assert.NoError(t, err)
+
+	second, err := client.CreateAd(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, first.Id+1, second.Id)
+}
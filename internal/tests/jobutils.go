@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type jobData struct {
+	ID       int64   `json:"id"`
+	Kind     string  `json:"kind"`
+	UserID   int64   `json:"user_id"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error"`
+	Created  string  `json:"created"`
+	Finished *string `json:"finished"`
+}
+
+type jobResponse struct {
+	Data jobData `json:"data"`
+}
+
+func (tc *testClient) requestExport(userID any, format string) (jobResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/export?format=%s", userID, format), nil)
+	if err != nil {
+		return jobResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response jobResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return jobResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) requestErasure(userID any) (jobResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/erasure", userID), nil)
+	if err != nil {
+		return jobResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response jobResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return jobResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) getJob(userID any, jobID any) (jobResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/jobs/%v", userID, jobID), nil)
+	if err != nil {
+		return jobResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response jobResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return jobResponse{}, err
+	}
+
+	return response, nil
+}
+
+// waitJob polls the job until it is finished
+func (tc *testClient) waitJob(userID any, jobID any) (jobResponse, error) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		response, err := tc.getJob(userID, jobID)
+		if err != nil || response.Data.Finished != nil {
+			return response, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return jobResponse{}, fmt.Errorf("job %v is not finished in time", jobID)
+}
+
+func (tc *testClient) downloadJobResult(userID any, jobID any) (string, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/jobs/%v/result", userID, jobID), nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("unexpected error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil, ErrNotFound
+	case http.StatusConflict:
+		return "", nil, ErrConflict
+	default:
+		return "", nil, fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read response: %w", err)
+	}
+	return resp.Header.Get("Content-Type"), body, nil
+}
@@ -4,13 +4,30 @@ package mocks
 
 import (
 	ads "github.com/TobbyMax/ad-service.git/internal/ads"
+
 	app "github.com/TobbyMax/ad-service.git/internal/app"
 
+	audit "github.com/TobbyMax/ad-service.git/internal/audit"
+
 	context "context"
 
+	events "github.com/TobbyMax/ad-service.git/internal/events"
+
+	io "io"
+
+	jobs "github.com/TobbyMax/ad-service.git/internal/jobs"
+
 	mock "github.com/stretchr/testify/mock"
 
+	notifications "github.com/TobbyMax/ad-service.git/internal/notifications"
+
+	searches "github.com/TobbyMax/ad-service.git/internal/searches"
+
+	time "time"
+
 	user "github.com/TobbyMax/ad-service.git/internal/user"
+
+	webhooks "github.com/TobbyMax/ad-service.git/internal/webhooks"
 )
 
 // App is an autogenerated mock type for the App type
@@ -18,6 +35,56 @@ type App struct {
 	mock.Mock
 }
 
+// AdsChangedAt provides a mock function with given fields: ctx
+func (_m *App) AdsChangedAt(ctx context.Context) (time.Time, error) {
+	ret := _m.Called(ctx)
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (time.Time, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) time.Time); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchAds provides a mock function with given fields: ctx, uid, ops, atomic
+func (_m *App) BatchAds(ctx context.Context, uid int64, ops []app.BatchOp, atomic bool) ([]app.BatchResult, error) {
+	ret := _m.Called(ctx, uid, ops, atomic)
+
+	var r0 []app.BatchResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, []app.BatchOp, bool) ([]app.BatchResult, error)); ok {
+		return rf(ctx, uid, ops, atomic)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, []app.BatchOp, bool) []app.BatchResult); ok {
+		r0 = rf(ctx, uid, ops, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]app.BatchResult)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, []app.BatchOp, bool) error); ok {
+		r1 = rf(ctx, uid, ops, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ChangeAdStatus provides a mock function with given fields: ctx, id, uid, published
 func (_m *App) ChangeAdStatus(ctx context.Context, id int64, uid int64, published bool) (*ads.Ad, error) {
 	ret := _m.Called(ctx, id, uid, published)
@@ -44,6 +111,44 @@ func (_m *App) ChangeAdStatus(ctx context.Context, id int64, uid int64, publishe
 	return r0, r1
 }
 
+// ChangePassword provides a mock function with given fields: ctx, id, oldPassword, newPassword
+func (_m *App) ChangePassword(ctx context.Context, id int64, oldPassword string, newPassword string) error {
+	ret := _m.Called(ctx, id, oldPassword, newPassword)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string) error); ok {
+		r0 = rf(ctx, id, oldPassword, newPassword)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CountUnreadNotifications provides a mock function with given fields: ctx, uid
+func (_m *App) CountUnreadNotifications(ctx context.Context, uid int64) (int, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (int, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) int); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateAd provides a mock function with given fields: ctx, title, text, uid
 func (_m *App) CreateAd(ctx context.Context, title string, text string, uid int64) (*ads.Ad, error) {
 	ret := _m.Called(ctx, title, text, uid)
@@ -70,6 +175,32 @@ func (_m *App) CreateAd(ctx context.Context, title string, text string, uid int6
 	return r0, r1
 }
 
+// CreateSavedSearch provides a mock function with given fields: ctx, uid, params
+func (_m *App) CreateSavedSearch(ctx context.Context, uid int64, params app.SavedSearchParams) (*searches.SavedSearch, error) {
+	ret := _m.Called(ctx, uid, params)
+
+	var r0 *searches.SavedSearch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.SavedSearchParams) (*searches.SavedSearch, error)); ok {
+		return rf(ctx, uid, params)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.SavedSearchParams) *searches.SavedSearch); ok {
+		r0 = rf(ctx, uid, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*searches.SavedSearch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, app.SavedSearchParams) error); ok {
+		r1 = rf(ctx, uid, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateUser provides a mock function with given fields: ctx, nickname, email
 func (_m *App) CreateUser(ctx context.Context, nickname string, email string) (*user.User, error) {
 	ret := _m.Called(ctx, nickname, email)
@@ -96,6 +227,32 @@ func (_m *App) CreateUser(ctx context.Context, nickname string, email string) (*
 	return r0, r1
 }
 
+// CreateWebhook provides a mock function with given fields: ctx, uid, url, eventNames
+func (_m *App) CreateWebhook(ctx context.Context, uid int64, url string, eventNames []string) (*webhooks.Subscription, error) {
+	ret := _m.Called(ctx, uid, url, eventNames)
+
+	var r0 *webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, []string) (*webhooks.Subscription, error)); ok {
+		return rf(ctx, uid, url, eventNames)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, []string) *webhooks.Subscription); ok {
+		r0 = rf(ctx, uid, url, eventNames)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string, []string) error); ok {
+		r1 = rf(ctx, uid, url, eventNames)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteAd provides a mock function with given fields: ctx, id, uid
 func (_m *App) DeleteAd(ctx context.Context, id int64, uid int64) error {
 	ret := _m.Called(ctx, id, uid)
@@ -110,13 +267,81 @@ func (_m *App) DeleteAd(ctx context.Context, id int64, uid int64) error {
 	return r0
 }
 
-// DeleteUser provides a mock function with given fields: ctx, id
-func (_m *App) DeleteUser(ctx context.Context, id int64) error {
-	ret := _m.Called(ctx, id)
+// DeleteSavedSearch provides a mock function with given fields: ctx, uid, id
+func (_m *App) DeleteSavedSearch(ctx context.Context, uid int64, id int64) error {
+	ret := _m.Called(ctx, uid, id)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
-		r0 = rf(ctx, id)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) error); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteUser provides a mock function with given fields: ctx, id, params
+func (_m *App) DeleteUser(ctx context.Context, id int64, params app.DeleteUserParams) error {
+	ret := _m.Called(ctx, id, params)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.DeleteUserParams) error); ok {
+		r0 = rf(ctx, id, params)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, uid, id
+func (_m *App) DeleteWebhook(ctx context.Context, uid int64, id int64) error {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) error); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// EnableWebhook provides a mock function with given fields: ctx, uid, id
+func (_m *App) EnableWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*webhooks.Subscription, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *webhooks.Subscription); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExportAds provides a mock function with given fields: ctx, uid, format, w
+func (_m *App) ExportAds(ctx context.Context, uid *int64, format app.BulkFormat, w io.Writer) error {
+	ret := _m.Called(ctx, uid, format, w)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *int64, app.BulkFormat, io.Writer) error); ok {
+		r0 = rf(ctx, uid, format, w)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -150,6 +375,58 @@ func (_m *App) GetAd(ctx context.Context, id int64) (*ads.Ad, error) {
 	return r0, r1
 }
 
+// GetJob provides a mock function with given fields: ctx, uid, id
+func (_m *App) GetJob(ctx context.Context, uid int64, id int64) (*jobs.Job, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *jobs.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*jobs.Job, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *jobs.Job); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jobs.Job)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedSearch provides a mock function with given fields: ctx, uid, id
+func (_m *App) GetSavedSearch(ctx context.Context, uid int64, id int64) (*searches.SavedSearch, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *searches.SavedSearch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*searches.SavedSearch, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *searches.SavedSearch); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*searches.SavedSearch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetUser provides a mock function with given fields: ctx, id
 func (_m *App) GetUser(ctx context.Context, id int64) (*user.User, error) {
 	ret := _m.Called(ctx, id)
@@ -176,6 +453,58 @@ func (_m *App) GetUser(ctx context.Context, id int64) (*user.User, error) {
 	return r0, r1
 }
 
+// GetWebhook provides a mock function with given fields: ctx, uid, id
+func (_m *App) GetWebhook(ctx context.Context, uid int64, id int64) (*webhooks.Subscription, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*webhooks.Subscription, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *webhooks.Subscription); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImportAds provides a mock function with given fields: ctx, uid, r, format, dryRun
+func (_m *App) ImportAds(ctx context.Context, uid int64, r io.Reader, format app.BulkFormat, dryRun bool) (*app.ImportReport, error) {
+	ret := _m.Called(ctx, uid, r, format, dryRun)
+
+	var r0 *app.ImportReport
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, io.Reader, app.BulkFormat, bool) (*app.ImportReport, error)); ok {
+		return rf(ctx, uid, r, format, dryRun)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, io.Reader, app.BulkFormat, bool) *app.ImportReport); ok {
+		r0 = rf(ctx, uid, r, format, dryRun)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*app.ImportReport)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, io.Reader, app.BulkFormat, bool) error); ok {
+		r1 = rf(ctx, uid, r, format, dryRun)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListAds provides a mock function with given fields: ctx, params
 func (_m *App) ListAds(ctx context.Context, params app.ListAdsParams) (*ads.AdList, error) {
 	ret := _m.Called(ctx, params)
@@ -202,25 +531,25 @@ func (_m *App) ListAds(ctx context.Context, params app.ListAdsParams) (*ads.AdLi
 	return r0, r1
 }
 
-// UpdateAd provides a mock function with given fields: ctx, id, uid, title, text
-func (_m *App) UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error) {
-	ret := _m.Called(ctx, id, uid, title, text)
+// ListNotifications provides a mock function with given fields: ctx, uid, params
+func (_m *App) ListNotifications(ctx context.Context, uid int64, params app.ListNotificationsParams) (*notifications.Page, error) {
+	ret := _m.Called(ctx, uid, params)
 
-	var r0 *ads.Ad
+	var r0 *notifications.Page
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, string) (*ads.Ad, error)); ok {
-		return rf(ctx, id, uid, title, text)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ListNotificationsParams) (*notifications.Page, error)); ok {
+		return rf(ctx, uid, params)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, string) *ads.Ad); ok {
-		r0 = rf(ctx, id, uid, title, text)
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ListNotificationsParams) *notifications.Page); ok {
+		r0 = rf(ctx, uid, params)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*ads.Ad)
+			r0 = ret.Get(0).(*notifications.Page)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, string, string) error); ok {
-		r1 = rf(ctx, id, uid, title, text)
+	if rf, ok := ret.Get(1).(func(context.Context, int64, app.ListNotificationsParams) error); ok {
+		r1 = rf(ctx, uid, params)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -228,25 +557,77 @@ func (_m *App) UpdateAd(ctx context.Context, id int64, uid int64, title string,
 	return r0, r1
 }
 
-// UpdateUser provides a mock function with given fields: ctx, id, nickname, email
-func (_m *App) UpdateUser(ctx context.Context, id int64, nickname string, email string) (*user.User, error) {
-	ret := _m.Called(ctx, id, nickname, email)
+// ListSavedSearches provides a mock function with given fields: ctx, uid
+func (_m *App) ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error) {
+	ret := _m.Called(ctx, uid)
 
-	var r0 *user.User
+	var r0 []searches.SavedSearch
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string) (*user.User, error)); ok {
-		return rf(ctx, id, nickname, email)
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]searches.SavedSearch, error)); ok {
+		return rf(ctx, uid)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string) *user.User); ok {
-		r0 = rf(ctx, id, nickname, email)
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []searches.SavedSearch); ok {
+		r0 = rf(ctx, uid)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*user.User)
+			r0 = ret.Get(0).([]searches.SavedSearch)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int64, string, string) error); ok {
-		r1 = rf(ctx, id, nickname, email)
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhookDeliveries provides a mock function with given fields: ctx, uid, id
+func (_m *App) ListWebhookDeliveries(ctx context.Context, uid int64, id int64) ([]webhooks.Delivery, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 []webhooks.Delivery
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) ([]webhooks.Delivery, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) []webhooks.Delivery); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]webhooks.Delivery)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhooks provides a mock function with given fields: ctx, uid
+func (_m *App) ListWebhooks(ctx context.Context, uid int64) ([]webhooks.Subscription, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 []webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]webhooks.Subscription, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []webhooks.Subscription); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -254,6 +635,481 @@ func (_m *App) UpdateUser(ctx context.Context, id int64, nickname string, email
 	return r0, r1
 }
 
+// Login provides a mock function with given fields: ctx, email, password
+func (_m *App) Login(ctx context.Context, email string, password string) (*user.User, error) {
+	ret := _m.Called(ctx, email, password)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*user.User, error)); ok {
+		return rf(ctx, email, password)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *user.User); ok {
+		r0 = rf(ctx, email, password)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, email, password)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkAllNotificationsRead provides a mock function with given fields: ctx, uid
+func (_m *App) MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (int, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) int); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkNotificationRead provides a mock function with given fields: ctx, uid, id
+func (_m *App) MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *notifications.Notification
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*notifications.Notification, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *notifications.Notification); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*notifications.Notification)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PatchAd provides a mock function with given fields: ctx, id, uid, patch
+func (_m *App) PatchAd(ctx context.Context, id int64, uid int64, patch app.AdPatch) (*ads.Ad, error) {
+	ret := _m.Called(ctx, id, uid, patch)
+
+	var r0 *ads.Ad
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, app.AdPatch) (*ads.Ad, error)); ok {
+		return rf(ctx, id, uid, patch)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, app.AdPatch) *ads.Ad); ok {
+		r0 = rf(ctx, id, uid, patch)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ads.Ad)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, app.AdPatch) error); ok {
+		r1 = rf(ctx, id, uid, patch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PatchUser provides a mock function with given fields: ctx, id, patch
+func (_m *App) PatchUser(ctx context.Context, id int64, patch app.UserPatch) (*user.User, error) {
+	ret := _m.Called(ctx, id, patch)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.UserPatch) (*user.User, error)); ok {
+		return rf(ctx, id, patch)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.UserPatch) *user.User); ok {
+		r0 = rf(ctx, id, patch)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, app.UserPatch) error); ok {
+		r1 = rf(ctx, id, patch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeDeleted provides a mock function with given fields: ctx, retention
+func (_m *App) PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	ret := _m.Called(ctx, retention)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) (int, error)); ok {
+		return rf(ctx, retention)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) int); ok {
+		r0 = rf(ctx, retention)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, retention)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// QueryAudit provides a mock function with given fields: ctx, filter
+func (_m *App) QueryAudit(ctx context.Context, filter audit.Filter) ([]audit.Record, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []audit.Record
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, audit.Filter) ([]audit.Record, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, audit.Filter) []audit.Record); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]audit.Record)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, audit.Filter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Register provides a mock function with given fields: ctx, nickname, email, password
+func (_m *App) Register(ctx context.Context, nickname string, email string, password string) (*user.User, error) {
+	ret := _m.Called(ctx, nickname, email, password)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) (*user.User, error)); ok {
+		return rf(ctx, nickname, email, password)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *user.User); ok {
+		r0 = rf(ctx, nickname, email, password)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, nickname, email, password)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RequestErasure provides a mock function with given fields: ctx, uid
+func (_m *App) RequestErasure(ctx context.Context, uid int64) (*jobs.Job, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 *jobs.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*jobs.Job, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *jobs.Job); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jobs.Job)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RequestExport provides a mock function with given fields: ctx, uid, format
+func (_m *App) RequestExport(ctx context.Context, uid int64, format app.ExportFormat) (*jobs.Job, error) {
+	ret := _m.Called(ctx, uid, format)
+
+	var r0 *jobs.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ExportFormat) (*jobs.Job, error)); ok {
+		return rf(ctx, uid, format)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ExportFormat) *jobs.Job); ok {
+		r0 = rf(ctx, uid, format)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jobs.Job)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, app.ExportFormat) error); ok {
+		r1 = rf(ctx, uid, format)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RequestPasswordReset provides a mock function with given fields: ctx, email
+func (_m *App) RequestPasswordReset(ctx context.Context, email string) error {
+	ret := _m.Called(ctx, email)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, email)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetPassword provides a mock function with given fields: ctx, token, password
+func (_m *App) ResetPassword(ctx context.Context, token string, password string) error {
+	ret := _m.Called(ctx, token, password)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, token, password)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreAd provides a mock function with given fields: ctx, id
+func (_m *App) RestoreAd(ctx context.Context, id int64) (*ads.Ad, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *ads.Ad
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*ads.Ad, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *ads.Ad); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ads.Ad)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreUser provides a mock function with given fields: ctx, id
+func (_m *App) RestoreUser(ctx context.Context, id int64) (*user.User, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*user.User, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *user.User); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SubscribeNotifications provides a mock function with given fields: ctx, uid
+func (_m *App) SubscribeNotifications(ctx context.Context, uid int64) (<-chan notifications.Notification, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 <-chan notifications.Notification
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (<-chan notifications.Notification, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) <-chan notifications.Notification); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan notifications.Notification)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateAd provides a mock function with given fields: ctx, id, uid, title, text
+func (_m *App) UpdateAd(ctx context.Context, id int64, uid int64, title string, text string) (*ads.Ad, error) {
+	ret := _m.Called(ctx, id, uid, title, text)
+
+	var r0 *ads.Ad
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, string) (*ads.Ad, error)); ok {
+		return rf(ctx, id, uid, title, text)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64, string, string) *ads.Ad); ok {
+		r0 = rf(ctx, id, uid, title, text)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ads.Ad)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64, string, string) error); ok {
+		r1 = rf(ctx, id, uid, title, text)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateUser provides a mock function with given fields: ctx, id, nickname, email
+func (_m *App) UpdateUser(ctx context.Context, id int64, nickname string, email string) (*user.User, error) {
+	ret := _m.Called(ctx, id, nickname, email)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string) (*user.User, error)); ok {
+		return rf(ctx, id, nickname, email)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, string) *user.User); ok {
+		r0 = rf(ctx, id, nickname, email)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, string, string) error); ok {
+		r1 = rf(ctx, id, nickname, email)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VerifyEmail provides a mock function with given fields: ctx, token
+func (_m *App) VerifyEmail(ctx context.Context, token string) (*user.User, error) {
+	ret := _m.Called(ctx, token)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*user.User, error)); ok {
+		return rf(ctx, token)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *user.User); ok {
+		r0 = rf(ctx, token)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, token)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// WatchAds provides a mock function with given fields: ctx, params
+func (_m *App) WatchAds(ctx context.Context, params app.ListAdsParams) (<-chan events.Event, func() error, error) {
+	ret := _m.Called(ctx, params)
+
+	var r0 <-chan events.Event
+	var r1 func() error
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, app.ListAdsParams) (<-chan events.Event, func() error, error)); ok {
+		return rf(ctx, params)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, app.ListAdsParams) <-chan events.Event); ok {
+		r0 = rf(ctx, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan events.Event)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, app.ListAdsParams) func() error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(func() error)
+		}
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, app.ListAdsParams) error); ok {
+		r2 = rf(ctx, params)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 type mockConstructorTestingTNewApp interface {
 	mock.TestingT
 	Cleanup(func())
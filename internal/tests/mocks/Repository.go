@@ -4,15 +4,26 @@ package mocks
 
 import (
 	ads "github.com/TobbyMax/ad-service.git/internal/ads"
+
 	app "github.com/TobbyMax/ad-service.git/internal/app"
 
 	context "context"
 
+	events "github.com/TobbyMax/ad-service.git/internal/events"
+
+	jobs "github.com/TobbyMax/ad-service.git/internal/jobs"
+
 	mock "github.com/stretchr/testify/mock"
 
+	notifications "github.com/TobbyMax/ad-service.git/internal/notifications"
+
+	searches "github.com/TobbyMax/ad-service.git/internal/searches"
+
 	time "time"
 
 	user "github.com/TobbyMax/ad-service.git/internal/user"
+
+	webhooks "github.com/TobbyMax/ad-service.git/internal/webhooks"
 )
 
 // Repository is an autogenerated mock type for the Repository type
@@ -20,6 +31,58 @@ type Repository struct {
 	mock.Mock
 }
 
+// ActiveSavedSearches provides a mock function with given fields: ctx
+func (_m *Repository) ActiveSavedSearches(ctx context.Context) ([]searches.SavedSearch, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []searches.SavedSearch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]searches.SavedSearch, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []searches.SavedSearch); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]searches.SavedSearch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ActiveWebhooks provides a mock function with given fields: ctx, event
+func (_m *Repository) ActiveWebhooks(ctx context.Context, event string) ([]webhooks.Subscription, error) {
+	ret := _m.Called(ctx, event)
+
+	var r0 []webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]webhooks.Subscription, error)); ok {
+		return rf(ctx, event)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []webhooks.Subscription); ok {
+		r0 = rf(ctx, event)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, event)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // AddAd provides a mock function with given fields: ctx, ad
 func (_m *Repository) AddAd(ctx context.Context, ad ads.Ad) (int64, error) {
 	ret := _m.Called(ctx, ad)
@@ -44,6 +107,106 @@ func (_m *Repository) AddAd(ctx context.Context, ad ads.Ad) (int64, error) {
 	return r0, r1
 }
 
+// AddEvent provides a mock function with given fields: ctx, e
+func (_m *Repository) AddEvent(ctx context.Context, e events.Event) error {
+	ret := _m.Called(ctx, e)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, events.Event) error); ok {
+		r0 = rf(ctx, e)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddJob provides a mock function with given fields: ctx, j
+func (_m *Repository) AddJob(ctx context.Context, j jobs.Job) (int64, error) {
+	ret := _m.Called(ctx, j)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, jobs.Job) (int64, error)); ok {
+		return rf(ctx, j)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, jobs.Job) int64); ok {
+		r0 = rf(ctx, j)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, jobs.Job) error); ok {
+		r1 = rf(ctx, j)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddNotification provides a mock function with given fields: ctx, n
+func (_m *Repository) AddNotification(ctx context.Context, n notifications.Notification) (int64, error) {
+	ret := _m.Called(ctx, n)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, notifications.Notification) (int64, error)); ok {
+		return rf(ctx, n)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, notifications.Notification) int64); ok {
+		r0 = rf(ctx, n)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, notifications.Notification) error); ok {
+		r1 = rf(ctx, n)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddSavedSearch provides a mock function with given fields: ctx, s
+func (_m *Repository) AddSavedSearch(ctx context.Context, s searches.SavedSearch) (int64, error) {
+	ret := _m.Called(ctx, s)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, searches.SavedSearch) (int64, error)); ok {
+		return rf(ctx, s)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, searches.SavedSearch) int64); ok {
+		r0 = rf(ctx, s)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, searches.SavedSearch) error); ok {
+		r1 = rf(ctx, s)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddToken provides a mock function with given fields: ctx, t
+func (_m *Repository) AddToken(ctx context.Context, t user.Token) error {
+	ret := _m.Called(ctx, t)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, user.Token) error); ok {
+		r0 = rf(ctx, t)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AddUser provides a mock function with given fields: ctx, u
 func (_m *Repository) AddUser(ctx context.Context, u user.User) (int64, error) {
 	ret := _m.Called(ctx, u)
@@ -68,6 +231,128 @@ func (_m *Repository) AddUser(ctx context.Context, u user.User) (int64, error) {
 	return r0, r1
 }
 
+// AddWebhook provides a mock function with given fields: ctx, s
+func (_m *Repository) AddWebhook(ctx context.Context, s webhooks.Subscription) (int64, error) {
+	ret := _m.Called(ctx, s)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, webhooks.Subscription) (int64, error)); ok {
+		return rf(ctx, s)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, webhooks.Subscription) int64); ok {
+		r0 = rf(ctx, s)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, webhooks.Subscription) error); ok {
+		r1 = rf(ctx, s)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddWebhookDelivery provides a mock function with given fields: ctx, d
+func (_m *Repository) AddWebhookDelivery(ctx context.Context, d webhooks.Delivery) (int, error) {
+	ret := _m.Called(ctx, d)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, webhooks.Delivery) (int, error)); ok {
+		return rf(ctx, d)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, webhooks.Delivery) int); ok {
+		r0 = rf(ctx, d)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, webhooks.Delivery) error); ok {
+		r1 = rf(ctx, d)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AdsChangedAt provides a mock function with given fields: ctx
+func (_m *Repository) AdsChangedAt(ctx context.Context) (time.Time, error) {
+	ret := _m.Called(ctx)
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (time.Time, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) time.Time); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountUnreadNotifications provides a mock function with given fields: ctx, uid
+func (_m *Repository) CountUnreadNotifications(ctx context.Context, uid int64) (int, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (int, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) int); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeadLetters provides a mock function with given fields: ctx
+func (_m *Repository) DeadLetters(ctx context.Context) ([]events.Message, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []events.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]events.Message, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []events.Message); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]events.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteAdByID provides a mock function with given fields: ctx, id
 func (_m *Repository) DeleteAdByID(ctx context.Context, id int64) error {
 	ret := _m.Called(ctx, id)
@@ -82,6 +367,34 @@ func (_m *Repository) DeleteAdByID(ctx context.Context, id int64) error {
 	return r0
 }
 
+// DeleteSavedSearch provides a mock function with given fields: ctx, id
+func (_m *Repository) DeleteSavedSearch(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteToken provides a mock function with given fields: ctx, value
+func (_m *Repository) DeleteToken(ctx context.Context, value string) error {
+	ret := _m.Called(ctx, value)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, value)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteUserByID provides a mock function with given fields: ctx, id
 func (_m *Repository) DeleteUserByID(ctx context.Context, id int64) error {
 	ret := _m.Called(ctx, id)
@@ -96,6 +409,98 @@ func (_m *Repository) DeleteUserByID(ctx context.Context, id int64) error {
 	return r0
 }
 
+// DeleteUserKeepAds provides a mock function with given fields: ctx, id, newAuthor
+func (_m *Repository) DeleteUserKeepAds(ctx context.Context, id int64, newAuthor int64) error {
+	ret := _m.Called(ctx, id, newAuthor)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) error); ok {
+		r0 = rf(ctx, id, newAuthor)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, id
+func (_m *Repository) DeleteWebhook(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DueMessages provides a mock function with given fields: ctx, now, limit
+func (_m *Repository) DueMessages(ctx context.Context, now time.Time, limit int) ([]events.Message, error) {
+	ret := _m.Called(ctx, now, limit)
+
+	var r0 []events.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) ([]events.Message, error)); ok {
+		return rf(ctx, now, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) []events.Message); ok {
+		r0 = rf(ctx, now, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]events.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(ctx, now, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EraseUser provides a mock function with given fields: ctx, id
+func (_m *Repository) EraseUser(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExpireJobResults provides a mock function with given fields: ctx, now
+func (_m *Repository) ExpireJobResults(ctx context.Context, now time.Time) (int, error) {
+	ret := _m.Called(ctx, now)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int, error)); ok {
+		return rf(ctx, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, now)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAdByID provides a mock function with given fields: ctx, id
 func (_m *Repository) GetAdByID(ctx context.Context, id int64) (*ads.Ad, error) {
 	ret := _m.Called(ctx, id)
@@ -148,23 +553,271 @@ func (_m *Repository) GetAdList(ctx context.Context, params app.ListAdsParams) (
 	return r0, r1
 }
 
-// GetUserByID provides a mock function with given fields: ctx, id
-func (_m *Repository) GetUserByID(ctx context.Context, id int64) (*user.User, error) {
+// GetJobByID provides a mock function with given fields: ctx, id
+func (_m *Repository) GetJobByID(ctx context.Context, id int64) (*jobs.Job, error) {
 	ret := _m.Called(ctx, id)
 
-	var r0 *user.User
+	var r0 *jobs.Job
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int64) (*user.User, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*jobs.Job, error)); ok {
 		return rf(ctx, id)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int64) *user.User); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *jobs.Job); ok {
 		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jobs.Job)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSavedSearchByID provides a mock function with given fields: ctx, id
+func (_m *Repository) GetSavedSearchByID(ctx context.Context, id int64) (*searches.SavedSearch, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *searches.SavedSearch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*searches.SavedSearch, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *searches.SavedSearch); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*searches.SavedSearch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetToken provides a mock function with given fields: ctx, value
+func (_m *Repository) GetToken(ctx context.Context, value string) (*user.Token, error) {
+	ret := _m.Called(ctx, value)
+
+	var r0 *user.Token
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*user.Token, error)); ok {
+		return rf(ctx, value)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *user.Token); ok {
+		r0 = rf(ctx, value)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.Token)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, value)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserByEmail provides a mock function with given fields: ctx, email
+func (_m *Repository) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	ret := _m.Called(ctx, email)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*user.User, error)); ok {
+		return rf(ctx, email)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *user.User); ok {
+		r0 = rf(ctx, email)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*user.User)
 		}
 	}
 
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, email)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserByID provides a mock function with given fields: ctx, id
+func (_m *Repository) GetUserByID(ctx context.Context, id int64) (*user.User, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*user.User, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *user.User); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserIncludingDeleted provides a mock function with given fields: ctx, id
+func (_m *Repository) GetUserIncludingDeleted(ctx context.Context, id int64) (*user.User, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *user.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*user.User, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *user.User); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*user.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhookByID provides a mock function with given fields: ctx, id
+func (_m *Repository) GetWebhookByID(ctx context.Context, id int64) (*webhooks.Subscription, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*webhooks.Subscription, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *webhooks.Subscription); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InTransaction provides a mock function with given fields: ctx, fn
+func (_m *Repository) InTransaction(ctx context.Context, fn func(ctx context.Context, tx app.Repository) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(ctx context.Context, tx app.Repository) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListNotifications provides a mock function with given fields: ctx, uid, params
+func (_m *Repository) ListNotifications(ctx context.Context, uid int64, params app.ListNotificationsParams) ([]notifications.Notification, error) {
+	ret := _m.Called(ctx, uid, params)
+
+	var r0 []notifications.Notification
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ListNotificationsParams) ([]notifications.Notification, error)); ok {
+		return rf(ctx, uid, params)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, app.ListNotificationsParams) []notifications.Notification); ok {
+		r0 = rf(ctx, uid, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]notifications.Notification)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, app.ListNotificationsParams) error); ok {
+		r1 = rf(ctx, uid, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListSavedSearches provides a mock function with given fields: ctx, uid
+func (_m *Repository) ListSavedSearches(ctx context.Context, uid int64) ([]searches.SavedSearch, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 []searches.SavedSearch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]searches.SavedSearch, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []searches.SavedSearch); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]searches.SavedSearch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhookDeliveries provides a mock function with given fields: ctx, id
+func (_m *Repository) ListWebhookDeliveries(ctx context.Context, id int64) ([]webhooks.Delivery, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []webhooks.Delivery
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]webhooks.Delivery, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []webhooks.Delivery); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]webhooks.Delivery)
+		}
+	}
+
 	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
 		r1 = rf(ctx, id)
 	} else {
@@ -174,6 +827,244 @@ func (_m *Repository) GetUserByID(ctx context.Context, id int64) (*user.User, er
 	return r0, r1
 }
 
+// ListWebhooks provides a mock function with given fields: ctx, ownerID
+func (_m *Repository) ListWebhooks(ctx context.Context, ownerID int64) ([]webhooks.Subscription, error) {
+	ret := _m.Called(ctx, ownerID)
+
+	var r0 []webhooks.Subscription
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]webhooks.Subscription, error)); ok {
+		return rf(ctx, ownerID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []webhooks.Subscription); ok {
+		r0 = rf(ctx, ownerID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]webhooks.Subscription)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, ownerID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkAllNotificationsRead provides a mock function with given fields: ctx, uid
+func (_m *Repository) MarkAllNotificationsRead(ctx context.Context, uid int64) (int, error) {
+	ret := _m.Called(ctx, uid)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (int, error)); ok {
+		return rf(ctx, uid)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) int); ok {
+		r0 = rf(ctx, uid)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, uid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkDelivered provides a mock function with given fields: ctx, id
+func (_m *Repository) MarkDelivered(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkFailed provides a mock function with given fields: ctx, id, reason, next
+func (_m *Repository) MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error {
+	ret := _m.Called(ctx, id, reason, next)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string, time.Time) error); ok {
+		r0 = rf(ctx, id, reason, next)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkHandled provides a mock function with given fields: ctx, id, subscribers
+func (_m *Repository) MarkHandled(ctx context.Context, id int64, subscribers []string) error {
+	ret := _m.Called(ctx, id, subscribers)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, []string) error); ok {
+		r0 = rf(ctx, id, subscribers)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MarkNotificationRead provides a mock function with given fields: ctx, uid, id
+func (_m *Repository) MarkNotificationRead(ctx context.Context, uid int64, id int64) (*notifications.Notification, error) {
+	ret := _m.Called(ctx, uid, id)
+
+	var r0 *notifications.Notification
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) (*notifications.Notification, error)); ok {
+		return rf(ctx, uid, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int64) *notifications.Notification); ok {
+		r0 = rf(ctx, uid, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*notifications.Notification)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int64) error); ok {
+		r1 = rf(ctx, uid, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MoveToDeadLetters provides a mock function with given fields: ctx, id, reason
+func (_m *Repository) MoveToDeadLetters(ctx context.Context, id int64, reason string) error {
+	ret := _m.Called(ctx, id, reason)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, string) error); ok {
+		r0 = rf(ctx, id, reason)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PendingMessages provides a mock function with given fields: ctx, limit
+func (_m *Repository) PendingMessages(ctx context.Context, limit int) ([]events.Message, error) {
+	ret := _m.Called(ctx, limit)
+
+	var r0 []events.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]events.Message, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []events.Message); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]events.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeDeleted provides a mock function with given fields: ctx, before
+func (_m *Repository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int, error)); ok {
+		return rf(ctx, before)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreAdByID provides a mock function with given fields: ctx, id
+func (_m *Repository) RestoreAdByID(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreUserByID provides a mock function with given fields: ctx, id
+func (_m *Repository) RestoreUserByID(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetUserVerified provides a mock function with given fields: ctx, id, verified
+func (_m *Repository) SetUserVerified(ctx context.Context, id int64, verified bool) error {
+	ret := _m.Called(ctx, id, verified)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, bool) error); ok {
+		r0 = rf(ctx, id, verified)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetWebhookActive provides a mock function with given fields: ctx, id, active
+func (_m *Repository) SetWebhookActive(ctx context.Context, id int64, active bool) error {
+	ret := _m.Called(ctx, id, active)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, bool) error); ok {
+		r0 = rf(ctx, id, active)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateAdContent provides a mock function with given fields: ctx, id, title, text, date
 func (_m *Repository) UpdateAdContent(ctx context.Context, id int64, title string, text string, date time.Time) error {
 	ret := _m.Called(ctx, id, title, text, date)
@@ -202,6 +1093,34 @@ func (_m *Repository) UpdateAdStatus(ctx context.Context, id int64, published bo
 	return r0
 }
 
+// UpdateJob provides a mock function with given fields: ctx, j
+func (_m *Repository) UpdateJob(ctx context.Context, j jobs.Job) error {
+	ret := _m.Called(ctx, j)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, jobs.Job) error); ok {
+		r0 = rf(ctx, j)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateSavedSearch provides a mock function with given fields: ctx, s
+func (_m *Repository) UpdateSavedSearch(ctx context.Context, s searches.SavedSearch) error {
+	ret := _m.Called(ctx, s)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, searches.SavedSearch) error); ok {
+		r0 = rf(ctx, s)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateUser provides a mock function with given fields: ctx, id, nickname, email
 func (_m *Repository) UpdateUser(ctx context.Context, id int64, nickname string, email string) error {
 	ret := _m.Called(ctx, id, nickname, email)
@@ -216,6 +1135,20 @@ func (_m *Repository) UpdateUser(ctx context.Context, id int64, nickname string,
 	return r0
 }
 
+// UpdateUserPassword provides a mock function with given fields: ctx, id, hash
+func (_m *Repository) UpdateUserPassword(ctx context.Context, id int64, hash []byte) error {
+	ret := _m.Called(ctx, id, hash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, []byte) error); ok {
+		r0 = rf(ctx, id, hash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewRepository interface {
 	mock.TestingT
 	Cleanup(func())
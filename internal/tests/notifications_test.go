@@ -0,0 +1,298 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/eventbus"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/notifier"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func addNotifications(t *testing.T, repo app.Repository, uid int64, count int) []int64 {
+	ids := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		id, err := repo.AddNotification(context.Background(), notifications.Notification{
+			UserID:  uid,
+			Kind:    notifications.KindSearchMatch,
+			Subject: "New ads",
+			Created: time.Now().UTC(),
+		})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func receive(t *testing.T, ch <-chan notifications.Notification) notifications.Notification {
+	select {
+	case n, ok := <-ch:
+		require.True(t, ok, "subscription is closed")
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("notification is not received")
+	}
+	return notifications.Notification{}
+}
+
+func TestStatusNotifications(t *testing.T) {
+	repo := adrepo.New()
+	hub := notifier.NewHub()
+	a := app.NewApp(repo, app.WithNotificationHub(hub))
+	statuses := app.NewStatusNotifications(notifier.NewInbox(repo, hub))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ch, err := a.SubscribeNotifications(ctx, u.ID)
+	require.NoError(t, err)
+
+	ad, err := a.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, u.ID, true)
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, u.ID, false)
+	require.NoError(t, err)
+	_, err = app.NewOutboxRelay(repo, handlerPublisher(statuses.Handle)).Flush(ctx)
+	require.NoError(t, err)
+
+	published := receive(t, ch)
+	assert.Equal(t, notifications.KindAdPublished, published.Kind)
+	assert.Equal(t, []int64{ad.ID}, published.AdIDs)
+	assert.Contains(t, published.Subject, "Red bike")
+	assert.Equal(t, notifications.KindAdUnpublished, receive(t, ch).Kind)
+
+	page, err := a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	require.Len(t, page.Notifications, 2)
+	assert.Equal(t, notifications.KindAdUnpublished, page.Notifications[0].Kind)
+	assert.Equal(t, 2, page.Unread)
+	assert.Nil(t, page.Next)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestInbox_Pagination(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	ctx := context.Background()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := a.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	ids := addNotifications(t, repo, u.ID, 5)
+	addNotifications(t, repo, other.ID, 1)
+
+	page, err := a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Notifications, 2)
+	assert.Equal(t, ids[4], page.Notifications[0].ID)
+	assert.Equal(t, ids[3], page.Notifications[1].ID)
+	require.NotNil(t, page.Next)
+
+	page, err = a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{Limit: 2, Before: page.Next})
+	require.NoError(t, err)
+	require.Len(t, page.Notifications, 2)
+	assert.Equal(t, ids[2], page.Notifications[0].ID)
+
+	page, err = a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{Limit: 2, Before: page.Next})
+	require.NoError(t, err)
+	require.Len(t, page.Notifications, 1)
+	assert.Equal(t, ids[0], page.Notifications[0].ID)
+	assert.Nil(t, page.Next)
+
+	_, err = a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{Limit: app.MaxNotificationsLimit + 1})
+	assert.ErrorIs(t, err, app.ErrInvalidPage)
+	_, err = a.ListNotifications(ctx, 100, app.ListNotificationsParams{})
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+
+	// notifications of other users can't be marked
+	_, err = a.MarkNotificationRead(ctx, other.ID, ids[1])
+	assert.ErrorIs(t, err, app.ErrNotificationNotFound)
+	n, err := a.MarkNotificationRead(ctx, u.ID, ids[1])
+	require.NoError(t, err)
+	assert.True(t, n.Read)
+
+	unread, err := a.CountUnreadNotifications(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, unread)
+	page, err = a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{UnreadOnly: true})
+	require.NoError(t, err)
+	assert.Len(t, page.Notifications, 4)
+
+	marked, err := a.MarkAllNotificationsRead(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, marked)
+	unread, err = a.CountUnreadNotifications(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, unread)
+}
+
+func TestNotificationHub(t *testing.T) {
+	hub := notifier.NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := hub.Subscribe(ctx, 1)
+	other := hub.Subscribe(ctx, 2)
+	// a slow subscriber loses the notifications above its buffer, but never blocks the publisher
+	for i := 0; i < notifier.DefaultSubscriptionBuffer+5; i++ {
+		hub.Publish(notifications.Notification{ID: int64(i), UserID: 1})
+	}
+	assert.Len(t, slow, notifier.DefaultSubscriptionBuffer)
+	assert.Len(t, other, 0)
+
+	hub.Close()
+	_, ok := <-other
+	assert.False(t, ok)
+	_, ok = <-hub.Subscribe(ctx, 3)
+	assert.False(t, ok)
+}
+
+func TestHTTPNotifications(t *testing.T) {
+	repo := adrepo.New()
+	client := getTestClientWithApp(app.NewApp(repo))
+
+	u, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ids := addNotifications(t, repo, u.Data.ID, 3)
+
+	page, err := client.listNotifications(u.Data.ID, url.Values{"limit": {"2"}})
+	require.NoError(t, err)
+	require.Len(t, page.Data.Notifications, 2)
+	assert.Equal(t, ids[2], page.Data.Notifications[0].ID)
+	assert.Equal(t, 3, page.Data.Unread)
+	require.NotNil(t, page.Data.Next)
+	assert.NotNil(t, page.Data.Notifications[0].AdIDs)
+
+	_, err = client.listNotifications(u.Data.ID, url.Values{"limit": {"abc"}})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.listNotifications(u.Data.ID, url.Values{"limit": {"1000"}})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.listNotifications(100, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	n, err := client.markNotificationRead(u.Data.ID, ids[0])
+	require.NoError(t, err)
+	assert.True(t, n.Data.Read)
+	_, err = client.markNotificationRead(u.Data.ID, 100)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	unread, err := client.countUnreadNotifications(u.Data.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+	page, err = client.listNotifications(u.Data.ID, url.Values{"unread": {"true"}})
+	require.NoError(t, err)
+	assert.Len(t, page.Data.Notifications, 2)
+
+	marked, err := client.markAllNotificationsRead(u.Data.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, marked)
+	unread, err = client.countUnreadNotifications(u.Data.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, unread)
+}
+
+func (suite *GRPCSuite) TestGRPCNotifications() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+	inbox := notifier.NewInbox(suite.Repo, suite.Hub)
+
+	ctx, cancel := context.WithCancel(suite.Context)
+	defer cancel()
+	stream, err := suite.Client.SubscribeNotifications(ctx, &grpcPort.UserNotificationsRequest{UserId: &u.Id})
+	suite.NoError(err)
+	// notifications published before the call reaches the server are only in the inbox
+	suite.Eventually(func() bool {
+		return suite.Hub.Subscribers(u.Id) == 1
+	}, time.Second, 10*time.Millisecond)
+	suite.NoError(inbox.Notify(suite.Context, notifications.Notification{UserID: u.Id, Kind: notifications.KindAdPublished}))
+
+	n, err := stream.Recv()
+	suite.NoError(err)
+	suite.Equal(notifications.KindAdPublished, n.Kind)
+
+	page, err := suite.Client.ListNotifications(suite.Context, &grpcPort.ListNotificationsRequest{UserId: &u.Id})
+	suite.NoError(err)
+	suite.Len(page.List, 1)
+	suite.Equal(int64(1), page.Unread)
+
+	read, err := suite.Client.MarkNotificationRead(suite.Context, &grpcPort.NotificationRequest{UserId: &u.Id, NotificationId: &n.Id})
+	suite.NoError(err)
+	suite.True(read.Read)
+	marked, err := suite.Client.MarkAllNotificationsRead(suite.Context, &grpcPort.UserNotificationsRequest{UserId: &u.Id})
+	suite.NoError(err)
+	suite.Equal(int64(0), marked.Marked)
+
+	_, err = suite.Client.MarkNotificationRead(suite.Context, &grpcPort.NotificationRequest{UserId: &u.Id})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.ListNotifications(suite.Context, &grpcPort.ListNotificationsRequest{UserId: &u.Id, Limit: -1})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	missing := int64(100)
+	stream, err = suite.Client.SubscribeNotifications(suite.Context, &grpcPort.UserNotificationsRequest{UserId: &missing})
+	suite.NoError(err)
+	_, err = stream.Recv()
+	suite.Equal(codes.NotFound, status.Code(err))
+}
+
+// the failure of another subscriber makes the relay deliver the event again, the inbox must not get it twice
+func TestStatusNotifications_NotDuplicatedByRetries(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	statuses := app.NewStatusNotifications(notifier.NewInbox(repo, notifier.NewHub()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := eventbus.New(eventbus.WithRetry(1, 0))
+	bus.Subscribe("statuses", statuses.Handle)
+	failures := 1
+	bus.Subscribe("index", func(ctx context.Context, e events.Event) error {
+		if e.EventName() == events.NameAdPublished && failures > 0 {
+			failures--
+			return errors.New("index is down")
+		}
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- bus.Run(ctx)()
+	}()
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	ad, err := a.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, u.ID, true)
+	require.NoError(t, err)
+
+	relay := app.NewOutboxRelay(repo, bus, app.WithRelayRetry(3, 0))
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	page, err := a.ListNotifications(ctx, u.ID, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	require.Len(t, page.Notifications, 1)
+	assert.Equal(t, notifications.KindAdPublished, page.Notifications[0].Kind)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+type notificationData struct {
+	ID       int64   `json:"id"`
+	UserID   int64   `json:"user_id"`
+	Kind     string  `json:"kind"`
+	Subject  string  `json:"subject"`
+	Text     string  `json:"text"`
+	AdIDs    []int64 `json:"ad_ids"`
+	SearchID *int64  `json:"search_id"`
+	Read     bool    `json:"read"`
+}
+
+type notificationResponse struct {
+	Data notificationData `json:"data"`
+}
+
+type notificationPageResponse struct {
+	Data struct {
+		Notifications []notificationData `json:"notifications"`
+		Unread        int                `json:"unread"`
+		Next          *int64             `json:"next"`
+	} `json:"data"`
+}
+
+type notificationCountResponse struct {
+	Data map[string]int `json:"data"`
+}
+
+func getTestClientWithApp(a app.App) *testClient {
+	server := httpgin.NewHTTPServer(":18080", a)
+	testServer := httptest.NewServer(server.Handler)
+
+	return &testClient{
+		client:  testServer.Client(),
+		baseURL: testServer.URL,
+	}
+}
+
+func (tc *testClient) listNotifications(userID any, query url.Values) (notificationPageResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/notifications?%s", userID, query.Encode()), nil)
+	if err != nil {
+		return notificationPageResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response notificationPageResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return notificationPageResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) countUnreadNotifications(userID any) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/notifications/unread", userID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response notificationCountResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.Data["unread"], nil
+}
+
+func (tc *testClient) markNotificationRead(userID any, notificationID any) (notificationResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/notifications/%v/read", userID, notificationID), nil)
+	if err != nil {
+		return notificationResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response notificationResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return notificationResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) markAllNotificationsRead(userID any) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/notifications/read", userID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response notificationCountResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.Data["marked"], nil
+}
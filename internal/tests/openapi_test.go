@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters []struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+	Responses map[string]any `json:"responses"`
+}
+
+func getOpenAPI(t *testing.T) (*httptest.Server, openAPIDocument, []byte) {
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New()))
+	testServer := httptest.NewServer(server.Handler)
+
+	resp, err := http.Get(testServer.URL + "/api/v1/openapi.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(body, &doc))
+	return testServer, doc, body
+}
+
+// TestOpenAPIRoutes fails, when a route is added to the router without the document or the other way round
+func TestOpenAPIRoutes(t *testing.T) {
+	testServer, doc, _ := getOpenAPI(t)
+	defer testServer.Close()
+	require.Len(t, doc.Servers, 1)
+	assert.Equal(t, httpgin.APIPrefix, doc.Servers[0].URL)
+
+	var routes []string
+	for _, r := range testServer.Config.Handler.(*gin.Engine).Routes() {
+		path, ok := strings.CutPrefix(r.Path, httpgin.APIPrefix)
+		require.True(t, ok, r.Path)
+		routes = append(routes, r.Method+" "+httpgin.OpenAPIPath(path))
+	}
+	var documented []string
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented)
+
+	pathParam := regexp.MustCompile(`{([a-z_]+)}`)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			var params []string
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					params = append(params, p.Name)
+				}
+			}
+			var expected []string
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				expected = append(expected, m[1])
+			}
+			assert.Equal(t, expected, params, method+" "+path)
+			assert.Contains(t, op.Responses, "500", method+" "+path)
+		}
+	}
+}
+
+func TestOpenAPIReferences(t *testing.T) {
+	testServer, doc, body := getOpenAPI(t)
+	defer testServer.Close()
+
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(body, &raw))
+	refs := regexp.MustCompile(`"\$ref": "#/components/(schemas|responses)/([A-Za-z]+)"`).FindAllStringSubmatch(string(body), -1)
+	require.NotEmpty(t, refs)
+	components := raw["components"].(map[string]any)
+	for _, ref := range refs {
+		section := components[ref[1]].(map[string]any)
+		assert.Contains(t, section, ref[2])
+	}
+	assert.Contains(t, doc.Components.Schemas, "AdResponse")
+	assert.Contains(t, doc.Components.Schemas, "Problem")
+}
+
+// the responses of the service have the fields of the schemas
+func TestOpenAPIResponses(t *testing.T) {
+	testServer, doc, _ := getOpenAPI(t)
+	defer testServer.Close()
+	client := &testClient{client: testServer.Client(), baseURL: testServer.URL}
+
+	keys := func(m map[string]any) []string {
+		var list []string
+		for k := range m {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		return list
+	}
+	check := func(resp *http.Response, schema string) {
+		defer resp.Body.Close()
+		var envelope map[string]map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&envelope))
+		assert.Equal(t, keys(doc.Components.Schemas[schema].Properties), keys(envelope["data"]), schema)
+	}
+
+	resp, _ := postWithKey(t, client, "/api/v1/users", "", map[string]any{"nickname": "Mac Miller", "email": "swimming@circles.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err := http.Get(testServer.URL + "/api/v1/users/0")
+	require.NoError(t, err)
+	check(resp, "UserResponse")
+
+	_, err = client.createAd(0, "Bike", "New")
+	require.NoError(t, err)
+	resp, err = http.Get(testServer.URL + "/api/v1/ads/0")
+	require.NoError(t, err)
+	check(resp, "AdResponse")
+
+	resp, err = http.Get(testServer.URL + "/api/v1/docs")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html"))
+}
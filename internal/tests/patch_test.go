@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPPatchAd(t *testing.T) {
+	client := getTestClient()
+
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	_, err = client.createUser("Travis Scott", "astro@world.com")
+	require.NoError(t, err)
+	ad, err := client.createAd(0, "Bike", "New")
+	require.NoError(t, err)
+
+	res, err := client.patchAd(ad.Data.ID, map[string]any{"user_id": 0, "text": "Old"})
+	require.NoError(t, err)
+	assert.Equal(t, "Bike", res.Data.Title)
+	assert.Equal(t, "Old", res.Data.Text)
+
+	res, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 0, "title": "Car"})
+	require.NoError(t, err)
+	assert.Equal(t, "Car", res.Data.Title)
+	assert.Equal(t, "Old", res.Data.Text)
+
+	// the empty patch changes nothing
+	res, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 0})
+	require.NoError(t, err)
+	assert.Equal(t, "Car", res.Data.Title)
+
+	_, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 0, "title": ""})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 0, "text": nil})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 0, "published": true})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchAd(ad.Data.ID, map[string]any{"user_id": 1, "text": "Stolen"})
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = client.patchAd(100, map[string]any{"user_id": 0, "text": "Old"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestHTTPPatchUser(t *testing.T) {
+	client := getTestClient()
+
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	res, err := client.patchUser(0, map[string]any{"nickname": "Larry Fisherman"})
+	require.NoError(t, err)
+	assert.Equal(t, "Larry Fisherman", res.Data.Nickname)
+	assert.Equal(t, "swimming@circles.com", res.Data.Email)
+
+	res, err = client.patchUser(0, map[string]any{"email": "faces@circles.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "Larry Fisherman", res.Data.Nickname)
+	assert.Equal(t, "faces@circles.com", res.Data.Email)
+
+	_, err = client.patchUser(0, map[string]any{"email": "circles"})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchUser(0, map[string]any{"nickname": ""})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchUser(0, map[string]any{"nickname": nil})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	_, err = client.patchUser(5, map[string]any{"nickname": "Larry"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestHTTPPatchUserEmailTaken(t *testing.T) {
+	client := getTestClient()
+
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	u, err := client.createUser("KDot", "money@trees.com")
+	require.NoError(t, err)
+
+	_, err = client.patchUser(u.Data.ID, map[string]any{"email": "swimming@circles.com"})
+	assert.ErrorIs(t, err, ErrConflict)
+	res, err := client.getUser(u.Data.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "money@trees.com", res.Data.Email)
+
+	// the user keeps the own email
+	res, err = client.patchUser(u.Data.ID, map[string]any{"email": "money@trees.com", "nickname": "Kendrick"})
+	require.NoError(t, err)
+	assert.Equal(t, "Kendrick", res.Data.Nickname)
+}
+
+// only the changed fields are validated, so the ad with the invalid title can still get a new text
+func TestHTTPPatchAdValidatesChangedFields(t *testing.T) {
+	repo := adrepo.New()
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(repo))
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+	client := &testClient{client: testServer.Client(), baseURL: testServer.URL}
+
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	title := strings.Repeat("a", 150)
+	id, err := repo.AddAd(context.Background(), ads.Ad{Title: title, Text: "New", AuthorID: 0})
+	require.NoError(t, err)
+
+	res, err := client.patchAd(id, map[string]any{"user_id": 0, "text": "Old"})
+	require.NoError(t, err)
+	assert.Equal(t, title, res.Data.Title)
+	assert.Equal(t, "Old", res.Data.Text)
+
+	_, err = client.updateAd(0, id, title, "Older")
+	assert.ErrorIs(t, err, ErrBadRequest)
+}
+
+func (suite *GRPCSuite) TestGRPCUpdateAdMask() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "Bike", Text: "New", UserId: &u.Id})
+	suite.NoError(err)
+
+	res, err := suite.Client.UpdateAd(suite.Context, &grpcPort.UpdateAdRequest{AdId: &ad.Id, UserId: &u.Id,
+		Text: "Old", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"text"}}})
+	suite.NoError(err)
+	suite.Equal("Bike", res.Title)
+	suite.Equal("Old", res.Text)
+
+	// the empty title is not validated, because it is not in the mask
+	res, err = suite.Client.UpdateAd(suite.Context, &grpcPort.UpdateAdRequest{AdId: &ad.Id, UserId: &u.Id,
+		Title: "Car", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}})
+	suite.NoError(err)
+	suite.Equal("Car", res.Title)
+	suite.Equal("Old", res.Text)
+
+	_, err = suite.Client.UpdateAd(suite.Context, &grpcPort.UpdateAdRequest{AdId: &ad.Id, UserId: &u.Id,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.UpdateAd(suite.Context, &grpcPort.UpdateAdRequest{AdId: &ad.Id, UserId: &u.Id,
+		Title: "Car", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"published"}}})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	// without the mask all of the fields are changed
+	_, err = suite.Client.UpdateAd(suite.Context, &grpcPort.UpdateAdRequest{AdId: &ad.Id, UserId: &u.Id, Text: "New"})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (suite *GRPCSuite) TestGRPCUpdateUserMask() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	res, err := suite.Client.UpdateUser(suite.Context, &grpcPort.UpdateUserRequest{Id: &u.Id,
+		Name: "Ivan", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}})
+	suite.NoError(err)
+	suite.Equal("Ivan", res.Name)
+	suite.Equal("ivanov@yandex.ru", res.Email)
+
+	res, err = suite.Client.UpdateUser(suite.Context, &grpcPort.UpdateUserRequest{Id: &u.Id,
+		Email: "ivan@yandex.ru", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}}})
+	suite.NoError(err)
+	suite.Equal("Ivan", res.Name)
+	suite.Equal("ivan@yandex.ru", res.Email)
+
+	_, err = suite.Client.UpdateUser(suite.Context, &grpcPort.UpdateUserRequest{Id: &u.Id,
+		Email: "ivan", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}}})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.UpdateUser(suite.Context, &grpcPort.UpdateUserRequest{Id: &u.Id,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}}})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,397 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func (suite *HTTPSuite) TestExport_JSON() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	deleted, err := suite.Client.createAd(u.Data.ID, "Self Care", "Swimming")
+	suite.NoError(err)
+	_, err = suite.Client.deleteAd(deleted.Data.ID, u.Data.ID)
+	suite.NoError(err)
+
+	job, err := suite.Client.requestExport(u.Data.ID, "json")
+	suite.NoError(err)
+	suite.Equal("export", job.Data.Kind)
+
+	job, err = suite.Client.waitJob(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+	suite.Equal("done", job.Data.Status)
+
+	contentType, body, err := suite.Client.downloadJobResult(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+	suite.Equal(app.ContentTypeJSON, contentType)
+
+	var data struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+		Ads []struct {
+			ID        int64   `json:"id"`
+			DeletedAt *string `json:"deleted_at"`
+		} `json:"ads"`
+	}
+	suite.NoError(json.Unmarshal(body, &data))
+	suite.Equal("swimming@circles.com", data.User.Email)
+	suite.Len(data.Ads, 2)
+	suite.Equal(ad.Data.ID, data.Ads[0].ID)
+	suite.Nil(data.Ads[0].DeletedAt)
+	suite.NotNil(data.Ads[1].DeletedAt)
+}
+
+func (suite *HTTPSuite) TestExport_ZIP() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	job, err := suite.Client.requestExport(u.Data.ID, "zip")
+	suite.NoError(err)
+	_, err = suite.Client.waitJob(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+
+	contentType, body, err := suite.Client.downloadJobResult(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+	suite.Equal(app.ContentTypeZIP, contentType)
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	suite.NoError(err)
+	names := make([]string, 0)
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	suite.ElementsMatch([]string{"user.json", "ads.json", "saved_searches.json", "notifications.json", "webhooks.json"}, names)
+}
+
+func (suite *HTTPSuite) TestExport_Errors() {
+	_, err := suite.Client.requestExport(0, "json")
+	suite.ErrorIs(err, ErrNotFound)
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.requestExport(u.Data.ID, "rar")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	job, err := suite.Client.requestExport(u.Data.ID, "json")
+	suite.NoError(err)
+	_, err = suite.Client.getJob(u.Data.ID+1, job.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *HTTPSuite) TestErasure() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	export, err := suite.Client.requestExport(u.Data.ID, "json")
+	suite.NoError(err)
+	_, err = suite.Client.waitJob(u.Data.ID, export.Data.ID)
+	suite.NoError(err)
+
+	job, err := suite.Client.requestErasure(u.Data.ID)
+	suite.NoError(err)
+	suite.Equal("erasure", job.Data.Kind)
+
+	job, err = suite.Client.waitJob(u.Data.ID, job.Data.ID)
+	suite.NoError(err)
+	suite.Equal("done", job.Data.Status)
+
+	_, err = suite.Client.getUser(u.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	_, err = suite.Client.restoreUser(u.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	_, _, err = suite.Client.downloadJobResult(u.Data.ID, export.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *GRPCSuite) TestGRPCExportAndErasure() {
+	user, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	_, err = suite.Client.RequestExport(suite.Context, &grpcPort.RequestExportRequest{})
+	suite.Equal(ErrMissingArgument.Error(), err.Error())
+
+	job, err := suite.Client.RequestExport(suite.Context, &grpcPort.RequestExportRequest{UserId: &user.Id})
+	suite.NoError(err)
+	suite.Equal(grpcPort.JobKind_JOB_KIND_EXPORT, job.Kind)
+
+	suite.Eventually(func() bool {
+		job, err = suite.Client.GetJob(suite.Context, &grpcPort.GetJobRequest{UserId: &user.Id, JobId: &job.Id})
+		return err == nil && job.Status == grpcPort.JobStatus_JOB_STATUS_DONE
+	}, time.Second, 10*time.Millisecond)
+	suite.Equal(app.ContentTypeJSON, job.ContentType)
+	suite.Contains(string(job.Result), "ivanov@yandex.ru")
+
+	erasure, err := suite.Client.RequestErasure(suite.Context, &grpcPort.RequestErasureRequest{UserId: &user.Id})
+	suite.NoError(err)
+	suite.Eventually(func() bool {
+		erasure, err = suite.Client.GetJob(suite.Context, &grpcPort.GetJobRequest{UserId: &user.Id, JobId: &erasure.Id})
+		return err == nil && erasure.Status == grpcPort.JobStatus_JOB_STATUS_DONE
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = suite.Client.GetUser(suite.Context, &grpcPort.GetUserRequest{Id: &user.Id})
+	suite.Equal(ErrUserNotFound.Error(), err.Error())
+}
+
+// waitAppJob polls the job until it is finished
+func waitAppJob(t *testing.T, a app.App, uid int64, id int64) *jobs.Job {
+	var j *jobs.Job
+	require.Eventually(t, func() bool {
+		var err error
+		j, err = a.GetJob(context.Background(), uid, id)
+		return err == nil && (j.Status == jobs.StatusDone || j.Status == jobs.StatusFailed)
+	}, time.Second, 10*time.Millisecond)
+	return j
+}
+
+// the export has every kind of the data of the user, the erasure removes all of it
+func TestExportAndErasure_AllUserData(t *testing.T) {
+	ctx := context.Background()
+	repo := adrepo.NewRepositoryMap()
+	a := app.NewApp(repo)
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	_, err = a.CreateAd(ctx, "Good News", "Dang!", u.ID)
+	require.NoError(t, err)
+	search, err := a.CreateSavedSearch(ctx, u.ID, app.SavedSearchParams{Name: "Bikes", Query: "bike"})
+	require.NoError(t, err)
+	hook, err := a.CreateWebhook(ctx, u.ID, "https://partner.example/hook", []string{events.NameAdPublished})
+	require.NoError(t, err)
+	_, err = repo.AddNotification(ctx, notifications.Notification{UserID: u.ID, Kind: notifications.KindSearchMatch,
+		Subject: "New bikes", AdIDs: []int64{0}, SearchID: &search.ID, Created: time.Now().UTC()})
+	require.NoError(t, err)
+
+	export, err := a.RequestExport(ctx, u.ID, app.ExportJSON)
+	require.NoError(t, err)
+	export = waitAppJob(t, a, u.ID, export.ID)
+	require.Equal(t, jobs.StatusDone, export.Status, export.Error)
+
+	var data struct {
+		Ads      []json.RawMessage `json:"ads"`
+		Searches []struct {
+			Name  string `json:"name"`
+			Query string `json:"query"`
+		} `json:"saved_searches"`
+		Notifications []struct {
+			Subject  string `json:"subject"`
+			SearchID *int64 `json:"search_id"`
+		} `json:"notifications"`
+		Webhooks []map[string]any `json:"webhooks"`
+	}
+	require.NoError(t, json.Unmarshal(export.Result, &data))
+	assert.Len(t, data.Ads, 1)
+	require.Len(t, data.Searches, 1)
+	assert.Equal(t, "bike", data.Searches[0].Query)
+	require.Len(t, data.Notifications, 1)
+	assert.Equal(t, "New bikes", data.Notifications[0].Subject)
+	assert.Equal(t, search.ID, *data.Notifications[0].SearchID)
+	require.Len(t, data.Webhooks, 1)
+	assert.Equal(t, hook.URL, data.Webhooks[0]["url"])
+	assert.NotContains(t, data.Webhooks[0], "secret")
+	assert.NotContains(t, string(export.Result), hook.Secret)
+
+	erasure, err := a.RequestErasure(ctx, u.ID)
+	require.NoError(t, err)
+	erasure = waitAppJob(t, a, u.ID, erasure.ID)
+	require.Equal(t, jobs.StatusDone, erasure.Status, erasure.Error)
+
+	_, err = repo.GetUserByID(ctx, u.ID)
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+	al, err := repo.GetAdList(ctx, app.ListAdsParams{Uid: &u.ID, IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Empty(t, al.Data)
+	saved, err := repo.ListSavedSearches(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Empty(t, saved)
+	inbox, err := repo.ListNotifications(ctx, u.ID, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	assert.Empty(t, inbox)
+	hooks, err := repo.ListWebhooks(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Empty(t, hooks)
+	_, err = repo.ListWebhookDeliveries(ctx, hook.ID)
+	assert.ErrorIs(t, err, app.ErrWebhookNotFound)
+	export, err = repo.GetJobByID(ctx, export.ID)
+	require.NoError(t, err)
+	assert.Nil(t, export.Result)
+}
+
+// the shutdown cancels the running jobs and waits for them, the jobs are not started afterwards
+func TestJobRunner_Stop(t *testing.T) {
+	runner := app.NewJobRunner()
+	cancelled := false
+	started := make(chan struct{})
+	require.True(t, runner.Go(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		cancelled = true
+	}))
+	<-started
+
+	runner.Stop()
+	assert.True(t, cancelled)
+	assert.False(t, runner.Go(func(ctx context.Context) {}))
+
+	ctx := context.Background()
+	repo := adrepo.NewRepositoryMap()
+	a := app.NewApp(repo, app.WithJobRunner(runner))
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	_, err = a.RequestExport(ctx, u.ID, app.ExportJSON)
+	assert.ErrorIs(t, err, app.ErrJobsStopped)
+	_, err = a.RequestErasure(ctx, u.ID)
+	assert.ErrorIs(t, err, app.ErrJobsStopped)
+	_, err = a.GetUser(ctx, u.ID)
+	assert.NoError(t, err)
+}
+
+// the erasure writes the deletions of the live user and ads, no personal data stays in the outbox,
+// the dead letters or the notifications of other users
+func TestErasure_ScrubsEventsAndNotifications(t *testing.T) {
+	ctx := context.Background()
+	repo := adrepo.NewRepositoryMap()
+	a := app.NewApp(repo)
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := a.CreateUser(ctx, "Ryan", "ryan@circles.com")
+	require.NoError(t, err)
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.NoError(t, repo.MoveToDeadLetters(ctx, pending[0].ID, "broker is unavailable"))
+	require.NoError(t, repo.MarkDelivered(ctx, pending[1].ID))
+
+	ad, err := a.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	deleted, err := a.CreateAd(ctx, "Blue bike", "Old", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, a.DeleteAd(ctx, deleted.ID, u.ID))
+	_, err = repo.AddNotification(ctx, notifications.Notification{
+		UserID:  other.ID,
+		Kind:    notifications.KindSearchMatch,
+		Subject: "New bikes",
+		Text:    notifications.AdLine("Red bike", ad.ID) + notifications.AdLine("Green bike", ad.ID+100),
+		AdIDs:   []int64{ad.ID, ad.ID + 100},
+		Created: time.Now().UTC(),
+	})
+	require.NoError(t, err)
+
+	erasure, err := a.RequestErasure(ctx, u.ID)
+	require.NoError(t, err)
+	erasure = waitAppJob(t, a, u.ID, erasure.ID)
+	require.Equal(t, jobs.StatusDone, erasure.Status, erasure.Error)
+
+	pending, err = repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	names := make([]string, 0, len(pending))
+	for _, m := range pending {
+		names = append(names, m.Event.EventName())
+	}
+	// the earlier deletion of the ad is still delivered, the creations of the ads are dropped
+	assert.Equal(t, []string{events.NameAdDeleted, events.NameAdDeleted, events.NameUserDeleted}, names)
+	assert.Equal(t, events.AdDeleted{AdID: ad.ID, AuthorID: u.ID, Time: pending[1].Event.OccurredAt()}, pending[1].Event)
+
+	dead, err := repo.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	created, ok := dead[0].Event.(events.UserCreated)
+	require.True(t, ok)
+	assert.Equal(t, u.ID, created.User.ID)
+	assert.Empty(t, created.User.Nickname)
+	assert.Empty(t, created.User.Email)
+
+	inbox, err := repo.ListNotifications(ctx, other.ID, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+	assert.Equal(t, notifications.AdLine(notifications.ErasedAdTitle, ad.ID)+notifications.AdLine("Green bike", ad.ID+100),
+		inbox[0].Text)
+}
+
+// a soft deleted user can be erased, the deletions were written, when the user was deleted
+func TestErasure_SoftDeletedUser(t *testing.T) {
+	ctx := context.Background()
+	repo := adrepo.NewRepositoryMap()
+	a := app.NewApp(repo)
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	_, err = a.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, a.DeleteUser(ctx, u.ID, app.DeleteUserParams{Mode: app.DeleteCascade}))
+
+	erasure, err := a.RequestErasure(ctx, u.ID)
+	require.NoError(t, err)
+	erasure = waitAppJob(t, a, u.ID, erasure.ID)
+	require.Equal(t, jobs.StatusDone, erasure.Status, erasure.Error)
+
+	_, err = repo.GetUserIncludingDeleted(ctx, u.ID)
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+	pending, err := repo.PendingMessages(ctx, 10)
+	require.NoError(t, err)
+	names := make([]string, 0, len(pending))
+	for _, m := range pending {
+		names = append(names, m.Event.EventName())
+	}
+	assert.Equal(t, []string{events.NameAdDeleted, events.NameUserDeleted}, names)
+
+	_, err = a.RequestErasure(ctx, u.ID)
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+}
+
+func TestExport_ResultExpires(t *testing.T) {
+	ctx := context.Background()
+	repo := adrepo.NewRepositoryMap()
+	a := app.NewApp(repo, app.WithExportTTL(time.Hour))
+
+	u, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	export, err := a.RequestExport(ctx, u.ID, app.ExportJSON)
+	require.NoError(t, err)
+	export = waitAppJob(t, a, u.ID, export.ID)
+	require.Equal(t, jobs.StatusDone, export.Status, export.Error)
+	require.NotNil(t, export.Expires)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *export.Expires, time.Minute)
+	assert.NotEmpty(t, export.Result)
+
+	expired, err := repo.ExpireJobResults(ctx, time.Now().UTC())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+	expired, err = repo.ExpireJobResults(ctx, export.Expires.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	stored, err := repo.GetJobByID(ctx, export.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.Result)
+	assert.Empty(t, stored.ContentType)
+
+	// the result is hidden as soon as it expires, even before the purge job removes it
+	a = app.NewApp(repo, app.WithExportTTL(-time.Second))
+	export, err = a.RequestExport(ctx, u.ID, app.ExportJSON)
+	require.NoError(t, err)
+	export = waitAppJob(t, a, u.ID, export.ID)
+	require.Equal(t, jobs.StatusDone, export.Status, export.Error)
+	assert.Nil(t, export.Result)
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "AD_NOT_FOUND", app.Translate(fmt.Errorf("wrapped: %w", app.ErrAdNotFound)).Code)
+	assert.Equal(t, "INVALID_ROW", app.Translate(fmt.Errorf("%w: row has 3 columns", app.ErrInvalidRow)).Code)
+	assert.Equal(t, app.CodeValidationFailed, app.Translate(validator.ValidationErrors{}).Code)
+	assert.Equal(t, app.CodeInternal, app.Translate(fmt.Errorf("unknown")).Code)
+
+	// the code is enough to find the outcome of the error
+	for _, err := range app.TranslatedErrors() {
+		assert.Equal(t, app.Translate(err), app.TranslateCode(app.Translate(err).Code))
+	}
+	assert.Equal(t, app.CodeInternal, app.TranslateCode("UNKNOWN").Code)
+
+	// the detailed error still matches the sentinel
+	detailed := app.ErrInvalidParameter.Withf("unknown delete mode: %q", "all")
+	assert.ErrorIs(t, detailed, app.ErrInvalidParameter)
+	assert.NotErrorIs(t, detailed, app.ErrInvalidPage)
+	assert.Equal(t, `unknown delete mode: "all"`, detailed.Error())
+
+	assert.Equal(t, "user_id", app.SnakeCase("UserID"))
+	assert.Equal(t, "title", app.SnakeCase("Title"))
+	assert.Equal(t, "transfer_to", app.SnakeCase("TransferTo"))
+	assert.Equal(t, "url_path", app.SnakeCase("URLPath"))
+}
+
+func getProblem(t *testing.T, resp *http.Response, body []byte) httpgin.Problem {
+	assert.Equal(t, httpgin.ProblemContentType, resp.Header.Get("Content-Type"))
+	var p httpgin.Problem
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, resp.StatusCode, p.Status)
+	assert.Equal(t, http.StatusText(resp.StatusCode), p.Title)
+	assert.Equal(t, "about:blank", p.Type)
+	return p
+}
+
+func TestHTTPProblem(t *testing.T) {
+	client := getTestClient()
+
+	resp, err := client.client.Get(client.baseURL + "/api/v1/ads/5")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	p := getProblem(t, resp, body)
+	assert.Equal(t, "AD_NOT_FOUND", p.Code)
+	assert.Equal(t, app.ErrAdNotFound.Error(), p.Detail)
+	assert.Equal(t, "/api/v1/ads/5", p.Instance)
+	assert.Empty(t, p.Errors)
+
+	// the errors of reading the request get the code too
+	resp, err = client.client.Get(client.baseURL + "/api/v1/ads/abc")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "INVALID_PARAMETER", getProblem(t, resp, body).Code)
+}
+
+func TestHTTPProblemValidation(t *testing.T) {
+	client := getTestClient()
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	resp, body := postWithKey(t, client, "/api/v1/ads", "", map[string]any{"user_id": 0, "title": strings.Repeat("a", 100), "text": ""})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	p := getProblem(t, resp, body)
+	assert.Equal(t, app.CodeValidationFailed, p.Code)
+	require.Len(t, p.Errors, 2)
+	assert.Equal(t, "title", p.Errors[0].Field)
+	assert.Equal(t, "max", p.Errors[0].Constraint)
+	assert.Equal(t, "text", p.Errors[1].Field)
+	assert.Equal(t, "min", p.Errors[1].Constraint)
+
+	// the constraints of the request are checked by gin before the application
+	resp, body = postWithKey(t, client, "/api/v1/auth/register", "", map[string]any{"nickname": "Larry", "email": "circles", "password": "pass"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	p = getProblem(t, resp, body)
+	assert.Equal(t, app.CodeValidationFailed, p.Code)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, "email", p.Errors[0].Field)
+	assert.Equal(t, "email", p.Errors[0].Constraint)
+}
+
+func errorInfo(err error) (*errdetails.ErrorInfo, *errdetails.BadRequest) {
+	var info *errdetails.ErrorInfo
+	var badRequest *errdetails.BadRequest
+	for _, d := range status.Convert(err).Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.BadRequest:
+			badRequest = d
+		}
+	}
+	return info, badRequest
+}
+
+func (suite *GRPCSuite) TestGRPCErrorDetails() {
+	id := int64(5)
+	_, err := suite.Client.GetAd(suite.Context, &grpcPort.GetAdRequest{AdId: &id})
+	suite.Equal(codes.NotFound, status.Code(err))
+	suite.Equal(ErrAdNotFound.Error(), err.Error())
+	info, badRequest := errorInfo(err)
+	suite.Require().NotNil(info)
+	suite.Equal("AD_NOT_FOUND", info.Reason)
+	suite.Equal(grpcPort.ErrorDomain, info.Domain)
+	suite.Nil(badRequest)
+
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+	_, err = suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: strings.Repeat("a", 100), Text: "New", UserId: &u.Id})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	info, badRequest = errorInfo(err)
+	suite.Require().NotNil(info)
+	suite.Equal(app.CodeValidationFailed, info.Reason)
+	suite.Require().NotNil(badRequest)
+	suite.Require().Len(badRequest.FieldViolations, 1)
+	suite.Equal("title", badRequest.FieldViolations[0].Field)
+
+	_, err = suite.Client.GetAd(suite.Context, &grpcPort.GetAdRequest{})
+	info, _ = errorInfo(err)
+	suite.Require().NotNil(info)
+	suite.Equal("MISSING_ARGUMENT", info.Reason)
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/ad-service.git/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseQuota(t *testing.T) {
+	q, err := ratelimit.ParseQuota("10/s")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Quota{Rate: 10, Burst: 10}, q)
+
+	q, err = ratelimit.ParseQuota("120/m,5")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Quota{Rate: 2, Burst: 5}, q)
+
+	q, err = ratelimit.ParseQuota("off")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Quota{}, q)
+
+	for _, s := range []string{"10", "10/d", "0/s", "x/s", "10/s,0", "10/s,"} {
+		_, err := ratelimit.ParseQuota(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	slow := ratelimit.Quota{Rate: 0.01, Burst: 2}
+	l := ratelimit.New(ratelimit.Config{
+		Default: ratelimit.Rule{PerIP: slow, PerUser: ratelimit.Quota{Rate: 0.01, Burst: 3}},
+		Routes: map[string]ratelimit.Rule{
+			"GET /free": {},
+		},
+	})
+
+	d := l.Allow("POST /ads", "10.0.0.1", "")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 2, d.Limit)
+	assert.Equal(t, 1, d.Remaining)
+	assert.InDelta(t, 100*time.Second, d.Reset, float64(time.Second))
+	assert.True(t, l.Allow("POST /ads", "10.0.0.1", "").Allowed)
+
+	d = l.Allow("POST /ads", "10.0.0.1", "")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.InDelta(t, 100*time.Second, d.RetryAfter, float64(time.Second))
+	assert.Equal(t, "100", d.Headers()[ratelimit.RetryAfter])
+
+	// the buckets are separate for every route and address, unlimited routes have no headers
+	assert.True(t, l.Allow("GET /ads", "10.0.0.1", "").Allowed)
+	assert.True(t, l.Allow("POST /ads", "10.0.0.2", "").Allowed)
+	d = l.Allow("GET /free", "10.0.0.1", "")
+	assert.True(t, d.Allowed)
+	assert.Nil(t, d.Headers())
+
+	// the user has one bucket for all of the addresses, a denied request takes no tokens
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("PUT /ads", "10.0.1."+strconv.Itoa(i), "7").Allowed)
+	}
+	d = l.Allow("PUT /ads", "10.0.1.9", "7")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 3, d.Limit)
+	assert.True(t, l.Allow("PUT /ads", "10.0.1.9", "8").Allowed)
+	// the most restrictive of the buckets is reported
+	d = l.Allow("PUT /ads", "10.0.1.9", "9")
+	assert.Equal(t, 2, d.Limit)
+	assert.Equal(t, 0, d.Remaining)
+
+	// the tokens are refilled with time
+	fast := ratelimit.New(ratelimit.Config{Default: ratelimit.Rule{PerIP: ratelimit.Quota{Rate: 20, Burst: 1}}})
+	assert.True(t, fast.Allow("GET /ads", "10.0.0.1", "").Allowed)
+	assert.False(t, fast.Allow("GET /ads", "10.0.0.1", "").Allowed)
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, fast.Allow("GET /ads", "10.0.0.1", "").Allowed)
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{
+		Default: ratelimit.Rule{PerIP: ratelimit.Quota{Rate: 100, Burst: 100}},
+		Routes: map[string]ratelimit.Rule{
+			"POST /api/v1/ads": {PerUser: ratelimit.Quota{Rate: 0.01, Burst: 2}},
+		},
+	})
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New()), httpgin.WithRateLimiter(l))
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+	client := &testClient{client: testServer.Client(), baseURL: testServer.URL}
+
+	_, err := client.createUser("Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = client.createAd(0, "Bike", "New")
+		require.NoError(t, err)
+	}
+	// the user is taken from the body
+	_, err = client.createAd(0, "Bike", "New")
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	_, err = client.createAd(1, "Bike", "New")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// and from the header
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/v1/ads/0", nil)
+	require.NoError(t, err)
+	req.Header.Set(ratelimit.UserHeader, "0")
+	resp, err := client.client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "100", resp.Header.Get(ratelimit.LimitHeader))
+	assert.Equal(t, "", resp.Header.Get(ratelimit.RetryAfter))
+
+	req, err = http.NewRequest(http.MethodPost, testServer.URL+"/api/v1/ads", nil)
+	require.NoError(t, err)
+	req.Header.Set(ratelimit.UserHeader, "0")
+	resp, err = client.client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(ratelimit.LimitHeader))
+	assert.Equal(t, "0", resp.Header.Get(ratelimit.RemainingHeader))
+	assert.Equal(t, "100", resp.Header.Get(ratelimit.RetryAfter))
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{
+		Default: ratelimit.Rule{PerUser: ratelimit.Quota{Rate: 0.01, Burst: 1}},
+	})
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(grpcPort.UnaryRateLimitInterceptor(l)))
+	grpcPort.RegisterAdServiceServer(server, grpcPort.NewService(app.NewApp(adrepo.New())))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := grpcPort.NewAdServiceClient(conn)
+
+	u, err := client.CreateUser(ctx, &grpcPort.CreateUserRequest{Name: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+
+	// the user is taken from the user_id field of the request
+	_, err = client.CreateAd(ctx, &grpcPort.CreateAdRequest{Title: "Bike", Text: "New", UserId: &u.Id})
+	require.NoError(t, err)
+	var header metadata.MD
+	_, err = client.CreateAd(ctx, &grpcPort.CreateAdRequest{Title: "Bike", Text: "New", UserId: &u.Id}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, header.Get("ratelimit-limit"))
+	assert.Equal(t, []string{"100"}, header.Get("retry-after"))
+
+	// and from the metadata
+	md := metadata.Pairs("x-user-id", "1")
+	_, err = client.GetUser(metadata.NewOutgoingContext(ctx, md), &grpcPort.GetUserRequest{Id: &u.Id})
+	assert.NoError(t, err)
+	_, err = client.GetUser(metadata.NewOutgoingContext(ctx, md), &grpcPort.GetUserRequest{Id: &u.Id})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
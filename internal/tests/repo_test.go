@@ -2,12 +2,18 @@ package tests
 
 import (
 	"context"
+	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
 	"github.com/TobbyMax/ad-service.git/internal/ads"
 	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/jobs"
 	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
 	"github.com/stretchr/testify/suite"
 	"log"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -36,8 +42,8 @@ func (suite *RepoSuite) TestRepo_AddUser() {
 }
 
 func (suite *RepoSuite) TestRepo_AddMultipleUsers() {
-	u := user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"}
 	for i := 0; i < 100; i++ {
+		u := user.User{Nickname: "Mac Miller", Email: fmt.Sprintf("swimmig%d@circles.com", i)}
 		id, err := suite.Repo.AddUser(suite.Ctx, u)
 		suite.NoError(err)
 		suite.Equal(int64(i), id)
@@ -247,6 +253,391 @@ func (suite *RepoSuite) TestRepo_GetAdList() {
 	suite.Equal(ad, res.Data[0])
 }
 
+func (suite *RepoSuite) TestRepo_GetUserByEmail() {
+	u := user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"}
+	_, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "KDot", Email: "money@trees.com"})
+	suite.NoError(err)
+	id, err := suite.Repo.AddUser(suite.Ctx, u)
+	suite.NoError(err)
+	_, err = suite.Repo.AddUser(suite.Ctx, u)
+	suite.ErrorIs(err, app.ErrEmailTaken)
+
+	res, err := suite.Repo.GetUserByEmail(suite.Ctx, "swimmig@circles.com")
+	suite.NoError(err)
+	suite.Equal(id, res.ID)
+
+	_, err = suite.Repo.GetUserByEmail(suite.Ctx, "blue_slide@park.com")
+	suite.ErrorIs(err, app.ErrUserNotFound)
+}
+
+// the email belongs to one user, it is freed when the user is deleted or changes it
+func (suite *RepoSuite) TestRepo_UniqueEmail() {
+	id, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	other, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "KDot", Email: "money@trees.com"})
+	suite.NoError(err)
+
+	suite.ErrorIs(suite.Repo.UpdateUser(suite.Ctx, other, "KDot", "swimmig@circles.com"), app.ErrEmailTaken)
+	suite.NoError(suite.Repo.UpdateUser(suite.Ctx, id, "Larry Fisherman", "swimmig@circles.com"))
+
+	suite.NoError(suite.Repo.UpdateUser(suite.Ctx, id, "Mac Miller", "faces@circles.com"))
+	suite.NoError(suite.Repo.UpdateUser(suite.Ctx, other, "KDot", "swimmig@circles.com"))
+	res, err := suite.Repo.GetUserByEmail(suite.Ctx, "swimmig@circles.com")
+	suite.NoError(err)
+	suite.Equal(other, res.ID)
+
+	// the deleted user can not be restored, while another user has the email
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, id))
+	_, err = suite.Repo.GetUserByEmail(suite.Ctx, "faces@circles.com")
+	suite.ErrorIs(err, app.ErrUserNotFound)
+	taken, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Larry Fisherman", Email: "faces@circles.com"})
+	suite.NoError(err)
+	suite.ErrorIs(suite.Repo.RestoreUserByID(suite.Ctx, id), app.ErrEmailTaken)
+
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, taken))
+	suite.NoError(suite.Repo.RestoreUserByID(suite.Ctx, id))
+	res, err = suite.Repo.GetUserByEmail(suite.Ctx, "faces@circles.com")
+	suite.NoError(err)
+	suite.Equal(id, res.ID)
+}
+
+func (suite *RepoSuite) TestRepo_Tokens() {
+	id, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+
+	t := user.Token{Value: "abc", UserID: id, Purpose: user.TokenVerifyEmail, Expires: time.Now().UTC()}
+	suite.NoError(suite.Repo.AddToken(suite.Ctx, t))
+
+	res, err := suite.Repo.GetToken(suite.Ctx, "abc")
+	suite.NoError(err)
+	suite.Equal(t, *res)
+
+	suite.NoError(suite.Repo.DeleteToken(suite.Ctx, "abc"))
+	_, err = suite.Repo.GetToken(suite.Ctx, "abc")
+	suite.ErrorIs(err, app.ErrInvalidToken)
+
+	err = suite.Repo.AddToken(suite.Ctx, user.Token{Value: "def", UserID: id + 1})
+	suite.ErrorIs(err, app.ErrUserNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_DeleteUserDeletesTokens() {
+	id, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	suite.NoError(suite.Repo.AddToken(suite.Ctx, user.Token{Value: "abc", UserID: id}))
+
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, id))
+	_, err = suite.Repo.GetToken(suite.Ctx, "abc")
+	suite.ErrorIs(err, app.ErrInvalidToken)
+}
+
+func (suite *RepoSuite) TestRepo_UpdateUserPasswordAndVerified() {
+	id, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+
+	suite.NoError(suite.Repo.UpdateUserPassword(suite.Ctx, id, []byte("hash")))
+	suite.NoError(suite.Repo.SetUserVerified(suite.Ctx, id, true))
+
+	res, err := suite.Repo.GetUserByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Equal([]byte("hash"), res.PasswordHash)
+	suite.True(res.Verified)
+
+	suite.ErrorIs(suite.Repo.UpdateUserPassword(suite.Ctx, id+1, nil), app.ErrUserNotFound)
+	suite.ErrorIs(suite.Repo.SetUserVerified(suite.Ctx, id+1, true), app.ErrUserNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_RestoreAd() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	id, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+
+	suite.NoError(suite.Repo.DeleteAdByID(suite.Ctx, id))
+	res, err := suite.Repo.GetAdList(suite.Ctx, app.ListAdsParams{})
+	suite.NoError(err)
+	suite.Empty(res.Data)
+	res, err = suite.Repo.GetAdList(suite.Ctx, app.ListAdsParams{IncludeDeleted: true})
+	suite.NoError(err)
+	suite.Len(res.Data, 1)
+	suite.NotNil(res.Data[0].DeletedAt)
+
+	suite.NoError(suite.Repo.RestoreAdByID(suite.Ctx, id))
+	ad, err := suite.Repo.GetAdByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Nil(ad.DeletedAt)
+
+	err = suite.Repo.RestoreAdByID(suite.Ctx, id)
+	suite.ErrorIs(err, app.ErrAdNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_RestoreUser() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	deletedBefore, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+	deletedWithUser, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Weekend", Text: "GO:OD AM", AuthorID: uid})
+	suite.NoError(err)
+
+	suite.NoError(suite.Repo.DeleteAdByID(suite.Ctx, deletedBefore))
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, uid))
+
+	_, err = suite.Repo.GetAdByID(suite.Ctx, deletedWithUser)
+	suite.ErrorIs(err, app.ErrAdNotFound)
+	err = suite.Repo.RestoreAdByID(suite.Ctx, deletedWithUser)
+	suite.ErrorIs(err, app.ErrUserNotFound)
+
+	suite.NoError(suite.Repo.RestoreUserByID(suite.Ctx, uid))
+	_, err = suite.Repo.GetUserByID(suite.Ctx, uid)
+	suite.NoError(err)
+	_, err = suite.Repo.GetAdByID(suite.Ctx, deletedWithUser)
+	suite.NoError(err)
+	_, err = suite.Repo.GetAdByID(suite.Ctx, deletedBefore)
+	suite.ErrorIs(err, app.ErrAdNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_PurgeDeleted() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	id, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+	kept, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Weekend", Text: "GO:OD AM", AuthorID: uid})
+	suite.NoError(err)
+	suite.NoError(suite.Repo.DeleteAdByID(suite.Ctx, id))
+
+	n, err := suite.Repo.PurgeDeleted(suite.Ctx, time.Now().UTC().Add(-time.Hour))
+	suite.NoError(err)
+	suite.Zero(n)
+
+	n, err = suite.Repo.PurgeDeleted(suite.Ctx, time.Now().UTC().Add(time.Second))
+	suite.NoError(err)
+	suite.Equal(1, n)
+	err = suite.Repo.RestoreAdByID(suite.Ctx, id)
+	suite.ErrorIs(err, app.ErrAdNotFound)
+	_, err = suite.Repo.GetAdByID(suite.Ctx, kept)
+	suite.NoError(err)
+
+	newID, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+	suite.Equal(kept+1, newID)
+}
+
+func (suite *RepoSuite) TestRepo_PurgeDeletedUser() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	token := user.Token{Value: "secret", UserID: uid, Purpose: user.TokenVerifyEmail, Expires: time.Now().UTC().Add(time.Hour)}
+	suite.NoError(suite.Repo.AddToken(suite.Ctx, token))
+	jobID, err := suite.Repo.AddJob(suite.Ctx, jobs.Job{Kind: jobs.KindExport, UserID: uid, Status: jobs.StatusDone,
+		Result: []byte("archive"), ContentType: "application/zip"})
+	suite.NoError(err)
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, uid))
+
+	// the user is purged together with the tokens and the results of the export
+	n, err := suite.Repo.PurgeDeleted(suite.Ctx, time.Now().UTC().Add(time.Second))
+	suite.NoError(err)
+	suite.Equal(1, n)
+	_, err = suite.Repo.GetToken(suite.Ctx, token.Value)
+	suite.ErrorIs(err, app.ErrInvalidToken)
+	j, err := suite.Repo.GetJobByID(suite.Ctx, jobID)
+	suite.NoError(err)
+	suite.Nil(j.Result)
+	suite.Empty(j.ContentType)
+	suite.Equal(jobs.StatusDone, j.Status)
+}
+
 func TestRepo(t *testing.T) {
 	suite.Run(t, new(RepoSuite))
 }
+
+func (suite *RepoSuite) TestRepo_DeleteUserKeepAds() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	to, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Kendrick", Email: "damn@tde.com"})
+	suite.NoError(err)
+	id, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+
+	suite.ErrorIs(suite.Repo.DeleteUserKeepAds(suite.Ctx, uid, uid), app.ErrInvalidTransfer)
+	suite.ErrorIs(suite.Repo.DeleteUserKeepAds(suite.Ctx, uid, to+1), app.ErrInvalidTransfer)
+
+	suite.NoError(suite.Repo.DeleteUserKeepAds(suite.Ctx, uid, to))
+	_, err = suite.Repo.GetUserByID(suite.Ctx, uid)
+	suite.ErrorIs(err, app.ErrUserNotFound)
+
+	ad, err := suite.Repo.GetAdByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Equal(to, ad.AuthorID)
+
+	suite.NoError(suite.Repo.DeleteUserKeepAds(suite.Ctx, to, ads.AnonymousAuthorID))
+	ad, err = suite.Repo.GetAdByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Equal(ads.AnonymousAuthorID, ad.AuthorID)
+}
+
+func (suite *RepoSuite) TestRepo_EraseUser() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimmig@circles.com"})
+	suite.NoError(err)
+	id, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: "Dang!", Text: "The Divine Feminine", AuthorID: uid})
+	suite.NoError(err)
+	jobID, err := suite.Repo.AddJob(suite.Ctx, jobs.Job{UserID: uid, Status: jobs.StatusDone, Result: []byte("{}")})
+	suite.NoError(err)
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, uid))
+
+	suite.NoError(suite.Repo.EraseUser(suite.Ctx, uid))
+	suite.ErrorIs(suite.Repo.RestoreUserByID(suite.Ctx, uid), app.ErrUserNotFound)
+	suite.ErrorIs(suite.Repo.RestoreAdByID(suite.Ctx, id), app.ErrAdNotFound)
+
+	j, err := suite.Repo.GetJobByID(suite.Ctx, jobID)
+	suite.NoError(err)
+	suite.Nil(j.Result)
+
+	suite.ErrorIs(suite.Repo.EraseUser(suite.Ctx, uid), app.ErrUserNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_Jobs() {
+	id, err := suite.Repo.AddJob(suite.Ctx, jobs.Job{UserID: 1, Kind: jobs.KindErasure})
+	suite.NoError(err)
+
+	j, err := suite.Repo.GetJobByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Equal(jobs.StatusPending, j.Status)
+
+	j.Status = jobs.StatusDone
+	suite.NoError(suite.Repo.UpdateJob(suite.Ctx, *j))
+	j, err = suite.Repo.GetJobByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Equal(jobs.StatusDone, j.Status)
+
+	_, err = suite.Repo.GetJobByID(suite.Ctx, id+1)
+	suite.ErrorIs(err, app.ErrJobNotFound)
+	suite.ErrorIs(suite.Repo.UpdateJob(suite.Ctx, jobs.Job{ID: id + 1}), app.ErrJobNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_Webhooks() {
+	uid, err := suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	suite.NoError(err)
+	_, err = suite.Repo.AddWebhook(suite.Ctx, webhooks.Subscription{OwnerID: uid + 1, Active: true})
+	suite.ErrorIs(err, app.ErrUserNotFound)
+
+	id, err := suite.Repo.AddWebhook(suite.Ctx, webhooks.Subscription{OwnerID: uid, Active: true,
+		Events: []string{events.NameAdDeleted}})
+	suite.NoError(err)
+
+	active, err := suite.Repo.ActiveWebhooks(suite.Ctx, events.NameAdDeleted)
+	suite.NoError(err)
+	suite.Len(active, 1)
+	active, err = suite.Repo.ActiveWebhooks(suite.Ctx, events.NameAdPublished)
+	suite.NoError(err)
+	suite.Len(active, 0)
+
+	for i := 0; i < 3; i++ {
+		failures, err := suite.Repo.AddWebhookDelivery(suite.Ctx, webhooks.Delivery{SubscriptionID: id})
+		suite.NoError(err)
+		suite.Equal(i+1, failures)
+	}
+	failures, err := suite.Repo.AddWebhookDelivery(suite.Ctx, webhooks.Delivery{SubscriptionID: id, Success: true})
+	suite.NoError(err)
+	suite.Equal(0, failures)
+
+	// the log keeps only the latest deliveries
+	for i := 0; i < 200; i++ {
+		_, err = suite.Repo.AddWebhookDelivery(suite.Ctx, webhooks.Delivery{SubscriptionID: id, Attempts: i})
+		suite.NoError(err)
+	}
+	history, err := suite.Repo.ListWebhookDeliveries(suite.Ctx, id)
+	suite.NoError(err)
+	suite.Len(history, 100)
+	suite.Equal(199, history[0].Attempts)
+
+	suite.NoError(suite.Repo.SetWebhookActive(suite.Ctx, id, false))
+	s, err := suite.Repo.GetWebhookByID(suite.Ctx, id)
+	suite.NoError(err)
+	suite.False(s.Active)
+	suite.NotNil(s.DisabledAt)
+	active, err = suite.Repo.ActiveWebhooks(suite.Ctx, events.NameAdDeleted)
+	suite.NoError(err)
+	suite.Len(active, 0)
+
+	// subscriptions of deleted users are silent, erasure removes them
+	suite.NoError(suite.Repo.SetWebhookActive(suite.Ctx, id, true))
+	suite.NoError(suite.Repo.DeleteUserByID(suite.Ctx, uid))
+	active, err = suite.Repo.ActiveWebhooks(suite.Ctx, events.NameAdDeleted)
+	suite.NoError(err)
+	suite.Len(active, 0)
+	suite.NoError(suite.Repo.EraseUser(suite.Ctx, uid))
+	_, err = suite.Repo.GetWebhookByID(suite.Ctx, id)
+	suite.ErrorIs(err, app.ErrWebhookNotFound)
+	_, err = suite.Repo.AddWebhookDelivery(suite.Ctx, webhooks.Delivery{SubscriptionID: id})
+	suite.ErrorIs(err, app.ErrWebhookNotFound)
+}
+
+func (suite *RepoSuite) TestRepo_ListAdsIndexes() {
+	uids := make([]int64, 3)
+	for i := range uids {
+		var err error
+		uids[i], err = suite.Repo.AddUser(suite.Ctx, user.User{Nickname: "Mac Miller", Email: fmt.Sprintf("swimmig%d@circles.com", i)})
+		suite.NoError(err)
+	}
+	start := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	n := 300
+	for i := 0; i < n; i++ {
+		_, err := suite.Repo.AddAd(suite.Ctx, ads.Ad{Title: strconv.Itoa(i % 7), Text: "Swimming",
+			AuthorID: uids[i%3], Published: i%3 == 0, DateCreated: start.AddDate(0, 0, i%5)})
+		suite.NoError(err)
+	}
+
+	// every kind of change has to move the ads between the entries of the indexes
+	for id := int64(0); id < int64(n); id += 4 {
+		suite.NoError(suite.Repo.UpdateAdContent(suite.Ctx, id, "Changed", "Circles", start))
+	}
+	for id := int64(1); id < int64(n); id += 5 {
+		suite.NoError(suite.Repo.UpdateAdStatus(suite.Ctx, id, id%2 == 0, start))
+	}
+	for id := int64(2); id < int64(n); id += 6 {
+		suite.NoError(suite.Repo.DeleteAdByID(suite.Ctx, id))
+	}
+	suite.NoError(suite.Repo.DeleteUserKeepAds(suite.Ctx, uids[2], uids[0]))
+	err := suite.Repo.InTransaction(suite.Ctx, func(ctx context.Context, tx app.Repository) error {
+		suite.NoError(tx.UpdateAdContent(ctx, 3, "Rolled back", "", start))
+		suite.NoError(tx.DeleteUserKeepAds(ctx, uids[1], uids[0]))
+		return app.ErrForbidden
+	})
+	suite.ErrorIs(err, app.ErrForbidden)
+
+	live := make([]ads.Ad, 0, n)
+	for id := int64(0); id < int64(n); id++ {
+		if ad, err := suite.Repo.GetAdByID(suite.Ctx, id); err == nil {
+			live = append(live, *ad)
+		}
+	}
+	byID := func(list []ads.Ad) []ads.Ad {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list
+	}
+
+	published, unpublished := true, false
+	titles := []string{"0", "3", "Changed", "Rolled back"}
+	for _, p := range []*bool{nil, &published, &unpublished} {
+		for _, uid := range []*int64{nil, &uids[0], &uids[1], &uids[2]} {
+			for _, title := range []*string{nil, &titles[0], &titles[1], &titles[2], &titles[3]} {
+				for _, day := range []int{-1, 0, 2, 7} {
+					params := app.ListAdsParams{Published: p, Uid: uid, Title: title}
+					if day >= 0 {
+						date := time.Date(2023, time.January, 1+day, 0, 0, 0, 0, time.UTC)
+						params.Date = &date
+					}
+					want := make([]ads.Ad, 0)
+					for _, ad := range live {
+						if (p == nil || *p == ad.Published) && (uid == nil || *uid == ad.AuthorID) &&
+							(title == nil || *title == ad.Title) &&
+							(params.Date == nil || ad.DateCreated.Day() == params.Date.Day()) {
+							want = append(want, ad)
+						}
+					}
+					list, err := suite.Repo.GetAdList(suite.Ctx, params)
+					suite.NoError(err)
+					suite.Equal(want, byID(list.Data))
+				}
+			}
+		}
+	}
+}
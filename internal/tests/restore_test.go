@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adminauth"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func (suite *HTTPSuite) TestRestoreAd() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.deleteAd(ad.Data.ID, u.Data.ID)
+	suite.NoError(err)
+
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	restored, err := suite.Client.restoreAd(ad.Data.ID)
+	suite.NoError(err)
+	suite.Equal(ad.Data.ID, restored.Data.ID)
+	suite.Equal("Good News", restored.Data.Title)
+
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.NoError(err)
+}
+
+func (suite *HTTPSuite) TestRestoreAd_NotDeleted() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.restoreAd(ad.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *HTTPSuite) TestRestoreUser() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+
+	_, err = suite.Client.deleteUser(u.Data.ID)
+	suite.NoError(err)
+
+	_, err = suite.Client.restoreAd(ad.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	restored, err := suite.Client.restoreUser(u.Data.ID)
+	suite.NoError(err)
+	suite.Equal(u.Data.ID, restored.Data.ID)
+
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.NoError(err)
+}
+
+func (suite *GRPCSuite) TestGRPCRestoreAd() {
+	user, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "Forest", Text: "Hill Drive", UserId: &user.Id})
+	suite.NoError(err)
+
+	_, err = suite.Client.DeleteAd(suite.Context, &grpcPort.DeleteAdRequest{AdId: &ad.Id, AuthorId: &user.Id})
+	suite.NoError(err)
+
+	res, err := suite.Client.RestoreAd(suite.Context, &grpcPort.RestoreAdRequest{AdId: &ad.Id})
+	suite.NoError(err)
+	suite.Equal(ad.Id, res.Id)
+
+	_, err = suite.Client.RestoreAd(suite.Context, &grpcPort.RestoreAdRequest{})
+	suite.Equal(ErrMissingArgument.Error(), err.Error())
+}
+
+func (suite *GRPCSuite) TestGRPCRestoreUser() {
+	user, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	_, err = suite.Client.DeleteUser(suite.Context, &grpcPort.DeleteUserRequest{Id: &user.Id})
+	suite.NoError(err)
+
+	res, err := suite.Client.RestoreUser(suite.Context, &grpcPort.RestoreUserRequest{Id: &user.Id})
+	suite.NoError(err)
+	suite.Equal("Oleg", res.Name)
+
+	_, err = suite.Client.RestoreUser(suite.Context, &grpcPort.RestoreUserRequest{Id: &user.Id})
+	suite.Equal(ErrUserNotFound.Error(), err.Error())
+}
+
+func (suite *HTTPSuite) TestAdminRoutes_RequireToken() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	_, err = suite.Client.deleteAd(ad.Data.ID, u.Data.ID)
+	suite.NoError(err)
+
+	for _, token := range []string{"", "wrong"} {
+		client := &testClient{client: suite.Client.client, baseURL: suite.Client.baseURL, adminToken: token}
+		_, err = client.restoreAd(ad.Data.ID)
+		suite.ErrorIs(err, ErrUnauthorized)
+		_, err = client.restoreUser(u.Data.ID)
+		suite.ErrorIs(err, ErrUnauthorized)
+		_, err = client.queryAudit("")
+		suite.ErrorIs(err, ErrUnauthorized)
+	}
+
+	// the rejected request did not restore the ad
+	_, err = suite.Client.getAd(ad.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	_, err = suite.Client.restoreAd(ad.Data.ID)
+	suite.NoError(err)
+}
+
+// the admin routes are closed, until the admin token is configured
+func TestAdminRoutes_DisabledWithoutToken(t *testing.T) {
+	server := httptest.NewServer(httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New())).Handler)
+	defer server.Close()
+
+	for _, token := range []string{"", testAdminToken} {
+		client := &testClient{client: server.Client(), baseURL: server.URL, adminToken: token}
+		_, err := client.queryAudit("")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	}
+}
+
+func TestGRPCAdminMethods_RequireToken(t *testing.T) {
+	server, _ := newGateway(t, grpcPort.UnaryAdminInterceptor(testAdminToken))
+	defer server.Close()
+
+	resp, _ := gatewayCall(t, server, http.MethodPost, "/v1/users", nil, map[string]any{"name": "Mac Miller", "email": "swimming@circles.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = gatewayCall(t, server, http.MethodDelete, "/v1/users/0", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, header := range []http.Header{nil, {adminauth.Header: {adminauth.Scheme + " wrong"}}} {
+		resp, body := gatewayCall(t, server, http.MethodPost, "/v1/admin/users/0/restore", header, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		_, code := gatewayError(t, body)
+		assert.Equal(t, "ADMIN_UNAUTHORIZED", code)
+	}
+
+	asAdmin := http.Header{adminauth.Header: {adminauth.Scheme + " " + testAdminToken}}
+	resp, body := gatewayCall(t, server, http.MethodPost, "/v1/admin/users/0/restore", asAdmin, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+}
@@ -0,0 +1,298 @@
+package tests
+
+import (
+	"context"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/mailer"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/notifier"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/searches"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestSavedSearch_Matches(t *testing.T) {
+	author := int64(7)
+	title := "Red bike"
+	date := time.Date(2023, 4, 12, 0, 0, 0, 0, time.UTC)
+	ad := ads.Ad{ID: 1, Title: "Red bike", Text: "Almost NEW, 21 gears", AuthorID: 7, Published: true,
+		DateCreated: date.Add(15 * time.Hour)}
+
+	tests := []struct {
+		name   string
+		search searches.SavedSearch
+		ad     ads.Ad
+		want   bool
+	}{
+		{"query words in any order and case", searches.SavedSearch{Query: "new BIKE"}, ad, true},
+		{"query word is missing", searches.SavedSearch{Query: "blue bike"}, ad, false},
+		{"filters", searches.SavedSearch{AuthorID: &author, Title: &title, Date: &date}, ad, true},
+		{"other author", searches.SavedSearch{Query: "bike", AuthorID: new(int64)}, ad, false},
+		{"other date", searches.SavedSearch{Date: &ad.DateChanged}, ad, false},
+		{"unpublished ad", searches.SavedSearch{Query: "bike"}, ads.Ad{Title: "Red bike"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.search.Matches(tc.ad))
+		})
+	}
+}
+
+type searchAlertsEnv struct {
+	repo   app.Repository
+	app    app.App
+	alerts *app.SearchAlerts
+	buyer  int64
+	seller int64
+}
+
+func newSearchAlertsEnv(t *testing.T, opts ...app.AlertsOption) *searchAlertsEnv {
+	repo := adrepo.New()
+	env := &searchAlertsEnv{
+		repo:   repo,
+		app:    app.NewApp(repo),
+		alerts: app.NewSearchAlerts(repo, notifier.NewInbox(repo, nil), opts...),
+	}
+	buyer, err := env.app.CreateUser(context.Background(), "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	seller, err := env.app.CreateUser(context.Background(), "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	env.buyer, env.seller = buyer.ID, seller.ID
+	return env
+}
+
+// publish creates and publishes an ad of the seller and lets the alerts handle the events
+func (env *searchAlertsEnv) publish(t *testing.T, uid int64, title string, text string) int64 {
+	ctx := context.Background()
+	ad, err := env.app.CreateAd(ctx, title, text, uid)
+	require.NoError(t, err)
+	_, err = env.app.ChangeAdStatus(ctx, ad.ID, uid, true)
+	require.NoError(t, err)
+	env.dispatch(t)
+	return ad.ID
+}
+
+func (env *searchAlertsEnv) dispatch(t *testing.T) {
+	_, err := app.NewOutboxRelay(env.repo, handlerPublisher(env.alerts.Handle)).Flush(context.Background())
+	require.NoError(t, err)
+}
+
+func (env *searchAlertsEnv) inbox(t *testing.T) []notifications.Notification {
+	list, err := env.repo.ListNotifications(context.Background(), env.buyer, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	return list
+}
+
+func TestSearchAlerts_Instant(t *testing.T) {
+	env := newSearchAlertsEnv(t)
+	ctx := context.Background()
+
+	s, err := env.app.CreateSavedSearch(ctx, env.buyer, app.SavedSearchParams{Query: "red bike"})
+	require.NoError(t, err)
+	assert.Equal(t, "red bike", s.Name)
+
+	env.publish(t, env.seller, "Blue bike", "Almost new")
+	_, err = env.app.CreateAd(ctx, "Red bike", "Draft", env.seller)
+	require.NoError(t, err)
+	env.dispatch(t)
+	// own ads are not notified
+	env.publish(t, env.buyer, "Red bike", "Mine")
+	assert.Len(t, env.inbox(t), 0)
+
+	adID := env.publish(t, env.seller, "Red bike", "Almost new")
+	inbox := env.inbox(t)
+	require.Len(t, inbox, 1)
+	assert.Equal(t, notifications.KindSearchMatch, inbox[0].Kind)
+	assert.Equal(t, []int64{adID}, inbox[0].AdIDs)
+	assert.Equal(t, s.ID, *inbox[0].SearchID)
+	assert.Contains(t, inbox[0].Subject, "red bike")
+	assert.Contains(t, inbox[0].Text, "Red bike")
+
+	// the notified match is not pending anymore
+	s, err = env.app.GetSavedSearch(ctx, env.buyer, s.ID)
+	require.NoError(t, err)
+	assert.Len(t, s.Pending, 0)
+	assert.False(t, s.LastNotified.IsZero())
+}
+
+func TestSearchAlerts_Digest(t *testing.T) {
+	env := newSearchAlertsEnv(t)
+	ctx := context.Background()
+
+	s, err := env.app.CreateSavedSearch(ctx, env.buyer, app.SavedSearchParams{
+		Name: "bikes", Query: "bike", Frequency: searches.FrequencyDaily})
+	require.NoError(t, err)
+
+	first := env.publish(t, env.seller, "Red bike", "Almost new")
+	second := env.publish(t, env.seller, "Blue bike", "Almost new")
+	gone := env.publish(t, env.seller, "Green bike", "Sold")
+	_, err = env.app.ChangeAdStatus(ctx, gone, env.seller, false)
+	require.NoError(t, err)
+	assert.Len(t, env.inbox(t), 0)
+
+	n, err := env.alerts.Flush(ctx, time.Now().UTC())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// unpublished ads are dropped from the digest
+	next := time.Now().UTC().Add(25 * time.Hour)
+	n, err = env.alerts.Flush(ctx, next)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	inbox := env.inbox(t)
+	require.Len(t, inbox, 1)
+	assert.Equal(t, []int64{first, second}, inbox[0].AdIDs)
+
+	// the next digest waits for another day
+	env.publish(t, env.seller, "Yellow bike", "Almost new")
+	n, err = env.alerts.Flush(ctx, next.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = env.alerts.Flush(ctx, next.Add(25*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	s, err = env.app.GetSavedSearch(ctx, env.buyer, s.ID)
+	require.NoError(t, err)
+	assert.Len(t, s.Pending, 0)
+}
+
+func TestSearchAlerts_Cap(t *testing.T) {
+	env := newSearchAlertsEnv(t, app.WithNotificationCap(2))
+	ctx := context.Background()
+
+	_, err := env.app.CreateSavedSearch(ctx, env.buyer, app.SavedSearchParams{Query: "bike"})
+	require.NoError(t, err)
+	_, err = env.app.CreateSavedSearch(ctx, env.buyer, app.SavedSearchParams{Query: "scooter"})
+	require.NoError(t, err)
+
+	env.publish(t, env.seller, "Red bike", "Almost new")
+	env.publish(t, env.seller, "Red scooter", "Almost new")
+	capped := env.publish(t, env.seller, "Blue bike", "Almost new")
+	more := env.publish(t, env.seller, "Green bike", "Almost new")
+	assert.Len(t, env.inbox(t), 2)
+
+	// the matches above the cap are sent together, when the day is over
+	n, err := env.alerts.Flush(ctx, time.Now().UTC().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = env.alerts.Flush(ctx, time.Now().UTC().Add(25*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	inbox := env.inbox(t)
+	require.Len(t, inbox, 3)
+	assert.Equal(t, []int64{capped, more}, inbox[0].AdIDs)
+}
+
+func TestSearchAlerts_Email(t *testing.T) {
+	repo := adrepo.New()
+	a := app.NewApp(repo)
+	outbox := mailer.NewOutbox()
+	alerts := app.NewSearchAlerts(repo, notifier.Multi{notifier.NewInbox(repo, nil), notifier.NewEmail(repo, outbox)})
+	ctx := context.Background()
+
+	buyer, err := a.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	seller, err := a.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	_, err = a.CreateSavedSearch(ctx, buyer.ID, app.SavedSearchParams{Name: "bikes", Query: "bike"})
+	require.NoError(t, err)
+
+	ad, err := a.CreateAd(ctx, "Red bike", "Almost new", seller.ID)
+	require.NoError(t, err)
+	_, err = a.ChangeAdStatus(ctx, ad.ID, seller.ID, true)
+	require.NoError(t, err)
+	_, err = app.NewOutboxRelay(repo, handlerPublisher(alerts.Handle)).Flush(ctx)
+	require.NoError(t, err)
+
+	messages := outbox.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "swimming@circles.com", messages[0].To)
+	assert.Contains(t, messages[0].Subject, "bikes")
+	assert.Contains(t, messages[0].Body, "Red bike")
+
+	inbox, err := repo.ListNotifications(ctx, buyer.ID, app.ListNotificationsParams{})
+	require.NoError(t, err)
+	assert.Len(t, inbox, 1)
+}
+
+func (suite *HTTPSuite) TestSavedSearches() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	other, err := suite.Client.createUser("Kendrick", "good@kid.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.createSavedSearch(u.Data.ID, map[string]any{"name": "everything"})
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createSavedSearch(u.Data.ID, map[string]any{"query": "bike", "frequency": "hourly"})
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createSavedSearch(u.Data.ID, map[string]any{"query": "bike", "date": "yesterday"})
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createSavedSearch(100, map[string]any{"query": "bike"})
+	suite.ErrorIs(err, ErrNotFound)
+
+	s, err := suite.Client.createSavedSearch(u.Data.ID, map[string]any{
+		"name":      "bikes of Kendrick",
+		"query":     "bike",
+		"author_id": other.Data.ID,
+		"date":      "2023-04-12",
+		"frequency": "weekly",
+	})
+	suite.NoError(err)
+	suite.Equal("bikes of Kendrick", s.Data.Name)
+	suite.Equal(other.Data.ID, *s.Data.AuthorID)
+	suite.Equal("2023-04-12", *s.Data.Date)
+	suite.Equal("weekly", s.Data.Frequency)
+	suite.Nil(s.Data.LastNotified)
+
+	list, err := suite.Client.listSavedSearches(u.Data.ID)
+	suite.NoError(err)
+	suite.Len(list.Data, 1)
+
+	_, err = suite.Client.getSavedSearch(other.Data.ID, s.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	err = suite.Client.deleteSavedSearch(other.Data.ID, s.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	err = suite.Client.deleteSavedSearch(u.Data.ID, s.Data.ID)
+	suite.NoError(err)
+	_, err = suite.Client.getSavedSearch(u.Data.ID, s.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *GRPCSuite) TestGRPCSavedSearches() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	_, err = suite.Client.CreateSavedSearch(suite.Context, &grpcPort.CreateSavedSearchRequest{UserId: &u.Id})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.CreateSavedSearch(suite.Context, &grpcPort.CreateSavedSearchRequest{UserId: &u.Id, Query: "bike",
+		Frequency: grpcPort.SearchFrequency(10)})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	title := "Red bike"
+	s, err := suite.Client.CreateSavedSearch(suite.Context, &grpcPort.CreateSavedSearchRequest{
+		UserId:    &u.Id,
+		Title:     &title,
+		Frequency: grpcPort.SearchFrequency_SEARCH_FREQUENCY_DAILY,
+	})
+	suite.NoError(err)
+	suite.Equal(title, s.GetTitle())
+	suite.Equal(grpcPort.SearchFrequency_SEARCH_FREQUENCY_DAILY, s.Frequency)
+
+	list, err := suite.Client.ListSavedSearches(suite.Context, &grpcPort.ListSavedSearchesRequest{UserId: &u.Id})
+	suite.NoError(err)
+	suite.Len(list.List, 1)
+
+	_, err = suite.Client.DeleteSavedSearch(suite.Context, &grpcPort.SavedSearchRequest{UserId: &u.Id, SearchId: &s.Id})
+	suite.NoError(err)
+	_, err = suite.Client.GetSavedSearch(suite.Context, &grpcPort.SavedSearchRequest{UserId: &u.Id, SearchId: &s.Id})
+	suite.Equal(codes.NotFound, status.Code(err))
+}
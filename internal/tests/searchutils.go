@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type savedSearchData struct {
+	ID           int64   `json:"id"`
+	UserID       int64   `json:"user_id"`
+	Name         string  `json:"name"`
+	Query        string  `json:"query"`
+	AuthorID     *int64  `json:"author_id"`
+	Date         *string `json:"date"`
+	Title        *string `json:"title"`
+	Frequency    string  `json:"frequency"`
+	LastNotified *string `json:"last_notified"`
+	Pending      int     `json:"pending"`
+}
+
+type savedSearchResponse struct {
+	Data savedSearchData `json:"data"`
+}
+
+type savedSearchesResponse struct {
+	Data []savedSearchData `json:"data"`
+}
+
+func (tc *testClient) createSavedSearch(userID any, body map[string]any) (savedSearchResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return savedSearchResponse{}, fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/searches", userID), bytes.NewReader(data))
+	if err != nil {
+		return savedSearchResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	var response savedSearchResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return savedSearchResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) listSavedSearches(userID any) (savedSearchesResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/searches", userID), nil)
+	if err != nil {
+		return savedSearchesResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response savedSearchesResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return savedSearchesResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) getSavedSearch(userID any, searchID any) (savedSearchResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/searches/%v", userID, searchID), nil)
+	if err != nil {
+		return savedSearchResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response savedSearchResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return savedSearchResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) deleteSavedSearch(userID any, searchID any) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/searches/%v", userID, searchID), nil)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response savedSearchResponse
+	return tc.getResponse(req, &response)
+}
@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"github.com/TobbyMax/ad-service.git/internal/notifications"
+	"github.com/TobbyMax/ad-service.git/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTestRollback = errors.New("rollback")
+
+func TestRepository_InTransaction_Rollback(t *testing.T) {
+	repo := adrepo.NewRepositoryMap()
+	ctx := context.Background()
+	uid, err := repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	id, err := repo.AddAd(ctx, ads.Ad{Title: "Bike", Text: "Old", AuthorID: uid})
+	require.NoError(t, err)
+	nid, err := repo.AddNotification(ctx, notifications.Notification{UserID: uid})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEvent(ctx, events.AdCreated{Ad: ads.Ad{ID: id, AuthorID: uid}}))
+	before, err := repo.PendingMessages(ctx, 100)
+	require.NoError(t, err)
+
+	err = repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		now := time.Now().UTC()
+		require.NoError(t, tx.UpdateAdContent(ctx, id, "Car", "New", now))
+		require.NoError(t, tx.UpdateAdStatus(ctx, id, true, now))
+		_, err := tx.AddAd(ctx, ads.Ad{Title: "Boat", AuthorID: uid})
+		require.NoError(t, err)
+		_, err = tx.AddUser(ctx, user.User{Nickname: "Kendrick", Email: "good@kid.com"})
+		require.NoError(t, err)
+		_, err = tx.MarkNotificationRead(ctx, uid, nid)
+		require.NoError(t, err)
+		_, err = tx.AddNotification(ctx, notifications.Notification{UserID: uid})
+		require.NoError(t, err)
+		require.NoError(t, tx.AddEvent(ctx, events.AdUpdated{Ad: ads.Ad{ID: id, AuthorID: uid}}))
+		require.NoError(t, tx.MarkDelivered(ctx, before[0].ID))
+
+		// the transaction sees its own changes
+		ad, err := tx.GetAdByID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "Car", ad.Title)
+		return errTestRollback
+	})
+	assert.ErrorIs(t, err, errTestRollback)
+
+	ad, err := repo.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Bike", ad.Title)
+	assert.False(t, ad.Published)
+	list, err := repo.GetAdList(ctx, app.ListAdsParams{})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, 1)
+	_, err = repo.GetUserByEmail(ctx, "good@kid.com")
+	assert.ErrorIs(t, err, app.ErrUserNotFound)
+	unread, err := repo.CountUnreadNotifications(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, 1, unread)
+	after, err := repo.PendingMessages(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// IDs are not given back, like database sequences
+	next, err := repo.AddAd(ctx, ads.Ad{Title: "Boat", AuthorID: uid})
+	require.NoError(t, err)
+	assert.Equal(t, id+2, next)
+}
+
+func TestRepository_InTransaction_Panic(t *testing.T) {
+	repo := adrepo.NewRepositoryMap()
+	ctx := context.Background()
+	uid, err := repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+
+	assert.Panics(t, func() {
+		_ = repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+			require.NoError(t, tx.DeleteUserByID(ctx, uid))
+			panic("boom")
+		})
+	})
+	_, err = repo.GetUserByID(ctx, uid)
+	assert.NoError(t, err)
+
+	// the lock is released after the panic
+	_, err = repo.AddAd(ctx, ads.Ad{Title: "Bike", AuthorID: uid})
+	assert.NoError(t, err)
+}
+
+func TestRepository_InTransaction_Commit(t *testing.T) {
+	repo := adrepo.NewRepositoryMap()
+	ctx := context.Background()
+	uid, err := repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+
+	var id int64
+	err = repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		var err error
+		id, err = tx.AddAd(ctx, ads.Ad{Title: "Bike", AuthorID: uid})
+		if err != nil {
+			return err
+		}
+		// nested transactions join the running one
+		return tx.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+			return tx.UpdateAdStatus(ctx, id, true, time.Now().UTC())
+		})
+	})
+	require.NoError(t, err)
+	ad, err := repo.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, ad.Published)
+
+	// the failure of the nested transaction rolls back the whole transaction
+	err = repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+		if err := tx.DeleteAdByID(ctx, id); err != nil {
+			return err
+		}
+		return tx.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+			return errTestRollback
+		})
+	})
+	assert.ErrorIs(t, err, errTestRollback)
+	_, err = repo.GetAdByID(ctx, id)
+	assert.NoError(t, err)
+}
+
+func TestRepository_InTransaction_Concurrent(t *testing.T) {
+	repo := adrepo.NewRepositoryMap()
+	ctx := context.Background()
+	uid, err := repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	id, err := repo.AddAd(ctx, ads.Ad{Title: "0", AuthorID: uid})
+	require.NoError(t, err)
+
+	// every transaction increments the title, so lost updates would show up in the result
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+				ad, err := tx.GetAdByID(ctx, id)
+				if err != nil {
+					return err
+				}
+				count, err := strconv.Atoi(ad.Title)
+				if err != nil {
+					return err
+				}
+				return tx.UpdateAdContent(ctx, id, strconv.Itoa(count+1), ad.Text, time.Now().UTC())
+			})
+			assert.NoError(t, err)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.GetAdList(ctx, app.ListAdsParams{})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ad, err := repo.GetAdByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(n), ad.Title)
+}
+
+func TestApp_Register_Concurrent(t *testing.T) {
+	a := app.NewApp(adrepo.New())
+	ctx := context.Background()
+
+	// the email is checked and taken in one transaction, so only one of the users is registered
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = a.Register(ctx, "Mac Miller", "swimming@circles.com", "password")
+		}(i)
+	}
+	wg.Wait()
+
+	registered := 0
+	for _, err := range errs {
+		if err == nil {
+			registered++
+			continue
+		}
+		assert.ErrorIs(t, err, app.ErrEmailTaken)
+	}
+	assert.Equal(t, 1, registered)
+}
+
+func TestRepository_ConcurrentSnapshots(t *testing.T) {
+	repo := adrepo.NewRepositoryMap()
+	ctx := context.Background()
+	uid, err := repo.AddUser(ctx, user.User{Nickname: "Mac Miller", Email: "swimming@circles.com"})
+	require.NoError(t, err)
+	const total, published = 100, 10
+	for i := 0; i < total; i++ {
+		_, err := repo.AddAd(ctx, ads.Ad{Title: "Bike", AuthorID: uid, Published: i < published})
+		require.NoError(t, err)
+	}
+
+	// every transaction swaps a published ad with an unpublished one, so the readers must always
+	// see the same number of published ads, whatever the interleaving is
+	p := true
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				err := repo.InTransaction(ctx, func(ctx context.Context, tx app.Repository) error {
+					list, err := tx.GetAdList(ctx, app.ListAdsParams{Published: &p})
+					if err != nil {
+						return err
+					}
+					unpublished := int64((w*100 + i) % total)
+					ad, err := tx.GetAdByID(ctx, unpublished)
+					if err != nil {
+						return err
+					}
+					if ad.Published {
+						return nil
+					}
+					now := time.Now().UTC()
+					if err := tx.UpdateAdStatus(ctx, list.Data[0].ID, false, now); err != nil {
+						return err
+					}
+					return tx.UpdateAdStatus(ctx, unpublished, true, now)
+				})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				list, err := repo.GetAdList(ctx, app.ListAdsParams{Published: &p})
+				assert.NoError(t, err)
+				assert.Len(t, list.Data, published)
+				_, err = repo.GetAdByID(ctx, int64((r*200+i)%total))
+				assert.NoError(t, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	list, err := repo.GetAdList(ctx, app.ListAdsParams{Published: &p})
+	require.NoError(t, err)
+	assert.Len(t, list.Data, published)
+}
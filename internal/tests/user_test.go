@@ -42,6 +42,28 @@ func (suite *HTTPSuite) TestUpdateUser() {
 	suite.Equal("the_divine2016@feminine.ru", response.Data.Email)
 }
 
+func (suite *HTTPSuite) TestCreateUser_EmailTaken() {
+	_, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.createUser("Larry Fisherman", "swimming@circles.com")
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *HTTPSuite) TestUpdateUser_EmailTaken() {
+	_, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	response, err := suite.Client.createUser("KDot", "money@trees.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.updateUser(response.Data.ID, "KDot", "swimming@circles.com")
+	suite.ErrorIs(err, ErrConflict)
+
+	response, err = suite.Client.getUser(response.Data.ID)
+	suite.NoError(err)
+	suite.Equal("money@trees.com", response.Data.Email)
+}
+
 func (suite *HTTPSuite) TestUpdateUser_InvalidEmail() {
 	response, err := suite.Client.createUser("MacMiller", "swimming@circles.com")
 	suite.NoError(err)
@@ -55,11 +77,11 @@ func (suite *HTTPSuite) TestCreateUser_ID() {
 	suite.NoError(err)
 	suite.Equal(resp.Data.ID, int64(0))
 
-	resp, err = suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	resp, err = suite.Client.createUser("Mac Miller", "blue_slide@park.com")
 	suite.NoError(err)
 	suite.Equal(resp.Data.ID, int64(1))
 
-	resp, err = suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	resp, err = suite.Client.createUser("Mac Miller", "self_care@swimming.com")
 	suite.NoError(err)
 	suite.Equal(resp.Data.ID, int64(2))
 }
@@ -71,7 +93,7 @@ func (suite *HTTPSuite) TestDeleteUser() {
 	ad1, err := suite.Client.createAd(user1.Data.ID, "Good News", "Dang!")
 	suite.NoError(err)
 
-	_, err = suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	_, err = suite.Client.createUser("Mac Miller", "blue_slide@park.com")
 	suite.NoError(err)
 
 	_, err = suite.Client.deleteUser(user1.Data.ID)
@@ -11,6 +11,7 @@ type userData struct {
 	ID       int64  `json:"id"`
 	Nickname string `json:"nickname"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
 }
 
 type userResponse struct {
@@ -89,7 +90,11 @@ func (tc *testClient) updateUser(userID any, nickname any, email any) (userRespo
 }
 
 func (tc *testClient) deleteUser(userID any) (userResponse, error) {
-	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v", userID), nil)
+	return tc.deleteUserWithQuery(userID, "")
+}
+
+func (tc *testClient) deleteUserWithQuery(userID any, query string) (userResponse, error) {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v?%s", userID, query), nil)
 	if err != nil {
 		return userResponse{}, fmt.Errorf("unable to create request: %w", err)
 	}
@@ -104,3 +109,40 @@ func (tc *testClient) deleteUser(userID any) (userResponse, error) {
 
 	return response, nil
 }
+
+func (tc *testClient) restoreUser(userID any) (userResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/admin/users/%v/restore", userID), nil)
+	if err != nil {
+		return userResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response userResponse
+	err = tc.getResponse(tc.asAdmin(req), &response)
+	if err != nil {
+		return userResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) patchUser(userID any, patch map[string]any) (userResponse, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return userResponse{}, fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v", userID), bytes.NewReader(data))
+	if err != nil {
+		return userResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/merge-patch+json")
+
+	var response userResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return userResponse{}, err
+	}
+
+	return response, nil
+}
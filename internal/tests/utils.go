@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adminauth"
 	"github.com/TobbyMax/ad-service.git/internal/app"
 	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
 	"github.com/stretchr/testify/suite"
@@ -40,23 +41,40 @@ var (
 	ErrMock             = fmt.Errorf("mock error")
 	ErrInternal         = fmt.Errorf("internal server error")
 	ErrFailedDependency = fmt.Errorf("failed dependency")
+	ErrUnauthorized     = fmt.Errorf("unauthorized")
+	ErrConflict         = fmt.Errorf("conflict")
+	ErrTooManyRequests  = fmt.Errorf("too many requests")
 )
 
 type testClient struct {
 	client  *http.Client
 	baseURL string
+	// adminToken is sent to the admin routes
+	adminToken string
 }
 
+// testAdminToken is the admin token of the test servers
+const testAdminToken = "admin-secret"
+
 func getTestClient() *testClient {
-	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New()))
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(adrepo.New()), httpgin.WithAdminToken(testAdminToken))
 	testServer := httptest.NewServer(server.Handler)
 
 	return &testClient{
-		client:  testServer.Client(),
-		baseURL: testServer.URL,
+		client:     testServer.Client(),
+		baseURL:    testServer.URL,
+		adminToken: testAdminToken,
 	}
 }
 
+// asAdmin adds the admin token of the client to the request
+func (tc *testClient) asAdmin(req *http.Request) *http.Request {
+	if tc.adminToken != "" {
+		req.Header.Set(adminauth.Header, adminauth.Scheme+" "+tc.adminToken)
+	}
+	return req
+}
+
 type HTTPSuite struct {
 	suite.Suite
 	Client *testClient
@@ -77,16 +95,22 @@ func (tc *testClient) getResponse(req *http.Request, out any) error {
 		return fmt.Errorf("unexpected error: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		switch resp.StatusCode {
 		case http.StatusBadRequest:
 			return ErrBadRequest
+		case http.StatusUnauthorized:
+			return ErrUnauthorized
 		case http.StatusForbidden:
 			return ErrForbidden
 		case http.StatusNotFound:
 			return ErrNotFound
 		case http.StatusFailedDependency:
 			return ErrFailedDependency
+		case http.StatusConflict:
+			return ErrConflict
+		case http.StatusTooManyRequests:
+			return ErrTooManyRequests
 		case http.StatusInternalServerError:
 			return ErrInternal
 		}
@@ -258,3 +282,40 @@ func (tc *testClient) badDeleteAd(adID any) (adResponse, error) {
 
 	return response, nil
 }
+
+func (tc *testClient) restoreAd(adID any) (adResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/admin/ads/%v/restore", adID), nil)
+	if err != nil {
+		return adResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response adResponse
+	err = tc.getResponse(tc.asAdmin(req), &response)
+	if err != nil {
+		return adResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) patchAd(adID any, patch map[string]any) (adResponse, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return adResponse{}, fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf(tc.baseURL+"/api/v1/ads/%v", adID), bytes.NewReader(data))
+	if err != nil {
+		return adResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/merge-patch+json")
+
+	var response adResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return adResponse{}, err
+	}
+
+	return response, nil
+}
@@ -0,0 +1,286 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adfeed"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type watchEnv struct {
+	repo *adrepo.RepositoryMap
+	feed *adfeed.Feed
+	app  app.App
+}
+
+func newWatchEnv(opts ...adfeed.Option) *watchEnv {
+	env := &watchEnv{repo: adrepo.NewRepositoryMap(), feed: adfeed.New(opts...)}
+	env.app = app.NewApp(env.repo, app.WithAdFeed(env.feed))
+	return env
+}
+
+// relay delivers the events from the outbox to the feed
+func (env *watchEnv) relay(t *testing.T) {
+	_, err := app.NewOutboxRelay(env.repo, handlerPublisher(env.feed.Handle)).Flush(context.Background())
+	require.NoError(t, err)
+}
+
+func collect(t *testing.T, ch <-chan events.Event, n int) []string {
+	names := make([]string, 0, n)
+	for len(names) < n {
+		select {
+		case e, ok := <-ch:
+			require.True(t, ok, "watch is closed")
+			names = append(names, e.EventName())
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, expected %d events", names, n)
+		}
+	}
+	return names
+}
+
+func TestWatchAds_Filters(t *testing.T) {
+	env := newWatchEnv()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	u, err := env.app.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	other, err := env.app.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	// without filters only published ads are watched, as in ListAds
+	all, _, err := env.app.WatchAds(ctx, app.ListAdsParams{})
+	require.NoError(t, err)
+	own, _, err := env.app.WatchAds(ctx, app.ListAdsParams{Uid: &u.ID})
+	require.NoError(t, err)
+
+	ad, err := env.app.CreateAd(ctx, "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	_, err = env.app.ChangeAdStatus(ctx, ad.ID, u.ID, true)
+	require.NoError(t, err)
+	_, err = env.app.UpdateAd(ctx, ad.ID, u.ID, "Red bike", "Used")
+	require.NoError(t, err)
+	_, err = env.app.ChangeAdStatus(ctx, ad.ID, u.ID, false)
+	require.NoError(t, err)
+	require.NoError(t, env.app.DeleteAd(ctx, ad.ID, u.ID))
+	_, err = env.app.CreateAd(ctx, "Blue bike", "New", other.ID)
+	require.NoError(t, err)
+	env.relay(t)
+
+	assert.Equal(t, []string{events.NameAdCreated, events.NameAdPublished, events.NameAdUpdated,
+		events.NameAdUnpublished, events.NameAdDeleted}, collect(t, own, 5))
+	assert.Equal(t, []string{events.NameAdPublished, events.NameAdUpdated, events.NameAdUnpublished,
+		events.NameAdDeleted}, collect(t, all, 4))
+	assert.Len(t, own, 0)
+	assert.Len(t, all, 0)
+
+	cancel()
+	_, ok := <-own
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool { return env.feed.Watchers() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWatchAds_RemovalsOfDeletedUsers(t *testing.T) {
+	env := newWatchEnv()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deleted, err := env.app.CreateUser(ctx, "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+	transferred, err := env.app.CreateUser(ctx, "Kendrick", "good@kid.com")
+	require.NoError(t, err)
+	to, err := env.app.CreateUser(ctx, "Earl", "some@rap.com")
+	require.NoError(t, err)
+	var ids []int64
+	for _, uid := range []int64{deleted.ID, transferred.ID} {
+		ad, err := env.app.CreateAd(ctx, "Red bike", "Almost new", uid)
+		require.NoError(t, err)
+		_, err = env.app.ChangeAdStatus(ctx, ad.ID, uid, true)
+		require.NoError(t, err)
+		ids = append(ids, ad.ID)
+	}
+	env.relay(t)
+
+	ofDeleted, _, err := env.app.WatchAds(ctx, app.ListAdsParams{Uid: &deleted.ID})
+	require.NoError(t, err)
+	ofTransferred, _, err := env.app.WatchAds(ctx, app.ListAdsParams{Uid: &transferred.ID})
+	require.NoError(t, err)
+	ofNewAuthor, _, err := env.app.WatchAds(ctx, app.ListAdsParams{Uid: &to.ID})
+	require.NoError(t, err)
+
+	require.NoError(t, env.app.DeleteUser(ctx, deleted.ID, app.DeleteUserParams{}))
+	require.NoError(t, env.app.DeleteUser(ctx, transferred.ID, app.DeleteUserParams{Mode: app.DeleteTransfer, TransferTo: &to.ID}))
+	env.relay(t)
+
+	e := <-ofDeleted
+	assert.Equal(t, events.AdDeleted{AdID: ids[0], AuthorID: deleted.ID, Time: e.OccurredAt()}, e)
+	// the watchers of both authors learn about the ad handed over
+	for _, ch := range []<-chan events.Event{ofTransferred, ofNewAuthor} {
+		e = <-ch
+		require.IsType(t, events.AdUpdated{}, e)
+		assert.Equal(t, ids[1], e.(events.AdUpdated).Ad.ID)
+		assert.Equal(t, to.ID, e.(events.AdUpdated).Ad.AuthorID)
+	}
+	assert.Len(t, ofDeleted, 0)
+	assert.Len(t, ofTransferred, 0)
+	assert.Len(t, ofNewAuthor, 0)
+}
+
+func TestWatchAds_SlowConsumer(t *testing.T) {
+	env := newWatchEnv(adfeed.WithBuffer(1))
+	ctx := context.Background()
+
+	ch, errFunc, err := env.app.WatchAds(ctx, app.ListAdsParams{})
+	require.NoError(t, err)
+	env.feed.Publish(events.AdPublished{Ad: ads.Ad{ID: 1, Published: true}})
+	env.feed.Publish(events.AdPublished{Ad: ads.Ad{ID: 2, Published: true}})
+
+	// the buffered change is still delivered, then the watch ends with the error
+	_, ok := <-ch
+	assert.True(t, ok)
+	_, ok = <-ch
+	assert.False(t, ok)
+	assert.ErrorIs(t, errFunc(), app.ErrSlowConsumer)
+	assert.Equal(t, 0, env.feed.Watchers())
+
+	_, _, err = app.NewApp(adrepo.New()).WatchAds(ctx, app.ListAdsParams{})
+	assert.ErrorIs(t, err, app.ErrFeedUnavailable)
+}
+
+func TestHTTPWatchAds(t *testing.T) {
+	env := newWatchEnv()
+	server := httpgin.NewHTTPServer(":0", env.app)
+	testServer := httptest.NewUnstartedServer(nil)
+	testServer.Config = server
+	testServer.Start()
+	defer testServer.Close()
+
+	u, err := env.app.CreateUser(context.Background(), "Mac Miller", "swimming@circles.com")
+	require.NoError(t, err)
+
+	// every request has its own connection, otherwise the transport could leave a connection without
+	// requests, which Shutdown does not close at once
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(testServer.URL + "/api/v1/ads/watch?published=abc")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	resp, err = client.Get(testServer.URL + "/api/v1/ads/watch?published=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	require.Eventually(t, func() bool { return env.feed.Watchers() == 1 }, time.Second, 10*time.Millisecond)
+
+	ad, err := env.app.CreateAd(context.Background(), "Red bike", "Almost new", u.ID)
+	require.NoError(t, err)
+	_, err = env.app.ChangeAdStatus(context.Background(), ad.ID, u.ID, true)
+	require.NoError(t, err)
+	env.relay(t)
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event:"+events.NameAdPublished+"\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "data:"))
+	assert.Contains(t, line, `"title":"Red bike"`)
+
+	// shutdown ends the stream instead of waiting for the client to leave
+	shCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(shCtx))
+	_, err = io.ReadAll(reader)
+	assert.NoError(t, err)
+}
+
+func (suite *GRPCSuite) TestGRPCWatchAds() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	ctx, cancel := context.WithCancel(suite.Context)
+	defer cancel()
+	stream, err := suite.Client.WatchAds(ctx, &grpcPort.ListAdRequest{UserId: &u.Id})
+	suite.NoError(err)
+	suite.Eventually(func() bool { return suite.Feed.Watchers() == 1 }, time.Second, 10*time.Millisecond)
+
+	ad, err := suite.Client.CreateAd(suite.Context, &grpcPort.CreateAdRequest{Title: "hello", Text: "world", UserId: &u.Id})
+	suite.NoError(err)
+	_, err = suite.Client.DeleteAd(suite.Context, &grpcPort.DeleteAdRequest{AdId: &ad.Id, AuthorId: &u.Id})
+	suite.NoError(err)
+	_, err = app.NewOutboxRelay(suite.Repo, handlerPublisher(suite.Feed.Handle)).Flush(suite.Context)
+	suite.NoError(err)
+
+	created, err := stream.Recv()
+	suite.NoError(err)
+	suite.Equal(events.NameAdCreated, created.Event)
+	suite.Equal(ad.Id, created.AdId)
+	suite.Equal("hello", created.Ad.GetTitle())
+	deleted, err := stream.Recv()
+	suite.NoError(err)
+	suite.Equal(events.NameAdDeleted, deleted.Event)
+	suite.Equal(u.Id, deleted.AuthorId)
+	suite.Nil(deleted.Ad)
+
+	date := "yesterday"
+	stream, err = suite.Client.WatchAds(suite.Context, &grpcPort.ListAdRequest{Date: &date})
+	suite.NoError(err)
+	_, err = stream.Recv()
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCWatchAds_GracefulShutdown(t *testing.T) {
+	env := newWatchEnv()
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpcPort.RegisterAdServiceServer(server, grpcPort.NewService(env.app))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- grpcPort.RunGRPCServerGracefully(ctx, lis, server)()
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := grpcPort.NewAdServiceClient(conn).WatchAds(context.Background(), &grpcPort.ListAdRequest{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return env.feed.Watchers() == 1 }, time.Second, 10*time.Millisecond)
+
+	// main closes the feed together with the servers
+	cancel()
+	env.feed.Close()
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(2 * time.Second):
+		t.Fatal("grpc server is not stopped")
+	}
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,583 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/adrepo"
+	"github.com/TobbyMax/ad-service.git/internal/adapters/dispatcher"
+	"github.com/TobbyMax/ad-service.git/internal/ads"
+	"github.com/TobbyMax/ad-service.git/internal/app"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	grpcPort "github.com/TobbyMax/ad-service.git/internal/ports/grpc"
+	"github.com/TobbyMax/ad-service.git/internal/ports/httpgin"
+	"github.com/TobbyMax/ad-service.git/internal/webhooks"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedWebhook is a request, which came to the webhookReceiver
+type receivedWebhook struct {
+	Event    string
+	Delivery string
+	Verified bool
+	AdID     int64
+	AuthorID int64
+}
+
+// webhookReceiver is a partner endpoint, it checks signatures with the secret set after the subscription
+// and answers with the statuses from the queue, 200 when the queue is empty
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	received []receivedWebhook
+	server   *httptest.Server
+}
+
+func newWebhookReceiver(statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+func (r *webhookReceiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(webhooks.TimestampHeader), 10, 64)
+	var payload struct {
+		Ad struct {
+			ID       int64 `json:"id"`
+			AuthorID int64 `json:"author_id"`
+		} `json:"ad"`
+	}
+	_ = json.Unmarshal(body, &payload)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, receivedWebhook{
+		Event:    req.Header.Get(webhooks.EventHeader),
+		Delivery: req.Header.Get(webhooks.DeliveryHeader),
+		Verified: webhooks.Verify(r.secret, timestamp, body, req.Header.Get(webhooks.SignatureHeader)),
+		AdID:     payload.Ad.ID,
+		AuthorID: payload.Ad.AuthorID,
+	})
+	code := http.StatusOK
+	if len(r.statuses) > 0 {
+		code, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func (r *webhookReceiver) SetSecret(secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secret = secret
+}
+
+func (r *webhookReceiver) Received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.received...)
+}
+
+// handlerPublisher lets the outbox relay feed a bus handler directly
+type handlerPublisher func(ctx context.Context, e events.Event) error
+
+func (h handlerPublisher) Deliver(ctx context.Context, m events.Message) ([]string, error) {
+	return nil, h(events.WithMessageID(ctx, m.ID), m.Event)
+}
+
+type WebhookSuite struct {
+	suite.Suite
+	Client     *testClient
+	Repo       app.Repository
+	Dispatcher *dispatcher.Dispatcher
+}
+
+func (suite *WebhookSuite) SetupTest() {
+	log.Println("Setting Up Test")
+
+	suite.Repo = adrepo.New()
+	suite.Dispatcher = dispatcher.New(suite.Repo, dispatcher.WithRetry(3, time.Millisecond), dispatcher.WithMaxFailures(2))
+	server := httpgin.NewHTTPServer(":18080", app.NewApp(suite.Repo), httpgin.WithAdminToken(testAdminToken))
+	testServer := httptest.NewServer(server.Handler)
+
+	suite.Client = &testClient{
+		client:     testServer.Client(),
+		baseURL:    testServer.URL,
+		adminToken: testAdminToken,
+	}
+}
+
+// dispatch delivers all events from the outbox to the webhooks
+func (suite *WebhookSuite) dispatch() {
+	_, err := app.NewOutboxRelay(suite.Repo, handlerPublisher(suite.Dispatcher.Handle)).Flush(context.Background())
+	suite.NoError(err)
+	suite.Dispatcher.Wait()
+}
+
+func (suite *WebhookSuite) TestWebhooks_SignedDelivery() {
+	all := newWebhookReceiver()
+	defer all.server.Close()
+	removals := newWebhookReceiver()
+	defer removals.server.Close()
+
+	partner, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	hook, err := suite.Client.createWebhook(partner.Data.ID, all.server.URL, nil)
+	suite.NoError(err)
+	suite.True(hook.Data.Active)
+	suite.NotEmpty(hook.Data.Secret)
+	suite.Equal(webhooks.Events, hook.Data.Events)
+	all.SetSecret(hook.Data.Secret)
+	removalHook, err := suite.Client.createWebhook(partner.Data.ID, removals.server.URL, []string{events.NameAdDeleted})
+	suite.NoError(err)
+	removals.SetSecret(removalHook.Data.Secret)
+
+	u, err := suite.Client.createUser("Kendrick", "good@kid.com")
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, true)
+	suite.NoError(err)
+	_, err = suite.Client.updateAd(u.Data.ID, ad.Data.ID, "Self Care", "Swimming")
+	suite.NoError(err)
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, false)
+	suite.NoError(err)
+	// changes of unpublished ads are not sent
+	_, err = suite.Client.updateAd(u.Data.ID, ad.Data.ID, "Circles", "Swimming")
+	suite.NoError(err)
+	_, err = suite.Client.deleteAd(ad.Data.ID, u.Data.ID)
+	suite.NoError(err)
+
+	suite.dispatch()
+
+	received := all.Received()
+	suite.Len(received, 4)
+	names := make([]string, 0, len(received))
+	for _, r := range received {
+		names = append(names, r.Event)
+		suite.True(r.Verified)
+		suite.NotEmpty(r.Delivery)
+		suite.Equal(ad.Data.ID, r.AdID)
+	}
+	suite.Equal([]string{
+		events.NameAdPublished,
+		events.NameAdUpdated,
+		events.NameAdUnpublished,
+		events.NameAdDeleted,
+	}, names)
+
+	received = removals.Received()
+	suite.Len(received, 1)
+	suite.Equal(events.NameAdDeleted, received[0].Event)
+	suite.True(received[0].Verified)
+
+	deliveries, err := suite.Client.listWebhookDeliveries(partner.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.Len(deliveries.Data, 4)
+	// the newest delivery goes first
+	suite.Equal(events.NameAdDeleted, deliveries.Data[0].Event)
+	for _, d := range deliveries.Data {
+		suite.True(d.Success)
+		suite.Equal(1, d.Attempts)
+		suite.Equal(http.StatusOK, d.StatusCode)
+	}
+}
+
+// subscribe creates a user with a subscription to all events of the receiver
+func (suite *WebhookSuite) subscribe(receiver *webhookReceiver) {
+	partner, err := suite.Client.createUser("Partner", "partner@hooks.com")
+	suite.Require().NoError(err)
+	hook, err := suite.Client.createWebhook(partner.Data.ID, receiver.server.URL, nil)
+	suite.Require().NoError(err)
+	receiver.SetSecret(hook.Data.Secret)
+}
+
+// publishAds creates the published ads of the user and returns their ids
+func (suite *WebhookSuite) publishAds(uid int64, titles ...string) []int64 {
+	ids := make([]int64, 0, len(titles))
+	for _, title := range titles {
+		ad, err := suite.Client.createAd(uid, title, "Dang!")
+		suite.Require().NoError(err)
+		_, err = suite.Client.changeAdStatus(uid, ad.Data.ID, true)
+		suite.Require().NoError(err)
+		ids = append(ids, ad.Data.ID)
+	}
+	return ids
+}
+
+// receivedAfter returns the requests after the first n ones and checks their signatures
+func (suite *WebhookSuite) receivedAfter(receiver *webhookReceiver, n int) []receivedWebhook {
+	received := receiver.Received()
+	suite.Require().GreaterOrEqual(len(received), n)
+	for _, r := range received[n:] {
+		suite.True(r.Verified)
+	}
+	return received[n:]
+}
+
+func (suite *WebhookSuite) TestWebhooks_UserDeletedWithAds() {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	suite.subscribe(receiver)
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ids := suite.publishAds(u.Data.ID, "Good News", "Blue World")
+	suite.dispatch()
+	published := len(receiver.Received())
+
+	_, err = suite.Client.deleteUser(u.Data.ID)
+	suite.NoError(err)
+	suite.dispatch()
+
+	var deleted []int64
+	for _, r := range suite.receivedAfter(receiver, published) {
+		suite.Equal(events.NameAdDeleted, r.Event)
+		suite.Equal(u.Data.ID, r.AuthorID)
+		deleted = append(deleted, r.AdID)
+	}
+	suite.ElementsMatch(ids, deleted)
+
+	_, err = suite.Client.restoreUser(u.Data.ID)
+	suite.NoError(err)
+	suite.dispatch()
+
+	var restored []int64
+	for _, r := range suite.receivedAfter(receiver, published+len(deleted)) {
+		suite.Equal(events.NameAdRestored, r.Event)
+		restored = append(restored, r.AdID)
+	}
+	suite.ElementsMatch(ids, restored)
+}
+
+func (suite *WebhookSuite) TestWebhooks_UserAdsHandedOver() {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	suite.subscribe(receiver)
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	to, err := suite.Client.createUser("Kendrick", "good@kid.com")
+	suite.NoError(err)
+	anonymous, err := suite.Client.createUser("Earl", "some@rap.com")
+	suite.NoError(err)
+	ids := suite.publishAds(u.Data.ID, "Good News")
+	anonymousIDs := suite.publishAds(anonymous.Data.ID, "Blue World")
+	suite.dispatch()
+	published := len(receiver.Received())
+
+	_, err = suite.Client.deleteUserWithQuery(u.Data.ID, fmt.Sprintf("mode=transfer&transfer_to=%d", to.Data.ID))
+	suite.NoError(err)
+	_, err = suite.Client.deleteUserWithQuery(anonymous.Data.ID, "mode=anonymize")
+	suite.NoError(err)
+	suite.dispatch()
+
+	suite.Equal([]receivedWebhook{
+		{Event: events.NameAdUpdated, Verified: true, AdID: ids[0], AuthorID: to.Data.ID},
+		{Event: events.NameAdUpdated, Verified: true, AdID: anonymousIDs[0], AuthorID: ads.AnonymousAuthorID},
+	}, withoutDeliveryIDs(suite.receivedAfter(receiver, published)))
+}
+
+func (suite *WebhookSuite) TestWebhooks_AdRestored() {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	suite.subscribe(receiver)
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	ids := suite.publishAds(u.Data.ID, "Good News")
+	_, err = suite.Client.deleteAd(ids[0], u.Data.ID)
+	suite.NoError(err)
+	_, err = suite.Client.restoreAd(ids[0])
+	suite.NoError(err)
+	suite.dispatch()
+
+	suite.Equal([]receivedWebhook{
+		{Event: events.NameAdPublished, Verified: true, AdID: ids[0], AuthorID: u.Data.ID},
+		{Event: events.NameAdDeleted, Verified: true, AdID: ids[0], AuthorID: u.Data.ID},
+		{Event: events.NameAdRestored, Verified: true, AdID: ids[0], AuthorID: u.Data.ID},
+	}, withoutDeliveryIDs(suite.receivedAfter(receiver, 0)))
+}
+
+// withoutDeliveryIDs clears the random delivery ids, so the requests can be compared as a whole
+func withoutDeliveryIDs(received []receivedWebhook) []receivedWebhook {
+	for i := range received {
+		received[i].Delivery = ""
+	}
+	return received
+}
+
+func (suite *WebhookSuite) TestWebhooks_RetryWithBackoff() {
+	receiver := newWebhookReceiver(http.StatusInternalServerError, http.StatusBadGateway)
+	defer receiver.server.Close()
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	hook, err := suite.Client.createWebhook(u.Data.ID, receiver.server.URL, nil)
+	suite.NoError(err)
+	receiver.SetSecret(hook.Data.Secret)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, true)
+	suite.NoError(err)
+
+	suite.dispatch()
+
+	received := receiver.Received()
+	suite.Len(received, 3)
+	// retries keep the delivery id, so the partner can drop duplicates
+	suite.Equal(received[0].Delivery, received[1].Delivery)
+	suite.Equal(received[0].Delivery, received[2].Delivery)
+
+	deliveries, err := suite.Client.listWebhookDeliveries(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.Len(deliveries.Data, 1)
+	suite.True(deliveries.Data[0].Success)
+	suite.Equal(3, deliveries.Data[0].Attempts)
+	suite.Equal(http.StatusOK, deliveries.Data[0].StatusCode)
+	suite.Equal(received[0].Delivery, deliveries.Data[0].ID)
+}
+
+// the partner gets the same delivery ID, when the outbox message is delivered again
+func (suite *WebhookSuite) TestWebhooks_StableDeliveryID() {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	suite.subscribe(receiver)
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.Require().NoError(err)
+	suite.publishAds(u.Data.ID, "Good News")
+
+	pending, err := suite.Repo.PendingMessages(context.Background(), 100)
+	suite.Require().NoError(err)
+	m := pending[len(pending)-1]
+	suite.Require().Equal(events.NameAdPublished, m.Event.EventName())
+	for i := 0; i < 2; i++ {
+		suite.Require().NoError(suite.Dispatcher.Handle(events.WithMessageID(context.Background(), m.ID), m.Event))
+		suite.Dispatcher.Wait()
+	}
+
+	received := suite.receivedAfter(receiver, 0)
+	suite.Require().Len(received, 2)
+	suite.True(strings.HasPrefix(received[0].Delivery, strconv.FormatInt(m.ID, 10)+"-"))
+	suite.Equal(received[0].Delivery, received[1].Delivery)
+}
+
+func (suite *WebhookSuite) TestWebhooks_DisabledAfterFailures() {
+	receiver := newWebhookReceiver(
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+	)
+	defer receiver.server.Close()
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	hook, err := suite.Client.createWebhook(u.Data.ID, receiver.server.URL, nil)
+	suite.NoError(err)
+	ad, err := suite.Client.createAd(u.Data.ID, "Good News", "Dang!")
+	suite.NoError(err)
+	for _, published := range []bool{true, false, true} {
+		_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, published)
+		suite.NoError(err)
+	}
+
+	suite.dispatch()
+
+	// two deliveries of three attempts each, the third event is not sent to the disabled subscription
+	suite.Len(receiver.Received(), 6)
+	res, err := suite.Client.getWebhook(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.False(res.Data.Active)
+	suite.Equal(2, res.Data.Failures)
+	suite.NotNil(res.Data.DisabledAt)
+
+	deliveries, err := suite.Client.listWebhookDeliveries(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.Len(deliveries.Data, 2)
+	suite.False(deliveries.Data[0].Success)
+	suite.Equal(http.StatusServiceUnavailable, deliveries.Data[0].StatusCode)
+	suite.NotEmpty(deliveries.Data[0].Error)
+
+	res, err = suite.Client.enableWebhook(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.True(res.Data.Active)
+	suite.Equal(0, res.Data.Failures)
+	suite.Nil(res.Data.DisabledAt)
+
+	_, err = suite.Client.changeAdStatus(u.Data.ID, ad.Data.ID, false)
+	suite.NoError(err)
+	suite.dispatch()
+	suite.Len(receiver.Received(), 7)
+}
+
+func (suite *WebhookSuite) TestWebhooks_SlowPartnerDoesNotBlock() {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newWebhookReceiver()
+	defer fast.server.Close()
+
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	slowHook, err := suite.Client.createWebhook(u.Data.ID, slow.URL, nil)
+	suite.NoError(err)
+	fastHook, err := suite.Client.createWebhook(u.Data.ID, fast.server.URL, nil)
+	suite.NoError(err)
+	fast.SetSecret(fastHook.Data.Secret)
+	suite.publishAds(u.Data.ID, "Good News", "Blue World")
+
+	stop, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- suite.Dispatcher.Run(stop)()
+	}()
+
+	// the relay is not held by the partner, which does not answer
+	start := time.Now()
+	_, err = app.NewOutboxRelay(suite.Repo, handlerPublisher(suite.Dispatcher.Handle)).Flush(context.Background())
+	suite.NoError(err)
+	suite.Less(time.Since(start), time.Second)
+	suite.Eventually(func() bool {
+		return len(fast.Received()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the shutdown interrupts the delivery, which is neither logged nor counted as a failure
+	cancel()
+	select {
+	case err := <-stopped:
+		suite.NoError(err)
+	case <-time.After(time.Second):
+		suite.Fail("dispatcher is not stopped")
+	}
+	deliveries, err := suite.Client.listWebhookDeliveries(u.Data.ID, slowHook.Data.ID)
+	suite.NoError(err)
+	suite.Len(deliveries.Data, 0)
+	res, err := suite.Client.getWebhook(u.Data.ID, slowHook.Data.ID)
+	suite.NoError(err)
+	suite.True(res.Data.Active)
+	suite.Equal(0, res.Data.Failures)
+
+	// the stopped dispatcher makes the relay deliver new events later
+	err = suite.Dispatcher.Handle(context.Background(), events.AdDeleted{AdID: 1, AuthorID: u.Data.ID})
+	suite.ErrorIs(err, dispatcher.ErrStopped)
+}
+
+func (suite *WebhookSuite) TestWebhooks_Management() {
+	u, err := suite.Client.createUser("Mac Miller", "swimming@circles.com")
+	suite.NoError(err)
+	other, err := suite.Client.createUser("Kendrick", "good@kid.com")
+	suite.NoError(err)
+
+	_, err = suite.Client.createWebhook(u.Data.ID, "ftp://partner.com/hook", nil)
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createWebhook(u.Data.ID, "/hook", nil)
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createWebhook(u.Data.ID, "https://partner.com/hook", []string{"user.created"})
+	suite.ErrorIs(err, ErrBadRequest)
+	_, err = suite.Client.createWebhook(100, "https://partner.com/hook", nil)
+	suite.ErrorIs(err, ErrNotFound)
+
+	hook, err := suite.Client.createWebhook(u.Data.ID, "https://partner.com/hook",
+		[]string{events.NameAdPublished, events.NameAdPublished})
+	suite.NoError(err)
+	suite.Equal([]string{events.NameAdPublished}, hook.Data.Events)
+	suite.Equal(u.Data.ID, hook.Data.UserID)
+	suite.NotEmpty(hook.Data.Secret)
+
+	// the secret is shown only once, when the subscription is created
+	got, err := suite.Client.getWebhook(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.Equal(hook.Data.URL, got.Data.URL)
+	suite.Empty(got.Data.Secret)
+
+	list, err := suite.Client.listWebhooks(u.Data.ID)
+	suite.NoError(err)
+	suite.Len(list.Data, 1)
+	suite.Empty(list.Data[0].Secret)
+	list, err = suite.Client.listWebhooks(other.Data.ID)
+	suite.NoError(err)
+	suite.Len(list.Data, 0)
+
+	// subscriptions of other users look like missing ones
+	_, err = suite.Client.getWebhook(other.Data.ID, hook.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	err = suite.Client.deleteWebhook(other.Data.ID, hook.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	_, err = suite.Client.listWebhookDeliveries(other.Data.ID, hook.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+	_, err = suite.Client.getWebhook(u.Data.ID, "abc")
+	suite.ErrorIs(err, ErrBadRequest)
+
+	deliveries, err := suite.Client.listWebhookDeliveries(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	suite.Len(deliveries.Data, 0)
+
+	err = suite.Client.deleteWebhook(u.Data.ID, hook.Data.ID)
+	suite.NoError(err)
+	_, err = suite.Client.getWebhook(u.Data.ID, hook.Data.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func TestWebhookSuite(t *testing.T) {
+	suite.Run(t, new(WebhookSuite))
+}
+
+func (suite *GRPCSuite) TestGRPCWebhooks() {
+	u, err := suite.Client.CreateUser(suite.Context, &grpcPort.CreateUserRequest{Name: "Oleg", Email: "ivanov@yandex.ru"})
+	suite.NoError(err)
+
+	_, err = suite.Client.CreateWebhook(suite.Context, &grpcPort.CreateWebhookRequest{Url: "https://partner.com/hook"})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = suite.Client.CreateWebhook(suite.Context, &grpcPort.CreateWebhookRequest{UserId: &u.Id, Url: "partner"})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+
+	hook, err := suite.Client.CreateWebhook(suite.Context, &grpcPort.CreateWebhookRequest{
+		UserId: &u.Id,
+		Url:    "https://partner.com/hook",
+		Events: []string{events.NameAdDeleted},
+	})
+	suite.NoError(err)
+	suite.True(hook.Active)
+	suite.Equal([]string{events.NameAdDeleted}, hook.Events)
+	suite.NotEmpty(hook.Secret)
+
+	got, err := suite.Client.GetWebhook(suite.Context, &grpcPort.WebhookRequest{UserId: &u.Id, WebhookId: &hook.Id})
+	suite.NoError(err)
+	suite.Empty(got.Secret)
+
+	list, err := suite.Client.ListWebhooks(suite.Context, &grpcPort.ListWebhooksRequest{UserId: &u.Id})
+	suite.NoError(err)
+	suite.Len(list.List, 1)
+	suite.Empty(list.List[0].Secret)
+
+	deliveries, err := suite.Client.ListWebhookDeliveries(suite.Context, &grpcPort.WebhookRequest{UserId: &u.Id, WebhookId: &hook.Id})
+	suite.NoError(err)
+	suite.Len(deliveries.List, 0)
+
+	otherID := u.Id + 1
+	_, err = suite.Client.GetWebhook(suite.Context, &grpcPort.WebhookRequest{UserId: &otherID, WebhookId: &hook.Id})
+	suite.Equal(codes.NotFound, status.Code(err))
+
+	_, err = suite.Client.DeleteWebhook(suite.Context, &grpcPort.WebhookRequest{UserId: &u.Id, WebhookId: &hook.Id})
+	suite.NoError(err)
+	_, err = suite.Client.EnableWebhook(suite.Context, &grpcPort.WebhookRequest{UserId: &u.Id, WebhookId: &hook.Id})
+	suite.Equal(codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type webhookData struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"user_id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`
+	Active     bool     `json:"active"`
+	Failures   int      `json:"failures"`
+	Created    string   `json:"created"`
+	DisabledAt *string  `json:"disabled_at"`
+}
+
+type webhookResponse struct {
+	Data webhookData `json:"data"`
+}
+
+type webhooksResponse struct {
+	Data []webhookData `json:"data"`
+}
+
+type webhookDeliveryData struct {
+	ID         string `json:"id"`
+	Event      string `json:"event"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Success    bool   `json:"success"`
+}
+
+type webhookDeliveriesResponse struct {
+	Data []webhookDeliveryData `json:"data"`
+}
+
+func (tc *testClient) createWebhook(userID any, url string, events []string) (webhookResponse, error) {
+	body := map[string]any{
+		"url":    url,
+		"events": events,
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return webhookResponse{}, fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/webhooks", userID), bytes.NewReader(data))
+	if err != nil {
+		return webhookResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	var response webhookResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return webhookResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) listWebhooks(userID any) (webhooksResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/webhooks", userID), nil)
+	if err != nil {
+		return webhooksResponse{}, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	var response webhooksResponse
+	err = tc.getResponse(req, &response)
+	if err != nil {
+		return webhooksResponse{}, err
+	}
+
+	return response, nil
+}
+
+func (tc *testClient) webhookRequest(method string, userID any, webhookID any, suffix string, out any) error {
+	req, err := http.NewRequest(method, fmt.Sprintf(tc.baseURL+"/api/v1/users/%v/webhooks/%v%s", userID, webhookID, suffix), nil)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+	return tc.getResponse(req, out)
+}
+
+func (tc *testClient) getWebhook(userID any, webhookID any) (webhookResponse, error) {
+	var response webhookResponse
+	err := tc.webhookRequest(http.MethodGet, userID, webhookID, "", &response)
+	return response, err
+}
+
+func (tc *testClient) deleteWebhook(userID any, webhookID any) error {
+	var response webhookResponse
+	return tc.webhookRequest(http.MethodDelete, userID, webhookID, "", &response)
+}
+
+func (tc *testClient) enableWebhook(userID any, webhookID any) (webhookResponse, error) {
+	var response webhookResponse
+	err := tc.webhookRequest(http.MethodPost, userID, webhookID, "/enable", &response)
+	return response, err
+}
+
+func (tc *testClient) listWebhookDeliveries(userID any, webhookID any) (webhookDeliveriesResponse, error) {
+	var response webhookDeliveriesResponse
+	err := tc.webhookRequest(http.MethodGet, userID, webhookID, "/deliveries", &response)
+	return response, err
+}
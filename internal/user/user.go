@@ -1,7 +1,26 @@
 package user
 
+import "time"
+
 type User struct {
-	ID       int64
-	Nickname string `validate:"min:1"`
-	Email    string `validate:"min:1"`
+	ID           int64
+	Nickname     string `validate:"min:1"`
+	Email        string `validate:"min:1"`
+	PasswordHash []byte
+	Verified     bool
+	DeletedAt    *time.Time
+}
+
+type TokenPurpose int
+
+const (
+	TokenVerifyEmail TokenPurpose = iota
+	TokenResetPassword
+)
+
+type Token struct {
+	Value   string
+	UserID  int64
+	Purpose TokenPurpose
+	Expires time.Time
 }
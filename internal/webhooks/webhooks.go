@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/TobbyMax/ad-service.git/internal/events"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureHeader holds "sha256=" followed by the hex encoded HMAC of the timestamp and the body
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	// DeliveryHeader identifies the delivery, it stays the same for all retries of the delivery and for
+	// the deliveries of the same event again, so partners can drop the duplicates
+	DeliveryHeader = "X-Webhook-Delivery"
+
+	signaturePrefix = "sha256="
+)
+
+// Events lists the events partners can subscribe to, an empty list in a subscription means all of them
+var Events = []string{
+	events.NameAdPublished,
+	events.NameAdUnpublished,
+	events.NameAdUpdated,
+	events.NameAdDeleted,
+	events.NameAdRestored,
+}
+
+// Subscription is a partner endpoint, which receives POST requests about the ad lifecycle events
+type Subscription struct {
+	ID      int64
+	OwnerID int64
+	URL     string
+	// Secret is the key of the HMAC signature of every delivery
+	Secret string
+	Events []string
+	Active bool
+	// Failures counts deliveries failed in a row, the subscription is disabled when it gets too high
+	Failures   int
+	Created    time.Time
+	DisabledAt *time.Time
+}
+
+// Delivery is an entry of the delivery log of a subscription
+type Delivery struct {
+	ID             string
+	SubscriptionID int64
+	Event          string
+	Attempts       int
+	// StatusCode is the response status of the last attempt, zero if there was no response
+	StatusCode int
+	Error      string
+	Success    bool
+	Time       time.Time
+	Duration   time.Duration
+}
+
+// Wants reports whether the event must be delivered to the subscription
+func (s Subscription) Wants(event string) bool {
+	if len(s.Events) == 0 {
+		return IsSupported(event)
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func IsSupported(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret generates a signing secret for a new subscription
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the value of SignatureHeader, the timestamp is signed too, so that receivers can reject replays
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature in constant time
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}